@@ -0,0 +1,414 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/supby/gigbee2mqtt/internal/configuration"
+	"github.com/supby/gigbee2mqtt/internal/db"
+	"github.com/supby/gigbee2mqtt/internal/mqtt"
+)
+
+const (
+	devicesCommand    = "devices"
+	deviceCommand     = "device"
+	permitJoinCommand = "permit-join"
+	backupCommand     = "backup"
+	configCommand     = "config"
+	diagCommand       = "diag"
+)
+
+// Transports of admin subcommands, auto uses the admin socket when it exists.
+const (
+	viaAuto   = "auto"
+	viaSocket = "socket"
+	viaMQTT   = "mqtt"
+)
+
+// adminClient is the connection of admin subcommands to a running gateway.
+type adminClient interface {
+	Devices(ctx context.Context, filter mqtt.DevicesFilter) ([]db.Device, error)
+	Explore(ctx context.Context, deviceAddr uint64) (json.RawMessage, error)
+	Set(ctx context.Context, deviceAddr uint64, msg mqtt.DeviceSetMessage) (json.RawMessage, error)
+	Get(ctx context.Context, deviceAddr uint64, msg mqtt.DeviceGetMessage) (json.RawMessage, error)
+	Config(ctx context.Context) (configuration.Configuration, error)
+	SetPermitJoin(ctx context.Context, permitJoin bool) (configuration.Configuration, error)
+	Close()
+}
+
+// backupFile is written by backup subcommand.
+type backupFile struct {
+	Time          time.Time
+	Configuration configuration.Configuration
+	Devices       []db.Device
+}
+
+// adminFlags are accepted by every admin subcommand.
+type adminFlags struct {
+	configFile *string
+	timeout    *time.Duration
+	via        *string
+}
+
+func newAdminFlags(name string) (*flag.FlagSet, adminFlags) {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	return flags, adminFlags{
+		configFile: flags.String("c", "./configuration.yaml", "path to config file of the gateway"),
+		timeout:    flags.Duration("timeout", 30*time.Second, "how long to wait for the gateway or device to answer"),
+		via:        flags.String("via", viaAuto, "connect over admin socket (socket), MQTT broker (mqtt) or socket when it exists (auto)"),
+	}
+}
+
+// connect loads configuration of the gateway and connects to it, the returned context expires after -timeout.
+func (f adminFlags) connect() (adminClient, context.Context, context.CancelFunc, error) {
+	configService, err := configuration.Init(*f.configFile)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	cfg := configService.GetConfiguration()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *f.timeout)
+
+	var client adminClient
+	switch *f.via {
+	case viaAuto:
+		if _, statErr := os.Stat(cfg.Admin.SocketPath); cfg.Admin.Enabled && statErr == nil {
+			client, err = newSocketAdminClient(cfg)
+		} else {
+			client, err = newMQTTAdminClient(ctx, cfg)
+		}
+	case viaSocket:
+		client, err = newSocketAdminClient(cfg)
+	case viaMQTT:
+		client, err = newMQTTAdminClient(ctx, cfg)
+	default:
+		err = fmt.Errorf("invalid -via '%v'", *f.via)
+	}
+	if err != nil {
+		cancel()
+		return nil, nil, nil, err
+	}
+
+	return client, ctx, cancel, nil
+}
+
+// runAdminCommand runs admin subcommand, false is returned when args do not name one.
+func runAdminCommand(args []string) (bool, error) {
+	if len(args) == 0 {
+		return false, nil
+	}
+
+	switch args[0] {
+	case devicesCommand:
+		return true, runDevicesCommand(args[1:])
+	case deviceCommand:
+		return true, runDeviceCommand(args[1:])
+	case permitJoinCommand:
+		return true, runPermitJoin(args[1:])
+	case backupCommand:
+		return true, runBackup(args[1:])
+	case configCommand:
+		return true, runConfigCommand(args[1:])
+	case diagCommand:
+		return true, runDiag(args[1:])
+	}
+
+	return false, nil
+}
+
+func runDevicesCommand(args []string) error {
+	if len(args) == 0 || args[0] != "list" {
+		return errors.New("usage: devices list [-tag tag] [-model model] [-cluster id] [-online true|false] [-json]")
+	}
+
+	flags, admin := newAdminFlags("devices list")
+	tag := flags.String("tag", "", "only devices with the tag")
+	model := flags.String("model", "", "only devices of the model")
+	cluster := flags.Int("cluster", -1, "only devices supporting the cluster")
+	online := flags.String("online", "", "only online (true) or offline (false) devices")
+	asJSON := flags.Bool("json", false, "print JSON instead of table")
+	flags.Parse(args[1:])
+
+	filter := mqtt.DevicesFilter{
+		ModelID: *model,
+		Tag:     *tag,
+	}
+	if *cluster >= 0 {
+		clusterID := uint16(*cluster)
+		filter.ClusterID = &clusterID
+	}
+	if *online != "" {
+		isOnline, err := strconv.ParseBool(*online)
+		if err != nil {
+			return fmt.Errorf("invalid -online: %v", err)
+		}
+		filter.Online = &isOnline
+	}
+
+	client, ctx, cancel, err := admin.connect()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer client.Close()
+
+	devices, err := client.Devices(ctx, filter)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return printJSON(os.Stdout, devices)
+	}
+
+	printDevices(os.Stdout, devices, time.Now())
+	return nil
+}
+
+func printDevices(out io.Writer, devices []db.Device, now time.Time) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ADDRESS\tNAME\tMODEL\tPOWER\tLQI\tLAST SEEN\tINTERVIEW")
+
+	for _, d := range devices {
+		lastSeen := "never"
+		if !d.LastReceived.IsZero() {
+			lastSeen = now.Sub(d.LastReceived).Round(time.Second).String() + " ago"
+		}
+
+		power := d.PowerSource
+		if d.BatteryLevel != nil {
+			power = fmt.Sprintf("%v %d%%", power, *d.BatteryLevel)
+		}
+
+		fmt.Fprintf(w, "0x%016x\t%v\t%v\t%v\t%d\t%v\t%v\n",
+			d.IEEEAddress, d.FriendlyName, d.ModelID, power, d.LQI, lastSeen, d.InterviewStatus)
+	}
+
+	w.Flush()
+}
+
+func runDeviceCommand(args []string) error {
+	const usage = "usage: device explore|get|set <device addr> [flags]"
+	if len(args) < 2 {
+		return errors.New(usage)
+	}
+
+	command := args[0]
+	deviceAddr, err := strconv.ParseUint(strings.Replace(args[1], "0x", "", -1), 16, 64)
+	if err != nil {
+		return fmt.Errorf("invalid device address '%v'", args[1])
+	}
+
+	flags, admin := newAdminFlags("device " + command)
+	cluster := flags.Uint("cluster", 0, "ZCL cluster ID")
+	endpoint := flags.Uint("endpoint", 1, "device endpoint")
+	attributes := flags.String("attributes", "", "comma separated attribute IDs to get")
+	commandID := flags.Uint("command", 0, "ZCL command ID to set")
+	data := flags.String("data", "{}", "JSON object with command data to set")
+	flags.Parse(args[2:])
+
+	var send func(ctx context.Context, client adminClient) (json.RawMessage, error)
+	switch command {
+	case "explore":
+		send = func(ctx context.Context, client adminClient) (json.RawMessage, error) {
+			return client.Explore(ctx, deviceAddr)
+		}
+	case "get":
+		attrs, err := parseAttributeIDs(*attributes)
+		if err != nil {
+			return err
+		}
+		send = func(ctx context.Context, client adminClient) (json.RawMessage, error) {
+			return client.Get(ctx, deviceAddr, mqtt.DeviceGetMessage{
+				ClusterID:  uint16(*cluster),
+				Endpoint:   uint8(*endpoint),
+				Attributes: attrs,
+			})
+		}
+	case "set":
+		var commandData map[string]interface{}
+		if err := json.Unmarshal([]byte(*data), &commandData); err != nil {
+			return fmt.Errorf("invalid -data: %v", err)
+		}
+		send = func(ctx context.Context, client adminClient) (json.RawMessage, error) {
+			return client.Set(ctx, deviceAddr, mqtt.DeviceSetMessage{
+				ClusterID:         uint16(*cluster),
+				Endpoint:          uint8(*endpoint),
+				CommandIdentifier: uint8(*commandID),
+				CommandData:       commandData,
+			})
+		}
+	default:
+		return errors.New(usage)
+	}
+
+	client, ctx, cancel, err := admin.connect()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer client.Close()
+
+	answer, err := send(ctx, client)
+	if err != nil {
+		return err
+	}
+
+	return printJSON(os.Stdout, answer)
+}
+
+func parseAttributeIDs(value string) ([]uint16, error) {
+	if value == "" {
+		return nil, errors.New("-attributes is required")
+	}
+
+	var ret []uint16
+	for _, s := range strings.Split(value, ",") {
+		id, err := strconv.ParseUint(strings.TrimSpace(s), 0, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid attribute ID '%v'", s)
+		}
+		ret = append(ret, uint16(id))
+	}
+
+	return ret, nil
+}
+
+func runPermitJoin(args []string) error {
+	if len(args) == 0 || (args[0] != "on" && args[0] != "off") {
+		return errors.New("usage: permit-join on|off")
+	}
+
+	flags, admin := newAdminFlags(permitJoinCommand)
+	flags.Parse(args[1:])
+
+	client, ctx, cancel, err := admin.connect()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer client.Close()
+
+	cfg, err := client.SetPermitJoin(ctx, args[0] == "on")
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("permit join: %v\n", cfg.PermitJoin)
+	return nil
+}
+
+func runBackup(args []string) error {
+	flags, admin := newAdminFlags(backupCommand)
+	output := flags.String("o", fmt.Sprintf("gigbee2mqtt-backup-%v.json", time.Now().Format("20060102-150405")), "backup file to write")
+	flags.Parse(args)
+
+	client, ctx, cancel, err := admin.connect()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer client.Close()
+
+	backup := backupFile{Time: time.Now()}
+
+	backup.Configuration, err = client.Config(ctx)
+	if err != nil {
+		return err
+	}
+
+	// the gateway does not send secrets, they are read from the local config and secret files
+	local, err := configuration.Init(*admin.configFile)
+	if err != nil {
+		return err
+	}
+	backup.Configuration = configuration.WithSecrets(backup.Configuration, local.GetConfiguration())
+
+	backup.Devices, err = client.Devices(ctx, mqtt.DevicesFilter{})
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(backup, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// configuration contains network key and MQTT password
+	if err := os.WriteFile(*output, data, 0600); err != nil {
+		return err
+	}
+
+	fmt.Printf("configuration and %v devices are written to '%v'\n", len(backup.Devices), *output)
+	return nil
+}
+
+func runConfigCommand(args []string) error {
+	if len(args) == 0 || args[0] != "validate" {
+		return errors.New("usage: config validate [-c config file]")
+	}
+
+	flags := flag.NewFlagSet("config validate", flag.ExitOnError)
+	configFile := flags.String("c", "./configuration.yaml", "path to config file")
+	flags.Parse(args[1:])
+
+	if _, err := configuration.Init(*configFile); err != nil {
+		return err
+	}
+
+	fmt.Printf("'%v' is valid\n", *configFile)
+	return nil
+}
+
+// runDiag prints internal state of the gateway, it is available only over the admin socket.
+func runDiag(args []string) error {
+	flags := flag.NewFlagSet(diagCommand, flag.ExitOnError)
+	configFile := flags.String("c", "./configuration.yaml", "path to config file of the gateway")
+	timeout := flags.Duration("timeout", 30*time.Second, "how long to wait for the gateway to answer")
+	nodes := flags.Bool("nodes", false, "print node table of the coordinator")
+	flags.Parse(args)
+
+	configService, err := configuration.Init(*configFile)
+	if err != nil {
+		return err
+	}
+
+	client, err := newSocketAdminClient(configService.GetConfiguration())
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	if *nodes {
+		table, err := client.Nodes(ctx)
+		if err != nil {
+			return err
+		}
+		return printJSON(os.Stdout, table)
+	}
+
+	diag, err := client.Diagnostics(ctx)
+	if err != nil {
+		return err
+	}
+
+	return printJSON(os.Stdout, diag)
+}
+
+func printJSON(out io.Writer, v interface{}) error {
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
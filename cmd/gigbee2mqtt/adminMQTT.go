@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/supby/gigbee2mqtt/internal/configuration"
+	"github.com/supby/gigbee2mqtt/internal/db"
+	"github.com/supby/gigbee2mqtt/internal/mqtt"
+	"github.com/supby/gigbee2mqtt/internal/router"
+)
+
+// mqttAdminClient talks to the gateway over the same MQTT topics as any other user of the gateway.
+type mqttAdminClient struct {
+	client *mqtt.AdminClient
+}
+
+func newMQTTAdminClient(ctx context.Context, cfg configuration.Configuration) (adminClient, error) {
+	mqttCfg := effectiveMqttConfiguration(cfg)
+
+	client, err := mqtt.NewAdminClient(ctx, &mqttCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &mqttAdminClient{client: client}, nil
+}
+
+func (c *mqttAdminClient) Devices(ctx context.Context, filter mqtt.DevicesFilter) ([]db.Device, error) {
+	payload, err := json.Marshal(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	answerTopic := fmt.Sprintf("%v/%v", router.MQTT_GATEWAY, router.MQTT_DEVICES)
+	if !reflect.DeepEqual(filter, mqtt.DevicesFilter{}) {
+		answerTopic = fmt.Sprintf("%v/%v", router.MQTT_GATEWAY, router.MQTT_DEVICES_FILTERED)
+	}
+
+	answer, err := c.client.Request(ctx, fmt.Sprintf("%v/%v", router.MQTT_GATEWAY, router.MQTT_GET_DEVICES), payload, answerTopic, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var devices []db.Device
+	return devices, json.Unmarshal(answer, &devices)
+}
+
+func (c *mqttAdminClient) Explore(ctx context.Context, deviceAddr uint64) (json.RawMessage, error) {
+	return c.client.Request(ctx,
+		fmt.Sprintf("0x%x/%v", deviceAddr, router.MQTT_DEVICE_EXPLORE), []byte("{}"),
+		fmt.Sprintf("0x%x/%v", deviceAddr, router.MQTT_DEVICE_DESCRIPTION), nil)
+}
+
+func (c *mqttAdminClient) Set(ctx context.Context, deviceAddr uint64, msg mqtt.DeviceSetMessage) (json.RawMessage, error) {
+	return c.deviceRequest(ctx, deviceAddr, router.MQTT_DEVICE_SET, msg, msg.ClusterID)
+}
+
+func (c *mqttAdminClient) Get(ctx context.Context, deviceAddr uint64, msg mqtt.DeviceGetMessage) (json.RawMessage, error) {
+	return c.deviceRequest(ctx, deviceAddr, router.MQTT_DEVICE_GET, msg, msg.ClusterID)
+}
+
+// deviceRequest waits for the message of the device from the same cluster, the same way as MQTT 5 replies are matched.
+func (c *mqttAdminClient) deviceRequest(ctx context.Context, deviceAddr uint64, command string, msg interface{}, clusterID uint16) (json.RawMessage, error) {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.client.Request(ctx, fmt.Sprintf("0x%x/%v", deviceAddr, command), payload, fmt.Sprintf("0x%x", deviceAddr),
+		func(answer []byte) bool {
+			var devMsg struct {
+				Message struct {
+					ClusterID uint16
+				}
+			}
+			return json.Unmarshal(answer, &devMsg) == nil && devMsg.Message.ClusterID == clusterID
+		})
+}
+
+func (c *mqttAdminClient) Config(ctx context.Context) (configuration.Configuration, error) {
+	var cfg configuration.Configuration
+
+	answer, err := c.client.Request(ctx,
+		fmt.Sprintf("%v/%v", router.MQTT_GATEWAY, router.MQTT_GET_CONFIG), []byte("{}"),
+		fmt.Sprintf("%v/%v", router.MQTT_GATEWAY, router.MQTT_CONFIG), nil)
+	if err != nil {
+		return cfg, err
+	}
+
+	return cfg, json.Unmarshal(answer, &cfg)
+}
+
+func (c *mqttAdminClient) SetPermitJoin(ctx context.Context, permitJoin bool) (configuration.Configuration, error) {
+	payload, err := json.Marshal(mqtt.SetGatewayConfig{PermitJoin: permitJoin})
+	if err != nil {
+		return configuration.Configuration{}, err
+	}
+
+	err = c.client.Publish(ctx, fmt.Sprintf("%v/%v", router.MQTT_GATEWAY, router.MQTT_SET_CONFIG), payload)
+	if err != nil {
+		return configuration.Configuration{}, err
+	}
+
+	// commands are handled concurrently by the gateway, so get_config may be answered before set_config is applied
+	for {
+		cfg, err := c.Config(ctx)
+		if err != nil || cfg.PermitJoin == permitJoin {
+			return cfg, err
+		}
+
+		select {
+		case <-time.After(500 * time.Millisecond):
+		case <-ctx.Done():
+			return cfg, fmt.Errorf("permit join is not changed: %w", ctx.Err())
+		}
+	}
+}
+
+func (c *mqttAdminClient) Close() {
+	c.client.Close()
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/supby/gigbee2mqtt/internal/admin"
+	"github.com/supby/gigbee2mqtt/internal/configuration"
+	"github.com/supby/gigbee2mqtt/internal/db"
+	"github.com/supby/gigbee2mqtt/internal/mqtt"
+)
+
+// socketAdminClient talks to the gateway over its admin socket, it works also while MQTT broker is unreachable.
+type socketAdminClient struct {
+	client *admin.Client
+}
+
+func newSocketAdminClient(cfg configuration.Configuration) (*socketAdminClient, error) {
+	client, err := admin.Dial(cfg.Admin.SocketPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &socketAdminClient{client: client}, nil
+}
+
+func (c *socketAdminClient) Devices(ctx context.Context, filter mqtt.DevicesFilter) ([]db.Device, error) {
+	var devices []db.Device
+	return devices, c.client.Call(ctx, admin.RPC_GET_DEVICES, filter, &devices)
+}
+
+func (c *socketAdminClient) Explore(ctx context.Context, deviceAddr uint64) (json.RawMessage, error) {
+	return c.deviceCall(ctx, admin.RPC_DEVICE_EXPLORE, deviceAddr, struct{}{})
+}
+
+func (c *socketAdminClient) Set(ctx context.Context, deviceAddr uint64, msg mqtt.DeviceSetMessage) (json.RawMessage, error) {
+	return c.deviceCall(ctx, admin.RPC_DEVICE_SET, deviceAddr, msg)
+}
+
+func (c *socketAdminClient) Get(ctx context.Context, deviceAddr uint64, msg mqtt.DeviceGetMessage) (json.RawMessage, error) {
+	return c.deviceCall(ctx, admin.RPC_DEVICE_GET, deviceAddr, msg)
+}
+
+// deviceCall sends DeviceParams together with fields of msg, the gateway waits for the device until ctx expires.
+func (c *socketAdminClient) deviceCall(ctx context.Context, method string, deviceAddr uint64, msg interface{}) (json.RawMessage, error) {
+	params := map[string]interface{}{}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &params); err != nil {
+		return nil, err
+	}
+
+	params["Device"] = fmt.Sprintf("0x%x", deviceAddr)
+	if deadline, ok := ctx.Deadline(); ok {
+		params["Timeout"] = remainingTimeout(deadline).String()
+	}
+
+	var answer json.RawMessage
+	return answer, c.client.Call(ctx, method, params, &answer)
+}
+
+// remainingTimeout leaves a second for the answer to come back, so the gateway gives up before the client does.
+func remainingTimeout(deadline time.Time) time.Duration {
+	d := time.Until(deadline) - time.Second
+	if d < 0 {
+		return 0
+	}
+
+	return d.Round(time.Millisecond)
+}
+
+func (c *socketAdminClient) Config(ctx context.Context) (configuration.Configuration, error) {
+	var cfg configuration.Configuration
+	return cfg, c.client.Call(ctx, admin.RPC_GET_CONFIG, struct{}{}, &cfg)
+}
+
+// SetPermitJoin is answered after the change is applied, unlike set_config MQTT command.
+func (c *socketAdminClient) SetPermitJoin(ctx context.Context, permitJoin bool) (configuration.Configuration, error) {
+	var result mqtt.SetGatewayConfigResult
+	if err := c.client.Call(ctx, admin.RPC_SET_CONFIG, mqtt.SetGatewayConfig{PermitJoin: permitJoin}, &result); err != nil {
+		return configuration.Configuration{}, err
+	}
+	if result.Config == nil {
+		return configuration.Configuration{}, fmt.Errorf("gateway did not return configuration")
+	}
+
+	return *result.Config, nil
+}
+
+func (c *socketAdminClient) Diagnostics(ctx context.Context) (admin.Diagnostics, error) {
+	var diag admin.Diagnostics
+	return diag, c.client.Call(ctx, admin.RPC_DIAGNOSTICS, struct{}{}, &diag)
+}
+
+func (c *socketAdminClient) Nodes(ctx context.Context) (json.RawMessage, error) {
+	var nodes json.RawMessage
+	return nodes, c.client.Call(ctx, admin.RPC_NODES, struct{}{}, &nodes)
+}
+
+func (c *socketAdminClient) Close() {
+	c.client.Close()
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/supby/gigbee2mqtt/internal/configuration"
+	"github.com/supby/gigbee2mqtt/internal/db"
+	"github.com/supby/gigbee2mqtt/internal/z2m"
+	"gopkg.in/yaml.v2"
+)
+
+const importZ2MCommand = "import-z2m"
+
+// runImportZ2M converts zigbee2mqtt data directory to gigbee2mqtt configuration file and device database.
+func runImportZ2M(args []string) error {
+	flags := flag.NewFlagSet(importZ2MCommand, flag.ExitOnError)
+	dataDir := flags.String("z2m-data", "./data", "zigbee2mqtt data directory with configuration.yaml, database.db and coordinator_backup.json")
+	configFile := flags.String("c", "./configuration.yaml", "path to gigbee2mqtt config file to create")
+	dbDir := flags.String("db-dir", "", "directory of device database to create, databaseconfiguration.dir when empty")
+	backend := flags.String("db-backend", db.BackendJSON, "device database backend, json or bolt")
+	force := flags.Bool("force", false, "overwrite existing config file and devices")
+	flags.Parse(args)
+
+	if _, err := os.Stat(*configFile); err == nil && !*force {
+		return fmt.Errorf("'%v' already exists, use -force to overwrite it", *configFile)
+	} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	result, err := z2m.Import(*dataDir, configuration.Default())
+	if err != nil {
+		return err
+	}
+
+	for _, w := range result.Warnings {
+		fmt.Fprintf(os.Stderr, "warning: %v\n", w)
+	}
+
+	cfg := result.Configuration
+	cfg.DatabaseConfiguration.Backend = *backend
+	if *dbDir != "" {
+		cfg.DatabaseConfiguration.Dir = *dbDir
+	}
+
+	if err := os.MkdirAll(cfg.DatabaseConfiguration.Dir, 0755); err != nil {
+		return err
+	}
+
+	deviceDB, err := db.OpenDeviceDB(cfg.DatabaseConfiguration.Dir, db.DeviceDBOptions{
+		Backend:              cfg.DatabaseConfiguration.Backend,
+		FlushPeriodInSeconds: cfg.DatabaseConfiguration.FlushPeriodInSeconds,
+		BackupCount:          cfg.DatabaseConfiguration.BackupCount,
+		LogLevel:             cfg.LogLevel,
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	existing, err := deviceDB.GetDevices(ctx)
+	if err != nil {
+		deviceDB.Close(ctx)
+		return err
+	}
+	if len(existing) > 0 && !*force {
+		deviceDB.Close(ctx)
+		return fmt.Errorf("device database in '%v' is not empty, use -force to overwrite devices", cfg.DatabaseConfiguration.Dir)
+	}
+
+	for _, device := range result.Devices {
+		if err := deviceDB.SaveDevice(ctx, device); err != nil {
+			deviceDB.Close(ctx)
+			return err
+		}
+	}
+
+	if err := deviceDB.Close(ctx); err != nil {
+		return err
+	}
+
+	data, err := yaml.Marshal(&cfg)
+	if err != nil {
+		return err
+	}
+
+	// config contains network key and MQTT password
+	if err := os.WriteFile(*configFile, data, 0600); err != nil {
+		return err
+	}
+
+	fmt.Printf("imported %v devices to '%v', configuration is written to '%v'\n",
+		len(result.Devices), cfg.DatabaseConfiguration.Dir, *configFile)
+
+	return nil
+}
@@ -3,25 +3,59 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
 	"os"
-	"os/signal"
+	"time"
 
 	"github.com/shimmeringbee/zigbee"
 
+	"github.com/supby/gigbee2mqtt/internal/admin"
+	"github.com/supby/gigbee2mqtt/internal/broker"
 	"github.com/supby/gigbee2mqtt/internal/configuration"
 	"github.com/supby/gigbee2mqtt/internal/db"
+	"github.com/supby/gigbee2mqtt/internal/history"
+	"github.com/supby/gigbee2mqtt/internal/httpserver"
+	"github.com/supby/gigbee2mqtt/internal/lifecycle"
 	"github.com/supby/gigbee2mqtt/internal/logger"
+	"github.com/supby/gigbee2mqtt/internal/metrics"
 	"github.com/supby/gigbee2mqtt/internal/mqtt"
 	"github.com/supby/gigbee2mqtt/internal/router"
+	"github.com/supby/gigbee2mqtt/internal/systemd"
 	"github.com/supby/gigbee2mqtt/internal/types"
+	"github.com/supby/gigbee2mqtt/internal/webui"
 	"github.com/supby/gigbee2mqtt/internal/zcldef"
 )
 
+// shutdownTimeout bounds draining of in-flight commands and flushing, systemd kills the gateway after
+// TimeoutStopSec, 90 seconds by default.
+const shutdownTimeout = 30 * time.Second
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == importZ2MCommand {
+		if err := runImportZ2M(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "import error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if ok, err := runAdminCommand(os.Args[1:]); ok {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	os.Exit(run())
+}
+
+// run starts the gateway and returns exit code once it is stopped, everything started is stopped in reverse order.
+func run() (exitCode int) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	logger := logger.GetLogger("[main]", logger.LogLevelError)
+	logger := logger.GetLogger("[main]", logger.LogLevelInfo)
 
 	var configFile = flag.String("c", "./configuration.yaml", "path to config file name")
 	flag.Parse()
@@ -29,73 +63,292 @@ func main() {
 	configService, err := configuration.Init(*configFile)
 	if err != nil {
 		logger.Error("Configuration initialization error: %v\n", err)
-		os.Exit(1)
+		return lifecycle.EXIT_FAILURE
+	}
+
+	cfg := configService.GetConfiguration()
+	if err := configureLog(cfg, nil); err != nil {
+		logger.Error("Log initialization error: %v\n", err)
+		return lifecycle.EXIT_FAILURE
 	}
 
-	db1, err := db.NewDeviceDB("./data", db.DeviceDBOptions{
-		FlushPeriodInSeconds: 60,
+	lc := lifecycle.NewManager(cfg.LogLevel)
+	defer func() {
+		exitCode = lc.Shutdown(shutdownTimeout, exitCode)
+	}()
+
+	db1, err := db.OpenDeviceDB(cfg.DatabaseConfiguration.Dir, db.DeviceDBOptions{
+		Backend:              cfg.DatabaseConfiguration.Backend,
+		FlushPeriodInSeconds: cfg.DatabaseConfiguration.FlushPeriodInSeconds,
+		BackupCount:          cfg.DatabaseConfiguration.BackupCount,
+		LogLevel:             cfg.LogLevel,
 	})
 	if err != nil {
 		logger.Error("db initialization error: %v\n", err)
-		os.Exit(1)
+		return lifecycle.EXIT_FAILURE
+	}
+	lc.OnStop("device database", db1.Close)
+
+	var historyStore history.Store
+	if cfg.HistoryConfiguration.Enabled {
+		historyStore, err = history.NewStore(cfg.DatabaseConfiguration.Dir, cfg.HistoryConfiguration)
+		if err != nil {
+			logger.Error("history initialization error: %v\n", err)
+			return lifecycle.EXIT_FAILURE
+		}
+		lc.OnStop("history", historyStore.Close)
 	}
-	defer db1.Close(ctx)
 
 	zclDefService := zcldef.New("./zcldef/zcldef.json")
 
-	cfg := configService.GetConfiguration()
+	if cfg.EmbeddedBroker.Enabled {
+		embeddedBroker, err := broker.NewBroker(&cfg.EmbeddedBroker, cfg.LogLevel)
+		if err != nil {
+			logger.Error("embedded MQTT broker initialization error: %v\n", err)
+			return lifecycle.EXIT_FAILURE
+		}
+		lc.OnStop("embedded MQTT broker", func(ctx context.Context) error {
+			return embeddedBroker.Close()
+		})
+	}
+	cfg.MqttConfiguration = effectiveMqttConfiguration(cfg)
 
-	mqttClient, mqttDisconnect := mqtt.NewClient(&cfg)
-	defer mqttDisconnect()
+	mqttClient, mqttDisconnect, err := mqtt.NewClient(&cfg)
+	if err != nil {
+		logger.Error("MQTT client initialization error: %v\n", err)
+		return lifecycle.EXIT_FAILURE
+	}
+	// publishes offline status
+	lc.OnStop("MQTT client", func(ctx context.Context) error {
+		mqttDisconnect()
+		return nil
+	})
 
-	mqttRouter := router.NewMQTTRouter(configService, mqttClient, db1)
+	if err := configureLog(cfg, mqttClient); err != nil {
+		logger.Error("Log initialization error: %v\n", err)
+	}
+
+	mqttRouter := router.NewMQTTRouter(configService, mqttClient, db1, historyStore)
 	zRouter := router.NewZigbeeRouter(zclDefService, db1, &cfg)
 
-	setupSubscriptions(mqttRouter, zRouter, ctx)
+	metrics.SetDeviceSource(func() ([]metrics.DeviceStats, error) {
+		devices, err := db1.GetDevices(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		ret := make([]metrics.DeviceStats, len(devices))
+		for i, d := range devices {
+			ret[i] = metrics.DeviceStats{
+				IEEEAddress:  d.IEEEAddress,
+				FriendlyName: d.FriendlyName,
+				LQI:          d.LQI,
+				LastSeen:     d.LastReceived,
+			}
+		}
+		return ret, nil
+	})
+
+	commands := router.NewCommandService(configService, zRouter, db1, historyStore)
+	publishers := []router.DevicePublisher{mqttRouter, commands}
+
+	reloader := &configReloader{
+		configService: configService,
+		mqttClient:    mqttClient,
+		zRouter:       zRouter,
+		logger:        logger,
+	}
+	mqttRouter.SubscribeOnConfigChange(func(result configuration.ReloadResult) {
+		reloader.Apply(ctx, result)
+	})
+	commands.SubscribeOnConfigChange(func(result configuration.ReloadResult) {
+		reloader.Apply(ctx, result)
+	})
+
+	if cfg.Admin.Enabled {
+		adminServer := admin.NewServer(&cfg.Admin, cfg.LogLevel)
+		admin.RegisterCommands(adminServer, commands)
+		admin.RegisterDiagnostics(adminServer, admin.DiagnosticsSources{
+			Commands:     commands,
+			MQTTRouter:   mqttRouter,
+			MQTTClient:   mqttClient,
+			ZigbeeRouter: zRouter,
+		})
+		adminServer.SubscribeOnError(lc.Fail)
+
+		err = adminServer.Start()
+		if err != nil {
+			logger.Error("admin socket initialization error: %v\n", err)
+			return lifecycle.EXIT_FAILURE
+		}
+		lc.OnStop("admin socket", func(ctx context.Context) error {
+			return adminServer.Close()
+		})
+	}
+
+	if cfg.HTTP.Enabled {
+		httpRouter := router.NewHTTPRouter(configService, commands, zclDefService)
+		eventStream := router.NewEventStream(configService, db1)
+		publishers = append(publishers, eventStream)
+
+		httpServer := httpserver.NewServer(&cfg.HTTP, cfg.LogLevel)
+		httpServer.Handle("/metrics", metrics.Handler())
+		httpServer.Handle(router.HTTP_API_PREFIX, httpRouter)
+		httpServer.Handle(router.HTTP_EVENTS, eventStream)
+		httpServer.Handle("/", webui.Handler())
+		httpServer.SubscribeOnError(lc.Fail)
+
+		err = httpServer.Start()
+		if err != nil {
+			logger.Error("HTTP server initialization error: %v\n", err)
+			return lifecycle.EXIT_FAILURE
+		}
+		lc.OnStop("HTTP server", httpServer.Close)
+		lc.OnStop("event stream", func(ctx context.Context) error {
+			eventStream.PublishGatewayStatus(router.GATEWAY_OFFLINE)
+			return nil
+		})
+	}
+
+	setupSubscriptions(mqttRouter, zRouter, historyStore, ctx, publishers, logger)
+	zRouter.SubscribeOnFatalError(lc.Fail)
 
-	zRouter.StartAsync(ctx)
-	defer zRouter.Stop()
+	if err := zRouter.StartAsync(ctx); err != nil {
+		logger.Error("%v\n", err)
+		return lifecycle.EXIT_FAILURE
+	}
+	lc.OnStop("Zigbee event loop", func(ctx context.Context) error {
+		zRouter.Stop()
+		return nil
+	})
+	lc.OnStop("in-flight commands and events", zRouter.Drain)
+	lc.OnStop("MQTT commands", func(ctx context.Context) error {
+		mqttClient.UnSubscribe()
+		return nil
+	})
+
+	recordCoordinator(configService, zRouter.AdapterNode(), logger)
+
+	notifier := systemd.NewNotifier()
+	mqttReadyTimeout := time.Duration(cfg.Systemd.MqttReadyTimeoutInSeconds) * time.Second
+	go notifySystemd(ctx, notifier, mqttReadyTimeout, mqttClient, commands, zRouter, logger)
+
+	err = configuration.Watch(ctx, *configFile, configWatchInterval, func() {
+		reloader.Reload(ctx)
+	})
+	if err != nil {
+		logger.Error("Configuration watch error: %v\n", err)
+	}
 
-	waitForInterruptSignal()
+	exitCode = lc.Wait(func() {
+		reloader.Reload(ctx)
+	})
+	notifier.Stopping()
 
 	logger.Info("exiting app...")
+
+	return exitCode
 }
 
-func setupSubscriptions(mqttRouter router.MQTTRouter, zRouter router.ZigbeeRouter, ctx context.Context) {
+// recordCoordinator keeps the adapter in the state file and warns when it is not the one found on the last start.
+func recordCoordinator(configService configuration.ConfigurationService, node zigbee.Node, logger logger.Logger) {
+	previous := configService.GetState().Coordinator
+	if previous.IEEEAddress != 0 && previous.IEEEAddress != uint64(node.IEEEAddress) {
+		logger.Warn("Coordinator changed from 0x%x to 0x%x\n", previous.IEEEAddress, uint64(node.IEEEAddress))
+	}
+
+	err := configService.UpdateState(func(state *configuration.State) {
+		state.Coordinator = configuration.CoordinatorState{
+			IEEEAddress:    uint64(node.IEEEAddress),
+			NetworkAddress: uint16(node.NetworkAddress),
+			InitialisedAt:  time.Now(),
+		}
+	})
+	if err != nil {
+		logger.Error("Saving state error: %v\n", err)
+	}
+}
+
+// effectiveMqttConfiguration points MQTT client to the embedded broker when it is enabled.
+func effectiveMqttConfiguration(cfg configuration.Configuration) configuration.MqttConfiguration {
+	ret := cfg.MqttConfiguration
+	if cfg.EmbeddedBroker.Enabled {
+		ret.Scheme = "tcp"
+		ret.Address = "127.0.0.1"
+		ret.Port = cfg.EmbeddedBroker.Port
+		ret.Username = cfg.EmbeddedBroker.Username
+		ret.Password = cfg.EmbeddedBroker.Password
+	}
+
+	return ret
+}
+
+func setupSubscriptions(
+	mqttRouter router.MQTTRouter,
+	zRouter router.ZigbeeRouter,
+	historyStore history.Store,
+	ctx context.Context,
+	publishers []router.DevicePublisher,
+	logger logger.Logger) {
+	publish := func(ieeeAddress uint64, msg interface{}, subtopic string) {
+		for _, p := range publishers {
+			p.PublishDeviceMessage(ieeeAddress, msg, subtopic)
+		}
+	}
+
 	mqttRouter.SubscribeOnSetMessage(func(devCmd types.DeviceCommandMessage) {
 		zRouter.ProccessMessageToDevice(ctx, devCmd)
 	})
 	mqttRouter.SubscribeOnGetMessage(func(devCmd types.DeviceGetMessage) {
 		zRouter.ProccessGetMessageToDevice(ctx, devCmd)
 	})
+	mqttRouter.SubscribeOnWriteMessage(func(devCmd types.DeviceWriteMessage) {
+		zRouter.ProccessWriteMessageToDevice(ctx, devCmd)
+	})
+	mqttRouter.SubscribeOnConfigureReportingMessage(func(devCmd types.DeviceConfigureReportingMessage) {
+		zRouter.ProccessConfigureReportingMessage(ctx, devCmd)
+	})
 	mqttRouter.SubscribeOnExploreMessage(func(devCmd types.DeviceExploreMessage) {
 		zRouter.ProccessGetDeviceDescriptionMessage(ctx, devCmd)
 	})
-	mqttRouter.SubscribeOnSetDeviceConfigMessage(func(devCmd types.DeviceConfigSetMessage) {
-		zRouter.ProccessSetDeviceConfigMessage(ctx, devCmd)
+	mqttRouter.SubscribeOnRemoveMessage(func(devCmd types.DeviceRemoveMessage) {
+		zRouter.ProccessRemoveMessage(ctx, devCmd)
 	})
 	zRouter.SubscribeOnDeviceMessage(func(devMsg mqtt.DeviceMessage) {
-		mqttRouter.PublishDeviceMessage(devMsg.IEEEAddress, devMsg, "")
+		publish(devMsg.IEEEAddress, devMsg, "")
+		if historyStore != nil {
+			if err := recordHistory(ctx, historyStore, devMsg); err != nil {
+				logger.Error("Error recording history of 0x%x: %v\n", devMsg.IEEEAddress, err)
+			}
+		}
 	})
 	zRouter.SubscribeOnDeviceDescription(func(devDscMsg mqtt.DeviceDescriptionMessage) {
-		mqttRouter.PublishDeviceMessage(devDscMsg.IEEEAddress, devDscMsg, "description")
+		publish(devDscMsg.IEEEAddress, devDscMsg, router.MQTT_DEVICE_DESCRIPTION)
 	})
 	zRouter.SubscribeOnDeviceJoin(func(e zigbee.NodeJoinEvent) {
-		mqttRouter.PublishDeviceMessage(uint64(e.IEEEAddress), e, "join")
+		publish(uint64(e.IEEEAddress), e, "join")
 	})
 	zRouter.SubscribeOnDeviceLeave(func(e zigbee.NodeLeaveEvent) {
-		mqttRouter.PublishDeviceMessage(uint64(e.IEEEAddress), e, "leave")
+		publish(uint64(e.IEEEAddress), e, "leave")
 	})
 	zRouter.SubscribeOnDeviceUpdate(func(e zigbee.NodeUpdateEvent) {
-		mqttRouter.PublishDeviceMessage(uint64(e.IEEEAddress), e, "update")
+		publish(uint64(e.IEEEAddress), e, "update")
+	})
+	zRouter.SubscribeOnCoordinatorEvent(func(event string, msg mqtt.CoordinatorEventMessage) {
+		mqttRouter.PublishGatewayEvent(event, msg)
 	})
 }
 
-func waitForInterruptSignal() {
-	sigchan := make(chan os.Signal, 1)
-	signal.Notify(sigchan, os.Interrupt)
-	defer func() {
-		signal.Stop(sigchan)
-	}()
-	<-sigchan
+func recordHistory(ctx context.Context, historyStore history.Store, devMsg mqtt.DeviceMessage) error {
+	report, ok := devMsg.Message.(mqtt.DeviceAttributesReportMessage)
+	if !ok {
+		return nil
+	}
+
+	attributes, ok := report.ClusterAttributes.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	return historyStore.Record(ctx, devMsg.IEEEAddress, report.ClusterID, attributes, time.Now())
 }
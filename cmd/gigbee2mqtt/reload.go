@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/supby/gigbee2mqtt/internal/configuration"
+	"github.com/supby/gigbee2mqtt/internal/logger"
+	"github.com/supby/gigbee2mqtt/internal/mqtt"
+	"github.com/supby/gigbee2mqtt/internal/router"
+	"github.com/supby/gigbee2mqtt/internal/types"
+)
+
+// configWatchInterval is how often the config file is checked for changes.
+const configWatchInterval = 5 * time.Second
+
+// configReloader applies changes of the config file to the running gateway, on SIGHUP or when the file changes.
+type configReloader struct {
+	mtx           sync.Mutex
+	configService configuration.ConfigurationService
+	mqttClient    mqtt.MqttClient
+	zRouter       router.ZigbeeRouter
+	logger        logger.Logger
+}
+
+func (r *configReloader) Reload(ctx context.Context) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	result, err := r.configService.Reload()
+	if err != nil {
+		r.logger.Error("Configuration reload is rejected: %v\n", err)
+		return
+	}
+
+	r.apply(ctx, result)
+}
+
+// Apply applies configuration changed by set_config.
+func (r *configReloader) Apply(ctx context.Context, result configuration.ReloadResult) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	r.apply(ctx, result)
+}
+
+func (r *configReloader) apply(ctx context.Context, result configuration.ReloadResult) {
+	for _, field := range result.RestartFields {
+		r.logger.Warn("'%v' is changed, it takes effect after restart", field)
+	}
+
+	if len(result.Applied) == 0 {
+		return
+	}
+
+	r.logger.Info("Applying changed configuration: %v", strings.Join(result.AppliedFields, ", "))
+
+	if result.IsApplied(configuration.SectionLogLevel) || result.IsApplied(configuration.SectionLogLevels) ||
+		result.IsApplied(configuration.SectionLog) {
+		if err := configureLog(result.Current, r.mqttClient); err != nil {
+			r.logger.Error("Error applying log configuration: %v\n", err)
+		}
+	}
+
+	if result.IsApplied(configuration.SectionMqtt) && mqttConnectionChanged(result.Previous, result.Current) {
+		mqttConfig := effectiveMqttConfiguration(result.Current)
+		if err := r.mqttClient.Reconnect(&mqttConfig); err != nil {
+			r.logger.Error("Error applying MQTT configuration: %v\n", err)
+		}
+	}
+
+	if result.IsApplied(configuration.SectionPermitJoin) {
+		r.zRouter.ProccessSetDeviceConfigMessage(ctx, types.DeviceConfigSetMessage{
+			PermitJoin: result.Current.PermitJoin,
+		})
+	}
+}
+
+// configureLog sets levels and outputs of the log, lines are published to MQTT only while connected,
+// so they do not fill the offline queue. mqttClient is nil until it is created.
+func configureLog(cfg configuration.Configuration, mqttClient mqtt.MqttClient) error {
+	logger.SetLevels(cfg.LogLevel, cfg.LogLevels)
+
+	err := logger.Configure(logger.Options{
+		Format:     cfg.Log.Format,
+		File:       cfg.Log.File,
+		MaxSizeMB:  cfg.Log.MaxSizeMB,
+		MaxBackups: cfg.Log.MaxBackups,
+	})
+
+	if mqttClient == nil || !cfg.Log.MqttTopic {
+		logger.SetPublisher(0, "", nil)
+		return err
+	}
+
+	topic := fmt.Sprintf("%v/%v", router.MQTT_GATEWAY, router.MQTT_LOG)
+	logger.SetPublisher(cfg.Log.MqttLevel, "MQTT Client", func(data []byte) {
+		if mqttClient.IsConnected() {
+			mqttClient.Publish(topic, data)
+		}
+	})
+
+	return err
+}
+
+// mqttConnectionChanged ignores QoS, retain and payload format of published messages, they are read on every publish.
+func mqttConnectionChanged(previous configuration.Configuration, current configuration.Configuration) bool {
+	a, b := effectiveMqttConfiguration(previous), effectiveMqttConfiguration(current)
+	for _, c := range []*configuration.MqttConfiguration{&a, &b} {
+		c.QoS = configuration.MqttQoSConfiguration{CommandsSubscription: c.QoS.CommandsSubscription}
+		c.Payload = configuration.MqttPayloadConfiguration{}
+	}
+
+	return !reflect.DeepEqual(a, b)
+}
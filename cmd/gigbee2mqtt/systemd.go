@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/supby/gigbee2mqtt/internal/logger"
+	"github.com/supby/gigbee2mqtt/internal/mqtt"
+	"github.com/supby/gigbee2mqtt/internal/router"
+	"github.com/supby/gigbee2mqtt/internal/systemd"
+)
+
+// systemdStatusInterval is how often status with device counts is sent to systemd.
+const systemdStatusInterval = 30 * time.Second
+
+// notifySystemd sends READY once MQTT is connected, the coordinator is initialised already, then keeps status
+// with device counts up to date. Watchdog is pinged while the Zigbee event loop is alive.
+// MQTT is waited for at most mqttTimeout, so an unreachable broker does not fail the start: the client keeps
+// reconnecting and buffers messages meanwhile.
+func notifySystemd(
+	ctx context.Context,
+	notifier *systemd.Notifier,
+	mqttTimeout time.Duration,
+	mqttClient mqtt.MqttClient,
+	commands router.CommandService,
+	zRouter router.ZigbeeRouter,
+	logger logger.Logger) {
+	if !notifier.Enabled() {
+		return
+	}
+
+	if interval := systemd.WatchdogInterval(); interval > 0 {
+		go notifier.RunWatchdog(ctx, interval, func() bool {
+			return time.Since(zRouter.Heartbeat()) < interval
+		})
+	}
+
+	deadline := time.Now().Add(mqttTimeout)
+	for !mqttClient.IsConnected() && time.Now().Before(deadline) {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+	if mqttTimeout > 0 && !mqttClient.IsConnected() {
+		logger.Warn("MQTT is not connected within %v, notifying systemd the gateway is ready anyway", mqttTimeout)
+	}
+
+	if err := notifier.Ready(gatewayStatus(ctx, mqttClient, commands)); err != nil {
+		logger.Warn("Error notifying systemd: %v", err)
+	}
+
+	ticker := time.NewTicker(systemdStatusInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := notifier.Status(gatewayStatus(ctx, mqttClient, commands)); err != nil {
+			logger.Warn("Error notifying systemd: %v", err)
+		}
+	}
+}
+
+// gatewayStatus describes the gateway in one line, e.g. "12 devices, 10 online, MQTT connected".
+func gatewayStatus(ctx context.Context, mqttClient mqtt.MqttClient, commands router.CommandService) string {
+	devices, err := commands.Devices(ctx, mqtt.DevicesFilter{})
+	if err != nil {
+		return fmt.Sprintf("error reading devices: %v", err)
+	}
+
+	online := true
+	onlineDevices, err := commands.Devices(ctx, mqtt.DevicesFilter{Online: &online})
+	if err != nil {
+		return fmt.Sprintf("error reading devices: %v", err)
+	}
+
+	mqttState := "connected"
+	if !mqttClient.IsConnected() {
+		mqttState = "disconnected"
+	}
+
+	return fmt.Sprintf("%v devices, %v online, MQTT %v", len(devices), len(onlineDevices), mqttState)
+}
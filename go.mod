@@ -4,10 +4,13 @@ go 1.16
 
 require (
 	github.com/creack/goselect v0.1.2 // indirect
+	github.com/eclipse/paho.golang v0.11.0
 	github.com/eclipse/paho.mqtt.golang v1.3.5
-	github.com/gorilla/websocket v1.5.0 // indirect
+	github.com/gorilla/websocket v1.5.0
 	github.com/kr/text v0.2.0 // indirect
+	github.com/mochi-co/mqtt v1.1.1
 	github.com/niemeyer/pretty v0.0.0-20200227124842-a10e7caefd8e // indirect
+	github.com/prometheus/client_golang v1.12.2
 	github.com/shimmeringbee/logwrap v0.1.3 // indirect
 	github.com/shimmeringbee/zcl v0.0.0-20210228205506-7c69558adab2
 	github.com/shimmeringbee/zigbee v0.0.0-20210427191220-76676a734066
@@ -15,10 +18,11 @@ require (
 	github.com/stretchr/objx v0.4.0 // indirect
 	github.com/stretchr/testify v1.7.1
 	go.bug.st/serial.v1 v0.0.0-20191202182710-24a6610f0541
+	go.etcd.io/bbolt v1.3.6
 	golang.org/x/net v0.0.0-20220520000938-2e3eb7b945c2 // indirect
 	golang.org/x/sync v0.0.0-20220513210516-0976fa681c29 // indirect
 	golang.org/x/sys v0.0.0-20221010170243-090e33056c14 // indirect
 	gopkg.in/check.v1 v1.0.0-20200227125254-8fa46927fb4f // indirect
 	gopkg.in/yaml.v2 v2.4.0
-	gopkg.in/yaml.v3 v3.0.0 // indirect
+	gopkg.in/yaml.v3 v3.0.0
 )
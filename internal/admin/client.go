@@ -0,0 +1,85 @@
+package admin
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// Client calls methods of a running gateway over its admin socket, calls are sent one at a time.
+type Client struct {
+	mtx    sync.Mutex
+	conn   net.Conn
+	dec    *json.Decoder
+	nextID int
+}
+
+func Dial(socketPath string) (*Client, error) {
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to admin socket: %w", err)
+	}
+
+	return &Client{
+		conn: conn,
+		dec:  json.NewDecoder(bufio.NewReader(conn)),
+	}, nil
+}
+
+// Call runs the method and decodes its result into result, errors of the gateway are returned as *Error.
+func (c *Client) Call(ctx context.Context, method string, params interface{}, result interface{}) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	rawParams, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+
+	c.nextID++
+	id := json.RawMessage(strconv.Itoa(c.nextID))
+
+	// zero deadline of context without one means no deadline
+	deadline, _ := ctx.Deadline()
+	c.conn.SetDeadline(deadline)
+
+	err = json.NewEncoder(c.conn).Encode(Request{
+		JSONRPC: jsonRPCVersion,
+		ID:      id,
+		Method:  method,
+		Params:  rawParams,
+	})
+	if err != nil {
+		return fmt.Errorf("calling '%v': %w", method, err)
+	}
+
+	for {
+		var resp Response
+		if err := c.dec.Decode(&resp); err != nil {
+			return fmt.Errorf("reading answer of '%v': %w", method, err)
+		}
+
+		// answers of earlier calls, which timed out on the client side, are skipped
+		if string(resp.ID) != string(id) {
+			continue
+		}
+
+		if resp.Error != nil {
+			return resp.Error
+		}
+
+		if result == nil {
+			return nil
+		}
+
+		return json.Unmarshal(resp.Result, result)
+	}
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,215 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/supby/gigbee2mqtt/internal/metrics"
+	"github.com/supby/gigbee2mqtt/internal/mqtt"
+	"github.com/supby/gigbee2mqtt/internal/router"
+	"github.com/supby/gigbee2mqtt/internal/types"
+)
+
+// Methods are named after MQTT topics of the same commands.
+const (
+	RPC_GET_DEVICES    = router.MQTT_GATEWAY + "." + router.MQTT_GET_DEVICES
+	RPC_SET_DEVICE     = router.MQTT_GATEWAY + "." + router.MQTT_SET_DEVICE
+	RPC_GET_CONFIG     = router.MQTT_GATEWAY + "." + router.MQTT_GET_CONFIG
+	RPC_SET_CONFIG     = router.MQTT_GATEWAY + "." + router.MQTT_SET_CONFIG
+	RPC_DEVICE_SET     = router.MQTT_DEVICE + "." + router.MQTT_DEVICE_SET
+	RPC_DEVICE_GET     = router.MQTT_DEVICE + "." + router.MQTT_DEVICE_GET
+	RPC_DEVICE_WRITE   = router.MQTT_DEVICE + "." + router.MQTT_DEVICE_WRITE
+	RPC_DEVICE_EXPLORE = router.MQTT_DEVICE + "." + router.MQTT_DEVICE_EXPLORE
+	RPC_DEVICE_REMOVE  = router.MQTT_DEVICE + "." + router.MQTT_DEVICE_REMOVE
+	RPC_DEVICE_HISTORY = router.MQTT_DEVICE + "." + router.MQTT_DEVICE_GET_HISTORY
+	RPC_DEVICE_STATE   = router.MQTT_DEVICE + ".state"
+	RPC_DIAGNOSTICS    = "diag.status"
+	RPC_NODES          = "diag.nodes"
+)
+
+// commandTimeout is how long device methods wait for the device to answer when Timeout param is empty.
+var commandTimeout = 10 * time.Second
+
+// DeviceParams select the device of device.* methods, the command message is read from the same params object.
+type DeviceParams struct {
+	Device  string // IEEE address as in MQTT topics, e.g. 0x842e14fffe05b879
+	Timeout string // how long to wait for the device to answer, e.g. 30s
+}
+
+// Diagnostics is the gateway internal state returned by diag.status.
+type Diagnostics struct {
+	Goroutines          int
+	PendingRequests     []string // admin socket and HTTP commands waiting for devices
+	PendingResponses    []string // MQTT 5 requests waiting for devices
+	MQTTConnected       bool
+	MQTTQueueLength     int   // messages buffered while the broker is unreachable
+	EventLoopQueueDepth int64 // Zigbee events still being processed
+	Nodes               int   // nodes in the coordinator node table
+}
+
+// DiagnosticsSources are parts of the gateway diag.* methods read from.
+type DiagnosticsSources struct {
+	Commands     router.CommandService
+	MQTTRouter   router.MQTTRouter
+	MQTTClient   mqtt.MqttClient
+	ZigbeeRouter router.ZigbeeRouter
+}
+
+// RegisterCommands registers methods running the same commands as MQTT topics do.
+func RegisterCommands(s Server, commands router.CommandService) {
+	s.Handle(RPC_GET_DEVICES, func(ctx context.Context, params json.RawMessage) (interface{}, error) {
+		var filter mqtt.DevicesFilter
+		if err := decodeParams(params, &filter); err != nil {
+			return nil, err
+		}
+
+		return commands.Devices(ctx, filter)
+	})
+	s.Handle(RPC_SET_DEVICE, func(ctx context.Context, params json.RawMessage) (interface{}, error) {
+		var msg mqtt.SetDeviceMessage
+		if err := decodeParams(params, &msg); err != nil {
+			return nil, err
+		}
+
+		device, err := commands.SetDevice(ctx, msg)
+		return device, commandError(err)
+	})
+	s.Handle(RPC_GET_CONFIG, func(ctx context.Context, params json.RawMessage) (interface{}, error) {
+		return commands.Config(), nil
+	})
+	s.Handle(RPC_SET_CONFIG, func(ctx context.Context, params json.RawMessage) (interface{}, error) {
+		result, err := commands.SetConfig(ctx, params)
+		return result, commandError(err)
+	})
+
+	s.Handle(RPC_DEVICE_SET, deviceMethod(commands, func(ctx context.Context, deviceAddr uint64, params json.RawMessage) (interface{}, error) {
+		var msg mqtt.DeviceSetMessage
+		if err := decodeParams(params, &msg); err != nil {
+			return nil, err
+		}
+
+		return commands.Set(ctx, deviceAddr, msg)
+	}))
+	s.Handle(RPC_DEVICE_GET, deviceMethod(commands, func(ctx context.Context, deviceAddr uint64, params json.RawMessage) (interface{}, error) {
+		var msg mqtt.DeviceGetMessage
+		if err := decodeParams(params, &msg); err != nil {
+			return nil, err
+		}
+
+		return commands.Get(ctx, deviceAddr, msg)
+	}))
+	s.Handle(RPC_DEVICE_WRITE, deviceMethod(commands, func(ctx context.Context, deviceAddr uint64, params json.RawMessage) (interface{}, error) {
+		var msg mqtt.DeviceWriteMessage
+		if err := decodeParams(params, &msg); err != nil {
+			return nil, err
+		}
+
+		return commands.Write(ctx, deviceAddr, msg)
+	}))
+	s.Handle(RPC_DEVICE_EXPLORE, deviceMethod(commands, func(ctx context.Context, deviceAddr uint64, params json.RawMessage) (interface{}, error) {
+		return commands.Explore(ctx, deviceAddr)
+	}))
+	s.Handle(RPC_DEVICE_REMOVE, deviceMethod(commands, func(ctx context.Context, deviceAddr uint64, params json.RawMessage) (interface{}, error) {
+		var msg mqtt.DeviceRemoveMessage
+		if err := decodeParams(params, &msg); err != nil {
+			return nil, err
+		}
+
+		return nil, commands.RemoveDevice(ctx, types.DeviceRemoveMessage{
+			IEEEAddress: deviceAddr,
+			Force:       msg.Force,
+		})
+	}))
+	s.Handle(RPC_DEVICE_HISTORY, deviceMethod(commands, func(ctx context.Context, deviceAddr uint64, params json.RawMessage) (interface{}, error) {
+		var query mqtt.DeviceHistoryQueryMessage
+		if err := decodeParams(params, &query); err != nil {
+			return nil, err
+		}
+
+		return commands.History(ctx, deviceAddr, query)
+	}))
+	s.Handle(RPC_DEVICE_STATE, deviceMethod(commands, func(ctx context.Context, deviceAddr uint64, params json.RawMessage) (interface{}, error) {
+		return commands.State(deviceAddr), nil
+	}))
+}
+
+// deviceMethod reads DeviceParams, checks that the device exists and limits waiting for its answer.
+func deviceMethod(commands router.CommandService, handler func(ctx context.Context, deviceAddr uint64, params json.RawMessage) (interface{}, error)) Handler {
+	return func(ctx context.Context, params json.RawMessage) (interface{}, error) {
+		var p DeviceParams
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+
+		deviceAddr, err := strconv.ParseUint(strings.Replace(p.Device, "0x", "", -1), 16, 64)
+		if err != nil {
+			return nil, &Error{Code: CodeInvalidParams, Message: fmt.Sprintf("invalid device address '%v'", p.Device)}
+		}
+
+		if _, err := commands.Device(ctx, deviceAddr); err != nil {
+			return nil, commandError(err)
+		}
+
+		timeout := commandTimeout
+		if p.Timeout != "" {
+			timeout, err = time.ParseDuration(p.Timeout)
+			if err != nil {
+				return nil, &Error{Code: CodeInvalidParams, Message: fmt.Sprintf("invalid timeout: %v", err)}
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+
+		result, err := handler(ctx, deviceAddr, params)
+		return result, commandError(err)
+	}
+}
+
+// commandError maps errors of CommandService to JSON-RPC error codes, other errors are left as CodeServerError.
+func commandError(err error) error {
+	code := 0
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, router.ErrDeviceNotFound):
+		code = CodeDeviceNotFound
+	case errors.Is(err, router.ErrNoResponse):
+		code = CodeNoResponse
+	case errors.Is(err, router.ErrDeviceNotRemoved), errors.Is(err, router.ErrDeviceUnreachable):
+		code = CodeDeviceUnreachable
+	case errors.Is(err, router.ErrCoordinatorUnavailable):
+		code = CodeCoordinatorUnavailable
+	case errors.Is(err, router.ErrInvalidConfig), errors.Is(err, router.ErrInvalidCommand):
+		code = CodeInvalidParams
+	default:
+		return err
+	}
+
+	return &Error{Code: code, Message: err.Error()}
+}
+
+// RegisterDiagnostics registers diag.* methods, they read only in-process state and answer also while
+// MQTT broker or the coordinator is unreachable.
+func RegisterDiagnostics(s Server, sources DiagnosticsSources) {
+	s.Handle(RPC_DIAGNOSTICS, func(ctx context.Context, params json.RawMessage) (interface{}, error) {
+		return Diagnostics{
+			Goroutines:          runtime.NumGoroutine(),
+			PendingRequests:     sources.Commands.PendingRequests(),
+			PendingResponses:    sources.MQTTRouter.PendingResponses(),
+			MQTTConnected:       sources.MQTTClient.IsConnected(),
+			MQTTQueueLength:     sources.MQTTClient.QueueLength(),
+			EventLoopQueueDepth: metrics.EventLoopQueueDepth(),
+			Nodes:               len(sources.ZigbeeRouter.Nodes()),
+		}, nil
+	})
+	s.Handle(RPC_NODES, func(ctx context.Context, params json.RawMessage) (interface{}, error) {
+		return sources.ZigbeeRouter.Nodes(), nil
+	})
+}
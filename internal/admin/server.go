@@ -0,0 +1,299 @@
+package admin
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/supby/gigbee2mqtt/internal/configuration"
+	"github.com/supby/gigbee2mqtt/internal/logger"
+)
+
+const jsonRPCVersion = "2.0"
+
+// JSON-RPC 2.0 error codes.
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeServerError    = -32000
+)
+
+// Server error codes of gateway commands, in the range JSON-RPC 2.0 reserves for implementations.
+const (
+	CodeDeviceNotFound         = -32001
+	CodeNoResponse             = -32002 // the device did not answer in time
+	CodeDeviceUnreachable      = -32003 // the message could not be sent or the device did not leave
+	CodeCoordinatorUnavailable = -32004
+)
+
+// Request is JSON-RPC 2.0 request, requests without ID are notifications and are not answered.
+type Request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type Response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%v (code %v)", e.Message, e.Code)
+}
+
+// Handler runs the method, returning *Error sets the error code, other errors are reported as CodeServerError.
+type Handler func(ctx context.Context, params json.RawMessage) (interface{}, error)
+
+// Server serves newline delimited JSON-RPC 2.0 on Unix domain socket, methods are registered before Start.
+type Server interface {
+	Handle(method string, handler Handler)
+	// SubscribeOnError is called when the server stops accepting connections on its own, e.g. the listener fails.
+	SubscribeOnError(cb func(err error))
+	Start() error
+	Addr() string
+	Close() error
+}
+
+func NewServer(config *configuration.AdminConfiguration, logLevel int) Server {
+	return &server{
+		config:   config,
+		handlers: map[string]Handler{},
+		conns:    map[net.Conn]struct{}{},
+		logger:   logger.GetLogger("[Admin Socket]", logLevel),
+	}
+}
+
+type server struct {
+	config   *configuration.AdminConfiguration
+	handlers map[string]Handler
+	listener net.Listener
+	logger   logger.Logger
+	connsMtx sync.Mutex
+	conns    map[net.Conn]struct{}
+	wg       sync.WaitGroup
+	onError  func(err error)
+}
+
+func (s *server) Handle(method string, handler Handler) {
+	s.handlers[method] = handler
+}
+
+func (s *server) SubscribeOnError(cb func(err error)) {
+	s.onError = cb
+}
+
+func (s *server) Start() error {
+	path := s.config.SocketPath
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	// socket file left by a crashed gateway is removed, but not the one of a running gateway
+	if _, err := os.Stat(path); err == nil {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return fmt.Errorf("socket '%v' is used by another process", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+
+	// the socket gives full control over the network, so only the owner may connect
+	if err := os.Chmod(path, 0600); err != nil {
+		l.Close()
+		return err
+	}
+	s.listener = l
+
+	s.logger.Info("Listening on %v", path)
+
+	s.wg.Add(1)
+	go s.acceptLoop()
+
+	return nil
+}
+
+func (s *server) Addr() string {
+	if s.listener == nil {
+		return ""
+	}
+
+	return s.listener.Addr().String()
+}
+
+func (s *server) Close() error {
+	if s.listener == nil {
+		return nil
+	}
+
+	err := s.listener.Close()
+
+	s.connsMtx.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.connsMtx.Unlock()
+
+	s.wg.Wait()
+
+	return err
+}
+
+func (s *server) acceptLoop() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				s.logger.Error("Error accepting admin connection: %v", err)
+				if s.onError != nil {
+					s.onError(fmt.Errorf("admin socket: %w", err))
+				}
+			}
+			return
+		}
+
+		s.connsMtx.Lock()
+		s.conns[conn] = struct{}{}
+		s.connsMtx.Unlock()
+
+		s.wg.Add(1)
+		go s.serveConn(conn)
+	}
+}
+
+// serveConn runs requests of the connection concurrently, so a slow device does not block other requests.
+func (s *server) serveConn(conn net.Conn) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var writeMtx sync.Mutex
+	var requests sync.WaitGroup
+	enc := json.NewEncoder(conn)
+
+	defer func() {
+		cancel()
+		requests.Wait()
+
+		s.connsMtx.Lock()
+		delete(s.conns, conn)
+		s.connsMtx.Unlock()
+
+		conn.Close()
+		s.wg.Done()
+	}()
+
+	write := func(resp Response) {
+		writeMtx.Lock()
+		defer writeMtx.Unlock()
+
+		if err := enc.Encode(resp); err != nil {
+			s.logger.Warn("Error writing admin response: %v", err)
+		}
+	}
+
+	dec := json.NewDecoder(bufio.NewReader(conn))
+	for {
+		var req Request
+		if err := dec.Decode(&req); err != nil {
+			var syntaxErr *json.SyntaxError
+			if errors.As(err, &syntaxErr) {
+				write(Response{
+					JSONRPC: jsonRPCVersion,
+					ID:      json.RawMessage("null"),
+					Error:   &Error{Code: CodeParseError, Message: err.Error()},
+				})
+			}
+			return
+		}
+
+		requests.Add(1)
+		go func() {
+			defer requests.Done()
+
+			resp := s.handle(ctx, req)
+			if len(req.ID) > 0 {
+				write(resp)
+			}
+		}()
+	}
+}
+
+func (s *server) handle(ctx context.Context, req Request) Response {
+	resp := Response{
+		JSONRPC: jsonRPCVersion,
+		ID:      req.ID,
+	}
+	if len(resp.ID) == 0 {
+		resp.ID = json.RawMessage("null")
+	}
+
+	if req.JSONRPC != jsonRPCVersion || req.Method == "" {
+		resp.Error = &Error{Code: CodeInvalidRequest, Message: "invalid JSON-RPC 2.0 request"}
+		return resp
+	}
+
+	handler, ok := s.handlers[req.Method]
+	if !ok {
+		resp.Error = &Error{Code: CodeMethodNotFound, Message: fmt.Sprintf("method '%v' not found", req.Method)}
+		return resp
+	}
+
+	s.logger.Debug("Admin request: %v", req.Method)
+
+	result, err := handler(ctx, req.Params)
+	if err != nil {
+		var rpcErr *Error
+		if !errors.As(err, &rpcErr) {
+			rpcErr = &Error{Code: CodeServerError, Message: err.Error()}
+		}
+		resp.Error = rpcErr
+		return resp
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		resp.Error = &Error{Code: CodeServerError, Message: err.Error()}
+		return resp
+	}
+	resp.Result = data
+
+	return resp
+}
+
+// decodeParams reads params of the method, missing params leave v unchanged.
+func decodeParams(params json.RawMessage, v interface{}) error {
+	if len(params) == 0 || string(params) == "null" {
+		return nil
+	}
+
+	if err := json.Unmarshal(params, v); err != nil {
+		return &Error{Code: CodeInvalidParams, Message: err.Error()}
+	}
+
+	return nil
+}
@@ -0,0 +1,185 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/supby/gigbee2mqtt/internal/configuration"
+	"github.com/supby/gigbee2mqtt/internal/db"
+	"github.com/supby/gigbee2mqtt/internal/mqtt"
+	"github.com/supby/gigbee2mqtt/internal/router"
+)
+
+const testDevice uint64 = 0x842e14fffe05b879
+
+// testCommands implements the methods used by tests, others panic on nil CommandService.
+type testCommands struct {
+	router.CommandService
+	setCalls []mqtt.DeviceSetMessage
+}
+
+func (c *testCommands) Devices(ctx context.Context, filter mqtt.DevicesFilter) ([]db.Device, error) {
+	return []db.Device{{IEEEAddress: testDevice, ModelID: filter.ModelID}}, nil
+}
+
+func (c *testCommands) Device(ctx context.Context, deviceAddr uint64) (db.Device, error) {
+	if deviceAddr != testDevice {
+		return db.Device{}, fmt.Errorf("0x%x: %w", deviceAddr, router.ErrDeviceNotFound)
+	}
+
+	return db.Device{IEEEAddress: deviceAddr}, nil
+}
+
+func (c *testCommands) Set(ctx context.Context, deviceAddr uint64, msg mqtt.DeviceSetMessage) (interface{}, error) {
+	c.setCalls = append(c.setCalls, msg)
+
+	if msg.ClusterID == 0 {
+		<-ctx.Done()
+		return nil, router.ErrNoResponse
+	}
+	if msg.ClusterID == 0xffff {
+		return nil, router.ErrCoordinatorUnavailable
+	}
+
+	return mqtt.DeviceMessage{
+		IEEEAddress: deviceAddr,
+		Message:     mqtt.DeviceDefaultResponseMessage{ClusterID: msg.ClusterID},
+	}, nil
+}
+
+func startTestServer(t *testing.T, commands router.CommandService) (Server, *Client) {
+	s := NewServer(&configuration.AdminConfiguration{
+		Enabled:    true,
+		SocketPath: filepath.Join(t.TempDir(), "admin.sock"),
+	}, 0)
+	RegisterCommands(s, commands)
+	assert.NoError(t, s.Start())
+	t.Cleanup(func() { s.Close() })
+
+	client, err := Dial(s.Addr())
+	assert.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+
+	return s, client
+}
+
+func TestServerSocketMode(t *testing.T) {
+	s, _ := startTestServer(t, &testCommands{})
+
+	info, err := os.Stat(s.Addr())
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
+
+func TestServerRemovesStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "admin.sock")
+	assert.NoError(t, os.WriteFile(path, nil, 0600))
+
+	s := NewServer(&configuration.AdminConfiguration{SocketPath: path}, 0)
+	assert.NoError(t, s.Start())
+	defer s.Close()
+
+	// a second gateway must not take over the socket of the running one
+	assert.Error(t, NewServer(&configuration.AdminConfiguration{SocketPath: path}, 0).Start())
+}
+
+func TestCallGetDevices(t *testing.T) {
+	_, client := startTestServer(t, &testCommands{})
+
+	var devices []db.Device
+	err := client.Call(context.Background(), RPC_GET_DEVICES, mqtt.DevicesFilter{ModelID: "bulb"}, &devices)
+	assert.NoError(t, err)
+	assert.Equal(t, []db.Device{{IEEEAddress: testDevice, ModelID: "bulb"}}, devices)
+}
+
+func TestCallDeviceSet(t *testing.T) {
+	commands := &testCommands{}
+	_, client := startTestServer(t, commands)
+
+	var answer struct {
+		IEEEAddress uint64
+		Message     mqtt.DeviceDefaultResponseMessage
+	}
+	err := client.Call(context.Background(), RPC_DEVICE_SET, map[string]interface{}{
+		"Device":            fmt.Sprintf("0x%x", testDevice),
+		"ClusterID":         6,
+		"Endpoint":          1,
+		"CommandIdentifier": 1,
+	}, &answer)
+	assert.NoError(t, err)
+	assert.Equal(t, testDevice, answer.IEEEAddress)
+	assert.Equal(t, uint16(6), answer.Message.ClusterID)
+	assert.Equal(t, mqtt.DeviceSetMessage{ClusterID: 6, Endpoint: 1, CommandIdentifier: 1}, commands.setCalls[0])
+}
+
+func TestCallDeviceTimeout(t *testing.T) {
+	_, client := startTestServer(t, &testCommands{})
+
+	err := client.Call(context.Background(), RPC_DEVICE_SET, DeviceParams{
+		Device:  fmt.Sprintf("0x%x", testDevice),
+		Timeout: "50ms",
+	}, nil)
+
+	var rpcErr *Error
+	assert.True(t, errors.As(err, &rpcErr))
+	assert.Equal(t, CodeNoResponse, rpcErr.Code)
+	assert.Equal(t, router.ErrNoResponse.Error(), rpcErr.Message)
+}
+
+func TestCallErrors(t *testing.T) {
+	_, client := startTestServer(t, &testCommands{})
+
+	var rpcErr *Error
+
+	err := client.Call(context.Background(), "gateway.unknown", nil, nil)
+	assert.True(t, errors.As(err, &rpcErr))
+	assert.Equal(t, CodeMethodNotFound, rpcErr.Code)
+
+	err = client.Call(context.Background(), RPC_DEVICE_SET, DeviceParams{Device: "kitchen"}, nil)
+	assert.True(t, errors.As(err, &rpcErr))
+	assert.Equal(t, CodeInvalidParams, rpcErr.Code)
+
+	err = client.Call(context.Background(), RPC_DEVICE_SET, DeviceParams{Device: "0x1"}, nil)
+	assert.True(t, errors.As(err, &rpcErr))
+	assert.Equal(t, CodeDeviceNotFound, rpcErr.Code)
+
+	err = client.Call(context.Background(), RPC_DEVICE_SET, map[string]interface{}{
+		"Device":    fmt.Sprintf("0x%x", testDevice),
+		"ClusterID": 0xffff,
+	}, nil)
+	assert.True(t, errors.As(err, &rpcErr))
+	assert.Equal(t, CodeCoordinatorUnavailable, rpcErr.Code)
+	assert.Equal(t, router.ErrCoordinatorUnavailable.Error(), rpcErr.Message)
+}
+
+func TestServerConcurrentRequests(t *testing.T) {
+	s, _ := startTestServer(t, &testCommands{})
+
+	conn, err := net.Dial("unix", s.Addr())
+	assert.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// the slow request must not hold back the answer of the one sent after it
+	fmt.Fprintf(conn, `{"jsonrpc":"2.0","id":1,"method":"%v","params":{"Device":"0x%x","Timeout":"1s"}}`+"\n", RPC_DEVICE_SET, testDevice)
+	fmt.Fprintf(conn, `{"jsonrpc":"2.0","id":2,"method":"%v"}`+"\n", RPC_GET_DEVICES)
+
+	dec := json.NewDecoder(conn)
+
+	var resp Response
+	assert.NoError(t, dec.Decode(&resp))
+	assert.Equal(t, "2", string(resp.ID))
+	assert.Nil(t, resp.Error)
+
+	assert.NoError(t, dec.Decode(&resp))
+	assert.Equal(t, "1", string(resp.ID))
+	assert.NotNil(t, resp.Error)
+}
@@ -0,0 +1,80 @@
+package broker
+
+import (
+	"crypto/subtle"
+	"fmt"
+
+	mqttserver "github.com/mochi-co/mqtt/server"
+	"github.com/mochi-co/mqtt/server/listeners"
+	"github.com/supby/gigbee2mqtt/internal/configuration"
+	"github.com/supby/gigbee2mqtt/internal/logger"
+)
+
+const (
+	listenerID = "gigbee2mqtt-tcp"
+)
+
+// Broker is in-process MQTT broker the gateway can use instead of an external one.
+type Broker interface {
+	Close() error
+}
+
+func NewBroker(config *configuration.EmbeddedBrokerConfiguration, logLevel int) (Broker, error) {
+	ret := &embeddedBroker{
+		server: mqttserver.New(),
+		logger: logger.GetLogger("[MQTT Broker]", logLevel),
+	}
+
+	tcp := listeners.NewTCP(listenerID, fmt.Sprintf("%s:%d", config.Address, config.Port))
+	err := ret.server.AddListener(tcp, &listeners.Config{
+		Auth: &credentialsAuth{
+			username: []byte(config.Username),
+			password: []byte(config.Password),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = ret.server.Serve()
+	if err != nil {
+		return nil, err
+	}
+
+	ret.logger.Info("Embedded MQTT broker is listening on '%v:%v'", config.Address, config.Port)
+
+	return ret, nil
+}
+
+type embeddedBroker struct {
+	server *mqttserver.Server
+	logger logger.Logger
+}
+
+func (b *embeddedBroker) Close() error {
+	b.logger.Info("Stopping embedded MQTT broker")
+
+	return b.server.Close()
+}
+
+// credentialsAuth allows everybody when username is not configured,
+// otherwise only clients with matching username and password.
+type credentialsAuth struct {
+	username []byte
+	password []byte
+}
+
+func (a *credentialsAuth) Authenticate(user, password []byte) bool {
+	if len(a.username) == 0 {
+		return true
+	}
+
+	userOk := subtle.ConstantTimeCompare(user, a.username) == 1
+	passwordOk := subtle.ConstantTimeCompare(password, a.password) == 1
+
+	return userOk && passwordOk
+}
+
+func (a *credentialsAuth) ACL(user []byte, topic string, write bool) bool {
+	return true
+}
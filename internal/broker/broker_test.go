@@ -0,0 +1,72 @@
+package broker
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	mqttlib "github.com/eclipse/paho.mqtt.golang"
+	"github.com/stretchr/testify/assert"
+	"github.com/supby/gigbee2mqtt/internal/configuration"
+)
+
+func freePort(t *testing.T) uint16 {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer l.Close()
+
+	return uint16(l.Addr().(*net.TCPAddr).Port)
+}
+
+func connect(port uint16, username string, password string) error {
+	opts := mqttlib.NewClientOptions()
+	opts.AddBroker(fmt.Sprintf("tcp://127.0.0.1:%d", port))
+	opts.SetClientID(fmt.Sprintf("test-%v", time.Now().UnixNano()))
+	opts.SetUsername(username)
+	opts.SetPassword(password)
+	opts.SetConnectTimeout(5 * time.Second)
+
+	client := mqttlib.NewClient(opts)
+	token := client.Connect()
+	token.WaitTimeout(5 * time.Second)
+	if token.Error() != nil {
+		return token.Error()
+	}
+
+	client.Disconnect(0)
+
+	return nil
+}
+
+func TestBrokerAuth(t *testing.T) {
+	cfg := configuration.EmbeddedBrokerConfiguration{
+		Enabled:  true,
+		Address:  "127.0.0.1",
+		Port:     freePort(t),
+		Username: "gateway",
+		Password: "secret",
+	}
+
+	b, err := NewBroker(&cfg, 0)
+	assert.NoError(t, err)
+	defer b.Close()
+
+	assert.NoError(t, connect(cfg.Port, "gateway", "secret"))
+	assert.Error(t, connect(cfg.Port, "gateway", "wrong"))
+	assert.Error(t, connect(cfg.Port, "", ""))
+}
+
+func TestBrokerAnonymous(t *testing.T) {
+	cfg := configuration.EmbeddedBrokerConfiguration{
+		Enabled: true,
+		Address: "127.0.0.1",
+		Port:    freePort(t),
+	}
+
+	b, err := NewBroker(&cfg, 0)
+	assert.NoError(t, err)
+	defer b.Close()
+
+	assert.NoError(t, connect(cfg.Port, "", ""))
+}
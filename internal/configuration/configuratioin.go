@@ -1,8 +1,12 @@
 package configuration
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"os"
+	"reflect"
+	"sync"
 
 	"github.com/supby/gigbee2mqtt/internal/utils"
 	"gopkg.in/yaml.v2"
@@ -10,41 +14,305 @@ import (
 
 type configurationService struct {
 	filename      string
+	stateFilename string
+	mtx           sync.Mutex
+	configuration Configuration // loaded configuration with State applied
+	loaded        loadedConfiguration
+	state         State
+}
+
+// loadedConfiguration keeps values set by environment or read from secret files apart,
+// so they are not written to the configuration file.
+type loadedConfiguration struct {
 	configuration Configuration
+	file          Configuration // content of the file
+	overrides     [][]int       // fields of configuration not taken from the file
+}
+
+// permitJoinIndex is the field kept in State instead of the configuration file.
+var permitJoinIndex = []int{fieldIndex("PermitJoin")}
+
+func fieldIndex(name string) int {
+	f, _ := reflect.TypeOf(Configuration{}).FieldByName(name)
+	return f.Index[0]
 }
 
 func (cs *configurationService) GetConfiguration() Configuration {
+	cs.mtx.Lock()
+	defer cs.mtx.Unlock()
+
 	return cs.configuration
 }
 
+// Update keeps permit join in the state file and writes other changed fields to the configuration file,
+// fields set by environment or secret files are not written.
 func (cs *configurationService) Update(updatedConfig Configuration) error {
-	buf, err := yaml.Marshal(&updatedConfig)
-	if err != nil {
+	cs.mtx.Lock()
+	defer cs.mtx.Unlock()
+
+	if err := cs.save(updatedConfig); err != nil {
 		return err
 	}
 
-	err = os.WriteFile(cs.filename, buf, 0666)
+	cs.configuration = updatedConfig
+
+	return nil
+}
+
+// Apply validates the changed configuration and stores it like Update, only sections which can change
+// at runtime are applied to the running configuration, as on Reload.
+func (cs *configurationService) Apply(updatedConfig Configuration) (ReloadResult, error) {
+	if err := Validate(updatedConfig); err != nil {
+		return ReloadResult{}, err
+	}
+
+	cs.mtx.Lock()
+	defer cs.mtx.Unlock()
+
+	result, err := Merge(cs.configuration, updatedConfig)
+	if err != nil {
+		return ReloadResult{}, err
+	}
+
+	if err := cs.save(updatedConfig); err != nil {
+		return ReloadResult{}, err
+	}
+
+	cs.configuration = result.Current
+
+	return result, nil
+}
+
+func (cs *configurationService) save(updatedConfig Configuration) error {
+	if updatedConfig.PermitJoin != cs.configuration.PermitJoin {
+		state := cs.state
+		state.PermitJoin = &updatedConfig.PermitJoin
+		if err := saveState(cs.stateFilename, state); err != nil {
+			return err
+		}
+		cs.state = state
+	}
+
+	changed, err := changedFields(cs.configuration, updatedConfig)
 	if err != nil {
 		return err
 	}
 
-	cs.configuration = updatedConfig
+	loaded := cs.loaded
+	loadedValue := reflect.ValueOf(&loaded.configuration).Elem()
+	fileValue := reflect.ValueOf(&loaded.file).Elem()
+
+	var fileFields [][]int
+	for _, index := range changed {
+		if indexEqual(index, permitJoinIndex) {
+			continue
+		}
+
+		value := reflect.ValueOf(updatedConfig).FieldByIndex(index)
+		loadedValue.FieldByIndex(index).Set(value)
+
+		if !containsIndex(cs.loaded.overrides, index) {
+			fileValue.FieldByIndex(index).Set(value)
+			fileFields = append(fileFields, index)
+		}
+	}
+
+	if len(fileFields) > 0 {
+		data, err := os.ReadFile(cs.filename)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		data, err = patchDocument(data, loaded.file, fileFields)
+		if err != nil {
+			return fmt.Errorf("updating '%v': %w", cs.filename, err)
+		}
+
+		if err := writeFileAtomic(cs.filename, data, configFileMode); err != nil {
+			return err
+		}
+	}
+
+	cs.loaded = loaded
+
+	return nil
+}
+
+func (cs *configurationService) GetState() State {
+	cs.mtx.Lock()
+	defer cs.mtx.Unlock()
+
+	return cs.state
+}
+
+func (cs *configurationService) UpdateState(update func(state *State)) error {
+	cs.mtx.Lock()
+	defer cs.mtx.Unlock()
+
+	state := cs.state
+	update(&state)
+
+	if err := saveState(cs.stateFilename, state); err != nil {
+		return err
+	}
+	cs.state = state
 
 	return nil
 }
 
+func (cs *configurationService) Reload() (ReloadResult, error) {
+	loaded, err := load(cs.filename)
+	if err != nil {
+		return ReloadResult{}, err
+	}
+
+	cs.mtx.Lock()
+	defer cs.mtx.Unlock()
+
+	// permit join set at runtime is kept, unless it was changed in the file
+	reloaded := loaded.configuration
+	permitJoinChanged := reloaded.PermitJoin != cs.loaded.configuration.PermitJoin
+	if !permitJoinChanged {
+		reloaded.PermitJoin = cs.configuration.PermitJoin
+	}
+
+	result, err := Merge(cs.configuration, reloaded)
+	if err != nil {
+		return result, err
+	}
+
+	if permitJoinChanged && cs.state.PermitJoin != nil {
+		state := cs.state
+		state.PermitJoin = nil
+		if err := saveState(cs.stateFilename, state); err != nil {
+			return ReloadResult{}, err
+		}
+		cs.state = state
+	}
+
+	cs.configuration = result.Current
+	cs.loaded = loaded
+
+	return result, nil
+}
+
 func Init(filename string) (ConfigurationService, error) {
+	loaded, err := load(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	cs := &configurationService{
+		filename:      filename,
+		stateFilename: stateFilename(loaded.configuration),
+		configuration: loaded.configuration,
+		loaded:        loaded,
+	}
+
+	cs.state, err = loadState(cs.stateFilename)
+	if err != nil {
+		return nil, fmt.Errorf("reading '%v': %w", cs.stateFilename, err)
+	}
+
+	if cs.state.PermitJoin != nil {
+		cs.configuration.PermitJoin = *cs.state.PermitJoin
+	}
+
+	return cs, nil
+}
+
+// Patch returns cfg with fields set in JSON object patch, e.g. {"Availability": {"ActiveTimeoutInSeconds": 300}},
+// fields missing in patch are left unchanged.
+func Patch(cfg Configuration, patch []byte) (Configuration, error) {
+	// decoding reuses maps and slices of cfg, which are shared with the running configuration
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return cfg, err
+	}
+
+	var patched Configuration
+	if err := json.Unmarshal(data, &patched); err != nil {
+		return cfg, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(patch))
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(&patched); err != nil {
+		return cfg, err
+	}
+
+	// secrets sent back as returned by Redacted keep their values
+	if patched.MqttConfiguration.Password == RedactedValue {
+		patched.MqttConfiguration.Password = cfg.MqttConfiguration.Password
+	}
+	if patched.EmbeddedBroker.Password == RedactedValue {
+		patched.EmbeddedBroker.Password = cfg.EmbeddedBroker.Password
+	}
+	if patched.ZNetworkConfiguration.NetworkKey == [16]byte{} {
+		patched.ZNetworkConfiguration.NetworkKey = cfg.ZNetworkConfiguration.NetworkKey
+	}
+
+	return patched, nil
+}
+
+func indexEqual(a []int, b []int) bool {
+	return reflect.DeepEqual(a, b)
+}
+
+func containsIndex(indexes [][]int, index []int) bool {
+	for _, i := range indexes {
+		if indexEqual(i, index) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// load reads configuration file on top of Default, applies environment variables and secret files and validates the result.
+func load(filename string) (loadedConfiguration, error) {
+	loaded := loadedConfiguration{file: Default()}
+
 	_, err := os.Stat(filename)
 	if os.IsNotExist(err) {
-		return nil, fmt.Errorf("Configuration file '%v' does not exist", filename)
+		return loaded, fmt.Errorf("Configuration file '%v' does not exist", filename)
 	}
 
 	data, err := os.ReadFile(filename)
 	if err != nil {
-		return nil, err
+		return loaded, err
+	}
+
+	// unknown keys are rejected, a typo would silently keep the default value otherwise
+	err = yaml.UnmarshalStrict(data, &loaded.file)
+	if err != nil {
+		return loaded, fmt.Errorf("reading '%v': %w", filename, err)
+	}
+
+	loaded.configuration = loaded.file
+
+	loaded.overrides, err = applyEnv(&loaded.configuration, os.LookupEnv)
+	if err != nil {
+		return loaded, err
+	}
+
+	secrets, err := resolveSecretFiles(&loaded.configuration)
+	if err != nil {
+		return loaded, err
+	}
+	loaded.overrides = append(loaded.overrides, secrets...)
+
+	if err := Validate(loaded.configuration); err != nil {
+		return loaded, fmt.Errorf("'%v': %w", filename, err)
 	}
 
-	cfg := Configuration{
+	return loaded, nil
+}
+
+// Default returns configuration used for settings missing in configuration file.
+func Default() Configuration {
+	return Configuration{
 		ZNetworkConfiguration: ZNetworkConfiguration{
 			PANID:         9945,
 			ExtendedPANID: utils.Btoi64([]byte{125, 221, 221, 125, 221, 221, 125, 221}),
@@ -54,21 +322,49 @@ func Init(filename string) (ConfigurationService, error) {
 		SerialConfiguration: SerialConfiguration{
 			BaudRate: 115200,
 		},
+		DatabaseConfiguration: DatabaseConfiguration{
+			Backend:              "json",
+			Dir:                  "./data",
+			FlushPeriodInSeconds: 60,
+			BackupCount:          3,
+		},
+		HistoryConfiguration: HistoryConfiguration{
+			DefaultRetentionHours: 7 * 24,
+		},
+		Availability: AvailabilityConfiguration{
+			ActiveTimeoutInSeconds:  10 * 60,
+			PassiveTimeoutInSeconds: 25 * 60 * 60,
+		},
+		Reporting: ReportingConfiguration{
+			MinIntervalInSeconds: 1,
+			MaxIntervalInSeconds: 60 * 60,
+		},
 		PermitJoin: true,
 		MqttConfiguration: MqttConfiguration{
+			Scheme:    "tcp",
 			Port:      1883,
 			RootTopic: "gigbee2mqtt",
+			OfflineQueue: OfflineQueueConfiguration{
+				MaxSize: 1000,
+			},
+			QoS: MqttQoSConfiguration{
+				Events:               MqttPublishPolicy{QoS: 1},
+				CommandsSubscription: 1,
+			},
+		},
+		EmbeddedBroker: EmbeddedBrokerConfiguration{
+			Port: 1883,
+		},
+		HTTP: HTTPConfiguration{
+			Port: 8080,
+		},
+		Admin: AdminConfiguration{
+			Enabled:    true,
+			SocketPath: "./data/admin.sock",
+		},
+		Systemd: SystemdConfiguration{
+			MqttReadyTimeoutInSeconds: 2 * 60,
 		},
 		LogLevel: 3,
 	}
-
-	err = yaml.Unmarshal([]byte(data), &cfg)
-	if err != nil {
-		return nil, err
-	}
-
-	return &configurationService{
-		filename:      filename,
-		configuration: cfg,
-	}, nil
 }
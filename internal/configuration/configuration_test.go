@@ -0,0 +1,167 @@
+package configuration
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInitRejectsUnknownKeys(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "configuration.yaml")
+	writeConfig(t, filename, requiredConfig(dir)+"permitjoin: false\npermit_join: true\n")
+
+	_, err := Init(filename)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "permit_join")
+}
+
+func TestInitValidation(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "configuration.yaml")
+	writeConfig(t, filename, `
+znetworkconfiguration:
+  channel: 30
+mqttconfiguration:
+  address: broker:1883
+`)
+
+	_, err := Init(filename)
+
+	var validationErr *ValidationError
+	assert.True(t, errors.As(err, &validationErr))
+	assert.Equal(t, []string{
+		"znetworkconfiguration.channel 30 is outside of 11-26",
+		"serialconfiguration.portname is empty",
+		"mqttconfiguration.address: 'broker:1883' is not a host name or IP address, port is set separately",
+	}, validationErr.Problems)
+}
+
+func TestValidateEmbeddedBrokerProtocolVersion(t *testing.T) {
+	cfg := Default()
+	cfg.SerialConfiguration.PortName = "/dev/ttyUSB0"
+	cfg.EmbeddedBroker.Enabled = true
+	assert.NoError(t, Validate(cfg))
+
+	cfg.MqttConfiguration.ProtocolVersion = 5
+
+	var validationErr *ValidationError
+	assert.True(t, errors.As(Validate(cfg), &validationErr))
+	assert.Equal(t, []string{
+		"mqttconfiguration.protocolversion 5 is not supported by embeddedbroker, it supports MQTT 3.1.1 only",
+	}, validationErr.Problems)
+}
+
+func TestValidateHost(t *testing.T) {
+	for _, host := range []string{"localhost", "192.168.1.10", "::1", "mqtt.example.com", "mqtt_broker"} {
+		assert.NoError(t, validateHost(host), host)
+	}
+
+	for _, host := range []string{"", "tcp://localhost", "-broker", "broker..local", "bro ker"} {
+		assert.Error(t, validateHost(host), host)
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "network_key")
+	assert.NoError(t, os.WriteFile(keyFile, []byte("000102030405060708090a0b0c0d0e0f\n"), 0600))
+
+	env := map[string]string{
+		"GIGBEE_MQTT_PASSWORD":            "p#ss: word",
+		"GIGBEE_MQTT_QOS_EVENTS_QOS":      "2",
+		"GIGBEE_ZNETWORK_CHANNEL":         "0x14",
+		"GIGBEE_ZNETWORK_NETWORKKEY_FILE": keyFile,
+		"GIGBEE_HTTP_ALLOWEDORIGINS":      "[https://a.example.com, https://b.example.com]",
+		"GIGBEE_PERMITJOIN":               "false",
+		"GIGBEE_LOG_FORMAT":               "json",
+	}
+	lookup := func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+
+	cfg := Default()
+	overrides, err := applyEnv(&cfg, lookup)
+	assert.NoError(t, err)
+	assert.Len(t, overrides, len(env))
+
+	assert.Equal(t, "p#ss: word", cfg.MqttConfiguration.Password)
+	assert.Equal(t, byte(2), cfg.MqttConfiguration.QoS.Events.QoS)
+	assert.Equal(t, uint8(20), cfg.ZNetworkConfiguration.Channel)
+	assert.Equal(t, [16]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}, cfg.ZNetworkConfiguration.NetworkKey)
+	assert.Equal(t, []string{"https://a.example.com", "https://b.example.com"}, cfg.HTTP.AllowedOrigins)
+	assert.False(t, cfg.PermitJoin)
+	assert.Equal(t, "json", cfg.Log.Format)
+
+	env["GIGBEE_ZNETWORK_CHANNEL"] = "twenty"
+	_, err = applyEnv(&cfg, lookup)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "GIGBEE_ZNETWORK_CHANNEL")
+}
+
+func TestSecretFilesAreNotWritten(t *testing.T) {
+	dir := t.TempDir()
+	passwordFile := filepath.Join(dir, "mqtt_password")
+	assert.NoError(t, os.WriteFile(passwordFile, []byte("secret\n"), 0600))
+
+	filename := filepath.Join(dir, "configuration.yaml")
+	writeConfig(t, filename, `
+serialconfiguration:
+  portname: /dev/ttyUSB0
+mqttconfiguration:
+  address: localhost
+  passwordfile: `+passwordFile+`
+databaseconfiguration:
+  dir: `+dir+`
+loglevel: 0
+`)
+
+	cs, err := Init(filename)
+	assert.NoError(t, err)
+
+	cfg := cs.GetConfiguration()
+	assert.Equal(t, "secret", cfg.MqttConfiguration.Password)
+
+	cfg.LogLevel = 3
+	assert.NoError(t, cs.Update(cfg))
+
+	data, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "password: secret")
+	assert.Contains(t, string(data), "passwordfile: "+passwordFile)
+	assert.Contains(t, string(data), "loglevel: 3")
+	assert.Equal(t, "secret", cs.GetConfiguration().MqttConfiguration.Password)
+}
+
+func TestRedacted(t *testing.T) {
+	cfg := Default()
+	cfg.MqttConfiguration.Password = "mqtt-secret"
+	cfg.EmbeddedBroker.Password = "broker-secret"
+	cfg.ZNetworkConfiguration.NetworkKey = [16]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+
+	redacted := Redacted(cfg)
+
+	data, err := json.Marshal(redacted)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "mqtt-secret")
+	assert.NotContains(t, string(data), "broker-secret")
+	assert.Equal(t, [16]byte{}, redacted.ZNetworkConfiguration.NetworkKey)
+	assert.Equal(t, RedactedValue, redacted.MqttConfiguration.Password)
+	assert.Equal(t, "mqtt-secret", cfg.MqttConfiguration.Password, "original is not changed")
+
+	assert.Equal(t, cfg, WithSecrets(redacted, cfg))
+
+	// redacted configuration sent back by set_config does not change secrets
+	data, err = json.Marshal(struct {
+		MqttConfiguration     MqttConfiguration
+		ZNetworkConfiguration ZNetworkConfiguration
+	}{redacted.MqttConfiguration, redacted.ZNetworkConfiguration})
+	assert.NoError(t, err)
+
+	patched, err := Patch(cfg, data)
+	assert.NoError(t, err)
+	assert.Equal(t, cfg, patched)
+}
@@ -0,0 +1,176 @@
+package configuration
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	ENV_PREFIX      = "GIGBEE"
+	ENV_FILE_SUFFIX = "_FILE"
+)
+
+// secretFields can be read from a file named by the field with File suffix, e.g. PasswordFile.
+var secretFields = [][]string{
+	{"ZNetworkConfiguration", "NetworkKey"},
+	{"MqttConfiguration", "Password"},
+	{"EmbeddedBroker", "Password"},
+}
+
+// envName returns name of the environment variable overriding field at path, e.g. GIGBEE_MQTT_PASSWORD
+// for MqttConfiguration.Password. Names are upper-cased field names joined with '_', "Configuration"
+// suffix of sections is dropped.
+func envName(path ...string) string {
+	name := ENV_PREFIX
+	for _, p := range path {
+		name += "_" + strings.ToUpper(strings.TrimSuffix(p, "Configuration"))
+	}
+
+	return name
+}
+
+// applyEnv overrides fields of cfg by environment variables, a variable with _FILE suffix names a file
+// the value is read from. Index paths of overridden fields are returned.
+func applyEnv(cfg *Configuration, lookup func(string) (string, bool)) ([][]int, error) {
+	var overrides [][]int
+	err := applyEnvFields(reflect.ValueOf(cfg).Elem(), nil, nil, lookup, &overrides)
+
+	return overrides, err
+}
+
+func applyEnvFields(v reflect.Value, path []string, index []int, lookup func(string) (string, bool), overrides *[][]int) error {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		fieldPath := append(append([]string{}, path...), field.Name)
+		fieldIndex := append(append([]int{}, index...), i)
+
+		if field.Type.Kind() == reflect.Struct {
+			if err := applyEnvFields(v.Field(i), fieldPath, fieldIndex, lookup, overrides); err != nil {
+				return err
+			}
+			continue
+		}
+
+		name := envName(fieldPath...)
+		value, isSet := lookup(name)
+		filename, isFileSet := lookup(name + ENV_FILE_SUFFIX)
+
+		switch {
+		case isSet && isFileSet:
+			return fmt.Errorf("both %v and %v are set", name, name+ENV_FILE_SUFFIX)
+		case isFileSet:
+			var err error
+			if value, err = readSecret(filename); err != nil {
+				return fmt.Errorf("%v: %w", name+ENV_FILE_SUFFIX, err)
+			}
+		case !isSet:
+			continue
+		}
+
+		if err := setField(v.Field(i), value); err != nil {
+			return fmt.Errorf("%v: %w", name, err)
+		}
+		*overrides = append(*overrides, fieldIndex)
+	}
+
+	return nil
+}
+
+// resolveSecretFiles reads secretFields from files set in cfg, index paths of the read fields are returned.
+func resolveSecretFiles(cfg *Configuration) ([][]int, error) {
+	var resolved [][]int
+
+	v := reflect.ValueOf(cfg).Elem()
+	for _, path := range secretFields {
+		section, _ := v.Type().FieldByName(path[0])
+		field, _ := section.Type.FieldByName(path[1])
+
+		filename := v.FieldByIndex(section.Index).FieldByName(path[1] + "File").String()
+		if filename == "" {
+			continue
+		}
+
+		value, err := readSecret(filename)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", strings.ToLower(strings.Join(path, ".")+"file"), err)
+		}
+
+		index := append(append([]int{}, section.Index...), field.Index...)
+		if err := setField(v.FieldByIndex(index), value); err != nil {
+			return nil, fmt.Errorf("%v from '%v': %w", strings.ToLower(strings.Join(path, ".")), filename, err)
+		}
+		resolved = append(resolved, index)
+	}
+
+	return resolved, nil
+}
+
+// readSecret returns content of the file without trailing line break, as written by editors and echo.
+func readSecret(filename string) (string, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// setField parses value into field, scalars use Go syntax (0x prefix is accepted by numbers), lists and
+// structs are given in YAML flow style, e.g. [a, b].
+func setField(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 0, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 0, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(n)
+	case reflect.Array:
+		if field.Type().Elem().Kind() == reflect.Uint8 && !strings.HasPrefix(strings.TrimSpace(value), "[") {
+			return setBytes(field, value)
+		}
+		fallthrough
+	default:
+		return yaml.UnmarshalStrict([]byte(value), field.Addr().Interface())
+	}
+
+	return nil
+}
+
+// setBytes parses hex digits, optionally with 0x prefix and separated by ':', '-' or spaces.
+func setBytes(field reflect.Value, value string) error {
+	value = strings.TrimPrefix(strings.TrimSpace(value), "0x")
+	value = strings.NewReplacer(":", "", "-", "", " ", "").Replace(value)
+
+	data, err := hex.DecodeString(value)
+	if err != nil {
+		return err
+	}
+	if len(data) != field.Len() {
+		return fmt.Errorf("expected %v bytes, got %v", field.Len(), len(data))
+	}
+
+	reflect.Copy(field, reflect.ValueOf(data))
+
+	return nil
+}
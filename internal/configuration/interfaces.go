@@ -3,4 +3,12 @@ package configuration
 type ConfigurationService interface {
 	Update(updatedConfig Configuration) error
 	GetConfiguration() Configuration
+	// Apply validates and stores changed configuration, it applies only the sections which can change at runtime.
+	Apply(updatedConfig Configuration) (ReloadResult, error)
+	// Reload reads the configuration file again and keeps the sections which can not change at runtime.
+	Reload() (ReloadResult, error)
+	// GetState returns runtime state kept in StateFilename.
+	GetState() State
+	// UpdateState changes runtime state and writes it to StateFilename.
+	UpdateState(update func(state *State)) error
 }
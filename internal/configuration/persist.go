@@ -0,0 +1,156 @@
+package configuration
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	yaml3 "gopkg.in/yaml.v3"
+)
+
+// configFileMode keeps secrets like the network key readable by the owner only.
+const configFileMode = 0600
+
+// changedFields returns index paths of fields which differ, structs are compared field by field.
+func changedFields(previous Configuration, updated Configuration) ([][]int, error) {
+	var changed [][]int
+	err := collectChangedFields(reflect.ValueOf(previous), reflect.ValueOf(updated), nil, &changed)
+
+	return changed, err
+}
+
+func collectChangedFields(previous reflect.Value, updated reflect.Value, index []int, changed *[][]int) error {
+	for i := 0; i < updated.NumField(); i++ {
+		fieldIndex := append(append([]int{}, index...), i)
+
+		if updated.Field(i).Kind() == reflect.Struct {
+			if err := collectChangedFields(previous.Field(i), updated.Field(i), fieldIndex, changed); err != nil {
+				return err
+			}
+			continue
+		}
+
+		equal, err := sectionEqual(previous.Field(i).Interface(), updated.Field(i).Interface())
+		if err != nil {
+			return err
+		}
+		if !equal {
+			*changed = append(*changed, fieldIndex)
+		}
+	}
+
+	return nil
+}
+
+// patchDocument sets fields of cfg at index paths in YAML document data, comments, key order
+// and other values of the document are kept.
+func patchDocument(data []byte, cfg Configuration, fields [][]int) ([]byte, error) {
+	var doc yaml3.Node
+	if err := yaml3.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	if doc.Kind == 0 {
+		doc = yaml3.Node{
+			Kind:    yaml3.DocumentNode,
+			Content: []*yaml3.Node{{Kind: yaml3.MappingNode}},
+		}
+	}
+
+	root := doc.Content[0]
+	if root.Kind != yaml3.MappingNode {
+		return nil, fmt.Errorf("configuration file is not a YAML mapping")
+	}
+
+	for _, index := range fields {
+		if err := patchField(root, reflect.ValueOf(cfg), index); err != nil {
+			return nil, err
+		}
+	}
+
+	var buf bytes.Buffer
+	enc := yaml3.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func patchField(mapping *yaml3.Node, v reflect.Value, index []int) error {
+	key := strings.ToLower(v.Type().Field(index[0]).Name)
+	value := mappingValue(mapping, key)
+	field := v.Field(index[0])
+
+	if len(index) > 1 {
+		if value.Kind != yaml3.MappingNode {
+			*value = yaml3.Node{Kind: yaml3.MappingNode, HeadComment: value.HeadComment, LineComment: value.LineComment}
+		}
+
+		return patchField(value, field, index[1:])
+	}
+
+	var encoded yaml3.Node
+	if err := encoded.Encode(field.Interface()); err != nil {
+		return err
+	}
+	encoded.HeadComment = value.HeadComment
+	encoded.LineComment = value.LineComment
+	encoded.FootComment = value.FootComment
+	*value = encoded
+
+	return nil
+}
+
+// mappingValue returns value node of key, the key is appended when missing.
+func mappingValue(mapping *yaml3.Node, key string) *yaml3.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+
+	value := &yaml3.Node{}
+	mapping.Content = append(mapping.Content, &yaml3.Node{Kind: yaml3.ScalarNode, Value: key}, value)
+
+	return value
+}
+
+// writeFileAtomic replaces the file with data, so it is never left half written. Symlink is followed,
+// so a config file linked from e.g. a git checkout stays there.
+func writeFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	if target, err := filepath.EvalSymlinks(filename); err == nil {
+		filename = target
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filename)
+}
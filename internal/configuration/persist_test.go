@@ -0,0 +1,142 @@
+package configuration
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdateKeepsLayout(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "configuration.yaml")
+	writeConfig(t, filename, `# gateway of the first floor
+serialconfiguration:
+  portname: /dev/ttyUSB0 # CC2652
+mqttconfiguration:
+  address: localhost
+databaseconfiguration:
+  dir: `+dir+`
+# debug while installing
+loglevel: 3
+`)
+	assert.NoError(t, os.Chmod(filename, 0666))
+
+	cs, err := Init(filename)
+	assert.NoError(t, err)
+
+	cfg := cs.GetConfiguration()
+	cfg.LogLevel = 0
+	cfg.Availability.ActiveTimeoutInSeconds = 120
+	assert.NoError(t, cs.Update(cfg))
+
+	data, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, `# gateway of the first floor
+serialconfiguration:
+  portname: /dev/ttyUSB0 # CC2652
+mqttconfiguration:
+  address: localhost
+databaseconfiguration:
+  dir: `+dir+`
+# debug while installing
+loglevel: 0
+availability:
+  activetimeoutinseconds: 120
+`, string(data))
+
+	info, err := os.Stat(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
+
+func TestApply(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "configuration.yaml")
+	writeConfig(t, filename, requiredConfig(dir))
+
+	cs, err := Init(filename)
+	assert.NoError(t, err)
+
+	cfg, err := Patch(cs.GetConfiguration(), []byte(`{"LogLevels": {"MQTT Client": 0}, "SerialConfiguration": {"BaudRate": 57600}}`))
+	assert.NoError(t, err)
+	assert.Nil(t, cs.GetConfiguration().LogLevels, "running configuration is not changed by Patch")
+
+	result, err := cs.Apply(cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"LogLevels"}, result.AppliedFields)
+	assert.Equal(t, []string{"SerialConfiguration.BaudRate"}, result.RestartFields)
+	assert.Equal(t, uint32(115200), cs.GetConfiguration().SerialConfiguration.BaudRate)
+
+	// restart fields are stored for the next start
+	cs, err = Init(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(57600), cs.GetConfiguration().SerialConfiguration.BaudRate)
+	assert.Equal(t, map[string]int{"MQTT Client": 0}, cs.GetConfiguration().LogLevels)
+
+	cfg.LogLevel = 7
+	_, err = cs.Apply(cfg)
+	var validationErr *ValidationError
+	assert.True(t, errors.As(err, &validationErr))
+}
+
+func TestPermitJoinIsKeptInState(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "configuration.yaml")
+	writeConfig(t, filename, requiredConfig(dir)+"permitjoin: true\n")
+
+	cs, err := Init(filename)
+	assert.NoError(t, err)
+
+	cfg := cs.GetConfiguration()
+	cfg.PermitJoin = false
+	assert.NoError(t, cs.Update(cfg))
+
+	data, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, requiredConfig(dir)+"permitjoin: true\n", string(data), "config file is not written")
+
+	// runtime value survives restart and reload of unrelated changes
+	cs, err = Init(filename)
+	assert.NoError(t, err)
+	assert.False(t, cs.GetConfiguration().PermitJoin)
+
+	writeConfig(t, filename, requiredConfig(dir)+"permitjoin: true\nloglevel: 0\n")
+	_, err = cs.Reload()
+	assert.NoError(t, err)
+	assert.False(t, cs.GetConfiguration().PermitJoin)
+
+	// editing the file wins over the runtime value
+	writeConfig(t, filename, requiredConfig(dir)+"permitjoin: false\nloglevel: 0\n")
+	_, err = cs.Reload()
+	assert.NoError(t, err)
+	writeConfig(t, filename, requiredConfig(dir)+"permitjoin: true\nloglevel: 0\n")
+	result, err := cs.Reload()
+	assert.NoError(t, err)
+	assert.True(t, result.IsApplied(SectionPermitJoin))
+	assert.True(t, cs.GetConfiguration().PermitJoin)
+	assert.Nil(t, cs.GetState().PermitJoin)
+}
+
+func TestUpdateState(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "configuration.yaml")
+	writeConfig(t, filename, requiredConfig(dir))
+
+	cs, err := Init(filename)
+	assert.NoError(t, err)
+
+	assert.NoError(t, cs.UpdateState(func(state *State) {
+		state.Coordinator.IEEEAddress = 0x00124b0012345678
+	}))
+
+	info, err := os.Stat(filepath.Join(dir, StateFilename))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	cs, err = Init(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0x00124b0012345678), cs.GetState().Coordinator.IEEEAddress)
+}
@@ -0,0 +1,27 @@
+package configuration
+
+// RedactedValue replaces secrets set in the configuration.
+const RedactedValue = "********"
+
+// Redacted returns copy of cfg without passwords and network key, it is what leaves the gateway
+// over MQTT, HTTP and admin socket.
+func Redacted(cfg Configuration) Configuration {
+	if cfg.MqttConfiguration.Password != "" {
+		cfg.MqttConfiguration.Password = RedactedValue
+	}
+	if cfg.EmbeddedBroker.Password != "" {
+		cfg.EmbeddedBroker.Password = RedactedValue
+	}
+	cfg.ZNetworkConfiguration.NetworkKey = [16]byte{}
+
+	return cfg
+}
+
+// WithSecrets returns cfg with secrets removed by Redacted taken from secrets.
+func WithSecrets(cfg Configuration, secrets Configuration) Configuration {
+	cfg.MqttConfiguration.Password = secrets.MqttConfiguration.Password
+	cfg.EmbeddedBroker.Password = secrets.EmbeddedBroker.Password
+	cfg.ZNetworkConfiguration.NetworkKey = secrets.ZNetworkConfiguration.NetworkKey
+
+	return cfg
+}
@@ -0,0 +1,233 @@
+package configuration
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Sections of Configuration as named in the configuration file.
+const (
+	SectionNetwork      = "znetworkconfiguration"
+	SectionMqtt         = "mqttconfiguration"
+	SectionAvailability = "availability"
+	SectionReporting    = "reporting"
+	SectionPermitJoin   = "permitjoin"
+	SectionLogLevel     = "loglevel"
+	SectionLogLevels    = "loglevels"
+	SectionLog          = "log"
+)
+
+// liveSections are applied by the running gateway, changes of other sections except the network
+// take effect after restart.
+var liveSections = map[string]bool{
+	SectionMqtt:         true,
+	SectionAvailability: true,
+	SectionReporting:    true,
+	SectionPermitJoin:   true,
+	SectionLogLevel:     true,
+	SectionLogLevels:    true,
+	SectionLog:          true,
+}
+
+// restartOnlyFields of liveSections can not be applied by the running gateway, they are kept unchanged
+// until restart like fields of other sections.
+var restartOnlyFields = []string{
+	"MqttConfiguration.ProtocolVersion",
+	"MqttConfiguration.OfflineQueue.",
+}
+
+// ErrNetworkChange rejects reload, the coordinator would have to form a new network and all devices re-join.
+var ErrNetworkChange = errors.New("changing Zigbee network requires to re-form it, restart the gateway to apply it")
+
+// ReloadResult tells which sections of the reloaded configuration file differ from the running configuration.
+type ReloadResult struct {
+	Previous      Configuration
+	Current       Configuration // Previous with Applied sections taken from the file
+	Applied       []string      // sections to apply at runtime
+	Restart       []string      // sections kept unchanged until restart, a live section is in both when some fields are restart only
+	AppliedFields []string      // changed fields of Applied sections, e.g. Availability.ActiveTimeoutInSeconds
+	RestartFields []string      // changed fields of Restart sections
+}
+
+func (r ReloadResult) IsApplied(section string) bool {
+	for _, s := range r.Applied {
+		if s == section {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Merge compares loaded configuration with the running one and takes the sections which can change at runtime.
+func Merge(running Configuration, loaded Configuration) (ReloadResult, error) {
+	result := ReloadResult{
+		Previous: running,
+		Current:  running,
+	}
+
+	current := reflect.ValueOf(&result.Current).Elem()
+	file := reflect.ValueOf(loaded)
+
+	for i := 0; i < current.NumField(); i++ {
+		section := strings.ToLower(current.Type().Field(i).Name)
+
+		equal, err := sectionEqual(current.Field(i).Interface(), file.Field(i).Interface())
+		if err != nil {
+			return ReloadResult{}, err
+		}
+		if equal {
+			continue
+		}
+
+		var fields []string
+		if err := collectChangedNames(current.Field(i), file.Field(i), current.Type().Field(i).Name, &fields); err != nil {
+			return ReloadResult{}, err
+		}
+
+		switch {
+		case section == SectionNetwork:
+			return ReloadResult{}, ErrNetworkChange
+		case liveSections[section]:
+			running := reflect.New(current.Field(i).Type()).Elem()
+			running.Set(current.Field(i))
+			current.Field(i).Set(file.Field(i))
+
+			applied, restart := splitRestartOnly(fields)
+			for _, field := range restart {
+				keepRunning(current.Field(i), running, field)
+			}
+
+			if len(applied) > 0 {
+				result.Applied = append(result.Applied, section)
+				result.AppliedFields = append(result.AppliedFields, applied...)
+			}
+			if len(restart) > 0 {
+				result.Restart = append(result.Restart, section)
+				result.RestartFields = append(result.RestartFields, restart...)
+			}
+		default:
+			result.Restart = append(result.Restart, section)
+			result.RestartFields = append(result.RestartFields, fields...)
+		}
+	}
+
+	return result, nil
+}
+
+// splitRestartOnly separates changed fields of a live section which can not be applied at runtime.
+func splitRestartOnly(fields []string) (applied []string, restart []string) {
+	for _, field := range fields {
+		if isRestartOnly(field) {
+			restart = append(restart, field)
+		} else {
+			applied = append(applied, field)
+		}
+	}
+
+	return applied, restart
+}
+
+func isRestartOnly(field string) bool {
+	for _, restartOnly := range restartOnlyFields {
+		if field == restartOnly || (strings.HasSuffix(restartOnly, ".") && strings.HasPrefix(field, restartOnly)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// keepRunning sets the field named like MqttConfiguration.OfflineQueue.MaxSize in section back to its running value.
+func keepRunning(section reflect.Value, running reflect.Value, field string) {
+	path := strings.Split(field, ".")[1:]
+	for _, name := range path {
+		section = section.FieldByName(name)
+		running = running.FieldByName(name)
+	}
+
+	section.Set(running)
+}
+
+// collectChangedNames appends names of changed fields, nested fields are joined with '.'.
+func collectChangedNames(previous reflect.Value, updated reflect.Value, name string, names *[]string) error {
+	if updated.Kind() == reflect.Struct {
+		for i := 0; i < updated.NumField(); i++ {
+			fieldName := name + "." + updated.Type().Field(i).Name
+			if err := collectChangedNames(previous.Field(i), updated.Field(i), fieldName, names); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	equal, err := sectionEqual(previous.Interface(), updated.Interface())
+	if err != nil {
+		return err
+	}
+	if !equal {
+		*names = append(*names, name)
+	}
+
+	return nil
+}
+
+// sectionEqual compares sections the way they are written to the file, so nil and empty lists are equal.
+func sectionEqual(a interface{}, b interface{}) (bool, error) {
+	aData, err := yaml.Marshal(a)
+	if err != nil {
+		return false, err
+	}
+
+	bData, err := yaml.Marshal(b)
+	if err != nil {
+		return false, err
+	}
+
+	return bytes.Equal(aData, bData), nil
+}
+
+// Watch calls onChange when modification time or size of the file changes, the file is checked every interval until ctx is done.
+func Watch(ctx context.Context, filename string, interval time.Duration, onChange func()) error {
+	last, err := os.Stat(filename)
+	if err != nil {
+		return fmt.Errorf("watching '%v': %w", filename, err)
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			// editors replace the file, so it can be missing for a moment
+			info, err := os.Stat(filename)
+			if err != nil {
+				continue
+			}
+
+			if info.ModTime().Equal(last.ModTime()) && info.Size() == last.Size() {
+				continue
+			}
+			last = info
+
+			onChange()
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,152 @@
+package configuration
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// requiredConfig has settings without valid defaults, the state file is kept in dir.
+func requiredConfig(dir string) string {
+	return `
+serialconfiguration:
+  portname: /dev/ttyUSB0
+mqttconfiguration:
+  address: localhost
+databaseconfiguration:
+  dir: ` + dir + "\n"
+}
+
+func writeConfig(t *testing.T, filename string, data string) {
+	assert.NoError(t, os.WriteFile(filename, []byte(data), 0600))
+}
+
+func TestReloadAppliesLiveSections(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "configuration.yaml")
+	writeConfig(t, filename, requiredConfig(dir)+"permitjoin: false\nloglevel: 0\n")
+
+	cs, err := Init(filename)
+	assert.NoError(t, err)
+
+	writeConfig(t, filename, `
+permitjoin: true
+loglevel: 3
+mqttconfiguration:
+  address: 192.168.1.10
+serialconfiguration:
+  portname: /dev/ttyUSB1
+databaseconfiguration:
+  dir: `+dir+`
+`)
+
+	result, err := cs.Reload()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{SectionMqtt, SectionPermitJoin, SectionLogLevel}, result.Applied)
+	assert.Equal(t, []string{"serialconfiguration"}, result.Restart)
+	assert.Equal(t, []string{"MqttConfiguration.Address", "PermitJoin", "LogLevel"}, result.AppliedFields)
+	assert.Equal(t, []string{"SerialConfiguration.PortName"}, result.RestartFields)
+	assert.True(t, result.IsApplied(SectionPermitJoin))
+	assert.False(t, result.Previous.PermitJoin)
+
+	cfg := cs.GetConfiguration()
+	assert.True(t, cfg.PermitJoin)
+	assert.Equal(t, 3, cfg.LogLevel)
+	assert.Equal(t, "192.168.1.10", cfg.MqttConfiguration.Address)
+	assert.Equal(t, "/dev/ttyUSB0", cfg.SerialConfiguration.PortName, "serial port is changed only after restart")
+}
+
+func TestReloadKeepsRestartOnlyFields(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "configuration.yaml")
+	writeConfig(t, filename, requiredConfig(dir))
+
+	cs, err := Init(filename)
+	assert.NoError(t, err)
+
+	writeConfig(t, filename, `
+serialconfiguration:
+  portname: /dev/ttyUSB0
+mqttconfiguration:
+  address: localhost
+  username: gateway
+  protocolversion: 5
+  offlinequeue:
+    maxsize: 10
+databaseconfiguration:
+  dir: `+dir+`
+`)
+
+	result, err := cs.Reload()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{SectionMqtt}, result.Applied)
+	assert.Equal(t, []string{"MqttConfiguration.Username"}, result.AppliedFields)
+	assert.Equal(t, []string{SectionMqtt}, result.Restart)
+	assert.Equal(t, []string{"MqttConfiguration.ProtocolVersion", "MqttConfiguration.OfflineQueue.MaxSize"}, result.RestartFields)
+
+	cfg := cs.GetConfiguration()
+	assert.Equal(t, "gateway", cfg.MqttConfiguration.Username)
+	assert.Equal(t, 0, cfg.MqttConfiguration.ProtocolVersion, "protocol version is changed only after restart")
+	assert.Equal(t, Default().MqttConfiguration.OfflineQueue, cfg.MqttConfiguration.OfflineQueue)
+}
+
+func TestReloadRejectsNetworkChange(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "configuration.yaml")
+	writeConfig(t, filename, requiredConfig(dir)+"permitjoin: false\n")
+
+	cs, err := Init(filename)
+	assert.NoError(t, err)
+
+	writeConfig(t, filename, requiredConfig(dir)+"permitjoin: true\nznetworkconfiguration:\n  channel: 25\n")
+
+	_, err = cs.Reload()
+	assert.True(t, errors.Is(err, ErrNetworkChange))
+	assert.False(t, cs.GetConfiguration().PermitJoin, "nothing is applied when reload is rejected")
+	assert.Equal(t, uint8(15), cs.GetConfiguration().ZNetworkConfiguration.Channel)
+}
+
+func TestReloadAfterUpdateIsNoop(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "configuration.yaml")
+	writeConfig(t, filename, requiredConfig(dir)+"permitjoin: false\n")
+
+	cs, err := Init(filename)
+	assert.NoError(t, err)
+
+	cfg := cs.GetConfiguration()
+	cfg.PermitJoin = true
+	assert.NoError(t, cs.Update(cfg))
+
+	result, err := cs.Reload()
+	assert.NoError(t, err)
+	assert.Empty(t, result.Applied)
+	assert.Empty(t, result.Restart)
+}
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "configuration.yaml")
+	writeConfig(t, filename, requiredConfig(dir)+"permitjoin: false\n")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changed := make(chan struct{}, 1)
+	assert.NoError(t, Watch(ctx, filename, 10*time.Millisecond, func() {
+		changed <- struct{}{}
+	}))
+
+	writeConfig(t, filename, "permitjoin: true\n")
+
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("change of the file is not noticed")
+	}
+}
@@ -0,0 +1,58 @@
+package configuration
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// StateFilename is kept in DatabaseConfiguration.Dir, next to the device database.
+const StateFilename = "state.json"
+
+// State is changed by the running gateway, it is kept apart from the configuration file,
+// which can be kept e.g. in git without a change on every permit join.
+type State struct {
+	PermitJoin  *bool `json:",omitempty"` // last permit join set at runtime, overrides Configuration.PermitJoin
+	Coordinator CoordinatorState
+}
+
+// CoordinatorState describes the adapter found on the last start.
+type CoordinatorState struct {
+	IEEEAddress    uint64
+	NetworkAddress uint16
+	InitialisedAt  time.Time
+}
+
+func stateFilename(cfg Configuration) string {
+	return filepath.Join(cfg.DatabaseConfiguration.Dir, StateFilename)
+}
+
+// loadState returns empty State when the file does not exist yet.
+func loadState(filename string) (State, error) {
+	var state State
+
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+
+	return state, json.Unmarshal(data, &state)
+}
+
+func saveState(filename string, state State) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filename, data, configFileMode)
+}
@@ -1,18 +1,136 @@
 package configuration
 
 type ZNetworkConfiguration struct {
-	PANID         uint16
-	ExtendedPANID uint64
-	NetworkKey    [16]byte
-	Channel       uint8
+	PANID          uint16
+	ExtendedPANID  uint64
+	NetworkKey     [16]byte
+	NetworkKeyFile string // file with the key as 32 hex digits or YAML list, takes precedence over NetworkKey
+	Channel        uint8
 }
 
 type MqttConfiguration struct {
-	Address   string
-	Port      uint16
-	RootTopic string
-	Username  string
-	Password  string
+	Scheme            string // tcp (default), ssl, ws or wss
+	Address           string
+	Port              uint16
+	Path              string // websocket path, e.g. /mqtt
+	ProtocolVersion   int    // 5 for MQTT 5, MQTT 3.1.1 is used otherwise
+	RootTopic         string
+	Username          string
+	Password          string
+	PasswordFile      string // file with the password, takes precedence over Password
+	ClientID          string // RootTopic is used when empty
+	PersistentSession bool   // keep subscriptions and in-flight messages on broker between connections
+	SessionStoreDir   string // where in-flight messages of persistent session are kept, in memory when empty
+	OfflineQueue      OfflineQueueConfiguration
+	TLS               MqttTLSConfiguration // used by ssl and wss schemes
+	QoS               MqttQoSConfiguration
+	Payload           MqttPayloadConfiguration
+}
+
+// MqttQoSConfiguration defines QoS and retain flag per class of published messages.
+type MqttQoSConfiguration struct {
+	DeviceReports        MqttPublishPolicy // <root>/<device>
+	Events               MqttPublishPolicy // <root>/<device>/join|leave|update
+	Descriptions         MqttPublishPolicy // <root>/<device>/description
+	GatewayResponses     MqttPublishPolicy // <root>/gateway/devices|config and replies to Response Topic
+	CommandsSubscription byte              // QoS of <root>/# subscription commands are received with
+}
+
+type MqttPublishPolicy struct {
+	QoS    byte
+	Retain bool
+}
+
+// MqttPayloadConfiguration sets format of device messages published to <root>/<device>.
+type MqttPayloadConfiguration struct {
+	Timestamp  bool // adds Timestamp, the time the gateway received the message
+	HexAddress bool // IEEEAddress as "0x..." string, JSON numbers over 2^53 lose precision e.g. in JavaScript
+}
+
+type MqttTLSConfiguration struct {
+	CAFile             string // PEM bundle to verify broker, system roots are used when empty
+	CertFile           string // PEM client certificate for mutual TLS
+	KeyFile            string
+	ServerName         string // overrides name used to verify broker certificate
+	InsecureSkipVerify bool
+}
+
+type OfflineQueueConfiguration struct {
+	MaxSize int    // max number of messages buffered while disconnected, 0 disables buffering
+	Dir     string // queue is kept in memory when empty
+}
+
+// EmbeddedBrokerConfiguration configures in-process MQTT broker, when enabled
+// the gateway connects to it instead of broker in MqttConfiguration.
+type EmbeddedBrokerConfiguration struct {
+	Enabled      bool
+	Address      string // listen address, all interfaces when empty
+	Port         uint16
+	Username     string // anonymous access is allowed when empty
+	Password     string
+	PasswordFile string // file with the password, takes precedence over Password
+}
+
+type DatabaseConfiguration struct {
+	Backend              string // json (default) or bolt
+	Dir                  string
+	FlushPeriodInSeconds int // how often json backend is written to disk
+	BackupCount          int // number of rotated backups kept by json backend
+}
+
+// HistoryConfiguration configures attribute values history kept in DatabaseConfiguration.Dir.
+type HistoryConfiguration struct {
+	Enabled               bool
+	DefaultRetentionHours int // 0 records only attributes matched by Retention rules
+	Retention             []HistoryRetentionRule
+}
+
+type HistoryRetentionRule struct {
+	ClusterID      uint16
+	Attribute      string // rule applies to all attributes of the cluster when empty
+	RetentionHours int    // 0 disables history of matched attributes
+}
+
+// AvailabilityConfiguration defines when a device is considered offline since the last received message.
+type AvailabilityConfiguration struct {
+	ActiveTimeoutInSeconds  int // mains and DC powered devices
+	PassiveTimeoutInSeconds int // battery powered devices
+}
+
+// ReportingConfiguration is used by configure_reporting command for fields missing in the request.
+type ReportingConfiguration struct {
+	MinIntervalInSeconds int     // shortest time between two reports
+	MaxIntervalInSeconds int     // a report is sent at least this often, also when the value does not change
+	ReportableChange     float64 // change of analog attributes which is reported, 0 reports every change
+}
+
+// HTTPConfiguration configures HTTP server exposing /metrics.
+type HTTPConfiguration struct {
+	Enabled        bool
+	Address        string // all interfaces when empty
+	Port           uint16
+	AllowedOrigins []string // origins of pages allowed to open event stream and change state over REST API, same origin only when empty, "*" allows any
+}
+
+// AdminConfiguration configures local JSON-RPC control socket, it does not depend on MQTT broker.
+type AdminConfiguration struct {
+	Enabled    bool
+	SocketPath string // Unix domain socket, created with owner only access
+}
+
+// SystemdConfiguration configures notifications sent when the gateway runs as Type=notify service.
+type SystemdConfiguration struct {
+	MqttReadyTimeoutInSeconds int // READY waits at most this long for MQTT connection, 0 sends it without waiting
+}
+
+// LogConfiguration sets format and outputs of the log, levels are set by LogLevel and LogLevels.
+type LogConfiguration struct {
+	Format     string // text (default) or json, one object per line e.g. for Loki
+	File       string // file written in addition to stdout when set
+	MaxSizeMB  int    // file is rotated when it grows over the size, 0 disables rotation
+	MaxBackups int    // rotated files kept as File.1 ... File.N
+	MqttTopic  bool   // publish lines as JSON to <roottopic>/gateway/log
+	MqttLevel  int    // most verbose level published to MQTT, lines of MQTT Client are never published
 }
 
 type SerialConfiguration struct {
@@ -23,7 +141,17 @@ type SerialConfiguration struct {
 type Configuration struct {
 	ZNetworkConfiguration ZNetworkConfiguration
 	MqttConfiguration     MqttConfiguration
+	EmbeddedBroker        EmbeddedBrokerConfiguration
 	SerialConfiguration   SerialConfiguration
+	DatabaseConfiguration DatabaseConfiguration
+	HistoryConfiguration  HistoryConfiguration
+	Availability          AvailabilityConfiguration
+	Reporting             ReportingConfiguration
+	HTTP                  HTTPConfiguration
+	Admin                 AdminConfiguration
+	Systemd               SystemdConfiguration
 	PermitJoin            bool
-	LogLevel              int // info=0, warn=1, error=2, debug=3
+	LogLevel              int            // error=0, warn=1, info=2, debug=3
+	LogLevels             map[string]int // per module, e.g. "MQTT Client": 3, overrides LogLevel
+	Log                   LogConfiguration
 }
@@ -0,0 +1,195 @@
+package configuration
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// ValidationError lists all problems found in configuration, so they can be fixed at once.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration: " + strings.Join(e.Problems, "; ")
+}
+
+// Validate checks values which would otherwise fail only when the gateway uses them.
+func Validate(cfg Configuration) error {
+	var problems []string
+	add := func(format string, v ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, v...))
+	}
+
+	if ch := cfg.ZNetworkConfiguration.Channel; ch < 11 || ch > 26 {
+		add("znetworkconfiguration.channel %v is outside of 11-26", ch)
+	}
+	if cfg.ZNetworkConfiguration.PANID == 0xffff {
+		add("znetworkconfiguration.panid 0xffff is reserved")
+	}
+
+	if cfg.SerialConfiguration.PortName == "" {
+		add("serialconfiguration.portname is empty")
+	}
+
+	// the gateway connects to embedded broker instead, see EmbeddedBrokerConfiguration
+	if !cfg.EmbeddedBroker.Enabled {
+		switch cfg.MqttConfiguration.Scheme {
+		case "tcp", "ssl", "ws", "wss":
+		default:
+			add("mqttconfiguration.scheme '%v' is not one of tcp, ssl, ws, wss", cfg.MqttConfiguration.Scheme)
+		}
+
+		if err := validateHost(cfg.MqttConfiguration.Address); err != nil {
+			add("mqttconfiguration.address: %v", err)
+		}
+		if cfg.MqttConfiguration.Port == 0 {
+			add("mqttconfiguration.port is 0")
+		}
+	} else {
+		if cfg.EmbeddedBroker.Address != "" {
+			if err := validateHost(cfg.EmbeddedBroker.Address); err != nil {
+				add("embeddedbroker.address: %v", err)
+			}
+		}
+
+		// the embedded broker speaks MQTT 3.1.1 only, the gateway would never connect to it
+		if cfg.MqttConfiguration.ProtocolVersion == 5 {
+			add("mqttconfiguration.protocolversion 5 is not supported by embeddedbroker, it supports MQTT 3.1.1 only")
+		}
+	}
+
+	if cfg.MqttConfiguration.RootTopic == "" || strings.ContainsAny(cfg.MqttConfiguration.RootTopic, "#+") {
+		add("mqttconfiguration.roottopic '%v' is empty or contains wildcards", cfg.MqttConfiguration.RootTopic)
+	}
+
+	if cfg.HTTP.Enabled && cfg.HTTP.Address != "" {
+		if err := validateHost(cfg.HTTP.Address); err != nil {
+			add("http.address: %v", err)
+		}
+	}
+
+	if cfg.Admin.Enabled && cfg.Admin.SocketPath == "" {
+		add("admin.socketpath is empty")
+	}
+
+	if cfg.Systemd.MqttReadyTimeoutInSeconds < 0 {
+		add("systemd.mqttreadytimeoutinseconds %v is negative", cfg.Systemd.MqttReadyTimeoutInSeconds)
+	}
+
+	qos := cfg.MqttConfiguration.QoS
+	for _, q := range []struct {
+		name string
+		qos  byte
+	}{
+		{"devicereports", qos.DeviceReports.QoS},
+		{"events", qos.Events.QoS},
+		{"descriptions", qos.Descriptions.QoS},
+		{"gatewayresponses", qos.GatewayResponses.QoS},
+		{"commandssubscription", qos.CommandsSubscription},
+	} {
+		if q.qos > 2 {
+			add("mqttconfiguration.qos.%v %v is not 0, 1 or 2", q.name, q.qos)
+		}
+	}
+
+	if cfg.Availability.ActiveTimeoutInSeconds <= 0 || cfg.Availability.PassiveTimeoutInSeconds <= 0 {
+		add("availability timeouts must be positive")
+	}
+
+	// 0xffff maximum interval turns reporting off, configure_reporting is not meant for that
+	reporting := cfg.Reporting
+	if reporting.MinIntervalInSeconds < 0 || reporting.MaxIntervalInSeconds < 1 || reporting.MaxIntervalInSeconds > 0xfffe ||
+		reporting.MinIntervalInSeconds > reporting.MaxIntervalInSeconds {
+		add("reporting intervals %v-%v must be within 0-65534 with minimum not above maximum",
+			reporting.MinIntervalInSeconds, reporting.MaxIntervalInSeconds)
+	}
+	if reporting.ReportableChange < 0 {
+		add("reporting.reportablechange %v is negative", reporting.ReportableChange)
+	}
+
+	if !validLogLevel(cfg.LogLevel) {
+		add("loglevel %v is outside of 0-3", cfg.LogLevel)
+	}
+	modules := make([]string, 0, len(cfg.LogLevels))
+	for module := range cfg.LogLevels {
+		modules = append(modules, module)
+	}
+	sort.Strings(modules)
+	for _, module := range modules {
+		if level := cfg.LogLevels[module]; !validLogLevel(level) {
+			add("loglevels.%v %v is outside of 0-3", module, level)
+		}
+	}
+
+	switch cfg.Log.Format {
+	case "", "text", "json":
+	default:
+		add("log.format '%v' is not one of text, json", cfg.Log.Format)
+	}
+	if cfg.Log.MaxSizeMB < 0 || cfg.Log.MaxBackups < 0 {
+		add("log.maxsizemb and log.maxbackups must not be negative")
+	}
+	if !validLogLevel(cfg.Log.MqttLevel) {
+		add("log.mqttlevel %v is outside of 0-3", cfg.Log.MqttLevel)
+	}
+
+	switch cfg.DatabaseConfiguration.Backend {
+	case "json", "bolt":
+	default:
+		add("databaseconfiguration.backend '%v' is not one of json, bolt", cfg.DatabaseConfiguration.Backend)
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+
+	return nil
+}
+
+func validLogLevel(level int) bool {
+	return level >= 0 && level <= 3
+}
+
+// validateHost accepts IP address or DNS name, port is configured separately.
+func validateHost(host string) error {
+	if host == "" {
+		return fmt.Errorf("is empty")
+	}
+
+	if net.ParseIP(host) != nil {
+		return nil
+	}
+
+	if strings.Contains(host, ":") {
+		return fmt.Errorf("'%v' is not a host name or IP address, port is set separately", host)
+	}
+
+	if len(host) > 253 {
+		return fmt.Errorf("'%v' is too long", host)
+	}
+
+	for _, label := range strings.Split(strings.TrimSuffix(host, "."), ".") {
+		if !isHostLabel(label) {
+			return fmt.Errorf("'%v' is not a host name or IP address", host)
+		}
+	}
+
+	return nil
+}
+
+func isHostLabel(label string) bool {
+	if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+		return false
+	}
+
+	for _, c := range label {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,311 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/supby/gigbee2mqtt/internal/logger"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	BoltDeviceDBFilename = "devices.db"
+	migratedFileSuffix   = ".migrated"
+)
+
+var (
+	devicesBucket = []byte("devices")
+)
+
+// NewBoltDeviceDB opens DeviceDB stored in bbolt file. UpdateDevice, called for every message from a device,
+// is committed in batches, other changes in their own transaction. On first start devices are imported from
+// JSON database if it exists.
+func NewBoltDeviceDB(dirname string, options DeviceDBOptions) (DeviceDB, error) {
+	if dirname != "" {
+		if err := os.MkdirAll(dirname, 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	boltDB, err := bolt.Open(filepath.Join(dirname, BoltDeviceDBFilename), 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = boltDB.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(devicesBucket)
+		return err
+	})
+	if err != nil {
+		boltDB.Close()
+		return nil, err
+	}
+
+	ret := &boltDeviceDB{
+		dirname: dirname,
+		options: options,
+		db:      boltDB,
+		logger:  logger.GetLogger("[Device DB]", options.LogLevel),
+	}
+
+	if err := ret.migrateFromJSON(); err != nil {
+		boltDB.Close()
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+type boltDeviceDB struct {
+	changeNotifier
+	dirname string
+	options DeviceDBOptions
+	db      *bolt.DB
+	logger  logger.Logger
+}
+
+func deviceKey(ieeeAddress uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, ieeeAddress)
+	return key
+}
+
+// migrateFromJSON imports devices.json once and renames it with its backups, so they are not imported again.
+// Backups are loaded the same way as by the JSON backend, when devices.json is missing or corrupted.
+func (d *boltDeviceDB) migrateFromJSON() error {
+	jsonDB := &deviceDB{
+		dirname: d.dirname,
+		options: d.options,
+		logger:  d.logger,
+	}
+
+	var files []string
+	for _, candidate := range jsonDB.candidateFiles() {
+		if _, err := os.Stat(candidate); err == nil {
+			files = append(files, candidate)
+		}
+	}
+	if len(files) == 0 {
+		return nil
+	}
+
+	devices, err := jsonDB.loadFromFile()
+	if err != nil {
+		return err
+	}
+
+	err = d.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(devicesBucket)
+		for _, dev := range devices {
+			// devices already in bolt are newer than ones in JSON
+			if b.Get(deviceKey(dev.IEEEAddress)) != nil {
+				continue
+			}
+
+			data, err := json.Marshal(dev)
+			if err != nil {
+				return err
+			}
+
+			if err := b.Put(deviceKey(dev.IEEEAddress), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if err := os.Rename(file, file+migratedFileSuffix); err != nil {
+			return err
+		}
+	}
+
+	d.logger.Info("Imported %v devices from JSON database", len(devices))
+
+	return nil
+}
+
+func (d *boltDeviceDB) GetDevices(ctx context.Context) ([]Device, error) {
+	var ret []Device
+
+	err := d.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(devicesBucket).ForEach(func(k, v []byte) error {
+			var dev Device
+			if err := json.Unmarshal(v, &dev); err != nil {
+				return err
+			}
+			ret = append(ret, dev)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+func (d *boltDeviceDB) GetDevice(ctx context.Context, ieeeAddress uint64) (Device, error) {
+	var ret Device
+	found := false
+
+	err := d.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(devicesBucket).Get(deviceKey(ieeeAddress))
+		if data == nil {
+			return nil
+		}
+
+		found = true
+		return json.Unmarshal(data, &ret)
+	})
+	if err != nil {
+		return Device{}, err
+	}
+
+	if !found {
+		return Device{}, errors.New("device does not exist")
+	}
+
+	return ret, nil
+}
+
+func (d *boltDeviceDB) SaveDevice(ctx context.Context, device Device) error {
+	data, err := json.Marshal(device)
+	if err != nil {
+		return err
+	}
+
+	var previous Device
+	changed := false
+	existed := false
+	err = d.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(devicesBucket)
+		key := deviceKey(device.IEEEAddress)
+
+		old := b.Get(key)
+		existed = old != nil
+		if existed && bytes.Equal(old, data) {
+			return nil
+		}
+		if existed {
+			if err := json.Unmarshal(old, &previous); err != nil {
+				return err
+			}
+		}
+
+		changed = true
+		return b.Put(key, data)
+	})
+	if err != nil {
+		return err
+	}
+
+	if changed {
+		d.notify(newDeviceChange(device, previous, existed))
+	}
+
+	return nil
+}
+
+// UpdateDevice is committed with concurrent updates in one transaction and one fsync, so a busy network does not
+// sync the file for every message. The trade-off: an update waits up to bolt.DefaultMaxBatchDelay (10ms) for
+// others to join, and the transaction function may run again when the batch is retried, so update has to be
+// idempotent. Errors of update do not fail the transaction, so they do not disturb other updates of the batch.
+func (d *boltDeviceDB) UpdateDevice(ctx context.Context, ieeeAddress uint64, update func(device *Device, exists bool) error) (Device, error) {
+	var ret, previous Device
+	var updateErr error
+	changed := false
+	existed := false
+
+	err := d.db.Batch(func(tx *bolt.Tx) error {
+		ret, previous, updateErr = Device{}, Device{}, nil
+		changed = false
+
+		b := tx.Bucket(devicesBucket)
+		key := deviceKey(ieeeAddress)
+
+		data := b.Get(key)
+		existed = data != nil
+		if existed {
+			if err := json.Unmarshal(data, &ret); err != nil {
+				return err
+			}
+			if err := json.Unmarshal(data, &previous); err != nil {
+				return err
+			}
+		}
+
+		ret.IEEEAddress = ieeeAddress
+		if updateErr = update(&ret, existed); updateErr != nil {
+			return nil
+		}
+
+		updated, err := json.Marshal(ret)
+		if err != nil {
+			return err
+		}
+
+		if existed && bytes.Equal(data, updated) {
+			return nil
+		}
+
+		changed = true
+		return b.Put(key, updated)
+	})
+	if err != nil {
+		return Device{}, err
+	}
+	if updateErr != nil {
+		return Device{}, updateErr
+	}
+
+	if changed {
+		d.notify(newDeviceChange(ret, previous, existed))
+	}
+
+	return ret, nil
+}
+
+func (d *boltDeviceDB) DeleteDevice(ctx context.Context, ieeeAddress uint64) error {
+	var device Device
+	existed := false
+
+	err := d.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(devicesBucket)
+		key := deviceKey(ieeeAddress)
+
+		data := b.Get(key)
+		if data == nil {
+			return nil
+		}
+
+		existed = true
+		if err := json.Unmarshal(data, &device); err != nil {
+			return err
+		}
+
+		return b.Delete(key)
+	})
+	if err != nil {
+		return err
+	}
+
+	if existed {
+		d.notify(DeviceChange{Type: DeviceRemoved, Device: device})
+	}
+
+	return nil
+}
+
+func (d *boltDeviceDB) Close(ctx context.Context) error {
+	return d.db.Close()
+}
@@ -0,0 +1,167 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBoltDeviceDB(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	dbIns, err := OpenDeviceDB(dir, DeviceDBOptions{Backend: BackendBolt})
+	assert.NoError(t, err)
+
+	dev1 := Device{
+		IEEEAddress:    12345,
+		NetworkAddress: 7890,
+		LogicalType:    67,
+		LQI:            33,
+		Depth:          1,
+	}
+	dev2 := Device{
+		IEEEAddress:    99999,
+		NetworkAddress: 8888,
+		LogicalType:    67,
+		LQI:            33,
+		Depth:          1,
+	}
+
+	assert.NoError(t, dbIns.SaveDevice(ctx, dev1))
+	assert.NoError(t, dbIns.SaveDevice(ctx, dev2))
+
+	device, err := dbIns.GetDevice(ctx, dev2.IEEEAddress)
+	assert.NoError(t, err)
+	assert.Equal(t, dev2.NetworkAddress, device.NetworkAddress)
+
+	assert.NoError(t, dbIns.DeleteDevice(ctx, dev1.IEEEAddress))
+
+	_, err = dbIns.GetDevice(ctx, dev1.IEEEAddress)
+	assert.Error(t, err)
+
+	assert.NoError(t, dbIns.Close(ctx))
+
+	// changes are on disk without explicit flush
+	dbIns, err = OpenDeviceDB(dir, DeviceDBOptions{Backend: BackendBolt})
+	assert.NoError(t, err)
+	defer dbIns.Close(ctx)
+
+	devices, err := dbIns.GetDevices(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(devices))
+	assert.Equal(t, dev2.IEEEAddress, devices[0].IEEEAddress)
+}
+
+func TestBoltDeviceDBConcurrentUpdates(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	dbIns, err := OpenDeviceDB(dir, DeviceDBOptions{Backend: BackendBolt})
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 1; i <= 50; i++ {
+		wg.Add(1)
+		go func(ieeeAddress uint64) {
+			defer wg.Done()
+
+			device, err := dbIns.UpdateDevice(ctx, ieeeAddress, func(device *Device, exists bool) error {
+				device.LQI = uint8(ieeeAddress)
+				return nil
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, uint8(ieeeAddress), device.LQI)
+		}(uint64(i))
+	}
+
+	// failed update is reported to its caller only
+	errRejected := errors.New("rejected")
+	_, err = dbIns.UpdateDevice(ctx, 1000, func(device *Device, exists bool) error {
+		return errRejected
+	})
+	assert.ErrorIs(t, err, errRejected)
+
+	wg.Wait()
+	assert.NoError(t, dbIns.Close(ctx))
+
+	dbIns, err = OpenDeviceDB(dir, DeviceDBOptions{Backend: BackendBolt})
+	assert.NoError(t, err)
+	defer dbIns.Close(ctx)
+
+	devices, err := dbIns.GetDevices(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 50, len(devices))
+}
+
+func TestBoltDeviceDBMigration(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	jsonDB, err := NewDeviceDB(dir, DeviceDBOptions{FlushPeriodInSeconds: 60})
+	assert.NoError(t, err)
+	assert.NoError(t, jsonDB.SaveDevice(ctx, Device{IEEEAddress: 12345, NetworkAddress: 7890}))
+	assert.NoError(t, jsonDB.Close(ctx))
+
+	dbIns, err := OpenDeviceDB(dir, DeviceDBOptions{Backend: BackendBolt})
+	assert.NoError(t, err)
+	defer dbIns.Close(ctx)
+
+	device, err := dbIns.GetDevice(ctx, 12345)
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(7890), device.NetworkAddress)
+
+	_, err = os.Stat(filepath.Join(dir, DeviceDBFilename))
+	assert.True(t, os.IsNotExist(err))
+
+	_, err = os.Stat(filepath.Join(dir, DeviceDBFilename+migratedFileSuffix))
+	assert.NoError(t, err)
+}
+
+func TestBoltDeviceDBMigrationFromBackup(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	options := DeviceDBOptions{FlushPeriodInSeconds: 60, BackupCount: 2}
+
+	jsonDB, err := NewDeviceDB(dir, options)
+	assert.NoError(t, err)
+	for i := uint64(1); i <= 2; i++ {
+		assert.NoError(t, jsonDB.SaveDevice(ctx, Device{IEEEAddress: i}))
+		assert.NoError(t, jsonDB.(*deviceDB).flushToFile())
+	}
+	assert.NoError(t, jsonDB.Close(ctx))
+
+	// devices.json is lost, the devices are imported from its backup
+	jsonPath := filepath.Join(dir, DeviceDBFilename)
+	assert.NoError(t, os.Remove(jsonPath))
+
+	options.Backend = BackendBolt
+	dbIns, err := OpenDeviceDB(dir, options)
+	assert.NoError(t, err)
+
+	devices, err := dbIns.GetDevices(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(devices))
+	assert.NoError(t, dbIns.Close(ctx))
+
+	_, err = os.Stat(backupPath(jsonPath, 1) + migratedFileSuffix)
+	assert.NoError(t, err, "backup is not imported again")
+
+	// corrupted devices.json does not fail the start while a backup is readable
+	assert.NoError(t, os.Rename(backupPath(jsonPath, 1)+migratedFileSuffix, backupPath(jsonPath, 1)))
+	assert.NoError(t, os.WriteFile(jsonPath, []byte(`{"Version":2,"Devi`), 0644))
+
+	dbIns, err = OpenDeviceDB(dir, options)
+	assert.NoError(t, err)
+	defer dbIns.Close(ctx)
+}
+
+func TestOpenDeviceDBUnknownBackend(t *testing.T) {
+	_, err := OpenDeviceDB(t.TempDir(), DeviceDBOptions{Backend: "mongo"})
+	assert.Error(t, err)
+}
@@ -2,26 +2,52 @@ package db
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/supby/gigbee2mqtt/internal/logger"
+	"github.com/supby/gigbee2mqtt/internal/metrics"
 )
 
 const (
 	DeviceDBFilename = "devices.json"
+	tmpFileSuffix    = ".tmp"
+
+	BackendJSON = "json"
+	BackendBolt = "bolt"
 )
 
 type DeviceDB interface {
 	GetDevices(ctx context.Context) ([]Device, error)
 	GetDevice(ctx context.Context, ieeeAddress uint64) (Device, error)
 	SaveDevice(ctx context.Context, device Device) error
+	// UpdateDevice atomically applies update to the stored device, exists is false for a new device
+	// which is saved only when update returns no error.
+	UpdateDevice(ctx context.Context, ieeeAddress uint64, update func(device *Device, exists bool) error) (Device, error)
 	DeleteDevice(ctx context.Context, ieeeAddress uint64) error
+	// SubscribeOnChange registers callback called after a device is added, changed or removed.
+	SubscribeOnChange(callback func(change DeviceChange))
 	Close(ctx context.Context) error
 }
 
+// OpenDeviceDB opens DeviceDB implementation selected by options.Backend, JSON file is used by default.
+func OpenDeviceDB(dirname string, options DeviceDBOptions) (DeviceDB, error) {
+	switch options.Backend {
+	case "", BackendJSON:
+		return NewDeviceDB(dirname, options)
+	case BackendBolt:
+		return NewBoltDeviceDB(dirname, options)
+	}
+
+	return nil, fmt.Errorf("unknown database backend '%v'", options.Backend)
+}
+
 func NewDeviceDB(dirname string, options DeviceDBOptions) (DeviceDB, error) {
 	tickerCtx, tickerCancel := context.WithCancel(context.Background())
 
@@ -31,6 +57,7 @@ func NewDeviceDB(dirname string, options DeviceDBOptions) (DeviceDB, error) {
 		deviceMap:    map[uint64]Device{},
 		tickerCtx:    tickerCtx,
 		tickerCancel: tickerCancel,
+		logger:       logger.GetLogger("[Device DB]", options.LogLevel),
 	}
 
 	devices, err := ret.loadFromFile()
@@ -48,16 +75,22 @@ func NewDeviceDB(dirname string, options DeviceDBOptions) (DeviceDB, error) {
 }
 
 type DeviceDBOptions struct {
-	FlushPeriodInSeconds int
+	Backend              string // json or bolt
+	FlushPeriodInSeconds int    // used by json backend only
+	BackupCount          int    // number of rotated devices.json backups, used by json backend only
+	LogLevel             int
 }
 
 type deviceDB struct {
+	changeNotifier
 	dirname      string
 	options      DeviceDBOptions
 	mtx          sync.Mutex
 	deviceMap    map[uint64]Device
+	dirty        bool
 	tickerCtx    context.Context
 	tickerCancel context.CancelFunc
+	logger       logger.Logger
 }
 
 func (d *deviceDB) startTicker() error {
@@ -77,46 +110,155 @@ func (d *deviceDB) startTicker() error {
 	return nil
 }
 
+// flushToFile atomically replaces devices file: data is written and synced to a temp file
+// which is renamed over the old one after rotating backups.
 func (d *deviceDB) flushToFile() error {
 	d.mtx.Lock()
 	defer d.mtx.Unlock()
 
-	jsonData, err := json.Marshal(d.deviceMap)
+	if !d.dirty {
+		return nil
+	}
+
+	start := time.Now()
+	defer func() { metrics.ObserveDBFlush(time.Since(start)) }()
+
+	jsonData, err := encodeDeviceDBFile(d.deviceMap)
 	if err != nil {
-		//d.logger.Log("Error Marshal DeviceMessage: %v\n", err)
 		return err
 	}
 
-	err = os.WriteFile(filepath.Join(d.dirname, DeviceDBFilename), jsonData, 0644)
-	if err != nil {
-		//d.logger.Log("Error Marshal DeviceMessage: %v\n", err)
+	filePath := filepath.Join(d.dirname, DeviceDBFilename)
+	tmpPath := filePath + tmpFileSuffix
+
+	if err := writeFileSync(tmpPath, jsonData, 0644); err != nil {
+		os.Remove(tmpPath)
 		return err
 	}
 
+	if err := d.rotateBackups(filePath); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpPath, filePath); err != nil {
+		return err
+	}
+
+	syncDir(d.dirname)
+
+	d.dirty = false
+
 	return nil
 }
 
+// rotateBackups shifts devices.json.1..N-1 to .2..N and current file to .1
+func (d *deviceDB) rotateBackups(filePath string) error {
+	if d.options.BackupCount <= 0 {
+		return nil
+	}
+
+	if _, err := os.Stat(filePath); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	for i := d.options.BackupCount - 1; i >= 1; i-- {
+		err := os.Rename(backupPath(filePath, i), backupPath(filePath, i+1))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	return os.Rename(filePath, backupPath(filePath, 1))
+}
+
+func backupPath(filePath string, n int) string {
+	return fmt.Sprintf("%s.%d", filePath, n)
+}
+
+func writeFileSync(filePath string, data []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// syncDir makes renames in directory durable, errors are ignored as not every platform supports it
+func syncDir(dirname string) {
+	if dirname == "" {
+		dirname = "."
+	}
+
+	dir, err := os.Open(dirname)
+	if err != nil {
+		return
+	}
+	defer dir.Close()
+
+	dir.Sync()
+}
+
+// candidateFiles returns paths of devices file and its backups, newest first.
+func (d *deviceDB) candidateFiles() []string {
+	filePath := filepath.Join(d.dirname, DeviceDBFilename)
+
+	ret := []string{filePath}
+	for i := 1; i <= d.options.BackupCount; i++ {
+		ret = append(ret, backupPath(filePath, i))
+	}
+
+	return ret
+}
+
+// loadFromFile reads devices file migrating it to current schema version,
+// backups are tried in order when it is missing or corrupted.
 func (d *deviceDB) loadFromFile() (map[uint64]Device, error) {
 	d.mtx.Lock()
 	defer d.mtx.Unlock()
 
 	filePath := filepath.Join(d.dirname, DeviceDBFilename)
 
-	if _, err := os.Stat(filePath); errors.Is(err, os.ErrNotExist) {
-		return make(map[uint64]Device), nil
-	}
+	var firstErr error
+	var skipped []string // files before the loaded one, with the reason they are not used
+	for _, candidate := range d.candidateFiles() {
+		data, err := os.ReadFile(candidate)
+		if errors.Is(err, os.ErrNotExist) {
+			skipped = append(skipped, fmt.Sprintf("'%v' is missing", candidate))
+			continue
+		}
+		if err == nil {
+			var devices map[uint64]Device
+			devices, err = decodeDeviceDBFile(data)
+			if err == nil {
+				if candidate != filePath {
+					d.logger.Warn("Loaded devices from backup '%v': %v", candidate, strings.Join(skipped, ", "))
+				}
+				return devices, nil
+			}
+		}
 
-	data, err := os.ReadFile(filePath)
-	if err != nil {
-		return nil, err
+		skipped = append(skipped, fmt.Sprintf("'%v' is corrupted: %v", candidate, err))
+		if firstErr == nil {
+			firstErr = fmt.Errorf("error loading '%v': %v", candidate, err)
+		}
 	}
 
-	var devices map[uint64]Device
-	if err := json.Unmarshal(data, &devices); err != nil {
-		return nil, err
+	if firstErr != nil {
+		return nil, firstErr
 	}
 
-	return devices, nil
+	return make(map[uint64]Device), nil
 }
 
 func (d *deviceDB) GetDevices(ctx context.Context) ([]Device, error) {
@@ -132,23 +274,82 @@ func (d *deviceDB) GetDevices(ctx context.Context) ([]Device, error) {
 }
 
 func (d *deviceDB) SaveDevice(ctx context.Context, device Device) error {
+	if change, ok := d.saveDevice(device); ok {
+		d.notify(change)
+	}
+
+	return nil
+}
+
+func (d *deviceDB) saveDevice(device Device) (DeviceChange, bool) {
 	d.mtx.Lock()
 	defer d.mtx.Unlock()
 
+	existing, exists := d.deviceMap[device.IEEEAddress]
+	if exists && reflect.DeepEqual(existing, device) {
+		return DeviceChange{}, false
+	}
+
 	d.deviceMap[device.IEEEAddress] = device
+	d.dirty = true
 
-	return nil
+	return newDeviceChange(device, existing, exists), true
+}
+
+func (d *deviceDB) UpdateDevice(ctx context.Context, ieeeAddress uint64, update func(device *Device, exists bool) error) (Device, error) {
+	d.mtx.Lock()
+
+	existing, exists := d.deviceMap[ieeeAddress]
+
+	device := existing
+	device.IEEEAddress = ieeeAddress
+	if err := update(&device, exists); err != nil {
+		d.mtx.Unlock()
+		return Device{}, err
+	}
+
+	if exists && reflect.DeepEqual(existing, device) {
+		d.mtx.Unlock()
+		return device, nil
+	}
+
+	d.deviceMap[ieeeAddress] = device
+	d.dirty = true
+
+	d.mtx.Unlock()
+
+	d.notify(newDeviceChange(device, existing, exists))
+
+	return device, nil
 }
 
 func (d *deviceDB) DeleteDevice(ctx context.Context, ieeeAddress uint64) error {
 	d.mtx.Lock()
-	defer d.mtx.Unlock()
+
+	device, ok := d.deviceMap[ieeeAddress]
+	if !ok {
+		d.mtx.Unlock()
+		return nil
+	}
 
 	delete(d.deviceMap, ieeeAddress)
+	d.dirty = true
+
+	d.mtx.Unlock()
+
+	d.notify(DeviceChange{Type: DeviceRemoved, Device: device})
 
 	return nil
 }
 
+func newDeviceChange(device Device, previous Device, existed bool) DeviceChange {
+	if existed {
+		return DeviceChange{Type: DeviceUpdated, Device: device, Previous: previous}
+	}
+
+	return DeviceChange{Type: DeviceAdded, Device: device}
+}
+
 func (d *deviceDB) GetDevice(ctx context.Context, ieeeAddress uint64) (Device, error) {
 	d.mtx.Lock()
 	defer d.mtx.Unlock()
@@ -162,7 +363,6 @@ func (d *deviceDB) GetDevice(ctx context.Context, ieeeAddress uint64) (Device, e
 
 func (d *deviceDB) Close(ctx context.Context) error {
 	d.tickerCancel()
-	d.flushToFile()
 
-	return nil
+	return d.flushToFile()
 }
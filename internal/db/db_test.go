@@ -2,10 +2,15 @@ package db
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/supby/gigbee2mqtt/internal/logger"
 )
 
 func sliceContainDevice(t *testing.T, devices []Device, pred func(d Device) bool) {
@@ -168,3 +173,214 @@ func TestGetDeviceNotExist(t *testing.T) {
 	_, err = db.GetDevice(ctx, dev1.IEEEAddress)
 	assert.Error(t, err)
 }
+
+func TestDeviceDBLoadLegacyFile(t *testing.T) {
+	dir := t.TempDir()
+
+	legacy := `{"12345":{"IEEEAddress":12345,"NetworkAddress":7890,"LogicalType":1,"LQI":33,"Depth":1}}`
+	err := os.WriteFile(filepath.Join(dir, DeviceDBFilename), []byte(legacy), 0644)
+	assert.NoError(t, err)
+
+	dbIns, err := NewDeviceDB(dir, DeviceDBOptions{
+		FlushPeriodInSeconds: 60,
+	})
+	assert.NoError(t, err)
+
+	device, err := dbIns.GetDevice(context.Background(), 12345)
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(7890), device.NetworkAddress)
+	assert.Equal(t, InterviewPending, device.InterviewStatus)
+	assert.Equal(t, PowerSourceUnknown, device.PowerSource)
+}
+
+func TestDeviceDBDeviceInfoRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	battery := uint8(87)
+	dev := Device{
+		IEEEAddress:      12345,
+		ManufacturerCode: 4098,
+		ManufacturerName: "IKEA of Sweden",
+		ModelID:          "TRADFRI bulb E27",
+		FirmwareBuild:    "2.3.086",
+		PowerSource:      PowerSourceBattery,
+		BatteryLevel:     &battery,
+		Endpoints: []Endpoint{
+			{Endpoint: 1, ProfileID: 260, InClusterList: []uint16{0, 6}, OutClusterList: []uint16{25}},
+		},
+		InterviewStatus: InterviewCompleted,
+		Tags:            []string{"kitchen"},
+		Notes:           "above the sink",
+	}
+
+	dbIns, err := NewDeviceDB(dir, DeviceDBOptions{FlushPeriodInSeconds: 60})
+	assert.NoError(t, err)
+	assert.NoError(t, dbIns.SaveDevice(ctx, dev))
+	assert.NoError(t, dbIns.Close(ctx))
+
+	dbIns, err = NewDeviceDB(dir, DeviceDBOptions{FlushPeriodInSeconds: 60})
+	assert.NoError(t, err)
+
+	device, err := dbIns.GetDevice(ctx, dev.IEEEAddress)
+	assert.NoError(t, err)
+	assert.Equal(t, dev, device)
+}
+
+func TestDeviceDBRejectsNewerSchema(t *testing.T) {
+	dir := t.TempDir()
+
+	data := fmt.Sprintf(`{"Version":%d,"Devices":{}}`, CurrentSchemaVersion+1)
+	err := os.WriteFile(filepath.Join(dir, DeviceDBFilename), []byte(data), 0644)
+	assert.NoError(t, err)
+
+	_, err = NewDeviceDB(dir, DeviceDBOptions{
+		FlushPeriodInSeconds: 60,
+	})
+	assert.Error(t, err)
+}
+
+func TestDeviceDBBackupsAndRecovery(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	options := DeviceDBOptions{
+		FlushPeriodInSeconds: 60,
+		BackupCount:          2,
+	}
+
+	dbIns, err := NewDeviceDB(dir, options)
+	assert.NoError(t, err)
+
+	for i := uint64(1); i <= 3; i++ {
+		assert.NoError(t, dbIns.SaveDevice(ctx, Device{IEEEAddress: i}))
+		assert.NoError(t, dbIns.(*deviceDB).flushToFile())
+	}
+
+	filePath := filepath.Join(dir, DeviceDBFilename)
+	_, err = os.Stat(backupPath(filePath, 2))
+	assert.NoError(t, err)
+	_, err = os.Stat(backupPath(filePath, 3))
+	assert.True(t, os.IsNotExist(err))
+
+	// main file is corrupted, the newest backup is used and it is logged
+	assert.NoError(t, os.WriteFile(filePath, []byte(`{"Version":2,"Devi`), 0644))
+
+	lines := make(chan []byte, 10)
+	logger.SetPublisher(logger.LogLevelWarn, "", func(data []byte) { lines <- data })
+	defer logger.SetPublisher(0, "", nil)
+
+	options.LogLevel = logger.LogLevelWarn
+	dbIns, err = NewDeviceDB(dir, options)
+	assert.NoError(t, err)
+
+	devices, err := dbIns.GetDevices(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(devices))
+
+	select {
+	case line := <-lines:
+		assert.Contains(t, string(line), fmt.Sprintf("Loaded devices from backup '%v'", backupPath(filePath, 1)))
+		assert.Contains(t, string(line), fmt.Sprintf("'%v' is corrupted", filePath))
+	case <-time.After(time.Second):
+		t.Fatal("loading backup is not logged")
+	}
+}
+
+func TestDeviceDBSkipsUnchangedFlush(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	dbIns, err := NewDeviceDB(dir, DeviceDBOptions{
+		FlushPeriodInSeconds: 60,
+	})
+	assert.NoError(t, err)
+
+	dev := Device{IEEEAddress: 12345}
+	assert.NoError(t, dbIns.SaveDevice(ctx, dev))
+	assert.NoError(t, dbIns.(*deviceDB).flushToFile())
+
+	filePath := filepath.Join(dir, DeviceDBFilename)
+	assert.NoError(t, os.Remove(filePath))
+
+	assert.NoError(t, dbIns.SaveDevice(ctx, dev))
+	assert.NoError(t, dbIns.(*deviceDB).flushToFile())
+
+	_, err = os.Stat(filePath)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestUpdateDevice(t *testing.T) {
+	for _, backend := range []string{BackendJSON, BackendBolt} {
+		t.Run(backend, func(t *testing.T) {
+			ctx := context.Background()
+
+			dbIns, err := OpenDeviceDB(t.TempDir(), DeviceDBOptions{Backend: backend, FlushPeriodInSeconds: 60})
+			assert.NoError(t, err)
+			defer dbIns.Close(ctx)
+
+			// aborted update of a new device saves nothing
+			_, err = dbIns.UpdateDevice(ctx, 12345, func(device *Device, exists bool) error {
+				assert.False(t, exists)
+				return errors.New("abort")
+			})
+			assert.Error(t, err)
+			_, err = dbIns.GetDevice(ctx, 12345)
+			assert.Error(t, err)
+
+			device, err := dbIns.UpdateDevice(ctx, 12345, func(device *Device, exists bool) error {
+				device.Notes = "note"
+				return nil
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, uint64(12345), device.IEEEAddress)
+
+			device, err = dbIns.UpdateDevice(ctx, 12345, func(device *Device, exists bool) error {
+				assert.True(t, exists)
+				device.LQI = 33
+				return nil
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, "note", device.Notes)
+
+			device, err = dbIns.GetDevice(ctx, 12345)
+			assert.NoError(t, err)
+			assert.Equal(t, uint8(33), device.LQI)
+			assert.Equal(t, "note", device.Notes)
+		})
+	}
+}
+
+func TestDeviceChangeNotifications(t *testing.T) {
+	for _, backend := range []string{BackendJSON, BackendBolt} {
+		t.Run(backend, func(t *testing.T) {
+			ctx := context.Background()
+
+			dbIns, err := OpenDeviceDB(t.TempDir(), DeviceDBOptions{Backend: backend, FlushPeriodInSeconds: 60})
+			assert.NoError(t, err)
+			defer dbIns.Close(ctx)
+
+			var changes []DeviceChange
+			dbIns.SubscribeOnChange(func(change DeviceChange) {
+				changes = append(changes, change)
+			})
+
+			assert.NoError(t, dbIns.SaveDevice(ctx, Device{IEEEAddress: 1}))
+			assert.NoError(t, dbIns.SaveDevice(ctx, Device{IEEEAddress: 1}))
+			_, err = dbIns.UpdateDevice(ctx, 1, func(device *Device, exists bool) error {
+				device.LQI = 10
+				return nil
+			})
+			assert.NoError(t, err)
+			assert.NoError(t, dbIns.DeleteDevice(ctx, 1))
+			assert.NoError(t, dbIns.DeleteDevice(ctx, 1))
+
+			assert.Equal(t, 3, len(changes))
+			assert.Equal(t, DeviceAdded, changes[0].Type)
+			assert.Equal(t, DeviceUpdated, changes[1].Type)
+			assert.Equal(t, uint8(10), changes[1].Device.LQI)
+			assert.Equal(t, uint8(0), changes[1].Previous.LQI)
+			assert.Equal(t, DeviceRemoved, changes[2].Type)
+			assert.Equal(t, uint64(1), changes[2].Device.IEEEAddress)
+		})
+	}
+}
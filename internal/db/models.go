@@ -2,12 +2,44 @@ package db
 
 import "time"
 
+const (
+	InterviewPending    = "pending"
+	InterviewInProgress = "in_progress"
+	InterviewCompleted  = "completed"
+	InterviewFailed     = "failed"
+
+	PowerSourceUnknown = "unknown"
+	PowerSourceMains   = "mains"
+	PowerSourceBattery = "battery"
+	PowerSourceDC      = "dc"
+)
+
 type Device struct {
-	IEEEAddress    uint64
-	NetworkAddress uint16
-	LogicalType    uint8
-	LQI            uint8
-	Depth          uint8
-	LastDiscovered time.Time
-	LastReceived   time.Time
+	IEEEAddress      uint64
+	NetworkAddress   uint16
+	LogicalType      uint8
+	LQI              uint8
+	Depth            uint8
+	LastDiscovered   time.Time
+	LastReceived     time.Time
+	ManufacturerCode uint16
+	ManufacturerName string
+	ModelID          string
+	FirmwareBuild    string
+	PowerSource      string
+	BatteryLevel     *uint8 // percent, nil when unknown
+	Endpoints        []Endpoint
+	InterviewStatus  string
+	FriendlyName     string   // set by user
+	Tags             []string // set by user
+	Notes            string   // set by user
+}
+
+type Endpoint struct {
+	Endpoint       uint8
+	ProfileID      uint16
+	DeviceID       uint16
+	DeviceVersion  uint8
+	InClusterList  []uint16
+	OutClusterList []uint16
 }
@@ -0,0 +1,40 @@
+package db
+
+import (
+	"sync"
+)
+
+const (
+	DeviceAdded   = "added"
+	DeviceUpdated = "updated"
+	DeviceRemoved = "removed"
+)
+
+type DeviceChange struct {
+	Type     string // added, updated or removed
+	Device   Device
+	Previous Device // the device before an update
+}
+
+// changeNotifier delivers device changes to subscribers after the change is stored.
+type changeNotifier struct {
+	mtx       sync.Mutex
+	callbacks []func(change DeviceChange)
+}
+
+func (n *changeNotifier) SubscribeOnChange(callback func(change DeviceChange)) {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+
+	n.callbacks = append(n.callbacks, callback)
+}
+
+func (n *changeNotifier) notify(change DeviceChange) {
+	n.mtx.Lock()
+	callbacks := append([]func(change DeviceChange){}, n.callbacks...)
+	n.mtx.Unlock()
+
+	for _, cb := range callbacks {
+		cb(change)
+	}
+}
@@ -0,0 +1,91 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	// CurrentSchemaVersion is the version of devices.json layout written by this build.
+	CurrentSchemaVersion = 3
+)
+
+// deviceDBFile is the layout of devices.json. Version 1 files are a bare map of devices.
+type deviceDBFile struct {
+	Version int
+	Devices map[uint64]Device
+}
+
+type rawDevices map[string]map[string]interface{}
+
+// schemaMigrations[i] upgrades device records from version i+1 to i+2.
+// New db.Device fields which need non-zero values for existing records get a migration here.
+var schemaMigrations = []func(devices rawDevices) error{
+	// 1 -> 2: file is wrapped with schema version, records are unchanged
+	func(devices rawDevices) error { return nil },
+	// 2 -> 3: device info fields are added, existing devices have not been interviewed yet
+	func(devices rawDevices) error {
+		for _, dev := range devices {
+			dev["InterviewStatus"] = InterviewPending
+			dev["PowerSource"] = PowerSourceUnknown
+		}
+		return nil
+	},
+}
+
+func decodeDeviceDBFile(data []byte) (map[uint64]Device, error) {
+	var top map[string]json.RawMessage
+	if err := json.Unmarshal(data, &top); err != nil {
+		return nil, err
+	}
+
+	version := 1
+	devicesData := data
+	if versionData, ok := top["Version"]; ok {
+		if err := json.Unmarshal(versionData, &version); err != nil {
+			return nil, err
+		}
+		devicesData = top["Devices"]
+	}
+
+	if version > CurrentSchemaVersion {
+		return nil, fmt.Errorf("devices file schema version %v is newer than supported %v", version, CurrentSchemaVersion)
+	}
+
+	if version < CurrentSchemaVersion {
+		var raw rawDevices
+		if err := json.Unmarshal(devicesData, &raw); err != nil {
+			return nil, err
+		}
+
+		for v := version; v < CurrentSchemaVersion; v++ {
+			if err := schemaMigrations[v-1](raw); err != nil {
+				return nil, fmt.Errorf("error migrating devices from schema version %v: %v", v, err)
+			}
+		}
+
+		migrated, err := json.Marshal(raw)
+		if err != nil {
+			return nil, err
+		}
+		devicesData = migrated
+	}
+
+	devices := make(map[uint64]Device)
+	if len(devicesData) == 0 || string(devicesData) == "null" {
+		return devices, nil
+	}
+
+	if err := json.Unmarshal(devicesData, &devices); err != nil {
+		return nil, err
+	}
+
+	return devices, nil
+}
+
+func encodeDeviceDBFile(devices map[uint64]Device) ([]byte, error) {
+	return json.Marshal(deviceDBFile{
+		Version: CurrentSchemaVersion,
+		Devices: devices,
+	})
+}
@@ -0,0 +1,338 @@
+package history
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/supby/gigbee2mqtt/internal/configuration"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	HistoryDBFilename = "history.db"
+	cleanupPeriod     = 1 * time.Hour
+)
+
+type Sample struct {
+	Time  time.Time
+	Value float64
+}
+
+type Bucket struct {
+	Start time.Time
+	Min   float64
+	Max   float64
+	Avg   float64
+	Count int
+}
+
+// Store keeps time series of numeric attribute values per device, cluster and attribute.
+type Store interface {
+	// Record stores numeric and boolean values of one report, other values are skipped.
+	Record(ctx context.Context, ieeeAddress uint64, clusterID uint16, attributes map[string]interface{}, ts time.Time) error
+	Query(ctx context.Context, ieeeAddress uint64, clusterID uint16, attribute string, from time.Time, to time.Time) ([]Sample, error)
+	Close(ctx context.Context) error
+}
+
+func NewStore(dirname string, config configuration.HistoryConfiguration) (Store, error) {
+	if dirname != "" {
+		if err := os.MkdirAll(dirname, 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	boltDB, err := bolt.Open(filepath.Join(dirname, HistoryDBFilename), 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	cleanupCtx, cleanupCancel := context.WithCancel(context.Background())
+
+	ret := &boltStore{
+		db:            boltDB,
+		config:        config,
+		cleanupCtx:    cleanupCtx,
+		cleanupCancel: cleanupCancel,
+	}
+
+	ret.startCleanup()
+
+	return ret, nil
+}
+
+type boltStore struct {
+	db            *bolt.DB
+	config        configuration.HistoryConfiguration
+	cleanupCtx    context.Context
+	cleanupCancel context.CancelFunc
+	cleanupWg     sync.WaitGroup
+}
+
+// retention returns how long values of the attribute are kept, 0 means the attribute is not recorded.
+func (s *boltStore) retention(clusterID uint16, attribute string) time.Duration {
+	hours := s.config.DefaultRetentionHours
+	matchedCluster := false
+
+	for _, rule := range s.config.Retention {
+		if rule.ClusterID != clusterID {
+			continue
+		}
+
+		if rule.Attribute == attribute {
+			return time.Duration(rule.RetentionHours) * time.Hour
+		}
+
+		if rule.Attribute == "" && !matchedCluster {
+			hours = rule.RetentionHours
+			matchedCluster = true
+		}
+	}
+
+	return time.Duration(hours) * time.Hour
+}
+
+func seriesName(ieeeAddress uint64, clusterID uint16, attribute string) []byte {
+	return []byte(fmt.Sprintf("0x%x/%d/%s", ieeeAddress, clusterID, attribute))
+}
+
+func parseSeriesName(name []byte) (clusterID uint16, attribute string, ok bool) {
+	parts := strings.SplitN(string(name), "/", 3)
+	if len(parts) != 3 {
+		return 0, "", false
+	}
+
+	id, err := strconv.ParseUint(parts[1], 10, 16)
+	if err != nil {
+		return 0, "", false
+	}
+
+	return uint16(id), parts[2], true
+}
+
+func timeKey(ts time.Time) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(ts.UnixNano()))
+	return key
+}
+
+// Record writes all attributes in one transaction, reports recorded at the same time are batched together,
+// so a busy network does not cost a disk sync per attribute.
+func (s *boltStore) Record(ctx context.Context, ieeeAddress uint64, clusterID uint16, attributes map[string]interface{}, ts time.Time) error {
+	values := make(map[string][]byte, len(attributes))
+	for attribute, value := range attributes {
+		if s.retention(clusterID, attribute) <= 0 {
+			continue
+		}
+
+		numeric, ok := ToFloat(value)
+		if !ok {
+			continue
+		}
+
+		data := make([]byte, 8)
+		binary.BigEndian.PutUint64(data, math.Float64bits(numeric))
+		values[attribute] = data
+	}
+
+	if len(values) == 0 {
+		return nil
+	}
+
+	return s.db.Batch(func(tx *bolt.Tx) error {
+		for attribute, data := range values {
+			b, err := tx.CreateBucketIfNotExists(seriesName(ieeeAddress, clusterID, attribute))
+			if err != nil {
+				return err
+			}
+
+			if err := b.Put(timeKey(ts), data); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (s *boltStore) Query(ctx context.Context, ieeeAddress uint64, clusterID uint16, attribute string, from time.Time, to time.Time) ([]Sample, error) {
+	ret := make([]Sample, 0)
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(seriesName(ieeeAddress, clusterID, attribute))
+		if b == nil {
+			return nil
+		}
+
+		toKey := uint64(to.UnixNano())
+		c := b.Cursor()
+		for k, v := c.Seek(timeKey(from)); k != nil; k, v = c.Next() {
+			nanos := binary.BigEndian.Uint64(k)
+			if nanos > toKey {
+				break
+			}
+
+			ret = append(ret, Sample{
+				Time:  time.Unix(0, int64(nanos)),
+				Value: math.Float64frombits(binary.BigEndian.Uint64(v)),
+			})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+func (s *boltStore) Close(ctx context.Context) error {
+	s.cleanupCancel()
+	s.cleanupWg.Wait()
+
+	return s.db.Close()
+}
+
+func (s *boltStore) startCleanup() {
+	ticker := time.NewTicker(cleanupPeriod)
+
+	s.cleanupWg.Add(1)
+	go func() {
+		defer s.cleanupWg.Done()
+		for {
+			select {
+			case <-ticker.C:
+				s.cleanup(time.Now())
+			case <-s.cleanupCtx.Done():
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+}
+
+// cleanup removes values older than retention of their attribute.
+func (s *boltStore) cleanup(now time.Time) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		var emptySeries [][]byte
+
+		err := tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			clusterID, attribute, ok := parseSeriesName(name)
+			if !ok {
+				return nil
+			}
+
+			oldest := timeKey(now.Add(-s.retention(clusterID, attribute)))
+
+			c := b.Cursor()
+			for k, _ := c.First(); k != nil && bytes.Compare(k, oldest) < 0; k, _ = c.First() {
+				if err := c.Delete(); err != nil {
+					return err
+				}
+			}
+
+			if k, _ := c.First(); k == nil {
+				emptySeries = append(emptySeries, append([]byte{}, name...))
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, name := range emptySeries {
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Downsample groups samples into buckets of given size starting at from.
+func Downsample(samples []Sample, from time.Time, size time.Duration) []Bucket {
+	ret := make([]Bucket, 0)
+	if size <= 0 {
+		return ret
+	}
+
+	var current *Bucket
+	sum := 0.0
+	for _, sample := range samples {
+		start := from.Add(sample.Time.Sub(from) / size * size)
+
+		if current == nil || !current.Start.Equal(start) {
+			if current != nil {
+				current.Avg = sum / float64(current.Count)
+				ret = append(ret, *current)
+			}
+
+			current = &Bucket{
+				Start: start,
+				Min:   sample.Value,
+				Max:   sample.Value,
+			}
+			sum = 0
+		}
+
+		current.Min = math.Min(current.Min, sample.Value)
+		current.Max = math.Max(current.Max, sample.Value)
+		current.Count++
+		sum += sample.Value
+	}
+
+	if current != nil {
+		current.Avg = sum / float64(current.Count)
+		ret = append(ret, *current)
+	}
+
+	return ret
+}
+
+// ToFloat converts numeric and boolean attribute values, other values are not recorded.
+func ToFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int8:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,111 @@
+package history
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/supby/gigbee2mqtt/internal/configuration"
+)
+
+func TestStoreRecordQuery(t *testing.T) {
+	ctx := context.Background()
+
+	store, err := NewStore(t.TempDir(), configuration.HistoryConfiguration{
+		DefaultRetentionHours: 24,
+	})
+	assert.NoError(t, err)
+	defer store.Close(ctx)
+
+	now := time.Now()
+	assert.NoError(t, store.Record(ctx, 0x1234, 1026, map[string]interface{}{"measuredValue": 2150}, now.Add(-2*time.Minute)))
+	assert.NoError(t, store.Record(ctx, 0x1234, 1026, map[string]interface{}{"measuredValue": 2200, "tolerance": 10}, now.Add(-time.Minute)))
+	assert.NoError(t, store.Record(ctx, 0x1234, 1026, map[string]interface{}{"measuredValue": "not a number"}, now))
+	assert.NoError(t, store.Record(ctx, 0x5678, 1026, map[string]interface{}{"measuredValue": 1000}, now))
+
+	samples, err := store.Query(ctx, 0x1234, 1026, "measuredValue", now.Add(-time.Hour), now)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(samples))
+	assert.Equal(t, 2150.0, samples[0].Value)
+	assert.Equal(t, 2200.0, samples[1].Value)
+
+	samples, err = store.Query(ctx, 0x1234, 1026, "tolerance", now.Add(-time.Hour), now)
+	assert.NoError(t, err)
+	assert.Equal(t, []Sample{{Time: time.Unix(0, now.Add(-time.Minute).UnixNano()), Value: 10}}, samples)
+
+	samples, err = store.Query(ctx, 0x1234, 6, "onOff", now.Add(-time.Hour), now)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(samples))
+}
+
+func TestStoreRetention(t *testing.T) {
+	ctx := context.Background()
+
+	store, err := NewStore(t.TempDir(), configuration.HistoryConfiguration{
+		DefaultRetentionHours: 24,
+		Retention: []configuration.HistoryRetentionRule{
+			{ClusterID: 6, RetentionHours: 0},
+			{ClusterID: 1026, Attribute: "measuredValue", RetentionHours: 48},
+		},
+	})
+	assert.NoError(t, err)
+	defer store.Close(ctx)
+
+	bs := store.(*boltStore)
+	assert.Equal(t, 48*time.Hour, bs.retention(1026, "measuredValue"))
+	assert.Equal(t, 24*time.Hour, bs.retention(1026, "tolerance"))
+	assert.Equal(t, time.Duration(0), bs.retention(6, "onOff"))
+
+	now := time.Now()
+	assert.NoError(t, store.Record(ctx, 0x1234, 6, map[string]interface{}{"onOff": true}, now))
+	assert.NoError(t, store.Record(ctx, 0x1234, 1026, map[string]interface{}{"measuredValue": 1, "tolerance": 1}, now.Add(-36*time.Hour)))
+
+	samples, err := store.Query(ctx, 0x1234, 6, "onOff", now.Add(-time.Hour), now)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(samples))
+
+	assert.NoError(t, bs.cleanup(now))
+
+	samples, err = store.Query(ctx, 0x1234, 1026, "measuredValue", now.Add(-72*time.Hour), now)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(samples))
+
+	samples, err = store.Query(ctx, 0x1234, 1026, "tolerance", now.Add(-72*time.Hour), now)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(samples))
+}
+
+func TestDownsample(t *testing.T) {
+	from := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	buckets := Downsample([]Sample{
+		{Time: from.Add(10 * time.Second), Value: 1},
+		{Time: from.Add(20 * time.Second), Value: 3},
+		{Time: from.Add(70 * time.Second), Value: 5},
+	}, from, time.Minute)
+
+	assert.Equal(t, 2, len(buckets))
+	assert.Equal(t, from, buckets[0].Start)
+	assert.Equal(t, 1.0, buckets[0].Min)
+	assert.Equal(t, 3.0, buckets[0].Max)
+	assert.Equal(t, 2.0, buckets[0].Avg)
+	assert.Equal(t, 2, buckets[0].Count)
+	assert.Equal(t, from.Add(time.Minute), buckets[1].Start)
+	assert.Equal(t, 5.0, buckets[1].Avg)
+
+	assert.Equal(t, 0, len(Downsample(nil, from, time.Minute)))
+}
+
+func TestToFloat(t *testing.T) {
+	v, ok := ToFloat(uint16(42))
+	assert.True(t, ok)
+	assert.Equal(t, 42.0, v)
+
+	v, ok = ToFloat(true)
+	assert.True(t, ok)
+	assert.Equal(t, 1.0, v)
+
+	_, ok = ToFloat("text")
+	assert.False(t, ok)
+}
@@ -0,0 +1,87 @@
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/supby/gigbee2mqtt/internal/configuration"
+	"github.com/supby/gigbee2mqtt/internal/logger"
+)
+
+// Server is the gateway HTTP server, features register their handlers on it before Start.
+type Server interface {
+	Handle(pattern string, handler http.Handler)
+	// SubscribeOnError is called when the server stops serving on its own, e.g. the listener fails.
+	SubscribeOnError(cb func(err error))
+	Start() error
+	Addr() string
+	Close(ctx context.Context) error
+}
+
+func NewServer(config *configuration.HTTPConfiguration, logLevel int) Server {
+	mux := http.NewServeMux()
+
+	return &server{
+		config: config,
+		mux:    mux,
+		srv: &http.Server{
+			Handler: mux,
+		},
+		logger: logger.GetLogger("[HTTP Server]", logLevel),
+	}
+}
+
+type server struct {
+	config   *configuration.HTTPConfiguration
+	mux      *http.ServeMux
+	srv      *http.Server
+	listener net.Listener
+	logger   logger.Logger
+	onError  func(err error)
+}
+
+func (s *server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+func (s *server) SubscribeOnError(cb func(err error)) {
+	s.onError = cb
+}
+
+func (s *server) Start() error {
+	l, err := net.Listen("tcp", fmt.Sprintf("%v:%v", s.config.Address, s.config.Port))
+	if err != nil {
+		return err
+	}
+	s.listener = l
+
+	s.logger.Info("Listening on %v", l.Addr())
+
+	go func() {
+		err := s.srv.Serve(l)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.logger.Error("HTTP server error: %v", err)
+			if s.onError != nil {
+				s.onError(fmt.Errorf("HTTP server: %w", err))
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Addr returns address the server listens on, useful when port 0 is configured.
+func (s *server) Addr() string {
+	if s.listener == nil {
+		return ""
+	}
+
+	return s.listener.Addr().String()
+}
+
+func (s *server) Close(ctx context.Context) error {
+	return s.srv.Shutdown(ctx)
+}
@@ -0,0 +1,112 @@
+package lifecycle
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/supby/gigbee2mqtt/internal/logger"
+)
+
+// Exit codes of the gateway process.
+const (
+	EXIT_OK             = 0
+	EXIT_FAILURE        = 1 // initialisation failed or the gateway can not go on, e.g. the lost coordinator is not reopened
+	EXIT_SHUTDOWN_ERROR = 2 // a shutdown step failed or did not finish before the deadline
+)
+
+type step struct {
+	name string
+	stop func(ctx context.Context) error
+}
+
+// Manager stops the gateway in steps run in reverse order they were added, like deferred calls,
+// so every step runs while everything started before it is still available.
+type Manager struct {
+	mtx    sync.Mutex
+	steps  []step
+	failed chan error
+	logger logger.Logger
+}
+
+func NewManager(logLevel int) *Manager {
+	return &Manager{
+		failed: make(chan error, 1),
+		logger: logger.GetLogger("[Lifecycle]", logLevel),
+	}
+}
+
+// OnStop adds shutdown step, stop is given context with the shutdown deadline.
+func (m *Manager) OnStop(name string, stop func(ctx context.Context) error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.steps = append(m.steps, step{name: name, stop: stop})
+}
+
+// Fail asks for shutdown with EXIT_FAILURE, e.g. from a goroutine which can not go on.
+func (m *Manager) Fail(err error) {
+	select {
+	case m.failed <- err:
+	default:
+	}
+}
+
+// Wait blocks until SIGINT or SIGTERM is received or Fail is called, onReload is called on SIGHUP.
+// Exit code the process should end with after Shutdown is returned.
+func (m *Manager) Wait(onReload func()) int {
+	sigchan := make(chan os.Signal, 1)
+	signal.Notify(sigchan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(sigchan)
+
+	return m.wait(sigchan, onReload)
+}
+
+func (m *Manager) wait(sigchan <-chan os.Signal, onReload func()) int {
+	for {
+		select {
+		case sig := <-sigchan:
+			if sig == syscall.SIGHUP {
+				onReload()
+				continue
+			}
+
+			m.logger.Info("%v received, shutting down", sig)
+			return EXIT_OK
+		case err := <-m.failed:
+			m.logger.Error("Shutting down on error: %v\n", err)
+			return EXIT_FAILURE
+		}
+	}
+}
+
+// Shutdown runs the steps, all of them within timeout. exitCode is returned, or EXIT_SHUTDOWN_ERROR
+// when it is EXIT_OK and a step failed.
+func (m *Manager) Shutdown(timeout time.Duration, exitCode int) int {
+	m.mtx.Lock()
+	steps := m.steps
+	m.steps = nil
+	m.mtx.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	failed := false
+	for i := len(steps) - 1; i >= 0; i-- {
+		m.logger.Debug("Stopping %v", steps[i].name)
+
+		if err := steps[i].stop(ctx); err != nil {
+			m.logger.Error("Error stopping %v: %v\n", steps[i].name, err)
+			failed = true
+		}
+	}
+
+	if failed && exitCode == EXIT_OK {
+		return EXIT_SHUTDOWN_ERROR
+	}
+
+	return exitCode
+}
@@ -0,0 +1,62 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/supby/gigbee2mqtt/internal/logger"
+)
+
+func TestShutdownOrder(t *testing.T) {
+	m := NewManager(logger.LogLevelError)
+
+	var stopped []string
+	for _, name := range []string{"db", "mqtt", "commands"} {
+		name := name
+		m.OnStop(name, func(ctx context.Context) error {
+			stopped = append(stopped, name)
+			return nil
+		})
+	}
+
+	assert.Equal(t, EXIT_OK, m.Shutdown(time.Second, EXIT_OK))
+	assert.Equal(t, []string{"commands", "mqtt", "db"}, stopped)
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	m := NewManager(logger.LogLevelError)
+
+	dbClosed := false
+	m.OnStop("db", func(ctx context.Context) error {
+		dbClosed = true
+		return nil
+	})
+	m.OnStop("drain", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	assert.Equal(t, EXIT_SHUTDOWN_ERROR, m.Shutdown(10*time.Millisecond, EXIT_OK))
+	assert.True(t, dbClosed, "steps after the failed one are run")
+}
+
+func TestWait(t *testing.T) {
+	m := NewManager(logger.LogLevelError)
+
+	sigchan := make(chan os.Signal, 2)
+	sigchan <- syscall.SIGHUP
+	sigchan <- syscall.SIGTERM
+
+	reloads := 0
+	assert.Equal(t, EXIT_OK, m.wait(sigchan, func() { reloads++ }))
+	assert.Equal(t, 1, reloads)
+
+	m.Fail(errors.New("coordinator is lost"))
+	assert.Equal(t, EXIT_FAILURE, m.wait(make(chan os.Signal), func() {}))
+	assert.Equal(t, EXIT_FAILURE, m.Shutdown(time.Second, EXIT_FAILURE))
+}
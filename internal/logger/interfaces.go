@@ -7,5 +7,7 @@ type Logger interface {
 	Warn(message string, v ...interface{})
 	Error(message string, v ...interface{})
 	Debug(message string, v ...interface{})
+	// With returns logger adding key/value pairs to every line, e.g. With("device", addr, "cluster", id).
+	With(keysAndValues ...interface{}) Logger
 	GetWriter() io.Writer
 }
@@ -3,63 +3,136 @@ package logger
 import (
 	"fmt"
 	"io"
-	"log"
-	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// Levels are ordered by verbosity, a logger writes lines with level up to its own, e.g. LogLevelInfo
+// writes errors, warnings and info.
 const (
-	LogLevelInfo  = 0
+	LogLevelError = 0
 	LogLevelWarn  = 1
-	LogLevelError = 2
+	LogLevelInfo  = 2
 	LogLevelDebug = 3
 )
 
+var levelNames = []string{"error", "warn", "info", "debug"}
+
+// module is shared by loggers with the same prefix created by one GetLogger and by their With.
+type module struct {
+	prefix string
+	level  int32
+}
+
 type logger struct {
-	prefix      string
-	innerLogger *log.Logger
-	level       int
+	module *module
+	fields []interface{}
 }
 
+var (
+	loggersMtx   sync.Mutex
+	modules      []*module
+	moduleLevels map[string]int
+)
+
 func GetLogger(prefix string, level int) Logger {
-	return &logger{
-		prefix:      prefix,
-		innerLogger: log.New(os.Stdout, "", log.Ldate|log.Ltime|log.Lmicroseconds),
-		level:       level,
+	loggersMtx.Lock()
+	defer loggersMtx.Unlock()
+
+	if moduleLevel, ok := moduleLevels[moduleName(prefix)]; ok {
+		level = moduleLevel
+	}
+
+	m := &module{
+		prefix: prefix,
+		level:  int32(level),
+	}
+	modules = append(modules, m)
+
+	return &logger{module: m}
+}
+
+// SetLevels changes level of all loggers, modules maps module name, e.g. "MQTT Client" for prefix
+// "[MQTT Client]", to its own level. Loggers created later get the module level too.
+func SetLevels(level int, levels map[string]int) {
+	loggersMtx.Lock()
+	defer loggersMtx.Unlock()
+
+	moduleLevels = levels
+
+	for _, m := range modules {
+		moduleLevel, ok := levels[moduleName(m.prefix)]
+		if !ok {
+			moduleLevel = level
+		}
+
+		atomic.StoreInt32(&m.level, int32(moduleLevel))
 	}
 }
 
+func moduleName(prefix string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(prefix, "["), "]")
+}
+
+func (l *logger) enabled(level int32) bool {
+	return level <= atomic.LoadInt32(&l.module.level)
+}
+
+// With returns logger adding key/value pairs, e.g. With("device", "0x00124b0012345678"), to every line.
+func (l *logger) With(keysAndValues ...interface{}) Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(keysAndValues))
+	fields = append(fields, l.fields...)
+	fields = append(fields, keysAndValues...)
+
+	return &logger{module: l.module, fields: fields}
+}
+
 func (l *logger) Info(message string, v ...interface{}) {
-	l.log(fmt.Sprintf("[INFO] %v", message), v)
+	l.log(LogLevelInfo, message, v...)
 }
 
 func (l *logger) Warn(message string, v ...interface{}) {
-	if l.level < LogLevelWarn {
-		return
-	}
-
-	l.log(fmt.Sprintf("[WARN] %v", message), v)
+	l.log(LogLevelWarn, message, v...)
 }
 
 func (l *logger) Error(message string, v ...interface{}) {
-	if l.level < LogLevelError {
-		return
-	}
-
-	l.log(fmt.Sprintf("[ERROR] %v", message), v)
+	l.log(LogLevelError, message, v...)
 }
 
 func (l *logger) Debug(message string, v ...interface{}) {
-	if l.level < LogLevelDebug {
+	l.log(LogLevelDebug, message, v...)
+}
+
+func (l *logger) log(level int32, message string, v ...interface{}) {
+	if !l.enabled(level) {
 		return
 	}
 
-	l.log(fmt.Sprintf("[DEBUG] %v", message), v)
-}
+	if len(v) > 0 {
+		message = fmt.Sprintf(message, v...)
+	}
 
-func (l *logger) log(message string, v ...interface{}) {
-	l.innerLogger.Printf("%v %v\n", l.prefix, fmt.Sprintf(message, v...))
+	write(Entry{
+		Time:    time.Now(),
+		Level:   int(level),
+		Module:  moduleName(l.module.prefix),
+		Message: strings.TrimRight(message, "\n"),
+		Fields:  l.fields,
+	})
 }
 
+// GetWriter returns writer logging every write as error, for libraries logging to io.Writer.
 func (l *logger) GetWriter() io.Writer {
-	return l.innerLogger.Writer()
+	return writerFunc(func(p []byte) (int, error) {
+		l.Error("%s", p)
+		return len(p), nil
+	})
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
 }
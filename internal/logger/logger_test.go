@@ -0,0 +1,133 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func captureOutput(t *testing.T, options Options) *bytes.Buffer {
+	var buf bytes.Buffer
+
+	out.mtx.Lock()
+	stdout := out.stdout
+	out.stdout = &buf
+	out.mtx.Unlock()
+
+	assert.NoError(t, Configure(options))
+
+	t.Cleanup(func() {
+		Configure(Options{})
+		SetPublisher(0, "", nil)
+
+		out.mtx.Lock()
+		out.stdout = stdout
+		out.mtx.Unlock()
+	})
+
+	return &buf
+}
+
+func TestLevels(t *testing.T) {
+	buf := captureOutput(t, Options{})
+
+	l := GetLogger("[Test Levels]", LogLevelWarn)
+	l.Debug("debug\n")
+	l.Info("info\n")
+	l.Warn("warn %v\n", 1)
+	l.Error("error %x %v\n", []byte{0x84, 0x2e}, false)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(t, lines, 2)
+	assert.True(t, strings.HasSuffix(lines[0], "[Test Levels] [WARN] warn 1"), lines[0])
+	assert.True(t, strings.HasSuffix(lines[1], "[Test Levels] [ERROR] error 842e false"), lines[1])
+
+	buf.Reset()
+	SetLevels(LogLevelError, map[string]int{"Test Levels": LogLevelDebug})
+	defer SetLevels(LogLevelDebug, nil)
+
+	l.Debug("debug")
+	assert.Contains(t, buf.String(), "[DEBUG] debug")
+}
+
+func TestJSONFormat(t *testing.T) {
+	buf := captureOutput(t, Options{Format: FORMAT_JSON})
+
+	l := GetLogger("[Test JSON]", LogLevelInfo).With("device", "0x1", "cluster", uint16(6))
+	l.With("transaction", 3).Error("failed: %v\n", errors.New("timeout"))
+
+	var entry map[string]interface{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, "error", entry["level"])
+	assert.Equal(t, "Test JSON", entry["module"])
+	assert.Equal(t, "failed: timeout", entry["msg"])
+	assert.Equal(t, "0x1", entry["device"])
+	assert.Equal(t, float64(6), entry["cluster"])
+	assert.Equal(t, float64(3), entry["transaction"])
+
+	_, err := time.Parse(time.RFC3339Nano, entry["time"].(string))
+	assert.NoError(t, err)
+}
+
+func TestTextFields(t *testing.T) {
+	buf := captureOutput(t, Options{})
+
+	GetLogger("[Test Text]", LogLevelInfo).With("device", "0x1", "err", errors.New("no route")).Info("sent")
+
+	assert.True(t, strings.HasSuffix(strings.TrimSpace(buf.String()), `[Test Text] [INFO] sent device=0x1 err="no route"`), buf.String())
+}
+
+func TestFileRotation(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "gigbee2mqtt.log")
+	captureOutput(t, Options{File: filename, MaxSizeMB: 1, MaxBackups: 2})
+
+	l := GetLogger("[Test File]", LogLevelInfo)
+	line := strings.Repeat("x", 1000)
+	for i := 0; i < 3*1100; i++ {
+		l.Info(line)
+	}
+
+	for _, name := range []string{filename, filename + ".1", filename + ".2"} {
+		info, err := os.Stat(name)
+		assert.NoError(t, err)
+		assert.LessOrEqual(t, info.Size(), int64(1024*1024))
+	}
+	_, err := os.Stat(filename + ".3")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestPublisher(t *testing.T) {
+	captureOutput(t, Options{})
+
+	published := make(chan []byte, 10)
+	SetPublisher(LogLevelWarn, "MQTT Client", func(data []byte) {
+		published <- data
+	})
+
+	GetLogger("[MQTT Client]", LogLevelDebug).Error("not published")
+	l := GetLogger("[Test Publisher]", LogLevelDebug)
+	l.Info("not published")
+	l.Warn("published")
+
+	select {
+	case data := <-published:
+		var entry map[string]interface{}
+		assert.NoError(t, json.Unmarshal(data, &entry))
+		assert.Equal(t, "published", entry["msg"])
+	case <-time.After(time.Second):
+		t.Fatal("line is not published")
+	}
+
+	select {
+	case data := <-published:
+		t.Fatalf("unexpected line %s", data)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,229 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	FORMAT_TEXT = "text"
+	FORMAT_JSON = "json"
+)
+
+// publishQueueSize is how many lines wait for the publisher before new ones are dropped.
+const publishQueueSize = 256
+
+// Entry is one log line.
+type Entry struct {
+	Time    time.Time
+	Level   int
+	Module  string
+	Message string
+	Fields  []interface{} // key/value pairs
+}
+
+// Options configure format and outputs shared by all loggers, lines are always written to stdout.
+type Options struct {
+	Format     string // text (default) or json, one object per line
+	File       string // file written in addition to stdout when set
+	MaxSizeMB  int    // file is rotated when it grows over the size, 0 disables rotation
+	MaxBackups int    // rotated files kept as File.1 ... File.N
+}
+
+type publisher struct {
+	level   int
+	exclude string
+	lines   chan []byte
+}
+
+type output struct {
+	mtx       sync.Mutex
+	format    string
+	stdout    io.Writer
+	file      *rotatingFile
+	options   Options
+	publisher *publisher
+}
+
+var out = &output{
+	format: FORMAT_TEXT,
+	stdout: os.Stdout,
+}
+
+// Configure sets format and file output, previous settings are kept when the file can not be opened.
+func Configure(options Options) error {
+	format := options.Format
+	switch format {
+	case "":
+		format = FORMAT_TEXT
+	case FORMAT_TEXT, FORMAT_JSON:
+	default:
+		return fmt.Errorf("unknown log format '%v'", options.Format)
+	}
+
+	out.mtx.Lock()
+	defer out.mtx.Unlock()
+
+	if options.File != out.options.File || options.MaxSizeMB != out.options.MaxSizeMB || options.MaxBackups != out.options.MaxBackups {
+		var file *rotatingFile
+		if options.File != "" {
+			var err error
+			if file, err = openRotatingFile(options.File, options.MaxSizeMB, options.MaxBackups); err != nil {
+				return err
+			}
+		}
+
+		if out.file != nil {
+			out.file.Close()
+		}
+		out.file = file
+	}
+
+	out.format = format
+	out.options = options
+
+	return nil
+}
+
+// SetPublisher sends lines up to level as JSON objects to publish, e.g. to MQTT. Lines are sent from
+// own goroutine and dropped when publish does not keep up. Lines of module exclude are not sent,
+// so the module publishing them can log without a feedback loop. nil publish stops sending.
+func SetPublisher(level int, exclude string, publish func(data []byte)) {
+	out.mtx.Lock()
+	defer out.mtx.Unlock()
+
+	if out.publisher != nil {
+		close(out.publisher.lines)
+		out.publisher = nil
+	}
+
+	if publish == nil {
+		return
+	}
+
+	p := &publisher{
+		level:   level,
+		exclude: exclude,
+		lines:   make(chan []byte, publishQueueSize),
+	}
+	go func() {
+		for line := range p.lines {
+			publish(line)
+		}
+	}()
+
+	out.publisher = p
+}
+
+func write(entry Entry) {
+	out.mtx.Lock()
+	defer out.mtx.Unlock()
+
+	var line []byte
+	if out.format == FORMAT_JSON {
+		line = encodeJSON(entry)
+	} else {
+		line = encodeText(entry)
+	}
+	line = append(line, '\n')
+
+	out.stdout.Write(line)
+	if out.file != nil {
+		out.file.Write(line)
+	}
+
+	if p := out.publisher; p != nil && entry.Level <= p.level && entry.Module != p.exclude {
+		select {
+		case p.lines <- encodeJSON(entry):
+		default:
+		}
+	}
+}
+
+// encodeText writes e.g. 2021/01/02 15:04:05.000000 [Zigbee Router] [INFO] message device=0x1 cluster=6
+func encodeText(entry Entry) []byte {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "%v [%v] [%v] %v",
+		entry.Time.Format("2006/01/02 15:04:05.000000"),
+		entry.Module,
+		strings.ToUpper(levelName(entry.Level)),
+		entry.Message)
+
+	for i := 0; i < len(entry.Fields); i += 2 {
+		value := fmt.Sprint(fieldValue(entry.Fields, i+1))
+		if value == "" || strings.ContainsAny(value, " \"=\n") {
+			value = strconv.Quote(value)
+		}
+
+		fmt.Fprintf(&buf, " %v=%v", entry.Fields[i], value)
+	}
+
+	return buf.Bytes()
+}
+
+// encodeJSON writes e.g. {"time":"2021-01-02T15:04:05.000000Z","level":"info","module":"Zigbee Router","msg":"message","device":"0x1"}
+func encodeJSON(entry Entry) []byte {
+	var buf bytes.Buffer
+
+	writeJSONField(&buf, "time", entry.Time.UTC().Format("2006-01-02T15:04:05.000000Z07:00"))
+	writeJSONField(&buf, "level", levelName(entry.Level))
+	writeJSONField(&buf, "module", entry.Module)
+	writeJSONField(&buf, "msg", entry.Message)
+
+	for i := 0; i < len(entry.Fields); i += 2 {
+		writeJSONField(&buf, fmt.Sprint(entry.Fields[i]), fieldValue(entry.Fields, i+1))
+	}
+	buf.WriteByte('}')
+
+	return buf.Bytes()
+}
+
+func writeJSONField(buf *bytes.Buffer, key string, value interface{}) {
+	if buf.Len() == 0 {
+		buf.WriteByte('{')
+	} else {
+		buf.WriteByte(',')
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(value))
+	}
+
+	keyData, _ := json.Marshal(key)
+	buf.Write(keyData)
+	buf.WriteByte(':')
+	buf.Write(data)
+}
+
+// fieldValue returns value at i, errors and Stringers as strings, nil when key has no value.
+func fieldValue(fields []interface{}, i int) interface{} {
+	if i >= len(fields) {
+		return nil
+	}
+
+	switch value := fields[i].(type) {
+	case error:
+		return value.Error()
+	case fmt.Stringer:
+		return value.String()
+	default:
+		return value
+	}
+}
+
+func levelName(level int) string {
+	if level < 0 || level >= len(levelNames) {
+		return strconv.Itoa(level)
+	}
+
+	return levelNames[level]
+}
@@ -0,0 +1,90 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+)
+
+const logFileMode = 0640
+
+// rotatingFile appends to the file and renames it to File.1 when it grows over maxSize,
+// File.1 to File.2 and so on up to maxBackups.
+type rotatingFile struct {
+	filename   string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+func openRotatingFile(filename string, maxSizeMB int, maxBackups int) (*rotatingFile, error) {
+	f := &rotatingFile{
+		filename:   filename,
+		maxSize:    int64(maxSizeMB) * 1024 * 1024,
+		maxBackups: maxBackups,
+	}
+
+	return f, f.open()
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, logFileMode)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	f.file = file
+	f.size = info.Size()
+
+	return nil
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+
+	return n, err
+}
+
+func (f *rotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+
+	var err error
+	if f.maxBackups > 0 {
+		for i := f.maxBackups - 1; i > 0; i-- {
+			os.Rename(backupName(f.filename, i), backupName(f.filename, i+1))
+		}
+		err = os.Rename(f.filename, backupName(f.filename, 1))
+	} else {
+		err = os.Remove(f.filename)
+	}
+
+	// the file is reopened even when it could not be moved, so logging goes on
+	if openErr := f.open(); openErr != nil {
+		return openErr
+	}
+
+	return err
+}
+
+func (f *rotatingFile) Close() error {
+	return f.file.Close()
+}
+
+func backupName(filename string, i int) string {
+	return fmt.Sprintf("%v.%v", filename, i)
+}
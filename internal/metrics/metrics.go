@@ -0,0 +1,216 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	namespace = "gigbee2mqtt"
+
+	CommandSet       = "set"
+	CommandGet       = "get"
+	CommandWrite     = "write"
+	CommandReporting = "configure_reporting"
+)
+
+var (
+	registry = prometheus.NewRegistry()
+
+	// eventLoopDepth mirrors eventLoopQueueDepth for diagnostics, gauges can not be read back
+	eventLoopDepth int64
+
+	incomingMessages = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "incoming_messages_total",
+		Help:      "Messages received from devices per cluster.",
+	}, []string{"cluster"})
+
+	commandsSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "commands_sent_total",
+		Help:      "Commands sent to devices per command type.",
+	}, []string{"type"})
+
+	sendErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "send_errors_total",
+		Help:      "Failures of sending commands to devices per command type.",
+	}, []string{"type"})
+
+	unmarshalErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "unmarshal_errors_total",
+		Help:      "Incoming messages which could not be parsed as ZCL.",
+	})
+
+	mqttConnected = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "mqtt_connected",
+		Help:      "1 when connected to MQTT broker.",
+	})
+
+	coordinatorConnected = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "coordinator_connected",
+		Help:      "1 while the Zigbee coordinator is open and answering.",
+	})
+
+	eventLoopQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "event_loop_queue_depth",
+		Help:      "Zigbee events read from the coordinator which are still being processed.",
+	})
+
+	dbFlushDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_flush_duration_seconds",
+		Help:      "Duration of writing device database to disk.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 4, 8),
+	})
+
+	deviceCollector = &devicesCollector{
+		lqi: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "device", "lqi"),
+			"Link quality of the device.",
+			[]string{"ieee_address", "friendly_name"}, nil),
+		lastSeenAge: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "device", "last_seen_age_seconds"),
+			"Seconds since the last message received from the device.",
+			[]string{"ieee_address", "friendly_name"}, nil),
+	}
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		incomingMessages,
+		commandsSent,
+		sendErrors,
+		unmarshalErrors,
+		mqttConnected,
+		coordinatorConnected,
+		eventLoopQueueDepth,
+		dbFlushDuration,
+		deviceCollector,
+	)
+}
+
+// Handler serves metrics in Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+func IncomingMessage(cluster string) {
+	incomingMessages.WithLabelValues(cluster).Inc()
+}
+
+func CommandSent(commandType string) {
+	commandsSent.WithLabelValues(commandType).Inc()
+}
+
+func SendError(commandType string) {
+	sendErrors.WithLabelValues(commandType).Inc()
+}
+
+func UnmarshalError() {
+	unmarshalErrors.Inc()
+}
+
+func SetMQTTConnected(connected bool) {
+	if connected {
+		mqttConnected.Set(1)
+	} else {
+		mqttConnected.Set(0)
+	}
+}
+
+func SetCoordinatorConnected(connected bool) {
+	if connected {
+		coordinatorConnected.Set(1)
+	} else {
+		coordinatorConnected.Set(0)
+	}
+}
+
+// EventStarted and EventDone track depth of the event loop queue.
+func EventStarted() {
+	atomic.AddInt64(&eventLoopDepth, 1)
+	eventLoopQueueDepth.Inc()
+}
+
+func EventDone() {
+	atomic.AddInt64(&eventLoopDepth, -1)
+	eventLoopQueueDepth.Dec()
+}
+
+// EventLoopQueueDepth returns number of Zigbee events still being processed.
+func EventLoopQueueDepth() int64 {
+	return atomic.LoadInt64(&eventLoopDepth)
+}
+
+func ObserveDBFlush(d time.Duration) {
+	dbFlushDuration.Observe(d.Seconds())
+}
+
+type DeviceStats struct {
+	IEEEAddress  uint64
+	FriendlyName string
+	LQI          uint8
+	LastSeen     time.Time
+}
+
+// SetDeviceSource sets function listing devices for per device metrics, it is called on every scrape.
+func SetDeviceSource(source func() ([]DeviceStats, error)) {
+	deviceCollector.mtx.Lock()
+	defer deviceCollector.mtx.Unlock()
+
+	deviceCollector.source = source
+}
+
+type devicesCollector struct {
+	mtx         sync.Mutex
+	source      func() ([]DeviceStats, error)
+	lqi         *prometheus.Desc
+	lastSeenAge *prometheus.Desc
+}
+
+func (c *devicesCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.lqi
+	ch <- c.lastSeenAge
+}
+
+func (c *devicesCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mtx.Lock()
+	source := c.source
+	c.mtx.Unlock()
+
+	if source == nil {
+		return
+	}
+
+	devices, err := source()
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(c.lqi, err)
+		return
+	}
+
+	now := time.Now()
+	for _, d := range devices {
+		address := fmt.Sprintf("0x%016x", d.IEEEAddress)
+
+		ch <- prometheus.MustNewConstMetric(c.lqi, prometheus.GaugeValue, float64(d.LQI), address, d.FriendlyName)
+
+		if !d.LastSeen.IsZero() {
+			ch <- prometheus.MustNewConstMetric(c.lastSeenAge, prometheus.GaugeValue, now.Sub(d.LastSeen).Seconds(), address, d.FriendlyName)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/supby/gigbee2mqtt/internal/configuration"
+	"github.com/supby/gigbee2mqtt/internal/httpserver"
+)
+
+func TestMetricsEndpoint(t *testing.T) {
+	srv := httpserver.NewServer(&configuration.HTTPConfiguration{Address: "127.0.0.1"}, 0)
+	srv.Handle("/metrics", Handler())
+	assert.NoError(t, srv.Start())
+	defer srv.Close(context.Background())
+
+	IncomingMessage("genOnOff")
+	IncomingMessage("genOnOff")
+	CommandSent(CommandSet)
+	SendError(CommandGet)
+	UnmarshalError()
+	SetMQTTConnected(true)
+	SetCoordinatorConnected(true)
+	EventStarted()
+	ObserveDBFlush(5 * time.Millisecond)
+
+	SetDeviceSource(func() ([]DeviceStats, error) {
+		return []DeviceStats{
+			{IEEEAddress: 0x842e14fffe05b879, FriendlyName: "kitchen_light", LQI: 170, LastSeen: time.Now().Add(-time.Minute)},
+		}, nil
+	})
+	defer SetDeviceSource(nil)
+
+	resp, err := http.Get(fmt.Sprintf("http://%v/metrics", srv.Addr()))
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	body := string(data)
+
+	for _, expected := range []string{
+		`gigbee2mqtt_incoming_messages_total{cluster="genOnOff"} 2`,
+		`gigbee2mqtt_commands_sent_total{type="set"} 1`,
+		`gigbee2mqtt_send_errors_total{type="get"} 1`,
+		`gigbee2mqtt_unmarshal_errors_total 1`,
+		`gigbee2mqtt_mqtt_connected 1`,
+		`gigbee2mqtt_coordinator_connected 1`,
+		`gigbee2mqtt_event_loop_queue_depth 1`,
+		`gigbee2mqtt_db_flush_duration_seconds_count 1`,
+		`gigbee2mqtt_device_lqi{friendly_name="kitchen_light",ieee_address="0x842e14fffe05b879"} 170`,
+		`gigbee2mqtt_device_last_seen_age_seconds{friendly_name="kitchen_light",ieee_address="0x842e14fffe05b879"}`,
+	} {
+		assert.True(t, strings.Contains(body, expected), expected)
+	}
+}
@@ -0,0 +1,108 @@
+package mqtt
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	mqttlib "github.com/eclipse/paho.mqtt.golang"
+	"github.com/supby/gigbee2mqtt/internal/configuration"
+)
+
+// AdminClient is a short lived MQTT client sending commands to a running gateway and waiting for answers,
+// unlike the gateway client it does not publish status and does not queue messages while offline.
+type AdminClient struct {
+	client    mqttlib.Client
+	rootTopic string
+}
+
+func NewAdminClient(ctx context.Context, config *configuration.MqttConfiguration) (*AdminClient, error) {
+	opts, err := newClientOptions(config)
+	if err != nil {
+		return nil, err
+	}
+
+	clientID := config.ClientID
+	if clientID == "" {
+		clientID = config.RootTopic
+	}
+	opts.SetClientID(fmt.Sprintf("%v-admin-%d", clientID, os.Getpid()))
+	opts.SetCleanSession(true)
+	opts.SetStore(mqttlib.NewMemoryStore())
+	opts.AutoReconnect = false
+	opts.SetConnectRetry(false)
+
+	client := mqttlib.NewClient(opts)
+	token := client.Connect()
+	select {
+	case <-token.Done():
+	case <-ctx.Done():
+		return nil, fmt.Errorf("connecting to MQTT broker: %w", ctx.Err())
+	}
+	if token.Error() != nil {
+		return nil, fmt.Errorf("connecting to MQTT broker: %w", token.Error())
+	}
+
+	return &AdminClient{
+		client:    client,
+		rootTopic: config.RootTopic,
+	}, nil
+}
+
+// Request subscribes to answerSubTopic, publishes payload to subTopic and returns the first answer accepted by match.
+// Empty subTopic only waits, e.g. for a retained message. Topics are relative to the root topic.
+func (c *AdminClient) Request(ctx context.Context, subTopic string, payload []byte, answerSubTopic string, match func(answer []byte) bool) ([]byte, error) {
+	answers := make(chan []byte, 16)
+	answerTopic := fmt.Sprintf("%v/%v", c.rootTopic, answerSubTopic)
+
+	token := c.client.Subscribe(answerTopic, 1, func(client mqttlib.Client, msg mqttlib.Message) {
+		if match == nil || match(msg.Payload()) {
+			select {
+			case answers <- msg.Payload():
+			default:
+			}
+		}
+	})
+	if err := waitToken(ctx, token); err != nil {
+		return nil, fmt.Errorf("subscribing to '%v': %w", answerTopic, err)
+	}
+	defer c.client.Unsubscribe(answerTopic)
+
+	if subTopic != "" {
+		if err := c.Publish(ctx, subTopic, payload); err != nil {
+			return nil, err
+		}
+	}
+
+	select {
+	case answer := <-answers:
+		return answer, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("no answer on '%v': %w", answerTopic, ctx.Err())
+	}
+}
+
+func (c *AdminClient) Publish(ctx context.Context, subTopic string, payload []byte) error {
+	topic := fmt.Sprintf("%v/%v", c.rootTopic, subTopic)
+	if err := waitToken(ctx, c.client.Publish(topic, 1, false, payload)); err != nil {
+		return fmt.Errorf("publishing to '%v': %w", topic, err)
+	}
+
+	return nil
+}
+
+func (c *AdminClient) Close() {
+	c.client.Disconnect(250)
+}
+
+func waitToken(ctx context.Context, token mqttlib.Token) error {
+	select {
+	case <-token.Done():
+		return token.Error()
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(publishTimeout):
+		return fmt.Errorf("timeout")
+	}
+}
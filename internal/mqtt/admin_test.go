@@ -0,0 +1,58 @@
+package mqtt
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	mqttlib "github.com/eclipse/paho.mqtt.golang"
+	"github.com/stretchr/testify/assert"
+	"github.com/supby/gigbee2mqtt/internal/broker"
+	"github.com/supby/gigbee2mqtt/internal/configuration"
+)
+
+func TestAdminClientRequest(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	port := uint16(l.Addr().(*net.TCPAddr).Port)
+	l.Close()
+
+	b, err := broker.NewBroker(&configuration.EmbeddedBrokerConfiguration{Enabled: true, Address: "127.0.0.1", Port: port}, 0)
+	assert.NoError(t, err)
+	defer b.Close()
+
+	cfg := &configuration.MqttConfiguration{
+		Scheme:    "tcp",
+		Address:   "127.0.0.1",
+		Port:      port,
+		RootTopic: "admin",
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// plays the gateway answering get_config twice, only the second answer matches
+	gateway := mqttlib.NewClient(mqttlib.NewClientOptions().AddBroker(l.Addr().String()).SetClientID("gateway"))
+	assert.True(t, gateway.Connect().WaitTimeout(5*time.Second))
+	defer gateway.Disconnect(0)
+	assert.True(t, gateway.Subscribe("admin/gateway/get_config", 0, func(c mqttlib.Client, m mqttlib.Message) {
+		c.Publish("admin/gateway/config", 0, false, `{"PermitJoin": false}`)
+		c.Publish("admin/gateway/config", 0, false, `{"PermitJoin": true}`)
+	}).WaitTimeout(5*time.Second))
+
+	client, err := NewAdminClient(ctx, cfg)
+	assert.NoError(t, err)
+	defer client.Close()
+
+	answer, err := client.Request(ctx, "gateway/get_config", []byte("{}"), "gateway/config", func(answer []byte) bool {
+		return string(answer) == `{"PermitJoin": true}`
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"PermitJoin": true}`, string(answer))
+
+	shortCtx, shortCancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer shortCancel()
+	_, err = client.Request(shortCtx, "0x1/get", []byte("{}"), "0x1", nil)
+	assert.Error(t, err)
+}
@@ -1,95 +1,333 @@
 package mqtt
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"log"
+	"os"
+	"strings"
+	"sync"
 	"time"
 
 	mqttlib "github.com/eclipse/paho.mqtt.golang"
 	"github.com/supby/gigbee2mqtt/internal/configuration"
 	"github.com/supby/gigbee2mqtt/internal/logger"
+	"github.com/supby/gigbee2mqtt/internal/metrics"
 )
 
-func NewClient(config *configuration.Configuration) (MqttClient, func()) {
-	retClient := defaultMqttClient{
-		configuration: config,
-		logger:        logger.GetLogger("[MQTT Client]", config.LogLevel),
+const (
+	ProtocolVersion5 = 5
+	publishTimeout   = 10 * time.Second
+)
+
+func NewClient(config *configuration.Configuration) (MqttClient, func(), error) {
+	if config.MqttConfiguration.ProtocolVersion == ProtocolVersion5 {
+		return newV5Client(config)
+	}
+
+	retClient := &defaultMqttClient{
+		mqttConfig: config.MqttConfiguration,
+		logger:     logger.GetLogger("[MQTT Client]", config.LogLevel),
 	}
 
 	// TODO: introduce log level to config
-	// mqttlib.DEBUG = log.New(retClient.logger.GetWriter(), "[MQTT Client]", 0)
-	mqttlib.ERROR = log.New(retClient.logger.GetWriter(), "[MQTT Client]", 0)
+	// mqttlib.DEBUG = log.New(retClient.logger.GetWriter(), "", 0)
+	mqttlib.ERROR = log.New(retClient.logger.GetWriter(), "", 0)
+
+	retClient.outbox = newOutbox(config.MqttConfiguration.OfflineQueue.MaxSize, config.MqttConfiguration.OfflineQueue.Dir, retClient.logger)
+	retClient.outbox.isConnected = retClient.IsConnected
+	retClient.outbox.publish = retClient.publish
+
+	if err := retClient.connect(&config.MqttConfiguration); err != nil {
+		return nil, nil, err
+	}
+
+	return retClient, func() { retClient.Dispose() }, nil
+}
+
+// connect creates inner client for config and starts connecting, the previous inner client is disconnected.
+func (cl *defaultMqttClient) connect(config *configuration.MqttConfiguration) error {
+	opts, err := newClientOptions(config)
+	if err != nil {
+		return err
+	}
+	opts.OnConnect = cl.onConnect
+	opts.OnConnectionLost = func(client mqttlib.Client, err error) {
+		metrics.SetMQTTConnected(false)
+		cl.logger.Info("Connect lost: %v", err)
+	}
+
+	innerClient := mqttlib.NewClient(opts)
+
+	cl.mtx.Lock()
+	prevClient := cl.innerClient
+	cl.innerClient = innerClient
+	cl.mqttConfig = *config
+	cl.mtx.Unlock()
+
+	if prevClient != nil {
+		prevClient.Disconnect(250)
+		metrics.SetMQTTConnected(false)
+	}
+
+	// with connect retry the token completes only once connected, so do not block on it
+	innerClient.Connect()
+
+	return nil
+}
+
+func newClientOptions(config *configuration.MqttConfiguration) (*mqttlib.ClientOptions, error) {
+	brokerURL, err := getBrokerURL(config)
+	if err != nil {
+		return nil, err
+	}
+
+	clientID := config.ClientID
+	if clientID == "" {
+		clientID = config.RootTopic
+	}
 
 	opts := mqttlib.NewClientOptions()
-	opts.AddBroker(fmt.Sprintf("tcp://%s:%d", config.MqttConfiguration.Address, config.MqttConfiguration.Port))
-	opts.SetClientID(config.MqttConfiguration.RootTopic)
-	opts.SetUsername(config.MqttConfiguration.Username)
-	opts.SetPassword(config.MqttConfiguration.Password)
+	opts.AddBroker(brokerURL)
+	opts.SetClientID(clientID)
+	opts.SetUsername(config.Username)
+	opts.SetPassword(config.Password)
+	opts.SetCleanSession(!config.PersistentSession)
+	if config.PersistentSession && config.SessionStoreDir != "" {
+		opts.SetStore(mqttlib.NewFileStore(config.SessionStoreDir))
+	}
 	opts.AutoReconnect = true
+	opts.SetConnectRetry(true)
 	opts.SetKeepAlive(60 * time.Second)
 	opts.SetPingTimeout(1 * time.Second)
 	opts.SetOrderMatters(false)
-	opts.OnConnect = func(client mqttlib.Client) {
-		retClient.logger.Info("Connected")
+
+	if isSecureScheme(config.Scheme) {
+		tlsConfig, err := newTLSConfig(&config.TLS)
+		if err != nil {
+			return nil, err
+		}
+		opts.SetTLSConfig(tlsConfig)
 	}
-	opts.OnConnectionLost = func(client mqttlib.Client, err error) {
-		retClient.logger.Info("Connect lost: %v", err)
+
+	return opts, nil
+}
+
+func isSecureScheme(scheme string) bool {
+	return scheme == "ssl" || scheme == "wss"
+}
+
+func getBrokerURL(config *configuration.MqttConfiguration) (string, error) {
+	switch config.Scheme {
+	case "", "tcp", "ssl":
+		scheme := config.Scheme
+		if scheme == "" {
+			scheme = "tcp"
+		}
+		return fmt.Sprintf("%s://%s:%d", scheme, config.Address, config.Port), nil
+	case "ws", "wss":
+		path := config.Path
+		if path != "" && !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+		return fmt.Sprintf("%s://%s:%d%s", config.Scheme, config.Address, config.Port, path), nil
 	}
 
-	innerClient := mqttlib.NewClient(opts)
+	return "", fmt.Errorf("unsupported MQTT scheme '%v'", config.Scheme)
+}
 
-	if token := innerClient.Connect(); token.Wait() && token.Error() != nil {
-		log.Fatal(token.Error())
+func newTLSConfig(config *configuration.MqttTLSConfiguration) (*tls.Config, error) {
+	ret := &tls.Config{
+		ServerName:         config.ServerName,
+		InsecureSkipVerify: config.InsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
 	}
 
-	if token := innerClient.Subscribe(fmt.Sprintf("%s/#", config.MqttConfiguration.RootTopic), 0, retClient.onMessageReceived); token.Wait() && token.Error() != nil {
-		log.Fatal(token.Error())
+	if config.CAFile != "" {
+		caData, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading CA file: %v", err)
+		}
+
+		ret.RootCAs = x509.NewCertPool()
+		if !ret.RootCAs.AppendCertsFromPEM(caData) {
+			return nil, fmt.Errorf("no certificates found in CA file '%v'", config.CAFile)
+		}
 	}
 
-	retClient.logger.Info("Connected to MQTT on '%v:%v'", config.MqttConfiguration.Address, config.MqttConfiguration.Port)
-	innerClient.Publish(fmt.Sprintf("%v/gateway/status", config.MqttConfiguration.RootTopic), 0, false, "Online")
+	if config.CertFile != "" || config.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading client certificate: %v", err)
+		}
 
-	retClient.innerClient = innerClient
+		ret.Certificates = []tls.Certificate{cert}
+	}
 
-	return &retClient, func() { retClient.Dispose() }
+	return ret, nil
 }
 
 type MqttClient interface {
 	Dispose()
 	Publish(subTopic string, data []byte)
+	// PublishWithOptions publishes under root topic with given QoS, retain flag and MQTT 5 properties.
+	PublishWithOptions(subTopic string, data []byte, opts PublishOptions)
+	// PublishResponse publishes to absolute topic, normally Response Topic of a request.
+	PublishResponse(topic string, data []byte, opts PublishOptions)
 	Subscribe(callback func(topic string, message []byte))
+	SubscribeWithProperties(callback func(topic string, message []byte, props MessageProperties))
 	UnSubscribe()
+	// Reconnect connects with new settings, messages published meanwhile are buffered in the offline queue.
+	// Protocol version and offline queue can not be changed.
+	Reconnect(config *configuration.MqttConfiguration) error
+	IsConnected() bool
+	// QueueLength returns number of messages buffered while the broker is unreachable.
+	QueueLength() int
 }
 
 type defaultMqttClient struct {
+	mtx             sync.Mutex
 	innerClient     mqttlib.Client
-	messageCallback func(topic string, message []byte)
-	configuration   *configuration.Configuration
+	mqttConfig      configuration.MqttConfiguration
+	messageCallback func(topic string, message []byte, props MessageProperties)
 	logger          logger.Logger
+	outbox          *outbox
 }
 
+func (cl *defaultMqttClient) client() (mqttlib.Client, configuration.MqttConfiguration) {
+	cl.mtx.Lock()
+	defer cl.mtx.Unlock()
+
+	return cl.innerClient, cl.mqttConfig
+}
+
+// Dispose publishes offline status and disconnects, messages being published are given time to complete.
 func (cl *defaultMqttClient) Dispose() {
 	cl.logger.Info("Disposing MQTT client")
-	cl.innerClient.Disconnect(0)
+
+	client, cfg := cl.client()
+	if client.IsConnectionOpen() {
+		token := client.Publish(fmt.Sprintf("%v/gateway/status", cfg.RootTopic), 0, false, "Offline")
+		token.WaitTimeout(publishTimeout)
+	}
+
+	client.Disconnect(250)
+}
+
+func (cl *defaultMqttClient) Reconnect(config *configuration.MqttConfiguration) error {
+	if err := checkReconnect(cl.config(), config); err != nil {
+		return err
+	}
+
+	cl.logger.Info("Reconnecting to MQTT on '%v://%v:%v%v'", config.Scheme, config.Address, config.Port, config.Path)
+
+	return cl.connect(config)
+}
+
+func (cl *defaultMqttClient) config() configuration.MqttConfiguration {
+	_, config := cl.client()
+	return config
 }
 
 func (cl *defaultMqttClient) Publish(subTopic string, data []byte) {
-	cl.innerClient.Publish(fmt.Sprintf("%v/%v", cl.configuration.MqttConfiguration.RootTopic, subTopic), 0, false, data)
+	cl.PublishWithOptions(subTopic, data, PublishOptions{})
+}
+
+func (cl *defaultMqttClient) PublishWithOptions(subTopic string, data []byte, opts PublishOptions) {
+	cl.PublishResponse(fmt.Sprintf("%v/%v", cl.config().RootTopic, subTopic), data, opts)
+}
+
+func (cl *defaultMqttClient) PublishResponse(topic string, data []byte, opts PublishOptions) {
+	cl.outbox.Send(queuedMessage{
+		Topic:   topic,
+		Payload: data,
+		QoS:     opts.QoS,
+		Retain:  opts.Retain,
+	})
 }
 
 func (cl *defaultMqttClient) Subscribe(callback func(topic string, message []byte)) {
+	cl.SubscribeWithProperties(func(topic string, message []byte, props MessageProperties) {
+		callback(topic, message)
+	})
+}
+
+func (cl *defaultMqttClient) SubscribeWithProperties(callback func(topic string, message []byte, props MessageProperties)) {
+	cl.mtx.Lock()
+	defer cl.mtx.Unlock()
+
 	cl.messageCallback = callback
 }
 
 func (cl *defaultMqttClient) UnSubscribe() {
+	cl.mtx.Lock()
+	defer cl.mtx.Unlock()
+
 	cl.messageCallback = nil
 }
 
+// callback is called from the goroutine of the MQTT library, so it is read under lock.
+func (cl *defaultMqttClient) callback() func(topic string, message []byte, props MessageProperties) {
+	cl.mtx.Lock()
+	defer cl.mtx.Unlock()
+
+	return cl.messageCallback
+}
+
+func (cl *defaultMqttClient) IsConnected() bool {
+	client, _ := cl.client()
+	return client.IsConnectionOpen()
+}
+
+func (cl *defaultMqttClient) QueueLength() int {
+	return cl.outbox.Len()
+}
+
+func (cl *defaultMqttClient) publish(msg queuedMessage) error {
+	client, _ := cl.client()
+	token := client.Publish(msg.Topic, msg.QoS, msg.Retain, msg.Payload)
+	if !token.WaitTimeout(publishTimeout) {
+		return fmt.Errorf("publish timeout")
+	}
+
+	return token.Error()
+}
+
+func (cl *defaultMqttClient) onConnect(client mqttlib.Client) {
+	cfg := cl.config()
+
+	cl.logger.Info("Connected to MQTT on '%v://%v:%v%v'", cfg.Scheme, cfg.Address, cfg.Port, cfg.Path)
+	metrics.SetMQTTConnected(true)
+
+	// subscription is lost on broker side with clean session, so it is renewed on every connect
+	if token := client.Subscribe(fmt.Sprintf("%s/#", cfg.RootTopic), cfg.QoS.CommandsSubscription, cl.onMessageReceived); token.Wait() && token.Error() != nil {
+		cl.logger.Error("Error subscribing to '%s/#': %v", cfg.RootTopic, token.Error())
+	}
+
+	client.Publish(fmt.Sprintf("%v/gateway/status", cfg.RootTopic), 0, false, "Online")
+
+	cl.outbox.Drain()
+}
+
 func (cl *defaultMqttClient) onMessageReceived(client mqttlib.Client, msg mqttlib.Message) {
 	topic := msg.Topic()
 	message := msg.Payload()
 
-	if cl.messageCallback != nil {
-		go cl.messageCallback(topic, message)
+	if callback := cl.callback(); callback != nil {
+		go callback(topic, message, MessageProperties{})
+	}
+}
+
+// checkReconnect rejects changes of settings the client is created with.
+func checkReconnect(prev configuration.MqttConfiguration, config *configuration.MqttConfiguration) error {
+	if (prev.ProtocolVersion == ProtocolVersion5) != (config.ProtocolVersion == ProtocolVersion5) {
+		return fmt.Errorf("changing MQTT protocol version requires restart")
 	}
+
+	if prev.OfflineQueue != config.OfflineQueue {
+		return fmt.Errorf("changing MQTT offline queue requires restart")
+	}
+
+	return nil
 }
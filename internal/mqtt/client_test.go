@@ -0,0 +1,369 @@
+package mqtt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/supby/gigbee2mqtt/internal/configuration"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+func newTestCert(t *testing.T, cn string, parent *testCert, isCA bool) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:              []string{cn},
+	}
+
+	parentCert, parentKey := tmpl, key
+	if parent != nil {
+		parentCert, parentKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parentCert, &key.PublicKey, parentKey)
+	assert.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}),
+	}
+}
+
+func writeTestFile(t *testing.T, dir string, name string, data []byte) string {
+	path := filepath.Join(dir, name)
+	assert.NoError(t, os.WriteFile(path, data, 0600))
+	return path
+}
+
+// serveFakeBroker answers the packets the client sends right after connecting
+// and reports topics of published messages.
+func serveFakeBroker(conn io.ReadWriter, published chan<- string) {
+	for {
+		cp, err := packets.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+
+		var resp packets.ControlPacket
+		switch p := cp.(type) {
+		case *packets.ConnectPacket:
+			resp = packets.NewControlPacket(packets.Connack)
+		case *packets.SubscribePacket:
+			suback := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+			suback.MessageID = p.MessageID
+			suback.ReturnCodes = p.Qoss
+			resp = suback
+		case *packets.PingreqPacket:
+			resp = packets.NewControlPacket(packets.Pingresp)
+		case *packets.PublishPacket:
+			published <- p.TopicName
+		case *packets.DisconnectPacket:
+			return
+		}
+
+		if resp != nil {
+			if err := resp.Write(conn); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func waitForTopic(t *testing.T, published <-chan string, topic string) {
+	for {
+		select {
+		case p := <-published:
+			if p == topic {
+				return
+			}
+		case <-time.After(5 * time.Second):
+			assert.Fail(t, "message is not published", topic)
+			return
+		}
+	}
+}
+
+func portOf(t *testing.T, addr string) uint16 {
+	_, portStr, err := net.SplitHostPort(addr)
+	assert.NoError(t, err)
+
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	assert.NoError(t, err)
+
+	return uint16(port)
+}
+
+func TestClientMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+
+	ca := newTestCert(t, "test-ca", nil, true)
+	serverCert := newTestCert(t, "localhost", ca, false)
+	clientCert := newTestCert(t, "gateway", ca, false)
+
+	serverKeyPair, err := tls.X509KeyPair(serverCert.certPEM, serverCert.keyPEM)
+	assert.NoError(t, err)
+
+	caPool := x509.NewCertPool()
+	caPool.AddCert(ca.cert)
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{serverKeyPair},
+		ClientCAs:    caPool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})
+	assert.NoError(t, err)
+	defer listener.Close()
+
+	published := make(chan string, 10)
+	peerNames := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		tlsConn := conn.(*tls.Conn)
+		if err := tlsConn.Handshake(); err != nil {
+			return
+		}
+		peerNames <- tlsConn.ConnectionState().PeerCertificates[0].Subject.CommonName
+
+		serveFakeBroker(conn, published)
+	}()
+
+	cfg := configuration.Configuration{
+		MqttConfiguration: configuration.MqttConfiguration{
+			Scheme:    "ssl",
+			Address:   "127.0.0.1",
+			Port:      portOf(t, listener.Addr().String()),
+			RootTopic: "tls_test",
+			TLS: configuration.MqttTLSConfiguration{
+				CAFile:     writeTestFile(t, dir, "ca.pem", ca.certPEM),
+				CertFile:   writeTestFile(t, dir, "client.pem", clientCert.certPEM),
+				KeyFile:    writeTestFile(t, dir, "client.key", clientCert.keyPEM),
+				ServerName: "localhost",
+			},
+		},
+	}
+
+	client, dispose, err := NewClient(&cfg)
+	assert.NoError(t, err)
+	defer dispose()
+
+	select {
+	case name := <-peerNames:
+		assert.Equal(t, "gateway", name)
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "TLS handshake is not completed")
+	}
+
+	waitForTopic(t, published, "tls_test/gateway/status")
+
+	client.Publish("0x1", []byte("{}"))
+	waitForTopic(t, published, "tls_test/0x1")
+}
+
+func TestClientTLSConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	_, err := newTLSConfig(&configuration.MqttTLSConfiguration{
+		CAFile: writeTestFile(t, dir, "ca.pem", []byte("not a certificate")),
+	})
+	assert.Error(t, err)
+
+	_, err = newTLSConfig(&configuration.MqttTLSConfiguration{
+		CertFile: filepath.Join(dir, "missing.pem"),
+		KeyFile:  filepath.Join(dir, "missing.key"),
+	})
+	assert.Error(t, err)
+
+	_, err = getBrokerURL(&configuration.MqttConfiguration{Scheme: "udp"})
+	assert.Error(t, err)
+}
+
+type websocketStream struct {
+	conn   *websocket.Conn
+	reader io.Reader
+}
+
+func (s *websocketStream) Read(p []byte) (int, error) {
+	for {
+		if s.reader == nil {
+			_, r, err := s.conn.NextReader()
+			if err != nil {
+				return 0, err
+			}
+			s.reader = r
+		}
+
+		n, err := s.reader.Read(p)
+		if err == io.EOF {
+			s.reader = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+
+		return n, err
+	}
+}
+
+func (s *websocketStream) Write(p []byte) (int, error) {
+	return len(p), s.conn.WriteMessage(websocket.BinaryMessage, p)
+}
+
+func TestClientWebsocket(t *testing.T) {
+	published := make(chan string, 10)
+	paths := make(chan string, 1)
+
+	upgrader := websocket.Upgrader{Subprotocols: []string{"mqtt"}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/mqtt", func(w http.ResponseWriter, r *http.Request) {
+		paths <- r.URL.Path
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		serveFakeBroker(&websocketStream{conn: conn}, published)
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	cfg := configuration.Configuration{
+		MqttConfiguration: configuration.MqttConfiguration{
+			Scheme:    "ws",
+			Address:   "127.0.0.1",
+			Port:      portOf(t, server.Listener.Addr().String()),
+			Path:      "mqtt",
+			RootTopic: "ws_test",
+		},
+	}
+
+	_, dispose, err := NewClient(&cfg)
+	assert.NoError(t, err)
+	defer dispose()
+
+	select {
+	case path := <-paths:
+		assert.Equal(t, "/mqtt", path)
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "websocket is not connected")
+	}
+
+	waitForTopic(t, published, "ws_test/gateway/status")
+}
+
+// startFakeBroker accepts plain TCP connections served by serveFakeBroker.
+func startFakeBroker(t *testing.T, published chan<- string) uint16 {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				serveFakeBroker(conn, published)
+			}()
+		}
+	}()
+
+	return portOf(t, l.Addr().String())
+}
+
+func TestReconnect(t *testing.T) {
+	firstPublished := make(chan string, 16)
+	secondPublished := make(chan string, 16)
+
+	cfg := configuration.Configuration{
+		MqttConfiguration: configuration.MqttConfiguration{
+			Scheme:    "tcp",
+			Address:   "127.0.0.1",
+			Port:      startFakeBroker(t, firstPublished),
+			RootTopic: "first",
+		},
+	}
+
+	client, dispose, err := NewClient(&cfg)
+	assert.NoError(t, err)
+	defer dispose()
+
+	waitForTopic(t, firstPublished, "first/gateway/status")
+
+	mqttConfig := cfg.MqttConfiguration
+	mqttConfig.Port = startFakeBroker(t, secondPublished)
+	mqttConfig.RootTopic = "second"
+	assert.NoError(t, client.Reconnect(&mqttConfig))
+
+	waitForTopic(t, secondPublished, "second/gateway/status")
+
+	mqttConfig.ProtocolVersion = ProtocolVersion5
+	assert.Error(t, client.Reconnect(&mqttConfig))
+}
+
+func TestPublishPropertiesConversion(t *testing.T) {
+	props := MessageProperties{
+		ResponseTopic:   "service/replies",
+		CorrelationData: []byte("req-1"),
+		UserProperties: map[string]string{
+			"cluster": "genOnOff",
+		},
+	}
+
+	converted := fromPublishProperties(toPublishProperties(&props))
+	assert.Equal(t, props, converted)
+
+	assert.Nil(t, toPublishProperties(nil))
+	assert.Equal(t, MessageProperties{}, fromPublishProperties(nil))
+}
@@ -0,0 +1,346 @@
+package mqtt
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/url"
+	"sync"
+
+	"github.com/eclipse/paho.golang/autopaho"
+	"github.com/eclipse/paho.golang/paho"
+	"github.com/supby/gigbee2mqtt/internal/configuration"
+	"github.com/supby/gigbee2mqtt/internal/logger"
+	"github.com/supby/gigbee2mqtt/internal/metrics"
+)
+
+func newV5Client(config *configuration.Configuration) (MqttClient, func(), error) {
+	retClient := &v5MqttClient{
+		logger: logger.GetLogger("[MQTT Client]", config.LogLevel),
+	}
+
+	retClient.outbox = newOutbox(config.MqttConfiguration.OfflineQueue.MaxSize, config.MqttConfiguration.OfflineQueue.Dir, retClient.logger)
+	retClient.outbox.isConnected = retClient.isConnected
+	retClient.outbox.publish = retClient.publish
+
+	if err := retClient.connect(&config.MqttConfiguration); err != nil {
+		return nil, nil, err
+	}
+
+	return retClient, func() { retClient.Dispose() }, nil
+}
+
+// connect creates connection manager for config, the previous one is disconnected.
+func (cl *v5MqttClient) connect(config *configuration.MqttConfiguration) error {
+	cliCfg, err := newV5ClientConfig(config)
+	if err != nil {
+		return err
+	}
+	cliCfg.OnConnectionUp = cl.onConnect
+	cliCfg.OnConnectError = func(err error) {
+		cl.logger.Warn("Connect error: %v", err)
+	}
+	cliCfg.ClientConfig.Router = paho.NewSingleHandlerRouter(cl.onMessageReceived)
+	cliCfg.ClientConfig.OnClientError = func(err error) {
+		cl.setConnected(false)
+		cl.logger.Info("Connect lost: %v", err)
+	}
+	cliCfg.ClientConfig.OnServerDisconnect = func(d *paho.Disconnect) {
+		cl.setConnected(false)
+		cl.logger.Info("Disconnected by broker, reason code: %v", d.ReasonCode)
+	}
+
+	cl.disconnect()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	cl.mtx.Lock()
+	cl.mqttConfig = *config
+	cl.mtx.Unlock()
+
+	cm, err := autopaho.NewConnection(ctx, cliCfg)
+	if err != nil {
+		cancel()
+		return err
+	}
+
+	cl.mtx.Lock()
+	cl.connectionManager = cm
+	cl.cancel = cancel
+	cl.mtx.Unlock()
+
+	return nil
+}
+
+func newV5ClientConfig(config *configuration.MqttConfiguration) (autopaho.ClientConfig, error) {
+	brokerURL, err := getBrokerURL(config)
+	if err != nil {
+		return autopaho.ClientConfig{}, err
+	}
+
+	u, err := url.Parse(brokerURL)
+	if err != nil {
+		return autopaho.ClientConfig{}, err
+	}
+
+	clientID := config.ClientID
+	if clientID == "" {
+		clientID = config.RootTopic
+	}
+
+	ret := autopaho.ClientConfig{
+		BrokerUrls: []*url.URL{u},
+		KeepAlive:  60,
+		ClientConfig: paho.ClientConfig{
+			ClientID: clientID,
+		},
+	}
+
+	if isSecureScheme(config.Scheme) {
+		ret.TlsCfg, err = newTLSConfig(&config.TLS)
+		if err != nil {
+			return autopaho.ClientConfig{}, err
+		}
+	}
+
+	if config.Username != "" || config.Password != "" {
+		ret.SetUsernamePassword(config.Username, []byte(config.Password))
+	}
+
+	if config.PersistentSession {
+		ret.SetConnectPacketConfigurator(func(c *paho.Connect) *paho.Connect {
+			sessionExpiry := uint32(math.MaxUint32)
+			c.CleanStart = false
+			c.Properties = &paho.ConnectProperties{
+				SessionExpiryInterval: &sessionExpiry,
+			}
+			return c
+		})
+	}
+
+	return ret, nil
+}
+
+type v5MqttClient struct {
+	mtx               sync.Mutex
+	connectionManager *autopaho.ConnectionManager
+	cancel            context.CancelFunc
+	mqttConfig        configuration.MqttConfiguration
+	messageCallback   func(topic string, message []byte, props MessageProperties)
+	logger            logger.Logger
+	outbox            *outbox
+	connectedMtx      sync.Mutex
+	connected         bool
+}
+
+func (cl *v5MqttClient) config() configuration.MqttConfiguration {
+	cl.mtx.Lock()
+	defer cl.mtx.Unlock()
+
+	return cl.mqttConfig
+}
+
+// Dispose publishes offline status and disconnects.
+func (cl *v5MqttClient) Dispose() {
+	cl.logger.Info("Disposing MQTT client")
+
+	if cl.isConnected() {
+		err := cl.publish(queuedMessage{
+			Topic:   fmt.Sprintf("%v/gateway/status", cl.config().RootTopic),
+			Payload: []byte("Offline"),
+		})
+		if err != nil {
+			cl.logger.Warn("Error publishing offline status: %v", err)
+		}
+	}
+
+	cl.disconnect()
+}
+
+func (cl *v5MqttClient) disconnect() {
+	cl.mtx.Lock()
+	cm, cancelConnection := cl.connectionManager, cl.cancel
+	cl.connectionManager, cl.cancel = nil, nil
+	cl.mtx.Unlock()
+
+	if cm == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+
+	cm.Disconnect(ctx)
+	cancelConnection()
+	cl.setConnected(false)
+}
+
+func (cl *v5MqttClient) Reconnect(config *configuration.MqttConfiguration) error {
+	if err := checkReconnect(cl.config(), config); err != nil {
+		return err
+	}
+
+	cl.logger.Info("Reconnecting to MQTT 5 on '%v://%v:%v%v'", config.Scheme, config.Address, config.Port, config.Path)
+
+	return cl.connect(config)
+}
+
+func (cl *v5MqttClient) Publish(subTopic string, data []byte) {
+	cl.PublishWithOptions(subTopic, data, PublishOptions{})
+}
+
+func (cl *v5MqttClient) PublishWithOptions(subTopic string, data []byte, opts PublishOptions) {
+	cl.PublishResponse(fmt.Sprintf("%v/%v", cl.config().RootTopic, subTopic), data, opts)
+}
+
+func (cl *v5MqttClient) PublishResponse(topic string, data []byte, opts PublishOptions) {
+	cl.outbox.Send(queuedMessage{
+		Topic:      topic,
+		Payload:    data,
+		QoS:        opts.QoS,
+		Retain:     opts.Retain,
+		Properties: &opts.Properties,
+	})
+}
+
+func (cl *v5MqttClient) Subscribe(callback func(topic string, message []byte)) {
+	cl.SubscribeWithProperties(func(topic string, message []byte, props MessageProperties) {
+		callback(topic, message)
+	})
+}
+
+func (cl *v5MqttClient) SubscribeWithProperties(callback func(topic string, message []byte, props MessageProperties)) {
+	cl.mtx.Lock()
+	defer cl.mtx.Unlock()
+
+	cl.messageCallback = callback
+}
+
+func (cl *v5MqttClient) UnSubscribe() {
+	cl.mtx.Lock()
+	defer cl.mtx.Unlock()
+
+	cl.messageCallback = nil
+}
+
+// callback is called from the goroutine of the MQTT library, so it is read under lock.
+func (cl *v5MqttClient) callback() func(topic string, message []byte, props MessageProperties) {
+	cl.mtx.Lock()
+	defer cl.mtx.Unlock()
+
+	return cl.messageCallback
+}
+
+func (cl *v5MqttClient) IsConnected() bool {
+	return cl.isConnected()
+}
+
+func (cl *v5MqttClient) QueueLength() int {
+	return cl.outbox.Len()
+}
+
+func (cl *v5MqttClient) isConnected() bool {
+	cl.connectedMtx.Lock()
+	defer cl.connectedMtx.Unlock()
+
+	return cl.connected
+}
+
+func (cl *v5MqttClient) setConnected(connected bool) {
+	cl.connectedMtx.Lock()
+	defer cl.connectedMtx.Unlock()
+
+	cl.connected = connected
+	metrics.SetMQTTConnected(connected)
+}
+
+func (cl *v5MqttClient) publish(msg queuedMessage) error {
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+
+	cl.mtx.Lock()
+	cm := cl.connectionManager
+	cl.mtx.Unlock()
+	if cm == nil {
+		return fmt.Errorf("not connected")
+	}
+
+	_, err := cm.Publish(ctx, &paho.Publish{
+		Topic:      msg.Topic,
+		Payload:    msg.Payload,
+		QoS:        msg.QoS,
+		Retain:     msg.Retain,
+		Properties: toPublishProperties(msg.Properties),
+	})
+
+	return err
+}
+
+func (cl *v5MqttClient) onConnect(cm *autopaho.ConnectionManager, connack *paho.Connack) {
+	cfg := cl.config()
+
+	cl.logger.Info("Connected to MQTT 5 on '%v://%v:%v%v'", cfg.Scheme, cfg.Address, cfg.Port, cfg.Path)
+
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+
+	_, err := cm.Subscribe(ctx, &paho.Subscribe{
+		Subscriptions: map[string]paho.SubscribeOptions{
+			fmt.Sprintf("%s/#", cfg.RootTopic): {QoS: cfg.QoS.CommandsSubscription},
+		},
+	})
+	if err != nil {
+		cl.logger.Error("Error subscribing to '%s/#': %v", cfg.RootTopic, err)
+	}
+
+	cl.setConnected(true)
+
+	cm.Publish(ctx, &paho.Publish{
+		Topic:   fmt.Sprintf("%v/gateway/status", cfg.RootTopic),
+		Payload: []byte("Online"),
+	})
+
+	cl.outbox.Drain()
+}
+
+func (cl *v5MqttClient) onMessageReceived(p *paho.Publish) {
+	if callback := cl.callback(); callback != nil {
+		go callback(p.Topic, p.Payload, fromPublishProperties(p.Properties))
+	}
+}
+
+func toPublishProperties(props *MessageProperties) *paho.PublishProperties {
+	if props == nil {
+		return nil
+	}
+
+	ret := &paho.PublishProperties{
+		ResponseTopic:   props.ResponseTopic,
+		CorrelationData: props.CorrelationData,
+	}
+
+	for k, v := range props.UserProperties {
+		ret.User.Add(k, v)
+	}
+
+	return ret
+}
+
+func fromPublishProperties(props *paho.PublishProperties) MessageProperties {
+	ret := MessageProperties{}
+	if props == nil {
+		return ret
+	}
+
+	ret.ResponseTopic = props.ResponseTopic
+	ret.CorrelationData = props.CorrelationData
+
+	if len(props.User) > 0 {
+		ret.UserProperties = make(map[string]string, len(props.User))
+		for _, u := range props.User {
+			ret.UserProperties[u.Key] = u.Value
+		}
+	}
+
+	return ret
+}
@@ -0,0 +1,157 @@
+package mqtt
+
+import (
+	"sync"
+	"time"
+
+	"github.com/supby/gigbee2mqtt/internal/logger"
+)
+
+const (
+	outboxRetryInterval = 5 * time.Second
+)
+
+// outbox publishes messages in order, keeping them in queue while the broker is unreachable
+// or a publish fails. Only one publish is in flight at a time and it runs without holding
+// the lock, so a slow broker does not block senders, their messages are queued meanwhile.
+type outbox struct {
+	mtx         sync.Mutex
+	queue       publishQueue
+	logger      logger.Logger
+	isConnected func() bool
+	publish     func(msg queuedMessage) error
+
+	busy          bool
+	retryInterval time.Duration
+	retryTimer    *time.Timer
+}
+
+func newOutbox(maxSize int, dirname string, log logger.Logger) *outbox {
+	queue, err := newPublishQueue(maxSize, dirname)
+	if err != nil {
+		log.Error("Error opening offline queue in '%v', falling back to memory: %v", dirname, err)
+		queue, _ = newPublishQueue(maxSize, "")
+	}
+
+	return &outbox{
+		queue:         queue,
+		logger:        log,
+		retryInterval: outboxRetryInterval,
+	}
+}
+
+func (o *outbox) Send(msg queuedMessage) {
+	o.mtx.Lock()
+
+	// keep order: while anything is buffered or being published new messages go behind it
+	if o.busy || o.queue.Len() != 0 || !o.isConnected() {
+		if err := o.queue.Push(msg); err != nil {
+			o.logger.Error("Error buffering message for '%v': %v", msg.Topic, err)
+		}
+		kick := !o.busy && o.isConnected()
+		o.mtx.Unlock()
+
+		if kick {
+			go o.Drain()
+		}
+		return
+	}
+
+	o.busy = true
+	o.mtx.Unlock()
+
+	err := o.publish(msg)
+
+	o.mtx.Lock()
+	o.busy = false
+
+	if err != nil {
+		o.logger.Warn("Error publishing message to '%v', buffering it: %v", msg.Topic, err)
+		if err := o.queue.PushFront(msg); err != nil {
+			o.logger.Error("Error buffering message for '%v': %v", msg.Topic, err)
+		}
+		o.scheduleRetry()
+		o.mtx.Unlock()
+		return
+	}
+
+	pending := o.queue.Len() != 0
+	o.mtx.Unlock()
+
+	if pending {
+		go o.Drain()
+	}
+}
+
+// Drain publishes buffered messages until the queue is empty, the connection is lost
+// or a publish fails, in the last case it is retried after retryInterval.
+func (o *outbox) Drain() {
+	o.mtx.Lock()
+	if o.busy || o.queue.Len() == 0 {
+		o.mtx.Unlock()
+		return
+	}
+	o.busy = true
+	o.logger.Info("Publishing %v buffered messages", o.queue.Len())
+	o.mtx.Unlock()
+
+	for {
+		o.mtx.Lock()
+		msg, ok := o.queue.Peek()
+		if !ok {
+			o.busy = false
+			o.mtx.Unlock()
+			return
+		}
+
+		if !o.isConnected() {
+			o.logger.Warn("Connection lost while publishing buffered messages, %v left", o.queue.Len())
+			o.busy = false
+			o.mtx.Unlock()
+			return
+		}
+		o.mtx.Unlock()
+
+		err := o.publish(msg)
+
+		o.mtx.Lock()
+		if err != nil {
+			o.logger.Warn("Error publishing buffered message to '%v': %v", msg.Topic, err)
+			o.busy = false
+			o.scheduleRetry()
+			o.mtx.Unlock()
+			return
+		}
+
+		if err := o.queue.Pop(); err != nil {
+			o.logger.Error("Error removing buffered message: %v", err)
+			o.busy = false
+			o.mtx.Unlock()
+			return
+		}
+		o.mtx.Unlock()
+	}
+}
+
+// scheduleRetry drains the queue after retryInterval, so messages which failed while
+// connected are not left until the next reconnect. Must be called with mtx held.
+func (o *outbox) scheduleRetry() {
+	if o.retryTimer != nil || !o.isConnected() {
+		return
+	}
+
+	o.retryTimer = time.AfterFunc(o.retryInterval, func() {
+		o.mtx.Lock()
+		o.retryTimer = nil
+		o.mtx.Unlock()
+
+		o.Drain()
+	})
+}
+
+func (o *outbox) Len() int {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+
+	return o.queue.Len()
+}
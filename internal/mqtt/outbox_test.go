@@ -0,0 +1,101 @@
+package mqtt
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/supby/gigbee2mqtt/internal/logger"
+)
+
+type testPublisher struct {
+	mtx       sync.Mutex
+	failures  int
+	published []string
+}
+
+func (p *testPublisher) publish(msg queuedMessage) error {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if p.failures > 0 {
+		p.failures--
+		return fmt.Errorf("publish timeout")
+	}
+
+	p.published = append(p.published, msg.Topic)
+	return nil
+}
+
+func (p *testPublisher) topics() []string {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	return append([]string{}, p.published...)
+}
+
+func TestOutboxRecoversFromPublishErrorWhileConnected(t *testing.T) {
+	publisher := &testPublisher{failures: 2}
+
+	o := newOutbox(10, "", logger.GetLogger("[Test Outbox]", logger.LogLevelError))
+	o.retryInterval = 10 * time.Millisecond
+	o.isConnected = func() bool { return true }
+	o.publish = publisher.publish
+
+	for i := 0; i < 3; i++ {
+		o.Send(queuedMessage{Topic: fmt.Sprintf("root/%v", i)})
+	}
+
+	assert.Eventually(t, func() bool { return o.Len() == 0 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, []string{"root/0", "root/1", "root/2"}, publisher.topics())
+
+	// the queue is empty again, so the next message is published directly
+	o.Send(queuedMessage{Topic: "root/3"})
+	assert.Equal(t, []string{"root/0", "root/1", "root/2", "root/3"}, publisher.topics())
+}
+
+func TestOutboxDoesNotBlockSendersDuringPublish(t *testing.T) {
+	release := make(chan struct{})
+	var published []string
+	var mtx sync.Mutex
+
+	o := newOutbox(10, "", logger.GetLogger("[Test Outbox]", logger.LogLevelError))
+	o.isConnected = func() bool { return true }
+	o.publish = func(msg queuedMessage) error {
+		if msg.Topic == "root/slow" {
+			<-release
+		}
+		mtx.Lock()
+		published = append(published, msg.Topic)
+		mtx.Unlock()
+		return nil
+	}
+
+	go o.Send(queuedMessage{Topic: "root/slow"})
+	assert.Eventually(t, func() bool {
+		o.mtx.Lock()
+		defer o.mtx.Unlock()
+		return o.busy
+	}, time.Second, time.Millisecond)
+
+	done := make(chan struct{})
+	go func() {
+		o.Send(queuedMessage{Topic: "root/next"})
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Send blocked while another publish was in flight")
+	}
+
+	close(release)
+
+	assert.Eventually(t, func() bool { return o.Len() == 0 }, time.Second, 5*time.Millisecond)
+	mtx.Lock()
+	assert.Equal(t, []string{"root/slow", "root/next"}, published)
+	mtx.Unlock()
+}
@@ -0,0 +1,286 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	queueFileExt = ".msg"
+	// fileQueueFirstSeq leaves room in front of the first message for PushFront.
+	fileQueueFirstSeq = 1 << 32
+)
+
+type queuedMessage struct {
+	Topic      string
+	Payload    []byte
+	QoS        byte
+	Retain     bool
+	Properties *MessageProperties `json:",omitempty"`
+}
+
+// publishQueue keeps outbound messages while the broker is unreachable.
+// When the queue is full the oldest message is dropped.
+type publishQueue interface {
+	Push(msg queuedMessage) error
+	// PushFront puts msg at the head, it is used to return a message which failed to publish.
+	PushFront(msg queuedMessage) error
+	Peek() (queuedMessage, bool)
+	Pop() error
+	Len() int
+}
+
+func newPublishQueue(maxSize int, dirname string) (publishQueue, error) {
+	if dirname == "" {
+		return &memoryQueue{maxSize: maxSize}, nil
+	}
+
+	return newFileQueue(maxSize, dirname)
+}
+
+type memoryQueue struct {
+	mtx      sync.Mutex
+	maxSize  int
+	messages []queuedMessage
+}
+
+func (q *memoryQueue) Push(msg queuedMessage) error {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	if q.maxSize <= 0 {
+		return nil
+	}
+
+	if len(q.messages) >= q.maxSize {
+		q.messages = q.messages[1:]
+	}
+	q.messages = append(q.messages, msg)
+
+	return nil
+}
+
+func (q *memoryQueue) PushFront(msg queuedMessage) error {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	if q.maxSize <= 0 {
+		return nil
+	}
+
+	// the queue is full of newer messages, the returned one is the oldest and is dropped
+	if len(q.messages) >= q.maxSize {
+		return nil
+	}
+	q.messages = append([]queuedMessage{msg}, q.messages...)
+
+	return nil
+}
+
+func (q *memoryQueue) Peek() (queuedMessage, bool) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	if len(q.messages) == 0 {
+		return queuedMessage{}, false
+	}
+
+	return q.messages[0], true
+}
+
+func (q *memoryQueue) Pop() error {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	if len(q.messages) > 0 {
+		q.messages = q.messages[1:]
+	}
+
+	return nil
+}
+
+func (q *memoryQueue) Len() int {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	return len(q.messages)
+}
+
+// fileQueue stores every message in its own file named by a sequence number,
+// so the queue survives gateway restarts.
+type fileQueue struct {
+	mtx     sync.Mutex
+	maxSize int
+	dirname string
+	seqs    []uint64
+	nextSeq uint64
+}
+
+func newFileQueue(maxSize int, dirname string) (*fileQueue, error) {
+	if err := os.MkdirAll(dirname, 0755); err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dirname)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &fileQueue{
+		maxSize: maxSize,
+		dirname: dirname,
+		nextSeq: fileQueueFirstSeq,
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, queueFileExt) {
+			continue
+		}
+
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, queueFileExt), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		ret.seqs = append(ret.seqs, seq)
+	}
+
+	sort.Slice(ret.seqs, func(i, j int) bool { return ret.seqs[i] < ret.seqs[j] })
+
+	if len(ret.seqs) > 0 {
+		ret.nextSeq = ret.seqs[len(ret.seqs)-1] + 1
+	}
+
+	return ret, nil
+}
+
+func (q *fileQueue) filePath(seq uint64) string {
+	return filepath.Join(q.dirname, fmt.Sprintf("%020d%s", seq, queueFileExt))
+}
+
+func (q *fileQueue) Push(msg queuedMessage) error {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	if q.maxSize <= 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	for len(q.seqs) >= q.maxSize {
+		if err := q.removeHead(); err != nil {
+			return err
+		}
+	}
+
+	if len(q.seqs) == 0 {
+		q.nextSeq = fileQueueFirstSeq
+	}
+
+	seq := q.nextSeq
+	if err := os.WriteFile(q.filePath(seq), data, 0644); err != nil {
+		return err
+	}
+
+	q.seqs = append(q.seqs, seq)
+	q.nextSeq++
+
+	return nil
+}
+
+func (q *fileQueue) PushFront(msg queuedMessage) error {
+	q.mtx.Lock()
+	if q.maxSize <= 0 {
+		q.mtx.Unlock()
+		return nil
+	}
+	if len(q.seqs) == 0 {
+		q.mtx.Unlock()
+		return q.Push(msg)
+	}
+	defer q.mtx.Unlock()
+
+	// the queue is full of newer messages, the returned one is the oldest and is dropped
+	if len(q.seqs) >= q.maxSize {
+		return nil
+	}
+
+	if q.seqs[0] == 0 {
+		return fmt.Errorf("no room in front of the queue")
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	seq := q.seqs[0] - 1
+	if err := os.WriteFile(q.filePath(seq), data, 0644); err != nil {
+		return err
+	}
+
+	q.seqs = append([]uint64{seq}, q.seqs...)
+
+	return nil
+}
+
+func (q *fileQueue) Peek() (queuedMessage, bool) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	for len(q.seqs) > 0 {
+		data, err := os.ReadFile(q.filePath(q.seqs[0]))
+		if err == nil {
+			var msg queuedMessage
+			if err := json.Unmarshal(data, &msg); err == nil {
+				return msg, true
+			}
+		}
+
+		// unreadable entry, skip it
+		q.removeHead()
+	}
+
+	return queuedMessage{}, false
+}
+
+func (q *fileQueue) Pop() error {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	if len(q.seqs) == 0 {
+		return nil
+	}
+
+	return q.removeHead()
+}
+
+func (q *fileQueue) Len() int {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	return len(q.seqs)
+}
+
+func (q *fileQueue) removeHead() error {
+	seq := q.seqs[0]
+	q.seqs = q.seqs[1:]
+
+	err := os.Remove(q.filePath(seq))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,77 @@
+package mqtt
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func pushMessages(t *testing.T, q publishQueue, count int) {
+	for i := 0; i < count; i++ {
+		err := q.Push(queuedMessage{
+			Topic:   fmt.Sprintf("root/%v", i),
+			Payload: []byte(fmt.Sprintf("%v", i)),
+		})
+		assert.NoError(t, err)
+	}
+}
+
+func TestMemoryQueueDropsOldest(t *testing.T) {
+	q, err := newPublishQueue(3, "")
+	assert.NoError(t, err)
+
+	pushMessages(t, q, 5)
+
+	assert.Equal(t, 3, q.Len())
+
+	msg, ok := q.Peek()
+	assert.True(t, ok)
+	assert.Equal(t, "root/2", msg.Topic)
+}
+
+func TestFileQueueOrderAndReload(t *testing.T) {
+	dir := t.TempDir()
+
+	q, err := newPublishQueue(10, dir)
+	assert.NoError(t, err)
+
+	pushMessages(t, q, 12)
+	assert.Equal(t, 10, q.Len())
+
+	err = q.Pop()
+	assert.NoError(t, err)
+
+	reloaded, err := newPublishQueue(10, dir)
+	assert.NoError(t, err)
+	assert.Equal(t, 9, reloaded.Len())
+
+	for i := 3; i < 12; i++ {
+		msg, ok := reloaded.Peek()
+		assert.True(t, ok)
+		assert.Equal(t, fmt.Sprintf("root/%v", i), msg.Topic)
+		assert.Equal(t, []byte(fmt.Sprintf("%v", i)), msg.Payload)
+		assert.NoError(t, reloaded.Pop())
+	}
+
+	_, ok := reloaded.Peek()
+	assert.False(t, ok)
+}
+
+func TestQueuePushFront(t *testing.T) {
+	for _, dir := range []string{"", t.TempDir()} {
+		q, err := newPublishQueue(10, dir)
+		assert.NoError(t, err)
+
+		pushMessages(t, q, 2)
+		assert.NoError(t, q.PushFront(queuedMessage{Topic: "root/front"}))
+		assert.Equal(t, 3, q.Len())
+
+		for _, topic := range []string{"root/front", "root/0", "root/1"} {
+			msg, ok := q.Peek()
+			assert.True(t, ok)
+			assert.Equal(t, topic, msg.Topic)
+			assert.NoError(t, q.Pop())
+		}
+	}
+}
@@ -1,5 +1,12 @@
 package mqtt
 
+import (
+	"time"
+
+	"github.com/supby/gigbee2mqtt/internal/configuration"
+	"github.com/supby/gigbee2mqtt/internal/history"
+)
+
 type DeviceAttributesReportMessage struct {
 	ClusterID         uint16
 	ClusterName       string
@@ -20,6 +27,42 @@ type DeviceGetMessage struct {
 	Attributes []uint16
 }
 
+type DeviceWriteMessage struct {
+	ClusterID  uint16
+	Endpoint   uint8
+	Attributes map[uint16]interface{} // attribute ID -> value
+}
+
+// DeviceConfigureReportingMessage sets up attribute reports, fields missing in the request are taken from
+// configuration.ReportingConfiguration.
+type DeviceConfigureReportingMessage struct {
+	ClusterID            uint16
+	Endpoint             uint8
+	Attributes           []uint16
+	MinIntervalInSeconds *int
+	MaxIntervalInSeconds *int
+	ReportableChange     *float64 // ignored for discrete attributes, e.g. boolean or enum
+}
+
+type DeviceConfigureReportingResponseMessage struct {
+	ClusterID uint16
+	Records   []WriteAttributeStatus // single record with status 0 when reporting of all attributes is set up
+}
+
+type DeviceWriteAttributesResponseMessage struct {
+	ClusterID uint16
+	Records   []WriteAttributeStatus // single record with status 0 when all attributes are written
+}
+
+type WriteAttributeStatus struct {
+	AttributeID uint16
+	Status      uint8
+}
+
+type DeviceRemoveMessage struct {
+	Force bool
+}
+
 type DeviceDefaultResponseMessage struct {
 	ClusterID         uint16
 	CommandIdentifier uint8
@@ -27,9 +70,10 @@ type DeviceDefaultResponseMessage struct {
 }
 
 type DeviceMessage struct {
-	IEEEAddress uint64
-	LinkQuality uint8
-	Message     interface{}
+	IEEEAddress         uint64
+	LinkQuality         uint8
+	TransactionSequence uint8 `json:",omitempty"` // of the request the message answers, 0 for reports
+	Message             interface{}
 }
 
 type DeviceDescriptionMessage struct {
@@ -48,6 +92,75 @@ type EndpointDescription struct {
 	OutClusterList []uint16
 }
 
+type DeviceHistoryQueryMessage struct {
+	ClusterID     uint16
+	Attribute     string
+	From          time.Time // 24 hours before To when empty
+	To            time.Time // now when empty
+	BucketSeconds int       // raw samples are returned when 0
+}
+
+type DeviceHistoryMessage struct {
+	IEEEAddress   uint64
+	ClusterID     uint16
+	Attribute     string
+	From          time.Time
+	To            time.Time
+	BucketSeconds int
+	Samples       []history.Sample `json:",omitempty"`
+	Buckets       []history.Bucket `json:",omitempty"`
+}
+
+// DevicesFilter selects devices returned by get_devices, empty fields match every device.
+type DevicesFilter struct {
+	ModelID                  string
+	Tag                      string
+	LogicalType              *uint8
+	ClusterID                *uint16 // supported as input or output cluster on any endpoint
+	Online                   *bool
+	LastSeenOlderThanSeconds int
+}
+
+// SetDeviceMessage edits user fields of the device record, nil fields are left unchanged.
+type SetDeviceMessage struct {
+	IEEEAddress  uint64
+	FriendlyName *string
+	Tags         *[]string
+	Notes        *string
+}
+
+// SetGatewayConfig changes permit join, set_config accepts any fields of configuration.Configuration,
+// fields missing in the JSON object are left unchanged, e.g. {"LogLevels": {"Zigbee Router": 3}}.
 type SetGatewayConfig struct {
 	PermitJoin bool
 }
+
+// SetGatewayConfigResult answers set_config, fields are named like Availability.ActiveTimeoutInSeconds.
+type SetGatewayConfigResult struct {
+	Applied []string                     `json:",omitempty"` // changed in the running gateway
+	Restart []string                     `json:",omitempty"` // stored in the config file, take effect after restart
+	Error   string                       `json:",omitempty"` // the request is rejected and nothing is changed
+	Config  *configuration.Configuration `json:",omitempty"`
+}
+
+// CoordinatorEventMessage is published in gateway/coordinator_lost and gateway/coordinator_recovered.
+type CoordinatorEventMessage struct {
+	Error           string  `json:",omitempty"` // why the coordinator is considered lost
+	Attempts        int     `json:",omitempty"` // reopen attempts it took to recover
+	DowntimeSeconds float64 `json:",omitempty"`
+	Time            time.Time
+}
+
+// PublishOptions control how a single message is published.
+type PublishOptions struct {
+	QoS        byte
+	Retain     bool
+	Properties MessageProperties
+}
+
+// MessageProperties are MQTT 5 publish properties, MQTT 3.1.1 client ignores them.
+type MessageProperties struct {
+	ResponseTopic   string
+	CorrelationData []byte
+	UserProperties  map[string]string
+}
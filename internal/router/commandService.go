@@ -0,0 +1,346 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/supby/gigbee2mqtt/internal/configuration"
+	"github.com/supby/gigbee2mqtt/internal/db"
+	"github.com/supby/gigbee2mqtt/internal/history"
+	"github.com/supby/gigbee2mqtt/internal/logger"
+	"github.com/supby/gigbee2mqtt/internal/mqtt"
+	"github.com/supby/gigbee2mqtt/internal/types"
+)
+
+var (
+	ErrNoResponse             = errors.New("device did not respond in time")
+	ErrDeviceNotFound         = errors.New("device does not exist")
+	ErrDeviceNotRemoved       = errors.New("device did not leave the network")
+	ErrHistoryDisabled        = errors.New("history is disabled")
+	ErrInvalidConfig          = errors.New("invalid configuration change")
+	ErrInvalidCommand         = errors.New("invalid command") // e.g. unknown attribute or value of wrong type
+	ErrCoordinatorUnavailable = errors.New("coordinator is not available")
+	ErrDeviceUnreachable      = errors.New("message could not be sent to the device")
+)
+
+type commandService struct {
+	configurationService configuration.ConfigurationService
+	zRouter              ZigbeeRouter
+	db                   db.DeviceDB
+	history              history.Store
+	logger               logger.Logger
+	waiters              *responseWaiters
+	stateMtx             sync.Mutex
+	state                map[uint64]map[uint16]mqtt.DeviceAttributesReportMessage
+	onConfigChange       func(result configuration.ReloadResult)
+}
+
+// NewCommandService runs gateway commands synchronously for HTTP and admin socket, device commands call
+// the same ZigbeeRouter methods as MQTT commands do and return the answer of the device.
+func NewCommandService(
+	configurationService configuration.ConfigurationService,
+	zRouter ZigbeeRouter,
+	db db.DeviceDB,
+	historyStore history.Store) CommandService {
+	return &commandService{
+		configurationService: configurationService,
+		zRouter:              zRouter,
+		db:                   db,
+		history:              historyStore,
+		logger:               logger.GetLogger("[Commands]", configurationService.GetConfiguration().LogLevel),
+		waiters:              newResponseWaiters(),
+		state:                map[uint64]map[uint16]mqtt.DeviceAttributesReportMessage{},
+	}
+}
+
+func (s *commandService) PublishDeviceMessage(ieeeAddress uint64, msg interface{}, subtopic string) {
+	if devMsg, ok := msg.(mqtt.DeviceMessage); ok {
+		if report, ok := devMsg.Message.(mqtt.DeviceAttributesReportMessage); ok {
+			s.updateState(ieeeAddress, report)
+		}
+	}
+
+	if responseKey := deviceResponseKey(ieeeAddress, msg); responseKey != "" {
+		s.waiters.Resolve(responseKey, msg)
+	}
+}
+
+// updateState merges reported attributes into the last known state of the cluster.
+func (s *commandService) updateState(ieeeAddress uint64, report mqtt.DeviceAttributesReportMessage) {
+	s.stateMtx.Lock()
+	defer s.stateMtx.Unlock()
+
+	clusters, ok := s.state[ieeeAddress]
+	if !ok {
+		clusters = map[uint16]mqtt.DeviceAttributesReportMessage{}
+		s.state[ieeeAddress] = clusters
+	}
+
+	attributes, ok := report.ClusterAttributes.(map[string]interface{})
+	if !ok {
+		clusters[report.ClusterID] = report
+		return
+	}
+
+	merged := map[string]interface{}{}
+	if prev, ok := clusters[report.ClusterID].ClusterAttributes.(map[string]interface{}); ok {
+		for k, v := range prev {
+			merged[k] = v
+		}
+	}
+	for k, v := range attributes {
+		merged[k] = v
+	}
+
+	report.ClusterAttributes = merged
+	clusters[report.ClusterID] = report
+}
+
+func (s *commandService) Devices(ctx context.Context, filter mqtt.DevicesFilter) ([]db.Device, error) {
+	devices, err := s.db.GetDevices(ctx)
+	if err != nil {
+		s.logger.Error("error getting devices from db: %v\n", err)
+		return nil, err
+	}
+
+	return filterDevices(devices, filter, s.configurationService.GetConfiguration().Availability, time.Now()), nil
+}
+
+func (s *commandService) Device(ctx context.Context, deviceAddr uint64) (db.Device, error) {
+	device, err := s.db.GetDevice(ctx, deviceAddr)
+	if err != nil {
+		return device, fmt.Errorf("0x%x: %w", deviceAddr, ErrDeviceNotFound)
+	}
+
+	return device, nil
+}
+
+func (s *commandService) SetDevice(ctx context.Context, msg mqtt.SetDeviceMessage) (db.Device, error) {
+	device, err := s.db.UpdateDevice(ctx, msg.IEEEAddress, func(device *db.Device, exists bool) error {
+		if !exists {
+			return fmt.Errorf("0x%x: %w", msg.IEEEAddress, ErrDeviceNotFound)
+		}
+
+		if msg.FriendlyName != nil {
+			device.FriendlyName = *msg.FriendlyName
+		}
+		if msg.Tags != nil {
+			device.Tags = *msg.Tags
+		}
+		if msg.Notes != nil {
+			device.Notes = *msg.Notes
+		}
+
+		return nil
+	})
+	if err != nil {
+		s.logger.Error("error updating device: %v\n", err)
+		return device, err
+	}
+
+	return device, nil
+}
+
+// RemoveDevice asks the device to leave, with Force it is removed also when it does not answer.
+func (s *commandService) RemoveDevice(ctx context.Context, msg types.DeviceRemoveMessage) error {
+	s.logger.Info("REMOVE request received. Device: 0x%x, Force: %v", msg.IEEEAddress, msg.Force)
+
+	if err := s.zRouter.ProccessRemoveMessage(ctx, msg); err != nil {
+		return err
+	}
+
+	if _, err := s.db.GetDevice(ctx, msg.IEEEAddress); err == nil {
+		return fmt.Errorf("0x%x: %w", msg.IEEEAddress, ErrDeviceNotRemoved)
+	}
+
+	return nil
+}
+
+func (s *commandService) Set(ctx context.Context, deviceAddr uint64, msg mqtt.DeviceSetMessage) (interface{}, error) {
+	s.logger.Info("SET request received. Device:%v, ClusterID:%v", deviceAddr, msg.ClusterID)
+
+	tsn := nextTransactionSequence(deviceAddr)
+
+	return s.sendAndWait(ctx, clusterResponseKey(deviceAddr, msg.ClusterID, tsn), func() error {
+		return s.zRouter.ProccessMessageToDevice(ctx, types.DeviceCommandMessage{
+			IEEEAddress:         deviceAddr,
+			ClusterID:           msg.ClusterID,
+			Endpoint:            msg.Endpoint,
+			CommandIdentifier:   msg.CommandIdentifier,
+			CommandData:         msg.CommandData,
+			TransactionSequence: tsn,
+		})
+	})
+}
+
+func (s *commandService) Get(ctx context.Context, deviceAddr uint64, msg mqtt.DeviceGetMessage) (interface{}, error) {
+	s.logger.Info("GET request received. Device:%v, ClusterID:%v", deviceAddr, msg.ClusterID)
+
+	tsn := nextTransactionSequence(deviceAddr)
+
+	return s.sendAndWait(ctx, clusterResponseKey(deviceAddr, msg.ClusterID, tsn), func() error {
+		return s.zRouter.ProccessGetMessageToDevice(ctx, types.DeviceGetMessage{
+			IEEEAddress:         deviceAddr,
+			ClusterID:           msg.ClusterID,
+			Endpoint:            msg.Endpoint,
+			Attributes:          msg.Attributes,
+			TransactionSequence: tsn,
+		})
+	})
+}
+
+func (s *commandService) Write(ctx context.Context, deviceAddr uint64, msg mqtt.DeviceWriteMessage) (interface{}, error) {
+	s.logger.Info("WRITE request received. Device:%v, ClusterID:%v", deviceAddr, msg.ClusterID)
+
+	tsn := nextTransactionSequence(deviceAddr)
+
+	return s.sendAndWait(ctx, clusterResponseKey(deviceAddr, msg.ClusterID, tsn), func() error {
+		return s.zRouter.ProccessWriteMessageToDevice(ctx, types.DeviceWriteMessage{
+			IEEEAddress:         deviceAddr,
+			ClusterID:           msg.ClusterID,
+			Endpoint:            msg.Endpoint,
+			Attributes:          msg.Attributes,
+			TransactionSequence: tsn,
+		})
+	})
+}
+
+func (s *commandService) Explore(ctx context.Context, deviceAddr uint64) (interface{}, error) {
+	s.logger.Info("EXPLORE request received. Device:%v", deviceAddr)
+
+	return s.sendAndWait(ctx, descriptionResponseKey(deviceAddr), func() error {
+		return s.zRouter.ProccessGetDeviceDescriptionMessage(ctx, types.DeviceExploreMessage{
+			IEEEAddress: deviceAddr,
+		})
+	})
+}
+
+// sendAndWait sends the command and returns the first message of the device matching responseKey,
+// ErrNoResponse is returned when ctx expires first. Error of send is returned without waiting.
+func (s *commandService) sendAndWait(ctx context.Context, responseKey string, send func() error) (interface{}, error) {
+	ch := s.waiters.Add(responseKey)
+	defer s.waiters.Remove(responseKey, ch)
+
+	if err := send(); err != nil {
+		if ctx.Err() != nil {
+			return nil, ErrNoResponse
+		}
+		return nil, err
+	}
+
+	select {
+	case msg := <-ch:
+		return msg, nil
+	case <-ctx.Done():
+		return nil, ErrNoResponse
+	}
+}
+
+// State returns the last reported attributes of every cluster ordered by cluster ID.
+func (s *commandService) State(deviceAddr uint64) []mqtt.DeviceAttributesReportMessage {
+	s.stateMtx.Lock()
+	defer s.stateMtx.Unlock()
+
+	ret := make([]mqtt.DeviceAttributesReportMessage, 0, len(s.state[deviceAddr]))
+	for _, report := range s.state[deviceAddr] {
+		ret = append(ret, report)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].ClusterID < ret[j].ClusterID })
+
+	return ret
+}
+
+// History answers the same way as get_history MQTT command, including defaults of missing From and To.
+func (s *commandService) History(ctx context.Context, deviceAddr uint64, query mqtt.DeviceHistoryQueryMessage) (mqtt.DeviceHistoryMessage, error) {
+	if s.history == nil {
+		return mqtt.DeviceHistoryMessage{}, ErrHistoryDisabled
+	}
+
+	if query.To.IsZero() {
+		query.To = time.Now()
+	}
+	if query.From.IsZero() {
+		query.From = query.To.Add(-24 * time.Hour)
+	}
+
+	samples, err := s.history.Query(ctx, deviceAddr, query.ClusterID, query.Attribute, query.From, query.To)
+	if err != nil {
+		s.logger.Error("error querying history: %v\n", err)
+		return mqtt.DeviceHistoryMessage{}, err
+	}
+
+	result := mqtt.DeviceHistoryMessage{
+		IEEEAddress:   deviceAddr,
+		ClusterID:     query.ClusterID,
+		Attribute:     query.Attribute,
+		From:          query.From,
+		To:            query.To,
+		BucketSeconds: query.BucketSeconds,
+	}
+	if query.BucketSeconds > 0 {
+		result.Buckets = history.Downsample(samples, query.From, time.Duration(query.BucketSeconds)*time.Second)
+	} else {
+		result.Samples = samples
+	}
+
+	return result, nil
+}
+
+// Config returns the running configuration without secrets.
+func (s *commandService) Config() configuration.Configuration {
+	return configuration.Redacted(s.configurationService.GetConfiguration())
+}
+
+func (s *commandService) SetConfig(ctx context.Context, patch []byte) (mqtt.SetGatewayConfigResult, error) {
+	result, err := applyConfigPatch(s.configurationService, patch)
+	if err != nil {
+		s.logger.Error("Applying new configuration error: %v\n", err)
+		return mqtt.SetGatewayConfigResult{}, err
+	}
+
+	if s.onConfigChange != nil {
+		s.onConfigChange(result)
+	}
+
+	return setConfigResult(result, s.configurationService.GetConfiguration()), nil
+}
+
+func (s *commandService) SubscribeOnConfigChange(callback func(result configuration.ReloadResult)) {
+	s.onConfigChange = callback
+}
+
+// applyConfigPatch validates and stores changed fields, those which can change at runtime are applied
+// to the running configuration.
+func applyConfigPatch(configurationService configuration.ConfigurationService, patch []byte) (configuration.ReloadResult, error) {
+	cfg, err := configuration.Patch(configurationService.GetConfiguration(), patch)
+	if err != nil {
+		return configuration.ReloadResult{}, fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+	}
+
+	result, err := configurationService.Apply(cfg)
+
+	var validationErr *configuration.ValidationError
+	if errors.As(err, &validationErr) || errors.Is(err, configuration.ErrNetworkChange) {
+		return result, fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+	}
+
+	return result, err
+}
+
+func setConfigResult(result configuration.ReloadResult, cfg configuration.Configuration) mqtt.SetGatewayConfigResult {
+	cfg = configuration.Redacted(cfg)
+
+	return mqtt.SetGatewayConfigResult{
+		Applied: result.AppliedFields,
+		Restart: result.RestartFields,
+		Config:  &cfg,
+	}
+}
+
+func (s *commandService) PendingRequests() []string {
+	return s.waiters.Keys()
+}
@@ -0,0 +1,184 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/shimmeringbee/zigbee"
+	"github.com/supby/gigbee2mqtt/internal/logger"
+	"go.bug.st/serial.v1"
+)
+
+// Events published in gateway/<event> when the coordinator is lost and opened again.
+const (
+	COORDINATOR_LOST      = "coordinator_lost"
+	COORDINATOR_RECOVERED = "coordinator_recovered"
+)
+
+const (
+	adapterProbeInterval = 10 * time.Second
+	adapterProbeTimeout  = 5 * time.Second
+	// adapterMaxFailures is the number of failed probes in a row after which the coordinator is lost.
+	adapterMaxFailures = 3
+	reopenMinBackoff   = time.Second
+	reopenMaxBackoff   = 5 * time.Minute
+	// reopenMaxDowntime is how long reopening is tried before the supervisor gives up and the gateway exits,
+	// so the service manager restarts it.
+	reopenMaxDowntime = time.Hour
+)
+
+// coordinatorSupervisor runs the coordinator and opens it again when it is lost, e.g. the USB adapter is
+// unplugged for a moment or stops answering.
+type coordinatorSupervisor struct {
+	// run processes events until ctx is done and returns nil, error is returned when the coordinator is lost.
+	run   func(ctx context.Context) error
+	open  func(ctx context.Context) error
+	close func()
+	// beat is called every second while recovering, so the watchdog does not restart the gateway meanwhile.
+	beat        func()
+	onLost      func(err error)
+	onRecovered func(attempts int, downtime time.Duration)
+	minBackoff  time.Duration
+	maxBackoff  time.Duration
+	maxDowntime time.Duration
+	logger      logger.Logger
+}
+
+// Run returns nil once ctx is done, error is returned when the coordinator is not reopened within maxDowntime.
+func (s *coordinatorSupervisor) Run(ctx context.Context) error {
+	for {
+		err := s.run(ctx)
+		if err == nil || ctx.Err() != nil {
+			return nil
+		}
+
+		s.logger.Error("Coordinator is lost: %v\n", err)
+		s.close()
+		s.onLost(err)
+
+		if err := s.recover(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+// recover opens the coordinator until it succeeds, waiting between attempts from minBackoff doubling up to
+// maxBackoff. Error is returned when it does not succeed within maxDowntime, nil also when ctx is done first.
+func (s *coordinatorSupervisor) recover(ctx context.Context) error {
+	lostAt := time.Now()
+	giveUp := time.NewTimer(s.maxDowntime)
+	defer giveUp.Stop()
+
+	beatCtx, stopBeat := context.WithCancel(ctx)
+	defer stopBeat()
+	go s.keepBeating(beatCtx)
+
+	backoff := s.minBackoff
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-giveUp.C:
+			return fmt.Errorf("coordinator is not reopened within %v, %v attempts", s.maxDowntime, attempt-1)
+		case <-time.After(backoff):
+		}
+
+		err := s.open(ctx)
+		if err == nil {
+			s.logger.Info("Coordinator is recovered after %v attempts\n", attempt)
+			s.onRecovered(attempt, time.Since(lostAt))
+			return nil
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		backoff = nextBackoff(backoff, s.maxBackoff)
+		s.logger.Warn("Error reopening coordinator, attempt %v, next in %v: %v\n", attempt, backoff, err)
+	}
+}
+
+func (s *coordinatorSupervisor) keepBeating(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		s.beat()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func nextBackoff(backoff time.Duration, max time.Duration) time.Duration {
+	if backoff *= 2; backoff > max {
+		return max
+	}
+
+	return backoff
+}
+
+type adapterProber interface {
+	GetAdapterIEEEAddress(ctx context.Context) (zigbee.IEEEAddress, error)
+}
+
+// probeAdapter asks the adapter for its address every interval, events alone do not tell a quiet network from
+// a hung adapter. Error is sent to lost when the adapter does not answer adapterMaxFailures times in a row.
+func probeAdapter(ctx context.Context, z adapterProber, interval time.Duration, lost chan<- error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	failures := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		probeCtx, cancel := context.WithTimeout(ctx, adapterProbeTimeout)
+		_, err := z.GetAdapterIEEEAddress(probeCtx)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			failures = 0
+			continue
+		}
+
+		failures++
+		if failures >= adapterMaxFailures {
+			lost <- fmt.Errorf("adapter does not answer: %w", err)
+			return
+		}
+	}
+}
+
+// coordinatorPort returns io.EOF from Read once the port is closed, the ZNP broker reading the port stops
+// only on EOF and would spin on errors of the closed port otherwise.
+type coordinatorPort struct {
+	serial.Port
+	closed int32
+}
+
+func (p *coordinatorPort) Read(b []byte) (int, error) {
+	n, err := p.Port.Read(b)
+	if err != nil && atomic.LoadInt32(&p.closed) == 1 {
+		return n, io.EOF
+	}
+
+	return n, err
+}
+
+func (p *coordinatorPort) Close() error {
+	atomic.StoreInt32(&p.closed, 1)
+
+	return p.Port.Close()
+}
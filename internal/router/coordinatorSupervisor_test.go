@@ -0,0 +1,139 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/shimmeringbee/zigbee"
+	"github.com/stretchr/testify/assert"
+	"github.com/supby/gigbee2mqtt/internal/logger"
+)
+
+func TestCoordinatorSupervisor(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the adapter stops answering probes until it is opened again, like an unplugged USB adapter
+	prober := &testAdapterProber{fail: 1}
+	runs, opens, closes := 0, 0, 0
+	var lost error
+	recoveredAfter := 0
+	done := make(chan struct{})
+
+	s := coordinatorSupervisor{
+		run: func(ctx context.Context) error {
+			runs++
+
+			probeCtx, stopProbe := context.WithCancel(ctx)
+			defer stopProbe()
+			adapterLost := make(chan error, 1)
+			go probeAdapter(probeCtx, prober, time.Millisecond, adapterLost)
+
+			select {
+			case <-ctx.Done():
+				return nil
+			case err := <-adapterLost:
+				return err
+			}
+		},
+		open: func(ctx context.Context) error {
+			if opens++; opens < 3 {
+				return errors.New("no such file or directory")
+			}
+			atomic.StoreInt32(&prober.fail, 0)
+			return nil
+		},
+		close:       func() { closes++ },
+		beat:        func() {},
+		onLost:      func(err error) { lost = err },
+		onRecovered: func(attempts int, downtime time.Duration) { recoveredAfter = attempts; cancel() },
+		minBackoff:  time.Millisecond,
+		maxBackoff:  2 * time.Millisecond,
+		maxDowntime: time.Minute,
+		logger:      logger.GetLogger("[test]", logger.LogLevelError),
+	}
+
+	go func() {
+		defer close(done)
+		assert.NoError(t, s.Run(ctx))
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("supervisor did not stop")
+	}
+
+	assert.ErrorIs(t, lost, context.DeadlineExceeded)
+	assert.Equal(t, 1, closes)
+	assert.Equal(t, 3, recoveredAfter)
+	assert.Equal(t, 2, runs, "events are read again after recovery")
+}
+
+func TestCoordinatorSupervisorGivesUp(t *testing.T) {
+	prober := &testAdapterProber{fail: 1}
+	recovered := false
+
+	s := coordinatorSupervisor{
+		run: func(ctx context.Context) error {
+			adapterLost := make(chan error, 1)
+			go probeAdapter(ctx, prober, time.Millisecond, adapterLost)
+			return <-adapterLost
+		},
+		open:        func(ctx context.Context) error { return errors.New("no such file or directory") },
+		close:       func() {},
+		beat:        func() {},
+		onLost:      func(err error) {},
+		onRecovered: func(attempts int, downtime time.Duration) { recovered = true },
+		minBackoff:  time.Millisecond,
+		maxBackoff:  time.Millisecond,
+		maxDowntime: 20 * time.Millisecond,
+		logger:      logger.GetLogger("[test]", logger.LogLevelError),
+	}
+
+	err := s.Run(context.Background())
+	assert.ErrorContains(t, err, "coordinator is not reopened within 20ms")
+	assert.False(t, recovered)
+}
+
+func TestNextBackoff(t *testing.T) {
+	assert.Equal(t, 2*time.Second, nextBackoff(time.Second, time.Minute))
+	assert.Equal(t, time.Minute, nextBackoff(40*time.Second, time.Minute))
+}
+
+type testAdapterProber struct {
+	fail int32
+}
+
+func (p *testAdapterProber) GetAdapterIEEEAddress(ctx context.Context) (zigbee.IEEEAddress, error) {
+	if atomic.LoadInt32(&p.fail) == 1 {
+		return 0, context.DeadlineExceeded
+	}
+	return 0x00124b0012345678, nil
+}
+
+func TestProbeAdapter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	prober := &testAdapterProber{}
+	lost := make(chan error, 1)
+	go probeAdapter(ctx, prober, time.Millisecond, lost)
+
+	select {
+	case err := <-lost:
+		t.Fatalf("answering adapter is lost: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	atomic.StoreInt32(&prober.fail, 1)
+	select {
+	case err := <-lost:
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	case <-time.After(time.Second):
+		t.Fatal("adapter which does not answer is not lost")
+	}
+}
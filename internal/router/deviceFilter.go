@@ -0,0 +1,83 @@
+package router
+
+import (
+	"time"
+
+	"github.com/supby/gigbee2mqtt/internal/configuration"
+	"github.com/supby/gigbee2mqtt/internal/db"
+	"github.com/supby/gigbee2mqtt/internal/mqtt"
+)
+
+// isDeviceOnline checks that the device sent something within availability timeout of its power source.
+func isDeviceOnline(device db.Device, availability configuration.AvailabilityConfiguration, now time.Time) bool {
+	if device.LastReceived.IsZero() {
+		return false
+	}
+
+	timeout := availability.ActiveTimeoutInSeconds
+	if device.PowerSource == db.PowerSourceBattery {
+		timeout = availability.PassiveTimeoutInSeconds
+	}
+
+	return now.Sub(device.LastReceived) <= time.Duration(timeout)*time.Second
+}
+
+func matchDevice(device db.Device, filter mqtt.DevicesFilter, availability configuration.AvailabilityConfiguration, now time.Time) bool {
+	if filter.ModelID != "" && device.ModelID != filter.ModelID {
+		return false
+	}
+
+	if filter.Tag != "" && !containsString(device.Tags, filter.Tag) {
+		return false
+	}
+
+	if filter.LogicalType != nil && device.LogicalType != *filter.LogicalType {
+		return false
+	}
+
+	if filter.ClusterID != nil && !deviceSupportsCluster(device, *filter.ClusterID) {
+		return false
+	}
+
+	if filter.Online != nil && isDeviceOnline(device, availability, now) != *filter.Online {
+		return false
+	}
+
+	if filter.LastSeenOlderThanSeconds > 0 &&
+		now.Sub(device.LastReceived) <= time.Duration(filter.LastSeenOlderThanSeconds)*time.Second {
+		return false
+	}
+
+	return true
+}
+
+func filterDevices(devices []db.Device, filter mqtt.DevicesFilter, availability configuration.AvailabilityConfiguration, now time.Time) []db.Device {
+	ret := make([]db.Device, 0)
+	for _, device := range devices {
+		if matchDevice(device, filter, availability, now) {
+			ret = append(ret, device)
+		}
+	}
+
+	return ret
+}
+
+func deviceSupportsCluster(device db.Device, clusterID uint16) bool {
+	for _, endpoint := range device.Endpoints {
+		if containsCluster(endpoint.InClusterList, clusterID) || containsCluster(endpoint.OutClusterList, clusterID) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,68 @@
+package router
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/supby/gigbee2mqtt/internal/configuration"
+	"github.com/supby/gigbee2mqtt/internal/db"
+	"github.com/supby/gigbee2mqtt/internal/mqtt"
+)
+
+func TestFilterDevices(t *testing.T) {
+	now := time.Now()
+	availability := configuration.AvailabilityConfiguration{
+		ActiveTimeoutInSeconds:  600,
+		PassiveTimeoutInSeconds: 3600,
+	}
+
+	devices := []db.Device{
+		{
+			IEEEAddress:  1,
+			ModelID:      "TRADFRI bulb E27",
+			LogicalType:  1,
+			PowerSource:  db.PowerSourceMains,
+			LastReceived: now.Add(-time.Minute),
+			Tags:         []string{"kitchen"},
+			Endpoints:    []db.Endpoint{{Endpoint: 1, InClusterList: []uint16{0, 6, 8}}},
+		},
+		{
+			IEEEAddress:  2,
+			ModelID:      "TRADFRI remote control",
+			LogicalType:  2,
+			PowerSource:  db.PowerSourceBattery,
+			LastReceived: now.Add(-30 * time.Minute),
+			Endpoints:    []db.Endpoint{{Endpoint: 1, OutClusterList: []uint16{6}}},
+		},
+		{
+			IEEEAddress:  3,
+			LogicalType:  1,
+			PowerSource:  db.PowerSourceMains,
+			LastReceived: now.Add(-2 * time.Hour),
+		},
+	}
+
+	addresses := func(filter mqtt.DevicesFilter) []uint64 {
+		ret := make([]uint64, 0)
+		for _, d := range filterDevices(devices, filter, availability, now) {
+			ret = append(ret, d.IEEEAddress)
+		}
+		return ret
+	}
+
+	logicalType := uint8(1)
+	clusterID := uint16(6)
+	online := true
+	offline := false
+
+	assert.Equal(t, []uint64{1, 2, 3}, addresses(mqtt.DevicesFilter{}))
+	assert.Equal(t, []uint64{2}, addresses(mqtt.DevicesFilter{ModelID: "TRADFRI remote control"}))
+	assert.Equal(t, []uint64{1}, addresses(mqtt.DevicesFilter{Tag: "kitchen"}))
+	assert.Equal(t, []uint64{1, 3}, addresses(mqtt.DevicesFilter{LogicalType: &logicalType}))
+	assert.Equal(t, []uint64{1, 2}, addresses(mqtt.DevicesFilter{ClusterID: &clusterID}))
+	assert.Equal(t, []uint64{1, 2}, addresses(mqtt.DevicesFilter{Online: &online}))
+	assert.Equal(t, []uint64{3}, addresses(mqtt.DevicesFilter{Online: &offline}))
+	assert.Equal(t, []uint64{2, 3}, addresses(mqtt.DevicesFilter{LastSeenOlderThanSeconds: 600}))
+	assert.Equal(t, []uint64{3}, addresses(mqtt.DevicesFilter{LogicalType: &logicalType, LastSeenOlderThanSeconds: 600}))
+}
@@ -0,0 +1,104 @@
+package router
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/supby/gigbee2mqtt/internal/db"
+	"github.com/supby/gigbee2mqtt/internal/history"
+)
+
+// ZCL clusters and attributes the device record is filled from.
+const (
+	clusterBasic              = uint16(0x0000)
+	clusterPowerConfiguration = uint16(0x0001)
+
+	attrBasicManufacturerName = uint16(0x0004)
+	attrBasicModelIdentifier  = uint16(0x0005)
+	attrBasicPowerSource      = uint16(0x0007)
+	attrBasicSWBuildID        = uint16(0x4000)
+
+	attrPowerBatteryPercentage = uint16(0x0021)
+)
+
+// errNoDeviceUpdate aborts DeviceDB.UpdateDevice when there is nothing to save.
+var errNoDeviceUpdate = errors.New("no device update")
+
+var deviceInfoAttributes = map[uint16][]uint16{
+	clusterBasic: {
+		attrBasicManufacturerName,
+		attrBasicModelIdentifier,
+		attrBasicPowerSource,
+		attrBasicSWBuildID,
+	},
+	clusterPowerConfiguration: {
+		attrPowerBatteryPercentage,
+	},
+}
+
+// applyDeviceAttributes copies Basic and Power Configuration attribute values into the device record,
+// it returns false when none of the attributes is relevant.
+func applyDeviceAttributes(device *db.Device, clusterID uint16, attributes map[uint16]interface{}) bool {
+	applied := false
+
+	for id, value := range attributes {
+		switch {
+		case clusterID == clusterBasic && id == attrBasicManufacturerName:
+			if s, ok := value.(string); ok {
+				device.ManufacturerName = strings.TrimRight(s, "\x00 ")
+				applied = true
+			}
+		case clusterID == clusterBasic && id == attrBasicModelIdentifier:
+			if s, ok := value.(string); ok {
+				device.ModelID = strings.TrimRight(s, "\x00 ")
+				applied = true
+			}
+		case clusterID == clusterBasic && id == attrBasicSWBuildID:
+			if s, ok := value.(string); ok {
+				device.FirmwareBuild = strings.TrimRight(s, "\x00 ")
+				applied = true
+			}
+		case clusterID == clusterBasic && id == attrBasicPowerSource:
+			if v, ok := history.ToFloat(value); ok {
+				device.PowerSource = powerSourceName(uint8(v))
+				applied = true
+			}
+		case clusterID == clusterPowerConfiguration && id == attrPowerBatteryPercentage:
+			// reported in half percent units, 0xff is invalid
+			if v, ok := history.ToFloat(value); ok && v != 0xff {
+				level := uint8(v / 2)
+				if level > 100 {
+					level = 100
+				}
+				device.BatteryLevel = &level
+				applied = true
+			}
+		}
+	}
+
+	return applied
+}
+
+// powerSourceName maps Basic cluster PowerSource enum, the highest bit flags a backup battery.
+func powerSourceName(value uint8) string {
+	switch value & 0x7f {
+	case 0x01, 0x02, 0x05, 0x06:
+		return db.PowerSourceMains
+	case 0x03:
+		return db.PowerSourceBattery
+	case 0x04:
+		return db.PowerSourceDC
+	}
+
+	return db.PowerSourceUnknown
+}
+
+func containsCluster(clusters []uint16, clusterID uint16) bool {
+	for _, c := range clusters {
+		if c == clusterID {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,40 @@
+package router
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/supby/gigbee2mqtt/internal/db"
+)
+
+func TestApplyDeviceAttributes(t *testing.T) {
+	device := db.Device{}
+
+	applied := applyDeviceAttributes(&device, clusterBasic, map[uint16]interface{}{
+		attrBasicManufacturerName: "IKEA of Sweden",
+		attrBasicModelIdentifier:  "TRADFRI remote control\x00",
+		attrBasicSWBuildID:        "2.3.014",
+		attrBasicPowerSource:      uint8(0x83),
+	})
+	assert.True(t, applied)
+	assert.Equal(t, "IKEA of Sweden", device.ManufacturerName)
+	assert.Equal(t, "TRADFRI remote control", device.ModelID)
+	assert.Equal(t, "2.3.014", device.FirmwareBuild)
+	assert.Equal(t, db.PowerSourceBattery, device.PowerSource)
+
+	applied = applyDeviceAttributes(&device, clusterPowerConfiguration, map[uint16]interface{}{
+		attrPowerBatteryPercentage: uint64(174),
+	})
+	assert.True(t, applied)
+	assert.Equal(t, uint8(87), *device.BatteryLevel)
+
+	// invalid battery value is ignored
+	applied = applyDeviceAttributes(&device, clusterPowerConfiguration, map[uint16]interface{}{
+		attrPowerBatteryPercentage: uint64(0xff),
+	})
+	assert.False(t, applied)
+	assert.Equal(t, uint8(87), *device.BatteryLevel)
+
+	applied = applyDeviceAttributes(&device, 6, map[uint16]interface{}{0: true})
+	assert.False(t, applied)
+}
@@ -0,0 +1,305 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/supby/gigbee2mqtt/internal/configuration"
+	"github.com/supby/gigbee2mqtt/internal/db"
+	"github.com/supby/gigbee2mqtt/internal/logger"
+	"github.com/supby/gigbee2mqtt/internal/mqtt"
+)
+
+const (
+	HTTP_EVENTS = "/api/events"
+
+	EVENT_MESSAGE = "message"
+	EVENT_STATUS  = "status"
+
+	GATEWAY_ONLINE  = "online"
+	GATEWAY_OFFLINE = "offline"
+)
+
+const (
+	eventClientBuffer = 256
+	eventPingPeriod   = 30 * time.Second
+	eventWriteTimeout = 10 * time.Second
+)
+
+// StreamEvent is one JSON frame of the event stream.
+type StreamEvent struct {
+	Type        string  // message, description, join, leave, update, device_added, device_removed or status
+	IEEEAddress uint64  `json:",omitempty"`
+	ClusterID   *uint16 `json:",omitempty"`
+	Time        time.Time
+	Payload     interface{}
+}
+
+// streamFilter is given in query parameters on connect, every parameter may be repeated.
+// Events without device or cluster (e.g. gateway status) pass device and cluster filters.
+type streamFilter struct {
+	devices  map[uint64]bool
+	clusters map[uint16]bool
+	types    map[string]bool
+}
+
+func (f streamFilter) match(e StreamEvent) bool {
+	if len(f.types) > 0 && !f.types[e.Type] {
+		return false
+	}
+	if len(f.devices) > 0 && e.IEEEAddress != 0 && !f.devices[e.IEEEAddress] {
+		return false
+	}
+	if len(f.clusters) > 0 && (e.ClusterID == nil || !f.clusters[*e.ClusterID]) {
+		return false
+	}
+
+	return true
+}
+
+func parseStreamFilter(r *http.Request) (streamFilter, error) {
+	query := r.URL.Query()
+	filter := streamFilter{
+		devices:  map[uint64]bool{},
+		clusters: map[uint16]bool{},
+		types:    map[string]bool{},
+	}
+
+	for _, v := range query["device"] {
+		addr, err := strconv.ParseUint(strings.Replace(v, "0x", "", -1), 16, 64)
+		if err != nil {
+			return filter, fmt.Errorf("invalid device '%v'", v)
+		}
+		filter.devices[addr] = true
+	}
+
+	for _, v := range query["cluster"] {
+		clusterID, err := strconv.ParseUint(v, 0, 16)
+		if err != nil {
+			return filter, fmt.Errorf("invalid cluster '%v'", v)
+		}
+		filter.clusters[uint16(clusterID)] = true
+	}
+
+	for _, v := range query["type"] {
+		filter.types[v] = true
+	}
+
+	return filter, nil
+}
+
+type streamClient struct {
+	filter streamFilter
+	events chan StreamEvent
+}
+
+type eventStream struct {
+	configurationService configuration.ConfigurationService
+	logger               logger.Logger
+	upgrader             websocket.Upgrader
+	mtx                  sync.Mutex
+	clients              map[*streamClient]bool
+	status               string
+}
+
+// NewEventStream streams device messages, device events and gateway status to WebSocket clients.
+func NewEventStream(configurationService configuration.ConfigurationService, db db.DeviceDB) EventStream {
+	ret := &eventStream{
+		configurationService: configurationService,
+		logger:               logger.GetLogger("[Event Stream]", configurationService.GetConfiguration().LogLevel),
+		clients:              map[*streamClient]bool{},
+		status:               GATEWAY_ONLINE,
+	}
+	ret.upgrader.CheckOrigin = ret.checkOrigin
+
+	db.SubscribeOnChange(ret.onDeviceChange)
+
+	return ret
+}
+
+func (s *eventStream) PublishDeviceMessage(ieeeAddress uint64, msg interface{}, subtopic string) {
+	e := StreamEvent{
+		Type:        subtopic,
+		IEEEAddress: ieeeAddress,
+		Time:        time.Now(),
+		Payload:     msg,
+	}
+	if e.Type == "" {
+		e.Type = EVENT_MESSAGE
+	}
+
+	if m, ok := msg.(mqtt.DeviceMessage); ok {
+		switch devMsg := m.Message.(type) {
+		case mqtt.DeviceAttributesReportMessage:
+			e.ClusterID = &devMsg.ClusterID
+		case mqtt.DeviceDefaultResponseMessage:
+			e.ClusterID = &devMsg.ClusterID
+		case mqtt.DeviceWriteAttributesResponseMessage:
+			e.ClusterID = &devMsg.ClusterID
+		}
+	}
+
+	s.broadcast(e)
+}
+
+func (s *eventStream) PublishGatewayStatus(status string) {
+	s.mtx.Lock()
+	s.status = status
+	s.mtx.Unlock()
+
+	s.broadcast(s.statusEvent(status))
+}
+
+func (s *eventStream) statusEvent(status string) StreamEvent {
+	return StreamEvent{
+		Type: EVENT_STATUS,
+		Time: time.Now(),
+		Payload: map[string]interface{}{
+			"Status":     status,
+			"PermitJoin": s.configurationService.GetConfiguration().PermitJoin,
+		},
+	}
+}
+
+func (s *eventStream) onDeviceChange(change db.DeviceChange) {
+	switch change.Type {
+	case db.DeviceAdded:
+		s.PublishDeviceMessage(change.Device.IEEEAddress, change.Device, MQTT_DEVICE_ADDED)
+	case db.DeviceRemoved:
+		s.PublishDeviceMessage(change.Device.IEEEAddress, change.Device, MQTT_DEVICE_REMOVED)
+	}
+}
+
+func (s *eventStream) broadcast(e StreamEvent) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	for c := range s.clients {
+		if !c.filter.match(e) {
+			continue
+		}
+
+		select {
+		case c.events <- e:
+		default:
+			// slow client is disconnected rather than silently missing events
+			s.logger.Warn("event stream client is too slow, disconnecting")
+			delete(s.clients, c)
+			close(c.events)
+		}
+	}
+}
+
+func (s *eventStream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseStreamFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		s.logger.Error("WebSocket upgrade error: %v\n", err)
+		return
+	}
+	defer conn.Close()
+
+	client := &streamClient{
+		filter: filter,
+		events: make(chan StreamEvent, eventClientBuffer),
+	}
+
+	s.mtx.Lock()
+	status := s.status
+	s.clients[client] = true
+	s.mtx.Unlock()
+
+	defer s.removeClient(client)
+
+	s.logger.Info("event stream client connected from %v", r.RemoteAddr)
+
+	// reading is needed to process control frames and notice the client going away
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	if statusEvent := s.statusEvent(status); filter.match(statusEvent) {
+		if err := s.write(conn, statusEvent); err != nil {
+			return
+		}
+	}
+
+	ping := time.NewTicker(eventPingPeriod)
+	defer ping.Stop()
+
+	for {
+		select {
+		case e, ok := <-client.events:
+			if !ok {
+				conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too slow"), time.Now().Add(eventWriteTimeout))
+				return
+			}
+			if err := s.write(conn, e); err != nil {
+				return
+			}
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(eventWriteTimeout)); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (s *eventStream) checkOrigin(r *http.Request) bool {
+	return originAllowed(r, s.configurationService.GetConfiguration().HTTP.AllowedOrigins)
+}
+
+// originAllowed accepts requests without Origin (not sent by a browser), from the same host
+// or from one of allowedOrigins.
+func originAllowed(r *http.Request, allowedOrigins []string) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	for _, allowed := range allowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+func (s *eventStream) write(conn *websocket.Conn, e StreamEvent) error {
+	conn.SetWriteDeadline(time.Now().Add(eventWriteTimeout))
+	return conn.WriteJSON(e)
+}
+
+func (s *eventStream) removeClient(client *streamClient) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.clients[client] {
+		delete(s.clients, client)
+		close(client.events)
+	}
+}
@@ -0,0 +1,119 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/supby/gigbee2mqtt/internal/configuration"
+	"github.com/supby/gigbee2mqtt/internal/db"
+	"github.com/supby/gigbee2mqtt/internal/mqtt"
+)
+
+func newTestEventStream(t *testing.T) (EventStream, db.DeviceDB, string) {
+	deviceDB, err := db.NewDeviceDB(t.TempDir(), db.DeviceDBOptions{
+		FlushPeriodInSeconds: 60,
+	})
+	assert.NoError(t, err)
+	t.Cleanup(func() { deviceDB.Close(context.Background()) })
+
+	stream := NewEventStream(&testConfigurationService{configuration: configuration.Default()}, deviceDB)
+
+	srv := httptest.NewServer(stream)
+	t.Cleanup(srv.Close)
+
+	return stream, deviceDB, "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+func dialEventStream(t *testing.T, url string) *websocket.Conn {
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return conn
+}
+
+func readEvent(t *testing.T, conn *websocket.Conn) StreamEvent {
+	var e StreamEvent
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	assert.NoError(t, conn.ReadJSON(&e))
+	return e
+}
+
+// waitForClients makes sure the connection is registered before events are published.
+func waitForClients(t *testing.T, stream EventStream, count int) {
+	s := stream.(*eventStream)
+	assert.Eventually(t, func() bool {
+		s.mtx.Lock()
+		defer s.mtx.Unlock()
+		return len(s.clients) == count
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func reportMessage(ieeeAddress uint64, clusterID uint16) mqtt.DeviceMessage {
+	return mqtt.DeviceMessage{
+		IEEEAddress: ieeeAddress,
+		Message: mqtt.DeviceAttributesReportMessage{
+			ClusterID:         clusterID,
+			ClusterAttributes: map[string]interface{}{"value": 1},
+		},
+	}
+}
+
+func TestEventStream(t *testing.T) {
+	stream, deviceDB, url := newTestEventStream(t)
+
+	conn := dialEventStream(t, url)
+
+	e := readEvent(t, conn)
+	assert.Equal(t, EVENT_STATUS, e.Type)
+	assert.Equal(t, GATEWAY_ONLINE, e.Payload.(map[string]interface{})["Status"])
+
+	waitForClients(t, stream, 1)
+
+	stream.PublishDeviceMessage(testDevice, reportMessage(testDevice, 6), "")
+	e = readEvent(t, conn)
+	assert.Equal(t, EVENT_MESSAGE, e.Type)
+	assert.Equal(t, testDevice, e.IEEEAddress)
+	assert.Equal(t, uint16(6), *e.ClusterID)
+
+	assert.NoError(t, deviceDB.SaveDevice(context.Background(), db.Device{IEEEAddress: testDevice}))
+	e = readEvent(t, conn)
+	assert.Equal(t, MQTT_DEVICE_ADDED, e.Type)
+	assert.Equal(t, testDevice, e.IEEEAddress)
+
+	stream.PublishGatewayStatus(GATEWAY_OFFLINE)
+	e = readEvent(t, conn)
+	assert.Equal(t, EVENT_STATUS, e.Type)
+	assert.Equal(t, GATEWAY_OFFLINE, e.Payload.(map[string]interface{})["Status"])
+}
+
+func TestEventStreamFilter(t *testing.T) {
+	stream, _, url := newTestEventStream(t)
+
+	conn := dialEventStream(t, fmt.Sprintf("%v?device=0x%x&cluster=6&type=message", url, testDevice))
+	waitForClients(t, stream, 1)
+
+	stream.PublishDeviceMessage(testDevice+1, reportMessage(testDevice+1, 6), "")
+	stream.PublishDeviceMessage(testDevice, reportMessage(testDevice, 8), "")
+	stream.PublishDeviceMessage(testDevice, mqtt.DeviceDescriptionMessage{IEEEAddress: testDevice}, MQTT_DEVICE_DESCRIPTION)
+	stream.PublishDeviceMessage(testDevice, reportMessage(testDevice, 6), "")
+
+	e := readEvent(t, conn)
+	assert.Equal(t, EVENT_MESSAGE, e.Type)
+	assert.Equal(t, testDevice, e.IEEEAddress)
+	assert.Equal(t, uint16(6), *e.ClusterID)
+}
+
+func TestEventStreamInvalidFilter(t *testing.T) {
+	_, _, url := newTestEventStream(t)
+
+	_, resp, err := websocket.DefaultDialer.Dial(url+"?cluster=onoff", nil)
+	assert.Error(t, err)
+	assert.Equal(t, 400, resp.StatusCode)
+}
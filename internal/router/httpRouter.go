@@ -0,0 +1,493 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/supby/gigbee2mqtt/internal/configuration"
+	"github.com/supby/gigbee2mqtt/internal/logger"
+	"github.com/supby/gigbee2mqtt/internal/mqtt"
+	"github.com/supby/gigbee2mqtt/internal/types"
+	"github.com/supby/gigbee2mqtt/internal/zcldef"
+)
+
+const (
+	HTTP_API_PREFIX  = "/api/"
+	HTTP_DEVICES     = "devices"
+	HTTP_STATE       = "state"
+	HTTP_CONFIG      = "config"
+	HTTP_PERMIT_JOIN = "permit_join"
+	HTTP_ZCL         = "zcl"
+)
+
+// httpResponseTimeout is how long a request waits for the device to answer, overridden by `timeout` query parameter.
+var httpResponseTimeout = 10 * time.Second
+
+// httpMaxResponseTimeout is the longest `timeout` query parameter accepted.
+const httpMaxResponseTimeout = 5 * time.Minute
+
+type httpRouter struct {
+	configurationService configuration.ConfigurationService
+	commands             CommandService
+	zclDefService        zcldef.ZCLDefService
+	logger               logger.Logger
+}
+
+// NewHTTPRouter serves REST API on HTTP_API_PREFIX, device commands are run by CommandService
+// and the answer of the device is returned as the response body.
+// The API is not authenticated, state changing requests are only protected against cross-site
+// requests of browsers: they must have JSON content type and an allowed Origin.
+func NewHTTPRouter(
+	configurationService configuration.ConfigurationService,
+	commands CommandService,
+	zclDefService zcldef.ZCLDefService) HTTPRouter {
+	return &httpRouter{
+		configurationService: configurationService,
+		commands:             commands,
+		zclDefService:        zclDefService,
+		logger:               logger.GetLogger("[HTTP Router]", configurationService.GetConfiguration().LogLevel),
+	}
+}
+
+func (h *httpRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := h.checkRequest(r); err != nil {
+		writeError(w, http.StatusForbidden, err)
+		return
+	}
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, HTTP_API_PREFIX), "/")
+	parts := strings.Split(path, "/")
+
+	switch {
+	case path == HTTP_DEVICES:
+		h.allowMethods(w, r, map[string]http.HandlerFunc{
+			http.MethodGet: h.handleGetDevices,
+		})
+	case parts[0] == HTTP_DEVICES && len(parts) <= 3:
+		h.handleDeviceRequest(w, r, parts[1:])
+	case path == HTTP_CONFIG:
+		h.allowMethods(w, r, map[string]http.HandlerFunc{
+			http.MethodGet:   h.handleGetConfig,
+			http.MethodPatch: h.handleSetConfig,
+		})
+	case parts[0] == HTTP_ZCL && len(parts) == 2:
+		h.allowMethods(w, r, map[string]http.HandlerFunc{
+			http.MethodGet: func(w http.ResponseWriter, r *http.Request) {
+				h.handleGetClusterDefinition(w, r, parts[1])
+			},
+		})
+	case path == HTTP_PERMIT_JOIN:
+		h.allowMethods(w, r, map[string]http.HandlerFunc{
+			http.MethodPost: h.handlePermitJoin,
+		})
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown path '%v'", r.URL.Path))
+	}
+}
+
+// checkRequest rejects state changing requests a page of another site can send without CORS preflight,
+// those are form posts which can not have JSON content type, and requests from origins not allowed.
+func (h *httpRouter) checkRequest(r *http.Request) error {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+		return nil
+	}
+
+	if !originAllowed(r, h.configurationService.GetConfiguration().HTTP.AllowedOrigins) {
+		return fmt.Errorf("origin '%v' is not allowed", r.Header.Get("Origin"))
+	}
+
+	if r.Method == http.MethodPost || r.Method == http.MethodPatch {
+		mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || mediaType != "application/json" {
+			return fmt.Errorf("content type must be application/json")
+		}
+	}
+
+	return nil
+}
+
+func (h *httpRouter) allowMethods(w http.ResponseWriter, r *http.Request, handlers map[string]http.HandlerFunc) {
+	handler, ok := handlers[r.Method]
+	if !ok {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %v is not allowed", r.Method))
+		return
+	}
+
+	handler(w, r)
+}
+
+func (h *httpRouter) handleDeviceRequest(w http.ResponseWriter, r *http.Request, parts []string) {
+	deviceAddr, err := strconv.ParseUint(strings.Replace(parts[0], "0x", "", -1), 16, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid device address '%v'", parts[0]))
+		return
+	}
+
+	device, err := h.commands.Device(r.Context(), deviceAddr)
+	if err != nil {
+		writeCommandError(w, err)
+		return
+	}
+
+	if len(parts) == 1 {
+		h.allowMethods(w, r, map[string]http.HandlerFunc{
+			http.MethodGet: func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, http.StatusOK, device)
+			},
+			http.MethodPatch: func(w http.ResponseWriter, r *http.Request) {
+				h.handleSetDevice(w, r, deviceAddr)
+			},
+			http.MethodDelete: func(w http.ResponseWriter, r *http.Request) {
+				h.handleRemoveDevice(w, r, deviceAddr)
+			},
+		})
+		return
+	}
+
+	deviceHandlers := map[string]map[string]http.HandlerFunc{
+		HTTP_STATE: {
+			http.MethodGet: func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, http.StatusOK, h.commands.State(deviceAddr))
+			},
+		},
+		MQTT_DEVICE_SET: {
+			http.MethodPost: func(w http.ResponseWriter, r *http.Request) {
+				h.handleDeviceSetCommand(w, r, deviceAddr)
+			},
+		},
+		MQTT_DEVICE_GET: {
+			http.MethodPost: func(w http.ResponseWriter, r *http.Request) {
+				h.handleDeviceGetCommand(w, r, deviceAddr)
+			},
+		},
+		MQTT_DEVICE_WRITE: {
+			http.MethodPost: func(w http.ResponseWriter, r *http.Request) {
+				h.handleDeviceWriteCommand(w, r, deviceAddr)
+			},
+		},
+		MQTT_DEVICE_EXPLORE: {
+			http.MethodPost: func(w http.ResponseWriter, r *http.Request) {
+				h.sendAndWait(w, r, func(ctx context.Context) (interface{}, error) {
+					return h.commands.Explore(ctx, deviceAddr)
+				})
+			},
+		},
+		MQTT_DEVICE_HISTORY: {
+			http.MethodGet: func(w http.ResponseWriter, r *http.Request) {
+				h.handleDeviceHistoryQuery(w, r, deviceAddr)
+			},
+		},
+	}
+
+	handlers, ok := deviceHandlers[parts[1]]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown path '%v'", r.URL.Path))
+		return
+	}
+
+	h.allowMethods(w, r, handlers)
+}
+
+func (h *httpRouter) handleGetDevices(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseDevicesFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	devices, err := h.commands.Devices(r.Context(), filter)
+	if err != nil {
+		writeCommandError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, devices)
+}
+
+// parseDevicesFilter reads mqtt.DevicesFilter fields from query parameters.
+func parseDevicesFilter(r *http.Request) (mqtt.DevicesFilter, error) {
+	query := r.URL.Query()
+	filter := mqtt.DevicesFilter{
+		ModelID: query.Get("model_id"),
+		Tag:     query.Get("tag"),
+	}
+
+	if v := query.Get("logical_type"); v != "" {
+		logicalType, err := strconv.ParseUint(v, 10, 8)
+		if err != nil {
+			return filter, fmt.Errorf("invalid logical_type: %v", err)
+		}
+		lt := uint8(logicalType)
+		filter.LogicalType = &lt
+	}
+
+	if v := query.Get("cluster_id"); v != "" {
+		clusterID, err := strconv.ParseUint(v, 0, 16)
+		if err != nil {
+			return filter, fmt.Errorf("invalid cluster_id: %v", err)
+		}
+		id := uint16(clusterID)
+		filter.ClusterID = &id
+	}
+
+	if v := query.Get("online"); v != "" {
+		online, err := strconv.ParseBool(v)
+		if err != nil {
+			return filter, fmt.Errorf("invalid online: %v", err)
+		}
+		filter.Online = &online
+	}
+
+	if v := query.Get("last_seen_older_than_seconds"); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil {
+			return filter, fmt.Errorf("invalid last_seen_older_than_seconds: %v", err)
+		}
+		filter.LastSeenOlderThanSeconds = seconds
+	}
+
+	return filter, nil
+}
+
+func (h *httpRouter) handleSetDevice(w http.ResponseWriter, r *http.Request, deviceAddr uint64) {
+	var msg mqtt.SetDeviceMessage
+	if !readJSON(w, r, &msg) {
+		return
+	}
+	msg.IEEEAddress = deviceAddr
+
+	device, err := h.commands.SetDevice(r.Context(), msg)
+	if err != nil {
+		writeCommandError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, device)
+}
+
+// handleRemoveDevice asks the device to leave, `force=true` query parameter removes it also when it does not answer.
+func (h *httpRouter) handleRemoveDevice(w http.ResponseWriter, r *http.Request, deviceAddr uint64) {
+	force, _ := strconv.ParseBool(r.URL.Query().Get("force"))
+
+	err := h.commands.RemoveDevice(r.Context(), types.DeviceRemoveMessage{
+		IEEEAddress: deviceAddr,
+		Force:       force,
+	})
+	if err != nil {
+		writeCommandError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *httpRouter) handleGetClusterDefinition(w http.ResponseWriter, r *http.Request, clusterIDStr string) {
+	clusterID, err := strconv.ParseUint(clusterIDStr, 0, 16)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid cluster ID '%v'", clusterIDStr))
+		return
+	}
+
+	def := h.zclDefService.GetById(uint16(clusterID))
+	if def.Name == "" {
+		writeError(w, http.StatusNotFound, fmt.Errorf("cluster %v is not defined", clusterID))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, def)
+}
+
+func (h *httpRouter) handleDeviceSetCommand(w http.ResponseWriter, r *http.Request, deviceAddr uint64) {
+	var msg mqtt.DeviceSetMessage
+	if !readJSON(w, r, &msg) {
+		return
+	}
+
+	h.sendAndWait(w, r, func(ctx context.Context) (interface{}, error) {
+		return h.commands.Set(ctx, deviceAddr, msg)
+	})
+}
+
+func (h *httpRouter) handleDeviceGetCommand(w http.ResponseWriter, r *http.Request, deviceAddr uint64) {
+	var msg mqtt.DeviceGetMessage
+	if !readJSON(w, r, &msg) {
+		return
+	}
+
+	h.sendAndWait(w, r, func(ctx context.Context) (interface{}, error) {
+		return h.commands.Get(ctx, deviceAddr, msg)
+	})
+}
+
+func (h *httpRouter) handleDeviceWriteCommand(w http.ResponseWriter, r *http.Request, deviceAddr uint64) {
+	var msg mqtt.DeviceWriteMessage
+	if !readJSON(w, r, &msg) {
+		return
+	}
+
+	h.sendAndWait(w, r, func(ctx context.Context) (interface{}, error) {
+		return h.commands.Write(ctx, deviceAddr, msg)
+	})
+}
+
+// sendAndWait runs the device command limited by `timeout` query parameter and writes the answer of the device,
+// the timeout is capped at httpMaxResponseTimeout.
+func (h *httpRouter) sendAndWait(w http.ResponseWriter, r *http.Request, send func(ctx context.Context) (interface{}, error)) {
+	timeout := httpResponseTimeout
+	if v := r.URL.Query().Get("timeout"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid timeout: %v", err))
+			return
+		}
+		if d <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid timeout: must be positive"))
+			return
+		}
+		if d > httpMaxResponseTimeout {
+			d = httpMaxResponseTimeout
+		}
+		timeout = d
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	msg, err := send(ctx)
+	if err != nil {
+		writeCommandError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, msg)
+}
+
+func (h *httpRouter) handleDeviceHistoryQuery(w http.ResponseWriter, r *http.Request, deviceAddr uint64) {
+	query := r.URL.Query()
+
+	clusterID, err := strconv.ParseUint(query.Get("cluster_id"), 0, 16)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid cluster_id: %v", err))
+		return
+	}
+
+	msg := mqtt.DeviceHistoryQueryMessage{
+		ClusterID: uint16(clusterID),
+		Attribute: query.Get("attribute"),
+	}
+
+	if v := query.Get("to"); v != "" {
+		if msg.To, err = time.Parse(time.RFC3339, v); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid to: %v", err))
+			return
+		}
+	}
+	if v := query.Get("from"); v != "" {
+		if msg.From, err = time.Parse(time.RFC3339, v); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid from: %v", err))
+			return
+		}
+	}
+	if v := query.Get("bucket_seconds"); v != "" {
+		if msg.BucketSeconds, err = strconv.Atoi(v); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid bucket_seconds: %v", err))
+			return
+		}
+	}
+
+	result, err := h.commands.History(r.Context(), deviceAddr, msg)
+	if err != nil {
+		writeCommandError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (h *httpRouter) handleGetConfig(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.commands.Config())
+}
+
+func (h *httpRouter) handleSetConfig(w http.ResponseWriter, r *http.Request) {
+	var patch json.RawMessage
+	if !readJSON(w, r, &patch) {
+		return
+	}
+
+	result, err := h.commands.SetConfig(r.Context(), patch)
+	if err != nil {
+		writeCommandError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// handlePermitJoin changes only PermitJoin, other configuration is changed by PATCH on HTTP_CONFIG.
+func (h *httpRouter) handlePermitJoin(w http.ResponseWriter, r *http.Request) {
+	var msg struct {
+		PermitJoin bool
+	}
+	if !readJSON(w, r, &msg) {
+		return
+	}
+
+	patch, err := json.Marshal(msg)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	result, err := h.commands.SetConfig(r.Context(), patch)
+	if err != nil {
+		writeCommandError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	err := json.NewDecoder(r.Body).Decode(v)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %v", err))
+		return false
+	}
+
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// writeCommandError maps errors of CommandService to HTTP statuses.
+func writeCommandError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrDeviceNotFound), errors.Is(err, ErrHistoryDisabled):
+		writeError(w, http.StatusNotFound, err)
+	case errors.Is(err, ErrNoResponse):
+		writeError(w, http.StatusGatewayTimeout, err)
+	case errors.Is(err, ErrDeviceNotRemoved), errors.Is(err, ErrDeviceUnreachable):
+		writeError(w, http.StatusBadGateway, err)
+	case errors.Is(err, ErrCoordinatorUnavailable):
+		writeError(w, http.StatusServiceUnavailable, err)
+	case errors.Is(err, ErrInvalidConfig), errors.Is(err, ErrInvalidCommand):
+		writeError(w, http.StatusBadRequest, err)
+	default:
+		writeError(w, http.StatusInternalServerError, err)
+	}
+}
@@ -0,0 +1,420 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shimmeringbee/zigbee"
+	"github.com/stretchr/testify/assert"
+	"github.com/supby/gigbee2mqtt/internal/configuration"
+	"github.com/supby/gigbee2mqtt/internal/db"
+	"github.com/supby/gigbee2mqtt/internal/mqtt"
+	"github.com/supby/gigbee2mqtt/internal/types"
+	"github.com/supby/gigbee2mqtt/internal/zcldef"
+)
+
+// testZigbeeRouter records commands, onCommand plays the device answering them, err fails sending them.
+type testZigbeeRouter struct {
+	onCommand func(cmd interface{})
+	commands  []interface{}
+	err       error
+}
+
+func (z *testZigbeeRouter) command(cmd interface{}) error {
+	z.commands = append(z.commands, cmd)
+	if z.err != nil {
+		return z.err
+	}
+	if z.onCommand != nil {
+		z.onCommand(cmd)
+	}
+	return nil
+}
+
+func (z *testZigbeeRouter) SubscribeOnDeviceMessage(callback func(devMsg mqtt.DeviceMessage)) {}
+func (z *testZigbeeRouter) SubscribeOnDeviceDescription(callback func(devMsg mqtt.DeviceDescriptionMessage)) {
+}
+func (z *testZigbeeRouter) SubscribeOnDeviceJoin(cb func(e zigbee.NodeJoinEvent))     {}
+func (z *testZigbeeRouter) SubscribeOnDeviceLeave(cb func(e zigbee.NodeLeaveEvent))   {}
+func (z *testZigbeeRouter) SubscribeOnDeviceUpdate(cb func(e zigbee.NodeUpdateEvent)) {}
+func (z *testZigbeeRouter) SubscribeOnCoordinatorEvent(cb func(event string, msg mqtt.CoordinatorEventMessage)) {
+}
+func (z *testZigbeeRouter) SubscribeOnFatalError(cb func(err error)) {}
+func (z *testZigbeeRouter) ProccessMessageToDevice(ctx context.Context, devCmd types.DeviceCommandMessage) error {
+	return z.command(devCmd)
+}
+func (z *testZigbeeRouter) ProccessGetMessageToDevice(ctx context.Context, devCmd types.DeviceGetMessage) error {
+	return z.command(devCmd)
+}
+func (z *testZigbeeRouter) ProccessWriteMessageToDevice(ctx context.Context, devCmd types.DeviceWriteMessage) error {
+	return z.command(devCmd)
+}
+func (z *testZigbeeRouter) ProccessConfigureReportingMessage(ctx context.Context, devCmd types.DeviceConfigureReportingMessage) error {
+	return z.command(devCmd)
+}
+func (z *testZigbeeRouter) ProccessSetDeviceConfigMessage(ctx context.Context, devCmd types.DeviceConfigSetMessage) error {
+	return z.command(devCmd)
+}
+func (z *testZigbeeRouter) ProccessGetDeviceDescriptionMessage(ctx context.Context, devCmd types.DeviceExploreMessage) error {
+	return z.command(devCmd)
+}
+func (z *testZigbeeRouter) ProccessRemoveMessage(ctx context.Context, devCmd types.DeviceRemoveMessage) error {
+	return z.command(devCmd)
+}
+func (z *testZigbeeRouter) Nodes() []zigbee.Node                 { return nil }
+func (z *testZigbeeRouter) AdapterNode() zigbee.Node             { return zigbee.Node{} }
+func (z *testZigbeeRouter) Heartbeat() time.Time                 { return time.Now() }
+func (z *testZigbeeRouter) StartAsync(ctx context.Context) error { return nil }
+func (z *testZigbeeRouter) Drain(ctx context.Context) error      { return nil }
+func (z *testZigbeeRouter) Stop()                                {}
+
+// testHTTPRouter serves requests and receives device messages the same way as in main.
+type testHTTPRouter struct {
+	HTTPRouter
+	CommandService
+}
+
+func newTestHTTPRouter(t *testing.T) (testHTTPRouter, *testZigbeeRouter, *testConfigurationService) {
+	deviceDB, err := db.NewDeviceDB(t.TempDir(), db.DeviceDBOptions{
+		FlushPeriodInSeconds: 60,
+	})
+	assert.NoError(t, err)
+	t.Cleanup(func() { deviceDB.Close(context.Background()) })
+
+	assert.NoError(t, deviceDB.SaveDevice(context.Background(), db.Device{IEEEAddress: testDevice, ModelID: "bulb"}))
+	assert.NoError(t, deviceDB.SaveDevice(context.Background(), db.Device{IEEEAddress: testDevice + 1, ModelID: "sensor"}))
+
+	zRouter := &testZigbeeRouter{}
+	cs := &testConfigurationService{configuration: configuration.Default()}
+	cs.configuration.SerialConfiguration.PortName = "/dev/ttyUSB0"
+	cs.configuration.MqttConfiguration.Address = "localhost"
+
+	commands := NewCommandService(cs, zRouter, deviceDB, nil)
+	commands.SubscribeOnConfigChange(func(result configuration.ReloadResult) {
+		if result.IsApplied(configuration.SectionPermitJoin) {
+			zRouter.ProccessSetDeviceConfigMessage(context.Background(), types.DeviceConfigSetMessage{PermitJoin: result.Current.PermitJoin})
+		}
+	})
+
+	return testHTTPRouter{
+		HTTPRouter:     NewHTTPRouter(cs, commands, zcldef.New("../zcldef/zcldef.json")),
+		CommandService: commands,
+	}, zRouter, cs
+}
+
+func doRequest(h http.Handler, method string, url string, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, url, strings.NewReader(body))
+	if body != "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestHTTPRouterGetDevices(t *testing.T) {
+	h, _, _ := newTestHTTPRouter(t)
+
+	w := doRequest(h, http.MethodGet, "/api/devices", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var devices []db.Device
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &devices))
+	assert.Equal(t, 2, len(devices))
+
+	w = doRequest(h, http.MethodGet, "/api/devices?model_id=sensor", "")
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &devices))
+	assert.Equal(t, 1, len(devices))
+	assert.Equal(t, testDevice+1, devices[0].IEEEAddress)
+
+	w = doRequest(h, http.MethodGet, "/api/devices?online=maybe", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestHTTPRouterDevice(t *testing.T) {
+	h, _, _ := newTestHTTPRouter(t)
+
+	w := doRequest(h, http.MethodGet, fmt.Sprintf("/api/devices/0x%x", testDevice), "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = doRequest(h, http.MethodPatch, fmt.Sprintf("/api/devices/0x%x", testDevice), `{"FriendlyName": "kitchen"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var device db.Device
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &device))
+	assert.Equal(t, "kitchen", device.FriendlyName)
+	assert.Equal(t, "bulb", device.ModelID)
+
+	w = doRequest(h, http.MethodGet, "/api/devices/0x1", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = doRequest(h, http.MethodPut, fmt.Sprintf("/api/devices/0x%x", testDevice), "")
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+func TestHTTPRouterRemoveDevice(t *testing.T) {
+	h, zRouter, _ := newTestHTTPRouter(t)
+
+	w := doRequest(h, http.MethodDelete, fmt.Sprintf("/api/devices/0x%x", testDevice), "")
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+
+	zRouter.onCommand = func(cmd interface{}) {
+		h.CommandService.(*commandService).db.DeleteDevice(context.Background(), cmd.(types.DeviceRemoveMessage).IEEEAddress)
+	}
+
+	w = doRequest(h, http.MethodDelete, fmt.Sprintf("/api/devices/0x%x?force=true", testDevice), "")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, types.DeviceRemoveMessage{IEEEAddress: testDevice, Force: true}, zRouter.commands[1])
+}
+
+func TestHTTPRouterClusterDefinition(t *testing.T) {
+	h, _, _ := newTestHTTPRouter(t)
+
+	w := doRequest(h, http.MethodGet, "/api/zcl/6", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var def zcldef.ClusterDefinition
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &def))
+	assert.Equal(t, "genOnOff", def.Name)
+	assert.Equal(t, "onOff", def.Attributes[0].Name)
+
+	w = doRequest(h, http.MethodGet, "/api/zcl/65000", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestHTTPRouterSetCommand(t *testing.T) {
+	h, zRouter, _ := newTestHTTPRouter(t)
+
+	zRouter.onCommand = func(cmd interface{}) {
+		// a report of the cluster does not answer the command
+		h.PublishDeviceMessage(testDevice, mqtt.DeviceMessage{
+			IEEEAddress: testDevice,
+			Message:     mqtt.DeviceAttributesReportMessage{ClusterID: 6},
+		}, "")
+		go h.PublishDeviceMessage(testDevice, mqtt.DeviceMessage{
+			IEEEAddress:         testDevice,
+			TransactionSequence: cmd.(types.DeviceCommandMessage).TransactionSequence,
+			Message:             mqtt.DeviceDefaultResponseMessage{ClusterID: 6, CommandIdentifier: 1},
+		}, "")
+	}
+
+	w := doRequest(h, http.MethodPost, fmt.Sprintf("/api/devices/0x%x/set", testDevice),
+		`{"ClusterID": 6, "Endpoint": 1, "CommandIdentifier": 1, "CommandData": {}}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response struct {
+		IEEEAddress uint64
+		Message     mqtt.DeviceDefaultResponseMessage
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, testDevice, response.IEEEAddress)
+	assert.Equal(t, uint16(6), response.Message.ClusterID)
+
+	cmd := zRouter.commands[0].(types.DeviceCommandMessage)
+	assert.NotZero(t, cmd.TransactionSequence)
+	cmd.TransactionSequence = 0
+	assert.Equal(t, types.DeviceCommandMessage{
+		IEEEAddress:       testDevice,
+		ClusterID:         6,
+		Endpoint:          1,
+		CommandIdentifier: 1,
+		CommandData:       map[string]interface{}{},
+	}, cmd)
+}
+
+func TestHTTPRouterCommandTimeout(t *testing.T) {
+	h, _, _ := newTestHTTPRouter(t)
+
+	w := doRequest(h, http.MethodPost, fmt.Sprintf("/api/devices/0x%x/get?timeout=50ms", testDevice),
+		`{"ClusterID": 6, "Endpoint": 1, "Attributes": [0]}`)
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+
+	for _, timeout := range []string{"0s", "-1s"} {
+		w = doRequest(h, http.MethodPost, fmt.Sprintf("/api/devices/0x%x/get?timeout=%v", testDevice, timeout),
+			`{"ClusterID": 6, "Endpoint": 1, "Attributes": [0]}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code, timeout)
+	}
+}
+
+func TestHTTPRouterCommandSendError(t *testing.T) {
+	h, zRouter, _ := newTestHTTPRouter(t)
+
+	for err, status := range map[error]int{
+		fmt.Errorf("0x%x: %w", testDevice, ErrDeviceNotFound):        http.StatusNotFound,
+		ErrCoordinatorUnavailable:                                    http.StatusServiceUnavailable,
+		fmt.Errorf("%w: unknown attribute 65000", ErrInvalidCommand): http.StatusBadRequest,
+		fmt.Errorf("%w: no route to device", ErrDeviceUnreachable):   http.StatusBadGateway,
+	} {
+		zRouter.err = err
+
+		// the cause is returned right away, not after the timeout
+		start := time.Now()
+		w := doRequest(h, http.MethodPost, fmt.Sprintf("/api/devices/0x%x/get?timeout=1m", testDevice),
+			`{"ClusterID": 6, "Endpoint": 1, "Attributes": [0]}`)
+		assert.Equal(t, status, w.Code, err.Error())
+		assert.Contains(t, w.Body.String(), err.Error())
+		assert.Less(t, time.Since(start), time.Second)
+	}
+}
+
+func TestHTTPRouterRejectsCrossSiteRequests(t *testing.T) {
+	h, zRouter, cs := newTestHTTPRouter(t)
+	cs.configuration.HTTP.AllowedOrigins = []string{"https://allowed.example.com"}
+	url := fmt.Sprintf("/api/devices/0x%x/set?timeout=50ms", testDevice)
+	body := `{"ClusterID": 6, "Endpoint": 1, "CommandIdentifier": 1}`
+
+	// form post, sent by browsers without preflight
+	r := httptest.NewRequest(http.MethodPost, url, strings.NewReader(body))
+	r.Header.Set("Content-Type", "text/plain")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	for origin, status := range map[string]int{
+		"https://evil.example.com":    http.StatusForbidden,
+		"https://allowed.example.com": http.StatusGatewayTimeout,
+		"http://example.com":          http.StatusGatewayTimeout, // same host as httptest request
+	} {
+		r = httptest.NewRequest(http.MethodPost, url, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("Origin", origin)
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		assert.Equal(t, status, w.Code, origin)
+	}
+
+	assert.Equal(t, 2, len(zRouter.commands))
+}
+
+func TestHTTPRouterState(t *testing.T) {
+	h, _, _ := newTestHTTPRouter(t)
+
+	h.PublishDeviceMessage(testDevice, mqtt.DeviceMessage{
+		IEEEAddress: testDevice,
+		Message: mqtt.DeviceAttributesReportMessage{
+			ClusterID:         8,
+			ClusterAttributes: map[string]interface{}{"currentLevel": 10},
+		},
+	}, "")
+	h.PublishDeviceMessage(testDevice, mqtt.DeviceMessage{
+		IEEEAddress: testDevice,
+		Message: mqtt.DeviceAttributesReportMessage{
+			ClusterID:         6,
+			ClusterAttributes: map[string]interface{}{"onOff": true},
+		},
+	}, "")
+	h.PublishDeviceMessage(testDevice, mqtt.DeviceMessage{
+		IEEEAddress: testDevice,
+		Message: mqtt.DeviceAttributesReportMessage{
+			ClusterID:         8,
+			ClusterAttributes: map[string]interface{}{"onLevel": 100},
+		},
+	}, "")
+
+	w := doRequest(h, http.MethodGet, fmt.Sprintf("/api/devices/0x%x/state", testDevice), "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var state []mqtt.DeviceAttributesReportMessage
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &state))
+	assert.Equal(t, 2, len(state))
+	assert.Equal(t, uint16(6), state[0].ClusterID)
+	assert.Equal(t, map[string]interface{}{"currentLevel": float64(10), "onLevel": float64(100)}, state[1].ClusterAttributes)
+}
+
+func TestHTTPRouterPermitJoin(t *testing.T) {
+	h, zRouter, cs := newTestHTTPRouter(t)
+	cs.configuration.PermitJoin = false
+
+	w := doRequest(h, http.MethodPost, "/api/permit_join", `{"PermitJoin": true}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, cs.configuration.PermitJoin)
+	assert.Equal(t, types.DeviceConfigSetMessage{PermitJoin: true}, zRouter.commands[0])
+
+	w = doRequest(h, http.MethodGet, "/api/config", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var cfg configuration.Configuration
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &cfg))
+	assert.True(t, cfg.PermitJoin)
+
+	// only PermitJoin is changed
+	w = doRequest(h, http.MethodPost, "/api/permit_join", `{"PermitJoin": false, "LogLevel": 0, "MqttConfiguration": {"Address": "evil"}}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.False(t, cs.configuration.PermitJoin)
+	assert.Equal(t, "localhost", cs.configuration.MqttConfiguration.Address)
+	assert.Equal(t, configuration.Default().LogLevel, cs.configuration.LogLevel)
+}
+
+func TestHTTPRouterConfigHasNoSecrets(t *testing.T) {
+	h, _, cs := newTestHTTPRouter(t)
+	cs.configuration.MqttConfiguration.Password = "mqtt-secret"
+	cs.configuration.ZNetworkConfiguration.NetworkKey = [16]byte{0xaa, 0xbb}
+
+	w := doRequest(h, http.MethodGet, "/api/config", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "mqtt-secret")
+
+	var cfg configuration.Configuration
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &cfg))
+	assert.Equal(t, [16]byte{}, cfg.ZNetworkConfiguration.NetworkKey)
+
+	w = doRequest(h, http.MethodPost, "/api/permit_join", `{"PermitJoin": true}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "mqtt-secret")
+
+	var result mqtt.SetGatewayConfigResult
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	assert.Equal(t, [16]byte{}, result.Config.ZNetworkConfiguration.NetworkKey)
+	assert.Equal(t, "mqtt-secret", cs.configuration.MqttConfiguration.Password)
+}
+
+func TestHTTPRouterSetConfig(t *testing.T) {
+	h, _, cs := newTestHTTPRouter(t)
+
+	w := doRequest(h, http.MethodPatch, "/api/config", `{
+		"LogLevel": 0,
+		"LogLevels": {"Zigbee Router": 3},
+		"Availability": {"ActiveTimeoutInSeconds": 300},
+		"Reporting": {"MaxIntervalInSeconds": 600},
+		"MqttConfiguration": {"Payload": {"Timestamp": true}, "OfflineQueue": {"MaxSize": 5}},
+		"SerialConfiguration": {"PortName": "/dev/ttyACM0"}
+	}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var result mqtt.SetGatewayConfigResult
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	assert.Equal(t, []string{
+		"MqttConfiguration.Payload.Timestamp",
+		"Availability.ActiveTimeoutInSeconds",
+		"Reporting.MaxIntervalInSeconds",
+		"LogLevel",
+		"LogLevels",
+	}, result.Applied)
+	assert.Equal(t, []string{"MqttConfiguration.OfflineQueue.MaxSize", "SerialConfiguration.PortName"}, result.Restart)
+
+	assert.Equal(t, 300, cs.configuration.Availability.ActiveTimeoutInSeconds)
+	assert.Equal(t, 600, cs.configuration.Reporting.MaxIntervalInSeconds)
+	assert.True(t, cs.configuration.MqttConfiguration.Payload.Timestamp)
+	assert.Equal(t, map[string]int{"Zigbee Router": 3}, cs.configuration.LogLevels)
+	assert.Equal(t, "/dev/ttyUSB0", cs.configuration.SerialConfiguration.PortName, "serial port is changed only after restart")
+	assert.Equal(t, 1000, cs.configuration.MqttConfiguration.OfflineQueue.MaxSize, "offline queue is changed only after restart")
+
+	for _, patch := range []string{
+		`{"MqttConfiguration": {"QoS": {"Events": {"QoS": 3}}}}`,
+		`{"Reporting": {"MinIntervalInSeconds": 900}}`,
+		`{"ZNetworkConfiguration": {"Channel": 20}}`,
+		`{"Unknown": true}`,
+	} {
+		w = doRequest(h, http.MethodPatch, "/api/config", patch)
+		assert.Equal(t, http.StatusBadRequest, w.Code, patch)
+	}
+	assert.Equal(t, byte(1), cs.configuration.MqttConfiguration.QoS.Events.QoS)
+	assert.Equal(t, 1, cs.configuration.Reporting.MinIntervalInSeconds)
+}
@@ -0,0 +1,64 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// inFlight counts commands and events being processed, so shutdown can wait for them.
+type inFlight struct {
+	mtx   sync.Mutex
+	count int
+	idle  chan struct{} // closed when count drops to 0
+}
+
+func (f *inFlight) Start() {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if f.count == 0 {
+		f.idle = make(chan struct{})
+	}
+	f.count++
+}
+
+func (f *inFlight) Done() {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	f.count--
+	if f.count == 0 {
+		close(f.idle)
+	}
+}
+
+// Go runs process in its own goroutine counted as in flight.
+func (f *inFlight) Go(process func()) {
+	f.Start()
+	go func() {
+		defer f.Done()
+		process()
+	}()
+}
+
+// Wait returns once nothing is in flight, or error when ctx is done first.
+func (f *inFlight) Wait(ctx context.Context) error {
+	f.mtx.Lock()
+	if f.count == 0 {
+		f.mtx.Unlock()
+		return nil
+	}
+	idle := f.idle
+	f.mtx.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		f.mtx.Lock()
+		defer f.mtx.Unlock()
+
+		return fmt.Errorf("%v commands and events still in flight: %w", f.count, ctx.Err())
+	}
+}
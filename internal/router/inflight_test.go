@@ -0,0 +1,31 @@
+package router
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInFlightWait(t *testing.T) {
+	var f inFlight
+	assert.NoError(t, f.Wait(context.Background()))
+
+	release := make(chan struct{})
+	f.Go(func() { <-release })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := f.Wait(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Contains(t, err.Error(), "1 commands and events")
+
+	close(release)
+	assert.NoError(t, f.Wait(context.Background()))
+
+	// idle again after being drained
+	f.Start()
+	f.Done()
+	assert.NoError(t, f.Wait(context.Background()))
+}
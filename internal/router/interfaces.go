@@ -2,19 +2,69 @@ package router
 
 import (
 	"context"
+	"net/http"
+	"time"
 
 	"github.com/shimmeringbee/zigbee"
+	"github.com/supby/gigbee2mqtt/internal/configuration"
+	"github.com/supby/gigbee2mqtt/internal/db"
 	"github.com/supby/gigbee2mqtt/internal/mqtt"
 	"github.com/supby/gigbee2mqtt/internal/types"
 )
 
-type MQTTRouter interface {
+// DevicePublisher receives messages and events of devices coming from ZigbeeRouter.
+type DevicePublisher interface {
 	PublishDeviceMessage(ieeeAddress uint64, msg interface{}, subtopic string)
+}
+
+type MQTTRouter interface {
+	DevicePublisher
 
 	SubscribeOnSetMessage(callback func(devCmd types.DeviceCommandMessage))
 	SubscribeOnGetMessage(callback func(devCmd types.DeviceGetMessage))
+	SubscribeOnWriteMessage(callback func(devCmd types.DeviceWriteMessage))
+	SubscribeOnConfigureReportingMessage(callback func(devCmd types.DeviceConfigureReportingMessage))
 	SubscribeOnExploreMessage(callback func(devCmd types.DeviceExploreMessage))
-	SubscribeOnSetDeviceConfigMessage(callback func(devCmd types.DeviceConfigSetMessage))
+	SubscribeOnRemoveMessage(callback func(devCmd types.DeviceRemoveMessage))
+	// SubscribeOnConfigChange is called after set_config changed the configuration, to apply it to the gateway.
+	SubscribeOnConfigChange(callback func(result configuration.ReloadResult))
+	// PublishGatewayEvent publishes msg in gateway/<event>, e.g. COORDINATOR_LOST.
+	PublishGatewayEvent(event string, msg interface{})
+	// PendingResponses returns keys of MQTT 5 requests waiting for the device to answer.
+	PendingResponses() []string
+}
+
+// CommandService runs gateway commands and waits for the answer of the device, the caller limits waiting with ctx.
+type CommandService interface {
+	DevicePublisher
+
+	Devices(ctx context.Context, filter mqtt.DevicesFilter) ([]db.Device, error)
+	Device(ctx context.Context, deviceAddr uint64) (db.Device, error)
+	SetDevice(ctx context.Context, msg mqtt.SetDeviceMessage) (db.Device, error)
+	RemoveDevice(ctx context.Context, msg types.DeviceRemoveMessage) error
+	Set(ctx context.Context, deviceAddr uint64, msg mqtt.DeviceSetMessage) (interface{}, error)
+	Get(ctx context.Context, deviceAddr uint64, msg mqtt.DeviceGetMessage) (interface{}, error)
+	Write(ctx context.Context, deviceAddr uint64, msg mqtt.DeviceWriteMessage) (interface{}, error)
+	Explore(ctx context.Context, deviceAddr uint64) (interface{}, error)
+	State(deviceAddr uint64) []mqtt.DeviceAttributesReportMessage
+	History(ctx context.Context, deviceAddr uint64, query mqtt.DeviceHistoryQueryMessage) (mqtt.DeviceHistoryMessage, error)
+	// Config returns the running configuration with secrets redacted.
+	Config() configuration.Configuration
+	// SetConfig changes fields present in JSON object patch, see mqtt.SetGatewayConfig.
+	SetConfig(ctx context.Context, patch []byte) (mqtt.SetGatewayConfigResult, error)
+	SubscribeOnConfigChange(callback func(result configuration.ReloadResult))
+	// PendingRequests returns keys of commands waiting for the device to answer.
+	PendingRequests() []string
+}
+
+type HTTPRouter interface {
+	http.Handler
+}
+
+type EventStream interface {
+	DevicePublisher
+	http.Handler
+	PublishGatewayStatus(status string)
 }
 
 type ZigbeeRouter interface {
@@ -23,10 +73,28 @@ type ZigbeeRouter interface {
 	SubscribeOnDeviceJoin(cb func(e zigbee.NodeJoinEvent))
 	SubscribeOnDeviceLeave(cb func(e zigbee.NodeLeaveEvent))
 	SubscribeOnDeviceUpdate(cb func(e zigbee.NodeUpdateEvent))
-	ProccessMessageToDevice(ctx context.Context, devCmd types.DeviceCommandMessage)
-	ProccessGetMessageToDevice(ctx context.Context, devCmd types.DeviceGetMessage)
-	ProccessSetDeviceConfigMessage(ctx context.Context, devCmd types.DeviceConfigSetMessage)
-	ProccessGetDeviceDescriptionMessage(ctx context.Context, devCmd types.DeviceExploreMessage)
-	StartAsync(ctx context.Context)
+	// SubscribeOnCoordinatorEvent is called with COORDINATOR_LOST and COORDINATOR_RECOVERED.
+	SubscribeOnCoordinatorEvent(cb func(event string, msg mqtt.CoordinatorEventMessage))
+	// SubscribeOnFatalError is called when the lost coordinator can not be reopened and the gateway can not go on.
+	SubscribeOnFatalError(cb func(err error))
+	ProccessMessageToDevice(ctx context.Context, devCmd types.DeviceCommandMessage) error
+	ProccessGetMessageToDevice(ctx context.Context, devCmd types.DeviceGetMessage) error
+	ProccessWriteMessageToDevice(ctx context.Context, devCmd types.DeviceWriteMessage) error
+	ProccessConfigureReportingMessage(ctx context.Context, devCmd types.DeviceConfigureReportingMessage) error
+	ProccessSetDeviceConfigMessage(ctx context.Context, devCmd types.DeviceConfigSetMessage) error
+	ProccessGetDeviceDescriptionMessage(ctx context.Context, devCmd types.DeviceExploreMessage) error
+	ProccessRemoveMessage(ctx context.Context, devCmd types.DeviceRemoveMessage) error
+	// Nodes returns the node table of the coordinator, empty until it is started.
+	Nodes() []zigbee.Node
+	// AdapterNode returns the coordinator itself, zero until it is started.
+	AdapterNode() zigbee.Node
+	// Heartbeat returns time of the last event loop iteration, the loop iterates at least every second, also while
+	// a lost coordinator is being reopened.
+	Heartbeat() time.Time
+	// StartAsync initialises the coordinator and starts processing its events.
+	StartAsync(ctx context.Context) error
+	// Drain waits until commands and events being processed are done or ctx is done.
+	Drain(ctx context.Context) error
+	// Stop stops processing of events and closes the coordinator port.
 	Stop()
 }
@@ -4,57 +4,130 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"reflect"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/supby/gigbee2mqtt/internal/configuration"
 	"github.com/supby/gigbee2mqtt/internal/db"
+	"github.com/supby/gigbee2mqtt/internal/history"
 	"github.com/supby/gigbee2mqtt/internal/logger"
 	"github.com/supby/gigbee2mqtt/internal/mqtt"
 	"github.com/supby/gigbee2mqtt/internal/types"
 )
 
 const (
-	MQTT_DEVICE_SET     = "set"
-	MQTT_DEVICE_GET     = "get"
-	MQTT_DEVICE_EXPLORE = "explore"
-	MQTT_GET_DEVICES    = "get_devices"
-	MQTT_GET_CONFIG     = "get_config"
-	MQTT_SET_CONFIG     = "set_config"
-	MQTT_DEVICES        = "devices"
-	MQTT_CONFIG         = "config"
-	MQTT_GATEWAY        = "gateway"
+	MQTT_DEVICE_SET         = "set"
+	MQTT_DEVICE_GET         = "get"
+	MQTT_DEVICE_WRITE       = "write"
+	MQTT_DEVICE_REPORTING   = "configure_reporting"
+	MQTT_DEVICE_EXPLORE     = "explore"
+	MQTT_DEVICE_REMOVE      = "remove"
+	MQTT_DEVICE_DESCRIPTION = "description"
+	MQTT_DEVICE_GET_HISTORY = "get_history"
+	MQTT_DEVICE_HISTORY     = "history"
+	MQTT_GET_DEVICES        = "get_devices"
+	MQTT_GET_CONFIG         = "get_config"
+	MQTT_SET_CONFIG         = "set_config"
+	MQTT_SET_CONFIG_RESULT  = "set_config_result"
+	MQTT_SET_DEVICE         = "set_device"
+	MQTT_DEVICES            = "devices"
+	MQTT_DEVICES_FILTERED   = "devices_filtered"
+	MQTT_DEVICE             = "device"
+	MQTT_DEVICE_ADDED       = "device_added"
+	MQTT_DEVICE_REMOVED     = "device_removed"
+	MQTT_CONFIG             = "config"
+	MQTT_LOG                = "log"
+	MQTT_GATEWAY            = "gateway"
 )
 
+// devicesRefreshDelay coalesces device changes into one publish of the retained devices list.
+var devicesRefreshDelay = 5 * time.Second
+
 type mqttRouter struct {
-	mqttClient               mqtt.MqttClient
-	configurationService     configuration.ConfigurationService
-	onSetMessage             func(devCmd types.DeviceCommandMessage)
-	onGetMessage             func(devCmd types.DeviceGetMessage)
-	onExploreMessage         func(devCmd types.DeviceExploreMessage)
-	onSetDeviceConfigMessage func(devCmd types.DeviceConfigSetMessage)
-	db                       db.DeviceDB
-	logger                   logger.Logger
+	mqttClient           mqtt.MqttClient
+	configurationService configuration.ConfigurationService
+	callbacksMtx         sync.Mutex
+	callbacks            mqttRouterCallbacks
+	db                   db.DeviceDB
+	history              history.Store
+	logger               logger.Logger
+	responses            *responseTracker
+	refreshMtx           sync.Mutex
+	refreshPending       bool
+}
+
+// mqttRouterCallbacks are set while MQTT messages may already be received, so they are accessed under lock.
+type mqttRouterCallbacks struct {
+	onSetMessage       func(devCmd types.DeviceCommandMessage)
+	onGetMessage       func(devCmd types.DeviceGetMessage)
+	onWriteMessage     func(devCmd types.DeviceWriteMessage)
+	onReportingMessage func(devCmd types.DeviceConfigureReportingMessage)
+	onExploreMessage   func(devCmd types.DeviceExploreMessage)
+	onRemoveMessage    func(devCmd types.DeviceRemoveMessage)
+	onConfigChange     func(result configuration.ReloadResult)
 }
 
 func NewMQTTRouter(
 	configurationService configuration.ConfigurationService,
 	mqttClient mqtt.MqttClient,
-	db db.DeviceDB) MQTTRouter {
+	db db.DeviceDB,
+	historyStore history.Store) MQTTRouter {
 	ret := mqttRouter{
 		mqttClient:           mqttClient,
 		configurationService: configurationService,
 		db:                   db,
+		history:              historyStore,
 		logger:               logger.GetLogger("[MQTT Router]", configurationService.GetConfiguration().LogLevel),
+		responses:            newResponseTracker(),
 	}
 
-	mqttClient.Subscribe(ret.mqttMessage)
+	mqttClient.SubscribeWithProperties(ret.mqttMessage)
+	db.SubscribeOnChange(ret.onDeviceChange)
+
+	ret.publishRetainedDevicesList()
 
 	return &ret
 }
 
+// devicePayload is mqtt.DeviceMessage in the format set by MqttPayloadConfiguration.
+type devicePayload struct {
+	IEEEAddress         interface{}
+	LinkQuality         uint8
+	TransactionSequence uint8      `json:",omitempty"`
+	Timestamp           *time.Time `json:",omitempty"`
+	Message             interface{}
+}
+
+// formatPayload applies payload options to device messages, other messages are published as they are.
+func (h *mqttRouter) formatPayload(msg interface{}) interface{} {
+	m, ok := msg.(mqtt.DeviceMessage)
+	options := h.configurationService.GetConfiguration().MqttConfiguration.Payload
+	if !ok || options == (configuration.MqttPayloadConfiguration{}) {
+		return msg
+	}
+
+	ret := devicePayload{
+		IEEEAddress:         m.IEEEAddress,
+		LinkQuality:         m.LinkQuality,
+		TransactionSequence: m.TransactionSequence,
+		Message:             m.Message,
+	}
+	if options.HexAddress {
+		ret.IEEEAddress = fmt.Sprintf("0x%x", m.IEEEAddress)
+	}
+	if options.Timestamp {
+		now := time.Now()
+		ret.Timestamp = &now
+	}
+
+	return ret
+}
+
 func (h *mqttRouter) PublishDeviceMessage(ieeeAddress uint64, msg interface{}, subtopic string) {
-	jsonData, err := json.Marshal(msg)
+	jsonData, err := json.Marshal(h.formatPayload(msg))
 	if err != nil {
 		h.logger.Error("Error Marshal DeviceMessage: %v\n", err)
 		return
@@ -65,26 +138,138 @@ func (h *mqttRouter) PublishDeviceMessage(ieeeAddress uint64, msg interface{}, s
 		topic = fmt.Sprintf("%v/%v", topic, subtopic)
 	}
 
-	h.mqttClient.Publish(topic, jsonData)
+	props := mqtt.MessageProperties{
+		UserProperties: map[string]string{
+			"ieee_address": fmt.Sprintf("0x%x", ieeeAddress),
+		},
+	}
+	if subtopic != "" {
+		props.UserProperties["event"] = subtopic
+	}
+
+	if m, ok := msg.(mqtt.DeviceMessage); ok {
+		switch devMsg := m.Message.(type) {
+		case mqtt.DeviceAttributesReportMessage:
+			props.UserProperties["cluster"] = devMsg.ClusterName
+			props.UserProperties["cluster_id"] = strconv.Itoa(int(devMsg.ClusterID))
+		case mqtt.DeviceDefaultResponseMessage:
+			props.UserProperties["cluster_id"] = strconv.Itoa(int(devMsg.ClusterID))
+		case mqtt.DeviceWriteAttributesResponseMessage:
+			props.UserProperties["cluster_id"] = strconv.Itoa(int(devMsg.ClusterID))
+		case mqtt.DeviceConfigureReportingResponseMessage:
+			props.UserProperties["cluster_id"] = strconv.Itoa(int(devMsg.ClusterID))
+		}
+	}
+
+	h.mqttClient.PublishWithOptions(topic, jsonData, withPolicy(h.devicePublishPolicy(subtopic), props))
+
+	if responseKey := deviceResponseKey(ieeeAddress, msg); responseKey != "" {
+		for _, request := range h.responses.Take(responseKey) {
+			h.publishResponse(request, jsonData, props)
+		}
+	}
+}
+
+func (h *mqttRouter) PendingResponses() []string {
+	return h.responses.Keys()
+}
+
+func (h *mqttRouter) devicePublishPolicy(subtopic string) configuration.MqttPublishPolicy {
+	qos := h.configurationService.GetConfiguration().MqttConfiguration.QoS
+
+	switch subtopic {
+	case "":
+		return qos.DeviceReports
+	case MQTT_DEVICE_DESCRIPTION:
+		return qos.Descriptions
+	}
+
+	return qos.Events
+}
+
+func (h *mqttRouter) gatewayPublishPolicy() configuration.MqttPublishPolicy {
+	return h.configurationService.GetConfiguration().MqttConfiguration.QoS.GatewayResponses
+}
+
+func withPolicy(policy configuration.MqttPublishPolicy, props mqtt.MessageProperties) mqtt.PublishOptions {
+	return mqtt.PublishOptions{
+		QoS:        policy.QoS,
+		Retain:     policy.Retain,
+		Properties: props,
+	}
+}
+
+func (h *mqttRouter) publishResponse(request mqtt.MessageProperties, data []byte, props mqtt.MessageProperties) {
+	if request.ResponseTopic == "" {
+		return
+	}
+
+	props.CorrelationData = request.CorrelationData
+
+	// replies are addressed to a single requester, so never retained
+	opts := withPolicy(h.gatewayPublishPolicy(), props)
+	opts.Retain = false
+
+	h.mqttClient.PublishResponse(request.ResponseTopic, data, opts)
 }
 
 func (h *mqttRouter) SubscribeOnSetMessage(callback func(devCmd types.DeviceCommandMessage)) {
-	h.onSetMessage = callback
+	h.callbacksMtx.Lock()
+	defer h.callbacksMtx.Unlock()
+
+	h.callbacks.onSetMessage = callback
 }
 
 func (h *mqttRouter) SubscribeOnGetMessage(callback func(devCmd types.DeviceGetMessage)) {
-	h.onGetMessage = callback
+	h.callbacksMtx.Lock()
+	defer h.callbacksMtx.Unlock()
+
+	h.callbacks.onGetMessage = callback
+}
+
+func (h *mqttRouter) SubscribeOnWriteMessage(callback func(devCmd types.DeviceWriteMessage)) {
+	h.callbacksMtx.Lock()
+	defer h.callbacksMtx.Unlock()
+
+	h.callbacks.onWriteMessage = callback
+}
+
+func (h *mqttRouter) SubscribeOnConfigureReportingMessage(callback func(devCmd types.DeviceConfigureReportingMessage)) {
+	h.callbacksMtx.Lock()
+	defer h.callbacksMtx.Unlock()
+
+	h.callbacks.onReportingMessage = callback
 }
 
 func (h *mqttRouter) SubscribeOnExploreMessage(callback func(devCmd types.DeviceExploreMessage)) {
-	h.onExploreMessage = callback
+	h.callbacksMtx.Lock()
+	defer h.callbacksMtx.Unlock()
+
+	h.callbacks.onExploreMessage = callback
 }
 
-func (h *mqttRouter) SubscribeOnSetDeviceConfigMessage(callback func(devCmd types.DeviceConfigSetMessage)) {
-	h.onSetDeviceConfigMessage = callback
+func (h *mqttRouter) SubscribeOnRemoveMessage(callback func(devCmd types.DeviceRemoveMessage)) {
+	h.callbacksMtx.Lock()
+	defer h.callbacksMtx.Unlock()
+
+	h.callbacks.onRemoveMessage = callback
+}
+
+func (h *mqttRouter) SubscribeOnConfigChange(callback func(result configuration.ReloadResult)) {
+	h.callbacksMtx.Lock()
+	defer h.callbacksMtx.Unlock()
+
+	h.callbacks.onConfigChange = callback
+}
+
+func (h *mqttRouter) getCallbacks() mqttRouterCallbacks {
+	h.callbacksMtx.Lock()
+	defer h.callbacksMtx.Unlock()
+
+	return h.callbacks
 }
 
-func (h *mqttRouter) mqttMessage(topic string, message []byte) {
+func (h *mqttRouter) mqttMessage(topic string, message []byte, props mqtt.MessageProperties) {
 	topicParts := strings.Split(topic, "/")
 	if len(topicParts) < 3 {
 		h.logger.Warn("invalid topic \"%s\"", topic)
@@ -92,79 +277,238 @@ func (h *mqttRouter) mqttMessage(topic string, message []byte) {
 	}
 
 	if topicParts[1] == MQTT_GATEWAY {
-		h.handleGatewayMessage(topicParts[2], message)
+		h.handleGatewayMessage(topicParts[2], message, props)
 		return
 	}
 
-	h.handleDeviceMessage(topicParts[1], topicParts[2], message)
+	h.handleDeviceMessage(topicParts[1], topicParts[2], message, props)
 }
 
-func (h *mqttRouter) handleGatewayMessage(command string, message []byte) {
+func (h *mqttRouter) handleGatewayMessage(command string, message []byte, props mqtt.MessageProperties) {
 	if command == MQTT_GET_DEVICES {
 		h.logger.Info("list of connected devies is requested.\n")
-		h.publishDevicesList()
+		h.handleGetDevices(message, props)
 	}
 	if command == MQTT_GET_CONFIG {
 		h.logger.Info("gateway configuration is requested.\n")
-		h.publishConfig()
+		h.publishConfig(props)
 	}
 	if command == MQTT_SET_CONFIG {
 		h.logger.Info("setting gateway configuration.\n")
-		h.handleSetConfig(message)
+		h.handleSetConfig(message, props)
+	}
+	if command == MQTT_SET_DEVICE {
+		h.logger.Info("setting device fields.\n")
+		h.handleSetDevice(message, props)
 	}
 }
 
-func (h *mqttRouter) publishConfig() {
-	jsonData, err := json.Marshal(h.configurationService.GetConfiguration())
+func (h *mqttRouter) handleSetDevice(message []byte, request mqtt.MessageProperties) {
+	var mqttMsg mqtt.SetDeviceMessage
+	err := json.Unmarshal(message, &mqttMsg)
 	if err != nil {
-		h.logger.Error("Error Marshal Configuration: %v\n", err)
+		h.logger.Error("Error unmarshal set device message: %v\n", err)
+		return
+	}
+
+	device, err := h.db.UpdateDevice(context.Background(), mqttMsg.IEEEAddress, func(device *db.Device, exists bool) error {
+		if !exists {
+			return fmt.Errorf("device 0x%x does not exist", mqttMsg.IEEEAddress)
+		}
+
+		if mqttMsg.FriendlyName != nil {
+			device.FriendlyName = *mqttMsg.FriendlyName
+		}
+		if mqttMsg.Tags != nil {
+			device.Tags = *mqttMsg.Tags
+		}
+		if mqttMsg.Notes != nil {
+			device.Notes = *mqttMsg.Notes
+		}
+
+		return nil
+	})
+	if err != nil {
+		h.logger.Error("error updating device: %v\n", err)
 		return
 	}
 
-	h.mqttClient.Publish(fmt.Sprintf("%v/%v", MQTT_GATEWAY, MQTT_CONFIG), jsonData)
+	jsonData, err := json.Marshal(device)
+	if err != nil {
+		h.logger.Error("Error Marshal Device: %v\n", err)
+		return
+	}
+
+	h.mqttClient.PublishWithOptions(fmt.Sprintf("%v/%v", MQTT_GATEWAY, MQTT_DEVICE), jsonData, withPolicy(h.gatewayPublishPolicy(), mqtt.MessageProperties{}))
+	h.publishResponse(request, jsonData, mqtt.MessageProperties{})
 }
 
-func (h *mqttRouter) handleSetConfig(message []byte) {
-	var mqttMsg mqtt.SetGatewayConfig
-	err := json.Unmarshal(message, &mqttMsg)
+func (h *mqttRouter) publishConfig(request mqtt.MessageProperties) {
+	jsonData, err := json.Marshal(configuration.Redacted(h.configurationService.GetConfiguration()))
 	if err != nil {
-		h.logger.Error("Error unmarshal Config SET message: %v\n", err)
+		h.logger.Error("Error Marshal Configuration: %v\n", err)
 		return
 	}
 
-	cfg := h.configurationService.GetConfiguration()
-	cfg.PermitJoin = mqttMsg.PermitJoin
+	h.mqttClient.PublishWithOptions(fmt.Sprintf("%v/%v", MQTT_GATEWAY, MQTT_CONFIG), jsonData, withPolicy(h.gatewayPublishPolicy(), mqtt.MessageProperties{}))
+	h.publishResponse(request, jsonData, mqtt.MessageProperties{})
+}
+
+func (h *mqttRouter) handleSetConfig(message []byte, request mqtt.MessageProperties) {
+	var answer mqtt.SetGatewayConfigResult
 
-	err = h.configurationService.Update(cfg)
+	result, err := applyConfigPatch(h.configurationService, message)
 	if err != nil {
 		h.logger.Error("Applying new configuration error: %v\n", err)
+		answer.Error = err.Error()
+	} else {
+		if onConfigChange := h.getCallbacks().onConfigChange; onConfigChange != nil {
+			onConfigChange(result)
+		}
+
+		answer = setConfigResult(result, h.configurationService.GetConfiguration())
+	}
+
+	jsonData, err := json.Marshal(answer)
+	if err != nil {
+		h.logger.Error("Error Marshal set config result: %v\n", err)
 		return
 	}
 
-	if h.onSetDeviceConfigMessage != nil {
-		h.onSetDeviceConfigMessage(types.DeviceConfigSetMessage{
-			PermitJoin: mqttMsg.PermitJoin,
-		})
+	h.mqttClient.PublishWithOptions(fmt.Sprintf("%v/%v", MQTT_GATEWAY, MQTT_SET_CONFIG_RESULT), jsonData, withPolicy(h.gatewayPublishPolicy(), mqtt.MessageProperties{}))
+	h.publishResponse(request, jsonData, mqtt.MessageProperties{})
+
+	if answer.Config != nil {
+		h.publishConfig(mqtt.MessageProperties{})
 	}
 }
 
-func (h *mqttRouter) publishDevicesList() {
+func (h *mqttRouter) handleGetDevices(message []byte, request mqtt.MessageProperties) {
+	var filter mqtt.DevicesFilter
+	if len(strings.TrimSpace(string(message))) > 0 {
+		err := json.Unmarshal(message, &filter)
+		if err != nil {
+			h.logger.Error("Error unmarshal get devices message: %v\n", err)
+			return
+		}
+	}
+
 	dbDevices, err := h.db.GetDevices(context.Background())
 	if err != nil {
 		h.logger.Error("error getting devices from db: %v\n", err)
 		return
 	}
 
+	topic := fmt.Sprintf("%v/%v", MQTT_GATEWAY, MQTT_DEVICES)
+	opts := withPolicy(h.gatewayPublishPolicy(), mqtt.MessageProperties{})
+	if reflect.DeepEqual(filter, mqtt.DevicesFilter{}) {
+		opts.Retain = true
+	} else {
+		// filtered list must not replace retained list of all devices
+		topic = fmt.Sprintf("%v/%v", MQTT_GATEWAY, MQTT_DEVICES_FILTERED)
+		dbDevices = filterDevices(dbDevices, filter, h.configurationService.GetConfiguration().Availability, time.Now())
+	}
+
 	jsonData, err := json.Marshal(dbDevices)
 	if err != nil {
 		h.logger.Error("Error Marshal Devices list: %v\n", err)
 		return
 	}
 
-	h.mqttClient.Publish(fmt.Sprintf("%v/%v", MQTT_GATEWAY, MQTT_DEVICES), jsonData)
+	h.mqttClient.PublishWithOptions(topic, jsonData, opts)
+	h.publishResponse(request, jsonData, mqtt.MessageProperties{})
 }
 
-func (h *mqttRouter) handleDeviceMessage(deviceAddrStr string, command string, message []byte) {
+// publishRetainedDevicesList publishes list of all devices as retained message,
+// so new subscribers get the current inventory without asking for it.
+func (h *mqttRouter) publishRetainedDevicesList() {
+	dbDevices, err := h.db.GetDevices(context.Background())
+	if err != nil {
+		h.logger.Error("error getting devices from db: %v\n", err)
+		return
+	}
+
+	if dbDevices == nil {
+		dbDevices = make([]db.Device, 0)
+	}
+
+	jsonData, err := json.Marshal(dbDevices)
+	if err != nil {
+		h.logger.Error("Error Marshal Devices list: %v\n", err)
+		return
+	}
+
+	opts := withPolicy(h.gatewayPublishPolicy(), mqtt.MessageProperties{})
+	opts.Retain = true
+
+	h.mqttClient.PublishWithOptions(fmt.Sprintf("%v/%v", MQTT_GATEWAY, MQTT_DEVICES), jsonData, opts)
+}
+
+func (h *mqttRouter) onDeviceChange(change db.DeviceChange) {
+	switch change.Type {
+	case db.DeviceAdded:
+		h.publishDeviceEvent(MQTT_DEVICE_ADDED, change.Device)
+	case db.DeviceRemoved:
+		h.publishDeviceEvent(MQTT_DEVICE_REMOVED, change.Device)
+	}
+
+	h.scheduleDevicesRefresh()
+}
+
+func (h *mqttRouter) publishDeviceEvent(event string, device db.Device) {
+	jsonData, err := json.Marshal(device)
+	if err != nil {
+		h.logger.Error("Error Marshal Device: %v\n", err)
+		return
+	}
+
+	props := mqtt.MessageProperties{
+		UserProperties: map[string]string{
+			"ieee_address": fmt.Sprintf("0x%x", device.IEEEAddress),
+			"event":        event,
+		},
+	}
+
+	policy := h.configurationService.GetConfiguration().MqttConfiguration.QoS.Events
+	h.mqttClient.PublishWithOptions(fmt.Sprintf("%v/%v", MQTT_GATEWAY, event), jsonData, withPolicy(policy, props))
+}
+
+func (h *mqttRouter) PublishGatewayEvent(event string, msg interface{}) {
+	jsonData, err := json.Marshal(msg)
+	if err != nil {
+		h.logger.Error("Error Marshal gateway event: %v\n", err)
+		return
+	}
+
+	props := mqtt.MessageProperties{
+		UserProperties: map[string]string{
+			"event": event,
+		},
+	}
+
+	policy := h.configurationService.GetConfiguration().MqttConfiguration.QoS.Events
+	h.mqttClient.PublishWithOptions(fmt.Sprintf("%v/%v", MQTT_GATEWAY, event), jsonData, withPolicy(policy, props))
+}
+
+func (h *mqttRouter) scheduleDevicesRefresh() {
+	h.refreshMtx.Lock()
+	defer h.refreshMtx.Unlock()
+
+	if h.refreshPending {
+		return
+	}
+	h.refreshPending = true
+
+	time.AfterFunc(devicesRefreshDelay, func() {
+		h.refreshMtx.Lock()
+		h.refreshPending = false
+		h.refreshMtx.Unlock()
+
+		h.publishRetainedDevicesList()
+	})
+}
+
+func (h *mqttRouter) handleDeviceMessage(deviceAddrStr string, command string, message []byte, props mqtt.MessageProperties) {
 
 	deviceAddr, err := strconv.ParseUint(strings.Replace(deviceAddrStr, "0x", "", -1), 16, 64)
 	if err != nil {
@@ -173,30 +517,132 @@ func (h *mqttRouter) handleDeviceMessage(deviceAddrStr string, command string, m
 
 	if command == MQTT_DEVICE_GET {
 		h.logger.Info("get command received for device: %s", deviceAddrStr)
-		h.handleDeviceGetCommand(deviceAddr, message)
+		h.handleDeviceGetCommand(deviceAddr, message, props)
 	}
 
 	if command == MQTT_DEVICE_SET {
 		h.logger.Info("set command received for device: %s", deviceAddrStr)
-		h.handleDeviceSetCommand(deviceAddr, message)
+		h.handleDeviceSetCommand(deviceAddr, message, props)
+	}
+
+	if command == MQTT_DEVICE_WRITE {
+		h.logger.Info("write command received for device: %s", deviceAddrStr)
+		h.handleDeviceWriteCommand(deviceAddr, message, props)
+	}
+
+	if command == MQTT_DEVICE_REPORTING {
+		h.logger.Info("configure_reporting command received for device: %s", deviceAddrStr)
+		h.handleDeviceReportingCommand(deviceAddr, message, props)
 	}
 
 	if command == MQTT_DEVICE_EXPLORE {
-		h.handleDeviceExploreCommand(deviceAddr, message)
+		h.handleDeviceExploreCommand(deviceAddr, message, props)
+	}
+
+	if command == MQTT_DEVICE_REMOVE {
+		h.handleDeviceRemoveCommand(deviceAddr, message)
+	}
+
+	if command == MQTT_DEVICE_GET_HISTORY {
+		h.handleDeviceHistoryQuery(deviceAddr, message, props)
 	}
 }
 
-func (h *mqttRouter) handleDeviceExploreCommand(deviceAddr uint64, message []byte) {
-	h.logger.Info("EXPLORE message received. Device: 0x%x", deviceAddr)
+// requestLogger adds the device and Correlation Data of MQTT 5 request, when set, to logged lines.
+func (h *mqttRouter) requestLogger(deviceAddr uint64, props mqtt.MessageProperties) logger.Logger {
+	ret := h.logger.With("device", fmt.Sprintf("0x%x", deviceAddr))
+	if len(props.CorrelationData) > 0 {
+		ret = ret.With("transaction", fmt.Sprintf("%x", props.CorrelationData))
+	}
+
+	return ret
+}
 
-	if h.onGetMessage != nil {
-		h.onExploreMessage(types.DeviceExploreMessage{
+func (h *mqttRouter) handleDeviceHistoryQuery(deviceAddr uint64, message []byte, props mqtt.MessageProperties) {
+	if h.history == nil {
+		h.logger.Warn("history query for device 0x%x, but history is disabled", deviceAddr)
+		return
+	}
+
+	var query mqtt.DeviceHistoryQueryMessage
+	err := json.Unmarshal(message, &query)
+	if err != nil {
+		h.logger.Error("Error unmarshal history query message: %v\n", err)
+		return
+	}
+
+	if query.To.IsZero() {
+		query.To = time.Now()
+	}
+	if query.From.IsZero() {
+		query.From = query.To.Add(-24 * time.Hour)
+	}
+
+	h.requestLogger(deviceAddr, props).With("cluster", query.ClusterID).Info("history query received. Attribute: %v", query.Attribute)
+
+	samples, err := h.history.Query(context.Background(), deviceAddr, query.ClusterID, query.Attribute, query.From, query.To)
+	if err != nil {
+		h.logger.Error("error querying history: %v\n", err)
+		return
+	}
+
+	result := mqtt.DeviceHistoryMessage{
+		IEEEAddress:   deviceAddr,
+		ClusterID:     query.ClusterID,
+		Attribute:     query.Attribute,
+		From:          query.From,
+		To:            query.To,
+		BucketSeconds: query.BucketSeconds,
+	}
+	if query.BucketSeconds > 0 {
+		result.Buckets = history.Downsample(samples, query.From, time.Duration(query.BucketSeconds)*time.Second)
+	} else {
+		result.Samples = samples
+	}
+
+	jsonData, err := json.Marshal(result)
+	if err != nil {
+		h.logger.Error("Error Marshal history: %v\n", err)
+		return
+	}
+
+	h.mqttClient.PublishWithOptions(fmt.Sprintf("0x%x/%v", deviceAddr, MQTT_DEVICE_HISTORY), jsonData, withPolicy(h.gatewayPublishPolicy(), mqtt.MessageProperties{}))
+	h.publishResponse(props, jsonData, mqtt.MessageProperties{})
+}
+
+func (h *mqttRouter) handleDeviceExploreCommand(deviceAddr uint64, message []byte, props mqtt.MessageProperties) {
+	h.requestLogger(deviceAddr, props).Info("EXPLORE message received")
+
+	h.responses.Add(descriptionResponseKey(deviceAddr), props)
+
+	if onExploreMessage := h.getCallbacks().onExploreMessage; onExploreMessage != nil {
+		onExploreMessage(types.DeviceExploreMessage{
 			IEEEAddress: deviceAddr,
 		})
 	}
 }
 
-func (h *mqttRouter) handleDeviceGetCommand(deviceAddr uint64, message []byte) {
+func (h *mqttRouter) handleDeviceRemoveCommand(deviceAddr uint64, message []byte) {
+	var devMsg mqtt.DeviceRemoveMessage
+	if len(strings.TrimSpace(string(message))) > 0 {
+		err := json.Unmarshal(message, &devMsg)
+		if err != nil {
+			h.logger.Error("Error unmarshal REMOVE message: %v\n", err)
+			return
+		}
+	}
+
+	h.requestLogger(deviceAddr, mqtt.MessageProperties{}).Info("REMOVE message received. Force: %v", devMsg.Force)
+
+	if onRemoveMessage := h.getCallbacks().onRemoveMessage; onRemoveMessage != nil {
+		onRemoveMessage(types.DeviceRemoveMessage{
+			IEEEAddress: deviceAddr,
+			Force:       devMsg.Force,
+		})
+	}
+}
+
+func (h *mqttRouter) handleDeviceGetCommand(deviceAddr uint64, message []byte, props mqtt.MessageProperties) {
 	var devMsg mqtt.DeviceGetMessage
 	err := json.Unmarshal(message, &devMsg)
 	if err != nil {
@@ -204,19 +650,92 @@ func (h *mqttRouter) handleDeviceGetCommand(deviceAddr uint64, message []byte) {
 		return
 	}
 
-	h.logger.Info("GET message received. Device:%v", deviceAddr)
+	h.requestLogger(deviceAddr, props).With("cluster", devMsg.ClusterID).Info("GET message received")
 
-	if h.onGetMessage != nil {
-		h.onGetMessage(types.DeviceGetMessage{
-			IEEEAddress: deviceAddr,
-			ClusterID:   devMsg.ClusterID,
-			Endpoint:    devMsg.Endpoint,
-			Attributes:  devMsg.Attributes,
+	tsn := nextTransactionSequence(deviceAddr)
+	h.responses.Add(clusterResponseKey(deviceAddr, devMsg.ClusterID, tsn), props)
+
+	if onGetMessage := h.getCallbacks().onGetMessage; onGetMessage != nil {
+		onGetMessage(types.DeviceGetMessage{
+			IEEEAddress:         deviceAddr,
+			ClusterID:           devMsg.ClusterID,
+			Endpoint:            devMsg.Endpoint,
+			Attributes:          devMsg.Attributes,
+			TransactionSequence: tsn,
+		})
+	}
+}
+
+func (h *mqttRouter) handleDeviceWriteCommand(deviceAddr uint64, message []byte, props mqtt.MessageProperties) {
+	var devMsg mqtt.DeviceWriteMessage
+	err := json.Unmarshal(message, &devMsg)
+	if err != nil {
+		h.logger.Error("Error unmarshal WRITE message: %v\n", err)
+		return
+	}
+
+	h.requestLogger(deviceAddr, props).With("cluster", devMsg.ClusterID).Info("WRITE message received")
+
+	tsn := nextTransactionSequence(deviceAddr)
+	h.responses.Add(clusterResponseKey(deviceAddr, devMsg.ClusterID, tsn), props)
+
+	if onWriteMessage := h.getCallbacks().onWriteMessage; onWriteMessage != nil {
+		onWriteMessage(types.DeviceWriteMessage{
+			IEEEAddress:         deviceAddr,
+			ClusterID:           devMsg.ClusterID,
+			Endpoint:            devMsg.Endpoint,
+			Attributes:          devMsg.Attributes,
+			TransactionSequence: tsn,
 		})
 	}
 }
 
-func (h *mqttRouter) handleDeviceSetCommand(deviceAddr uint64, message []byte) {
+// handleDeviceReportingCommand takes fields missing in the request from the running configuration.
+func (h *mqttRouter) handleDeviceReportingCommand(deviceAddr uint64, message []byte, props mqtt.MessageProperties) {
+	var devMsg mqtt.DeviceConfigureReportingMessage
+	err := json.Unmarshal(message, &devMsg)
+	if err != nil {
+		h.logger.Error("Error unmarshal CONFIGURE_REPORTING message: %v\n", err)
+		return
+	}
+
+	defaults := h.configurationService.GetConfiguration().Reporting
+	if devMsg.MinIntervalInSeconds == nil {
+		devMsg.MinIntervalInSeconds = &defaults.MinIntervalInSeconds
+	}
+	if devMsg.MaxIntervalInSeconds == nil {
+		devMsg.MaxIntervalInSeconds = &defaults.MaxIntervalInSeconds
+	}
+	if devMsg.ReportableChange == nil {
+		devMsg.ReportableChange = &defaults.ReportableChange
+	}
+
+	minInterval, maxInterval := *devMsg.MinIntervalInSeconds, *devMsg.MaxIntervalInSeconds
+	if minInterval < 0 || maxInterval > 0xffff || minInterval > maxInterval {
+		h.logger.Error("Invalid CONFIGURE_REPORTING intervals %v-%v\n", minInterval, maxInterval)
+		return
+	}
+
+	h.requestLogger(deviceAddr, props).With("cluster", devMsg.ClusterID).Info("CONFIGURE_REPORTING message received")
+
+	tsn := nextTransactionSequence(deviceAddr)
+	h.responses.Add(clusterResponseKey(deviceAddr, devMsg.ClusterID, tsn), props)
+
+	if onReportingMessage := h.getCallbacks().onReportingMessage; onReportingMessage != nil {
+		onReportingMessage(types.DeviceConfigureReportingMessage{
+			IEEEAddress:         deviceAddr,
+			ClusterID:           devMsg.ClusterID,
+			Endpoint:            devMsg.Endpoint,
+			Attributes:          devMsg.Attributes,
+			MinInterval:         uint16(minInterval),
+			MaxInterval:         uint16(maxInterval),
+			ReportableChange:    *devMsg.ReportableChange,
+			TransactionSequence: tsn,
+		})
+	}
+}
+
+func (h *mqttRouter) handleDeviceSetCommand(deviceAddr uint64, message []byte, props mqtt.MessageProperties) {
 	var devMsg mqtt.DeviceSetMessage
 	err := json.Unmarshal(message, &devMsg)
 	if err != nil {
@@ -224,15 +743,19 @@ func (h *mqttRouter) handleDeviceSetCommand(deviceAddr uint64, message []byte) {
 		return
 	}
 
-	h.logger.Info("SET message received. Device:%v, ClusterID:%v, CommandID:%v", deviceAddr, devMsg.ClusterID, devMsg.CommandIdentifier)
+	h.requestLogger(deviceAddr, props).With("cluster", devMsg.ClusterID).Info("SET message received. CommandID:%v", devMsg.CommandIdentifier)
+
+	tsn := nextTransactionSequence(deviceAddr)
+	h.responses.Add(clusterResponseKey(deviceAddr, devMsg.ClusterID, tsn), props)
 
-	if h.onSetMessage != nil {
-		h.onSetMessage(types.DeviceCommandMessage{
-			IEEEAddress:       deviceAddr,
-			ClusterID:         devMsg.ClusterID,
-			Endpoint:          devMsg.Endpoint,
-			CommandIdentifier: devMsg.CommandIdentifier,
-			CommandData:       devMsg.CommandData,
+	if onSetMessage := h.getCallbacks().onSetMessage; onSetMessage != nil {
+		onSetMessage(types.DeviceCommandMessage{
+			IEEEAddress:         deviceAddr,
+			ClusterID:           devMsg.ClusterID,
+			Endpoint:            devMsg.Endpoint,
+			CommandIdentifier:   devMsg.CommandIdentifier,
+			CommandData:         devMsg.CommandData,
+			TransactionSequence: tsn,
 		})
 	}
 
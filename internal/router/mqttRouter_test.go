@@ -0,0 +1,574 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.golang/packets"
+	mqttlib "github.com/eclipse/paho.mqtt.golang"
+	"github.com/stretchr/testify/assert"
+	"github.com/supby/gigbee2mqtt/internal/broker"
+	"github.com/supby/gigbee2mqtt/internal/configuration"
+	"github.com/supby/gigbee2mqtt/internal/db"
+	"github.com/supby/gigbee2mqtt/internal/mqtt"
+	"github.com/supby/gigbee2mqtt/internal/types"
+)
+
+const (
+	testRootTopic = "e2e"
+	testDevice    = uint64(0x842e14fffe05b879)
+)
+
+type testConfigurationService struct {
+	configuration configuration.Configuration
+	state         configuration.State
+}
+
+func (cs *testConfigurationService) GetConfiguration() configuration.Configuration {
+	return cs.configuration
+}
+
+func (cs *testConfigurationService) Update(updatedConfig configuration.Configuration) error {
+	cs.configuration = updatedConfig
+	return nil
+}
+
+func (cs *testConfigurationService) Apply(updatedConfig configuration.Configuration) (configuration.ReloadResult, error) {
+	if err := configuration.Validate(updatedConfig); err != nil {
+		return configuration.ReloadResult{}, err
+	}
+
+	result, err := configuration.Merge(cs.configuration, updatedConfig)
+	if err == nil {
+		cs.configuration = result.Current
+	}
+
+	return result, err
+}
+
+func (cs *testConfigurationService) Reload() (configuration.ReloadResult, error) {
+	return configuration.ReloadResult{Previous: cs.configuration, Current: cs.configuration}, nil
+}
+
+func (cs *testConfigurationService) GetState() configuration.State {
+	return cs.state
+}
+
+func (cs *testConfigurationService) UpdateState(update func(state *configuration.State)) error {
+	update(&cs.state)
+	return nil
+}
+
+type testEnv struct {
+	router    MQTTRouter
+	db        db.DeviceDB
+	received  chan mqttlib.Message
+	publisher mqttlib.Client
+}
+
+func freePort(t *testing.T) uint16 {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer l.Close()
+
+	return uint16(l.Addr().(*net.TCPAddr).Port)
+}
+
+// newTestEnv starts embedded broker, gateway MQTT client with router on top of it
+// and one more client playing the role of a user of the gateway.
+func newTestEnv(t *testing.T) *testEnv {
+	return newTestEnvWithConfig(t, nil)
+}
+
+// newTestEnvWithConfig is newTestEnv with configuration changed by configure before the router is created.
+func newTestEnvWithConfig(t *testing.T, configure func(cfg *configuration.Configuration)) *testEnv {
+	cfg := configuration.Configuration{
+		MqttConfiguration: configuration.MqttConfiguration{
+			Scheme:    "tcp",
+			Address:   "127.0.0.1",
+			Port:      freePort(t),
+			RootTopic: testRootTopic,
+			Username:  "gateway",
+			Password:  "secret",
+		},
+	}
+	cfg.EmbeddedBroker = configuration.EmbeddedBrokerConfiguration{
+		Enabled:  true,
+		Address:  cfg.MqttConfiguration.Address,
+		Port:     cfg.MqttConfiguration.Port,
+		Username: cfg.MqttConfiguration.Username,
+		Password: cfg.MqttConfiguration.Password,
+	}
+
+	b, err := broker.NewBroker(&cfg.EmbeddedBroker, cfg.LogLevel)
+	assert.NoError(t, err)
+	t.Cleanup(func() { b.Close() })
+
+	env := &testEnv{
+		received: make(chan mqttlib.Message, 100),
+	}
+
+	opts := mqttlib.NewClientOptions()
+	opts.AddBroker(fmt.Sprintf("tcp://127.0.0.1:%d", cfg.MqttConfiguration.Port))
+	opts.SetClientID("e2e-user")
+	opts.SetUsername(cfg.MqttConfiguration.Username)
+	opts.SetPassword(cfg.MqttConfiguration.Password)
+	env.publisher = mqttlib.NewClient(opts)
+	token := env.publisher.Connect()
+	assert.True(t, token.WaitTimeout(5*time.Second))
+	assert.NoError(t, token.Error())
+	t.Cleanup(func() { env.publisher.Disconnect(0) })
+
+	token = env.publisher.Subscribe(fmt.Sprintf("%s/#", testRootTopic), 0, func(c mqttlib.Client, m mqttlib.Message) {
+		env.received <- m
+	})
+	assert.True(t, token.WaitTimeout(5*time.Second))
+
+	deviceDB, err := db.NewDeviceDB(t.TempDir(), db.DeviceDBOptions{
+		FlushPeriodInSeconds: 60,
+	})
+	assert.NoError(t, err)
+	t.Cleanup(func() { deviceDB.Close(context.Background()) })
+
+	err = deviceDB.SaveDevice(context.Background(), db.Device{IEEEAddress: testDevice, NetworkAddress: 1234})
+	assert.NoError(t, err)
+
+	client, dispose, err := mqtt.NewClient(&cfg)
+	assert.NoError(t, err)
+	t.Cleanup(dispose)
+
+	env.db = deviceDB
+	if configure != nil {
+		configure(&cfg)
+	}
+	env.router = NewMQTTRouter(&testConfigurationService{configuration: cfg}, client, deviceDB, nil)
+
+	env.waitFor(t, "gateway/status")
+
+	return env
+}
+
+func (env *testEnv) publish(t *testing.T, subTopic string, payload string) {
+	token := env.publisher.Publish(fmt.Sprintf("%s/%s", testRootTopic, subTopic), 0, false, payload)
+	assert.True(t, token.WaitTimeout(5*time.Second))
+	assert.NoError(t, token.Error())
+}
+
+func (env *testEnv) waitFor(t *testing.T, subTopic string) []byte {
+	topic := fmt.Sprintf("%s/%s", testRootTopic, subTopic)
+	for {
+		select {
+		case m := <-env.received:
+			if m.Topic() == topic {
+				return m.Payload()
+			}
+		case <-time.After(5 * time.Second):
+			assert.Fail(t, "message is not received", topic)
+			return nil
+		}
+	}
+}
+
+func TestMQTTRouterGetDevices(t *testing.T) {
+	env := newTestEnv(t)
+
+	env.publish(t, "gateway/get_devices", "{}")
+
+	payload := env.waitFor(t, "gateway/devices")
+
+	var devices []db.Device
+	assert.NoError(t, json.Unmarshal(payload, &devices))
+	assert.Equal(t, 1, len(devices))
+	assert.Equal(t, testDevice, devices[0].IEEEAddress)
+}
+
+func TestMQTTRouterGetDevicesFiltered(t *testing.T) {
+	env := newTestEnv(t)
+
+	_, err := env.db.UpdateDevice(context.Background(), testDevice+1, func(device *db.Device, exists bool) error {
+		device.Tags = []string{"kitchen"}
+		return nil
+	})
+	assert.NoError(t, err)
+
+	env.publish(t, "gateway/get_devices", `{"Tag": "kitchen"}`)
+
+	payload := env.waitFor(t, "gateway/devices_filtered")
+
+	var devices []db.Device
+	assert.NoError(t, json.Unmarshal(payload, &devices))
+	assert.Equal(t, 1, len(devices))
+	assert.Equal(t, testDevice+1, devices[0].IEEEAddress)
+}
+
+func TestMQTTRouterDeviceChanges(t *testing.T) {
+	devicesRefreshDelay = 100 * time.Millisecond
+	defer func() { devicesRefreshDelay = 5 * time.Second }()
+
+	env := newTestEnv(t)
+
+	assert.NoError(t, env.db.SaveDevice(context.Background(), db.Device{IEEEAddress: testDevice + 1}))
+
+	var device db.Device
+	assert.NoError(t, json.Unmarshal(env.waitFor(t, "gateway/device_added"), &device))
+	assert.Equal(t, testDevice+1, device.IEEEAddress)
+
+	var devices []db.Device
+	assert.NoError(t, json.Unmarshal(env.waitFor(t, "gateway/devices"), &devices))
+	assert.Equal(t, 2, len(devices))
+
+	assert.NoError(t, env.db.DeleteDevice(context.Background(), testDevice))
+
+	assert.NoError(t, json.Unmarshal(env.waitFor(t, "gateway/device_removed"), &device))
+	assert.Equal(t, testDevice, device.IEEEAddress)
+
+	assert.NoError(t, json.Unmarshal(env.waitFor(t, "gateway/devices"), &devices))
+	assert.Equal(t, 1, len(devices))
+
+	// every message from the device refreshes link quality and last seen time, updates coming together
+	// are published once
+	for _, lqi := range []uint8{100, 120} {
+		_, err := env.db.UpdateDevice(context.Background(), testDevice+1, func(device *db.Device, exists bool) error {
+			device.LQI = lqi
+			device.LastReceived = time.Now()
+			return nil
+		})
+		assert.NoError(t, err)
+	}
+
+	assert.NoError(t, json.Unmarshal(env.waitFor(t, "gateway/devices"), &devices))
+	assert.Equal(t, uint8(120), devices[0].LQI)
+	assert.False(t, devices[0].LastReceived.IsZero())
+
+	timeout := time.After(3 * devicesRefreshDelay)
+	for done := false; !done; {
+		select {
+		case m := <-env.received:
+			assert.NotEqual(t, testRootTopic+"/gateway/devices", m.Topic(), "devices list is published once")
+		case <-timeout:
+			done = true
+		}
+	}
+}
+
+func TestMQTTRouterSetDevice(t *testing.T) {
+	env := newTestEnv(t)
+
+	env.publish(t, "gateway/set_device", fmt.Sprintf(`{"IEEEAddress": %d, "Tags": ["kitchen", "light"], "Notes": "above the sink"}`, testDevice))
+
+	payload := env.waitFor(t, "gateway/device")
+
+	var device db.Device
+	assert.NoError(t, json.Unmarshal(payload, &device))
+	assert.Equal(t, testDevice, device.IEEEAddress)
+	assert.Equal(t, []string{"kitchen", "light"}, device.Tags)
+	assert.Equal(t, "above the sink", device.Notes)
+	assert.Equal(t, uint16(1234), device.NetworkAddress)
+
+	// only given fields are changed
+	env.publish(t, "gateway/set_device", fmt.Sprintf(`{"IEEEAddress": %d, "Notes": ""}`, testDevice))
+
+	payload = env.waitFor(t, "gateway/device")
+	assert.NoError(t, json.Unmarshal(payload, &device))
+	assert.Equal(t, []string{"kitchen", "light"}, device.Tags)
+	assert.Equal(t, "", device.Notes)
+}
+
+func TestMQTTRouterConfigHasNoSecrets(t *testing.T) {
+	env := newTestEnv(t)
+
+	env.publish(t, "gateway/get_config", "")
+
+	payload := env.waitFor(t, "gateway/config")
+	assert.NotContains(t, string(payload), `"secret"`)
+
+	var cfg configuration.Configuration
+	assert.NoError(t, json.Unmarshal(payload, &cfg))
+	assert.Equal(t, configuration.RedactedValue, cfg.MqttConfiguration.Password)
+	assert.Equal(t, configuration.RedactedValue, cfg.EmbeddedBroker.Password)
+}
+
+func TestMQTTRouterSetConfigRejected(t *testing.T) {
+	env := newTestEnv(t)
+
+	env.publish(t, "gateway/set_config", `{"LogLevel": 3, "PermitJoinn": true}`)
+
+	var result mqtt.SetGatewayConfigResult
+	assert.NoError(t, json.Unmarshal(env.waitFor(t, "gateway/set_config_result"), &result))
+	assert.Contains(t, result.Error, "PermitJoinn")
+	assert.Empty(t, result.Applied)
+	assert.Nil(t, result.Config)
+}
+
+func TestMQTTRouterSetCommand(t *testing.T) {
+	env := newTestEnv(t)
+
+	commands := make(chan types.DeviceCommandMessage, 1)
+	env.router.SubscribeOnSetMessage(func(devCmd types.DeviceCommandMessage) {
+		commands <- devCmd
+	})
+
+	env.publish(t, fmt.Sprintf("0x%x/set", testDevice), `{"ClusterID": 6, "Endpoint": 1, "CommandIdentifier": 1, "CommandData": {}}`)
+
+	select {
+	case cmd := <-commands:
+		assert.Equal(t, testDevice, cmd.IEEEAddress)
+		assert.Equal(t, uint16(6), cmd.ClusterID)
+		assert.Equal(t, uint8(1), cmd.Endpoint)
+		assert.Equal(t, uint8(1), cmd.CommandIdentifier)
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "set command is not routed")
+	}
+}
+
+func TestMQTTRouterPublishDeviceMessage(t *testing.T) {
+	env := newTestEnv(t)
+
+	env.router.PublishDeviceMessage(testDevice, mqtt.DeviceMessage{
+		IEEEAddress: testDevice,
+		LinkQuality: 100,
+		Message: mqtt.DeviceAttributesReportMessage{
+			ClusterID:   6,
+			ClusterName: "genOnOff",
+		},
+	}, "")
+
+	payload := env.waitFor(t, fmt.Sprintf("0x%x", testDevice))
+
+	var msg mqtt.DeviceMessage
+	assert.NoError(t, json.Unmarshal(payload, &msg))
+	assert.Equal(t, testDevice, msg.IEEEAddress)
+	assert.Equal(t, uint8(100), msg.LinkQuality)
+}
+
+func TestMQTTRouterPublishPayloadFormat(t *testing.T) {
+	env := newTestEnvWithConfig(t, func(cfg *configuration.Configuration) {
+		cfg.MqttConfiguration.Payload = configuration.MqttPayloadConfiguration{Timestamp: true, HexAddress: true}
+	})
+
+	env.router.PublishDeviceMessage(testDevice, mqtt.DeviceMessage{
+		IEEEAddress: testDevice,
+		LinkQuality: 100,
+		Message:     mqtt.DeviceAttributesReportMessage{ClusterID: 6},
+	}, "")
+
+	var msg struct {
+		IEEEAddress string
+		LinkQuality uint8
+		Timestamp   time.Time
+	}
+	assert.NoError(t, json.Unmarshal(env.waitFor(t, fmt.Sprintf("0x%x", testDevice)), &msg))
+	assert.Equal(t, fmt.Sprintf("0x%x", testDevice), msg.IEEEAddress)
+	assert.Equal(t, uint8(100), msg.LinkQuality)
+	assert.WithinDuration(t, time.Now(), msg.Timestamp, time.Minute)
+}
+
+func TestMQTTRouterConfigureReportingDefaults(t *testing.T) {
+	env := newTestEnvWithConfig(t, func(cfg *configuration.Configuration) {
+		cfg.Reporting = configuration.ReportingConfiguration{
+			MinIntervalInSeconds: 10,
+			MaxIntervalInSeconds: 3600,
+			ReportableChange:     50,
+		}
+	})
+
+	commands := make(chan types.DeviceConfigureReportingMessage, 1)
+	env.router.SubscribeOnConfigureReportingMessage(func(devCmd types.DeviceConfigureReportingMessage) {
+		commands <- devCmd
+	})
+
+	env.publish(t, fmt.Sprintf("0x%x/configure_reporting", testDevice), `{"ClusterID": 1026, "Endpoint": 1, "Attributes": [0], "MaxIntervalInSeconds": 300}`)
+
+	select {
+	case cmd := <-commands:
+		assert.Equal(t, testDevice, cmd.IEEEAddress)
+		assert.Equal(t, uint16(1026), cmd.ClusterID)
+		assert.Equal(t, []uint16{0}, cmd.Attributes)
+		assert.Equal(t, uint16(10), cmd.MinInterval, "missing fields are taken from the configuration")
+		assert.Equal(t, uint16(300), cmd.MaxInterval)
+		assert.Equal(t, 50.0, cmd.ReportableChange)
+		assert.NotZero(t, cmd.TransactionSequence)
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "configure_reporting command is not routed")
+	}
+}
+
+// startTestV5Broker relays QoS 0 publishes between MQTT 5 clients with their properties,
+// the embedded broker supports MQTT 3.1.1 only.
+func startTestV5Broker(t *testing.T) uint16 {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	var mtx sync.Mutex
+	subscribers := map[net.Conn][]string{}
+
+	relay := func(p *packets.Publish) {
+		mtx.Lock()
+		defer mtx.Unlock()
+
+		for conn, filters := range subscribers {
+			for _, filter := range filters {
+				if strings.HasPrefix(p.Topic, strings.TrimSuffix(filter, "#")) {
+					(&packets.Publish{Topic: p.Topic, Payload: p.Payload, Properties: p.Properties}).WriteTo(conn)
+					break
+				}
+			}
+		}
+	}
+
+	serve := func(conn net.Conn) {
+		defer func() {
+			mtx.Lock()
+			delete(subscribers, conn)
+			mtx.Unlock()
+			conn.Close()
+		}()
+
+		for {
+			cp, err := packets.ReadPacket(conn)
+			if err != nil {
+				return
+			}
+
+			switch p := cp.Content.(type) {
+			case *packets.Connect:
+				(&packets.Connack{Properties: &packets.Properties{}}).WriteTo(conn)
+			case *packets.Subscribe:
+				suback := &packets.Suback{Properties: &packets.Properties{}, PacketID: p.PacketID}
+				mtx.Lock()
+				for filter := range p.Subscriptions {
+					subscribers[conn] = append(subscribers[conn], filter)
+					suback.Reasons = append(suback.Reasons, 0)
+				}
+				mtx.Unlock()
+				suback.WriteTo(conn)
+			case *packets.Publish:
+				if p.QoS > 0 {
+					(&packets.Puback{Properties: &packets.Properties{}, PacketID: p.PacketID}).WriteTo(conn)
+				}
+				relay(p)
+			case *packets.Pingreq:
+				packets.NewControlPacket(packets.PINGRESP).WriteTo(conn)
+			case *packets.Disconnect:
+				return
+			}
+		}
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serve(packets.NewThreadSafeConn(conn))
+		}
+	}()
+
+	return uint16(l.Addr().(*net.TCPAddr).Port)
+}
+
+func newTestV5Client(t *testing.T, port uint16, rootTopic string) mqtt.MqttClient {
+	cfg := configuration.Configuration{
+		MqttConfiguration: configuration.MqttConfiguration{
+			Scheme:          "tcp",
+			Address:         "127.0.0.1",
+			Port:            port,
+			ProtocolVersion: mqtt.ProtocolVersion5,
+			RootTopic:       rootTopic,
+		},
+	}
+
+	client, dispose, err := mqtt.NewClient(&cfg)
+	assert.NoError(t, err)
+	t.Cleanup(dispose)
+
+	assert.Eventually(t, client.IsConnected, 5*time.Second, 10*time.Millisecond)
+
+	return client
+}
+
+func TestMQTTRouterV5ResponseTopic(t *testing.T) {
+	port := startTestV5Broker(t)
+
+	deviceDB, err := db.NewDeviceDB(t.TempDir(), db.DeviceDBOptions{FlushPeriodInSeconds: 60})
+	assert.NoError(t, err)
+	t.Cleanup(func() { deviceDB.Close(context.Background()) })
+
+	gateway := newTestV5Client(t, port, testRootTopic)
+	router := NewMQTTRouter(&testConfigurationService{configuration: configuration.Default()}, gateway, deviceDB, nil)
+
+	commands := make(chan types.DeviceGetMessage, 2)
+	router.SubscribeOnGetMessage(func(devCmd types.DeviceGetMessage) {
+		commands <- devCmd
+	})
+
+	type reply struct {
+		correlation string
+		payload     []byte
+	}
+	replies := make(chan reply, 10)
+	requester := newTestV5Client(t, port, "requester")
+	requester.SubscribeWithProperties(func(topic string, message []byte, props mqtt.MessageProperties) {
+		if topic == "requester/replies" {
+			replies <- reply{correlation: string(props.CorrelationData), payload: message}
+		}
+	})
+
+	// two requests to the same cluster, each must get the answer to its own transaction
+	for i, correlation := range []string{"req-0", "req-1"} {
+		requester.PublishResponse(fmt.Sprintf("%v/0x%x/get", testRootTopic, testDevice),
+			[]byte(fmt.Sprintf(`{"ClusterID": 6, "Endpoint": 1, "Attributes": [%d]}`, i)),
+			mqtt.PublishOptions{Properties: mqtt.MessageProperties{
+				ResponseTopic:   "requester/replies",
+				CorrelationData: []byte(correlation),
+			}})
+	}
+
+	var sent []types.DeviceGetMessage
+	for len(sent) < 2 {
+		select {
+		case cmd := <-commands:
+			sent = append(sent, cmd)
+		case <-time.After(5 * time.Second):
+			assert.FailNow(t, "get command is not routed")
+		}
+	}
+	assert.NotEqual(t, sent[0].TransactionSequence, sent[1].TransactionSequence)
+
+	// answer in reverse order
+	for i := len(sent) - 1; i >= 0; i-- {
+		router.PublishDeviceMessage(testDevice, mqtt.DeviceMessage{
+			IEEEAddress:         testDevice,
+			TransactionSequence: sent[i].TransactionSequence,
+			Message: mqtt.DeviceAttributesReportMessage{
+				ClusterID:         6,
+				ClusterAttributes: map[string]interface{}{"attribute": sent[i].Attributes[0]},
+			},
+		}, "")
+	}
+
+	received := map[string]int{}
+	for len(received) < 2 {
+		select {
+		case r := <-replies:
+			var msg struct {
+				Message struct {
+					ClusterAttributes map[string]int
+				}
+			}
+			assert.NoError(t, json.Unmarshal(r.payload, &msg))
+			received[r.correlation] = msg.Message.ClusterAttributes["attribute"]
+		case <-time.After(5 * time.Second):
+			assert.FailNow(t, "reply is not received", "%v", received)
+		}
+	}
+	assert.Equal(t, map[string]int{"req-0": 0, "req-1": 1}, received)
+}
@@ -0,0 +1,262 @@
+package router
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/supby/gigbee2mqtt/internal/mqtt"
+)
+
+const (
+	responseTimeout = 1 * time.Minute
+)
+
+type pendingResponse struct {
+	props   mqtt.MessageProperties
+	expires time.Time
+}
+
+// responseTracker remembers MQTT 5 requests carrying Response Topic until
+// the device answers, the answer is matched by device, cluster and transaction sequence number.
+type responseTracker struct {
+	mtx     sync.Mutex
+	pending map[string][]pendingResponse
+}
+
+func newResponseTracker() *responseTracker {
+	return &responseTracker{
+		pending: map[string][]pendingResponse{},
+	}
+}
+
+func (t *responseTracker) Add(key string, props mqtt.MessageProperties) {
+	if props.ResponseTopic == "" {
+		return
+	}
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	now := time.Now()
+	for k, responses := range t.pending {
+		if len(responses) > 0 && responses[len(responses)-1].expires.Before(now) {
+			delete(t.pending, k)
+		}
+	}
+
+	t.pending[key] = append(t.pending[key], pendingResponse{
+		props:   props,
+		expires: now.Add(responseTimeout),
+	})
+}
+
+func (t *responseTracker) Take(key string) []mqtt.MessageProperties {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	responses, ok := t.pending[key]
+	if !ok {
+		return nil
+	}
+	delete(t.pending, key)
+
+	now := time.Now()
+	var ret []mqtt.MessageProperties
+	for _, r := range responses {
+		if r.expires.After(now) {
+			ret = append(ret, r.props)
+		}
+	}
+
+	return ret
+}
+
+// Keys returns keys of requests still waiting for the device to answer.
+func (t *responseTracker) Keys() []string {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	now := time.Now()
+	ret := []string{}
+	for k, responses := range t.pending {
+		for _, r := range responses {
+			if r.expires.After(now) {
+				ret = append(ret, k)
+			}
+		}
+	}
+	sort.Strings(ret)
+
+	return ret
+}
+
+// transactionSequences allocates ZCL transaction sequence numbers per device, a number is not given out again
+// while an answer to the request sent with it may still be awaited, so answers are not matched to a wrong request.
+type transactionSequences struct {
+	mtx     sync.Mutex
+	devices map[uint64]*deviceSequences
+}
+
+type deviceSequences struct {
+	last     uint8
+	reserved [256]time.Time // until when the number is in use
+}
+
+var sequences = &transactionSequences{
+	devices: map[uint64]*deviceSequences{},
+}
+
+// next skips 0, so it can mean not allocated. When all numbers are in use, more than 255 requests to the device
+// within responseTimeout, the oldest one is reused.
+func (s *transactionSequences) next(ieeeAddress uint64, now time.Time) uint8 {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	// devices without requests in flight are forgotten, e.g. addresses of removed devices
+	for addr, d := range s.devices {
+		if d.reserved[d.last].Before(now) {
+			delete(s.devices, addr)
+		}
+	}
+
+	d, ok := s.devices[ieeeAddress]
+	if !ok {
+		d = &deviceSequences{}
+		s.devices[ieeeAddress] = d
+	}
+
+	// the 256th step wraps around to the number following the last one, the oldest
+	for i := 0; i <= 255; i++ {
+		d.last++
+		if d.last == 0 {
+			d.last++
+		}
+
+		if i == 255 || d.reserved[d.last].Before(now) {
+			break
+		}
+	}
+	d.reserved[d.last] = now.Add(responseTimeout)
+
+	return d.last
+}
+
+// nextTransactionSequence allocates ZCL transaction sequence number of a request to the device.
+func nextTransactionSequence(ieeeAddress uint64) uint8 {
+	return sequences.next(ieeeAddress, time.Now())
+}
+
+// transactionSequence returns tsn or a new one when it is not allocated.
+func transactionSequence(ieeeAddress uint64, tsn uint8) uint8 {
+	if tsn == 0 {
+		return nextTransactionSequence(ieeeAddress)
+	}
+
+	return tsn
+}
+
+// clusterResponseKey matches the answer of the device by the transaction sequence number of the request,
+// so concurrent requests to the same cluster get their own answers.
+func clusterResponseKey(ieeeAddress uint64, clusterID uint16, tsn uint8) string {
+	return fmt.Sprintf("0x%x/%d/%d", ieeeAddress, clusterID, tsn)
+}
+
+func descriptionResponseKey(ieeeAddress uint64) string {
+	return fmt.Sprintf("0x%x/description", ieeeAddress)
+}
+
+// deviceResponseKey returns key of requests the message from device answers, empty when it answers none.
+func deviceResponseKey(ieeeAddress uint64, msg interface{}) string {
+	switch m := msg.(type) {
+	case mqtt.DeviceDescriptionMessage:
+		return descriptionResponseKey(ieeeAddress)
+	case mqtt.DeviceMessage:
+		// reports are not sent in answer to a request
+		if m.TransactionSequence == 0 {
+			return ""
+		}
+
+		switch devMsg := m.Message.(type) {
+		case mqtt.DeviceAttributesReportMessage:
+			return clusterResponseKey(ieeeAddress, devMsg.ClusterID, m.TransactionSequence)
+		case mqtt.DeviceDefaultResponseMessage:
+			return clusterResponseKey(ieeeAddress, devMsg.ClusterID, m.TransactionSequence)
+		case mqtt.DeviceWriteAttributesResponseMessage:
+			return clusterResponseKey(ieeeAddress, devMsg.ClusterID, m.TransactionSequence)
+		case mqtt.DeviceConfigureReportingResponseMessage:
+			return clusterResponseKey(ieeeAddress, devMsg.ClusterID, m.TransactionSequence)
+		}
+	}
+
+	return ""
+}
+
+// responseWaiters hands messages from devices to HTTP and admin socket requests blocked until the device answers.
+type responseWaiters struct {
+	mtx     sync.Mutex
+	waiters map[string][]chan interface{}
+}
+
+func newResponseWaiters() *responseWaiters {
+	return &responseWaiters{
+		waiters: map[string][]chan interface{}{},
+	}
+}
+
+// Add must be called before the command is sent, otherwise a fast answer can be missed.
+func (w *responseWaiters) Add(key string) chan interface{} {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	ch := make(chan interface{}, 1)
+	w.waiters[key] = append(w.waiters[key], ch)
+
+	return ch
+}
+
+func (w *responseWaiters) Remove(key string, ch chan interface{}) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	waiters := w.waiters[key]
+	for i, c := range waiters {
+		if c == ch {
+			waiters = append(waiters[:i], waiters[i+1:]...)
+			break
+		}
+	}
+
+	if len(waiters) == 0 {
+		delete(w.waiters, key)
+	} else {
+		w.waiters[key] = waiters
+	}
+}
+
+func (w *responseWaiters) Resolve(key string, msg interface{}) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	for _, ch := range w.waiters[key] {
+		ch <- msg
+	}
+	delete(w.waiters, key)
+}
+
+// Keys returns keys of requests still waiting for the device to answer.
+func (w *responseWaiters) Keys() []string {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	ret := []string{}
+	for k, waiters := range w.waiters {
+		for range waiters {
+			ret = append(ret, k)
+		}
+	}
+	sort.Strings(ret)
+
+	return ret
+}
@@ -0,0 +1,37 @@
+package router
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransactionSequences(t *testing.T) {
+	s := &transactionSequences{devices: map[uint64]*deviceSequences{}}
+	now := time.Now()
+
+	allocated := map[uint8]bool{}
+	for i := 0; i < 255; i++ {
+		tsn := s.next(testDevice, now)
+		assert.NotZero(t, tsn)
+		assert.False(t, allocated[tsn], "number %v is given out while in use", tsn)
+		allocated[tsn] = true
+	}
+
+	// other devices have their own numbers
+	assert.Equal(t, uint8(1), s.next(testDevice+1, now))
+
+	// all numbers are in use, the oldest is reused
+	assert.Equal(t, uint8(1), s.next(testDevice, now))
+	assert.Equal(t, uint8(2), s.next(testDevice, now))
+
+	// a number is skipped until its request times out
+	later := now.Add(responseTimeout / 2)
+	s.devices[testDevice].reserved[4] = time.Time{}
+	assert.Equal(t, uint8(4), s.next(testDevice, later))
+
+	// devices without requests in flight are forgotten
+	s.next(testDevice, now.Add(2*responseTimeout))
+	assert.Equal(t, 1, len(s.devices))
+}
@@ -0,0 +1,98 @@
+package router
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/shimmeringbee/zcl"
+)
+
+var zclUintTypes = map[string]zcl.AttributeDataType{
+	"uint8":  zcl.TypeUnsignedInt8,
+	"uint16": zcl.TypeUnsignedInt16,
+	"uint24": zcl.TypeUnsignedInt24,
+	"uint32": zcl.TypeUnsignedInt32,
+	"uint48": zcl.TypeUnsignedInt48,
+	"uint56": zcl.TypeUnsignedInt56,
+	"enum8":  zcl.TypeEnum8,
+	"enum16": zcl.TypeEnum16,
+	"map8":   zcl.TypeBitmap8,
+	"map16":  zcl.TypeBitmap16,
+	"map32":  zcl.TypeBitmap32,
+	"map48":  zcl.TypeBitmap48,
+	"map64":  zcl.TypeBitmap64,
+	"utc":    zcl.TypeUTCTime,
+}
+
+var zclIntTypes = map[string]zcl.AttributeDataType{
+	"int8":  zcl.TypeSignedInt8,
+	"int16": zcl.TypeSignedInt16,
+	"int24": zcl.TypeSignedInt24,
+	"int32": zcl.TypeSignedInt32,
+}
+
+// zclAttributeValue converts JSON value of attribute to ZCL data type named in zcldef and Go value its marshaller expects.
+func zclAttributeValue(typeName string, value interface{}) (*zcl.AttributeDataTypeValue, error) {
+	if dt, ok := zclUintTypes[typeName]; ok {
+		number, ok := value.(float64)
+		if !ok || number < 0 || number != math.Trunc(number) {
+			return nil, fmt.Errorf("unsigned integer expected for %v, got %v", typeName, value)
+		}
+
+		if dt == zcl.TypeUTCTime {
+			return &zcl.AttributeDataTypeValue{DataType: dt, Value: zcl.UTCTime(number)}, nil
+		}
+
+		return &zcl.AttributeDataTypeValue{DataType: dt, Value: uint64(number)}, nil
+	}
+
+	if dt, ok := zclIntTypes[typeName]; ok {
+		number, ok := value.(float64)
+		if !ok || number != math.Trunc(number) {
+			return nil, fmt.Errorf("integer expected for %v, got %v", typeName, value)
+		}
+
+		return &zcl.AttributeDataTypeValue{DataType: dt, Value: int64(number)}, nil
+	}
+
+	switch typeName {
+	case "boolean":
+		b, ok := value.(bool)
+		if !ok {
+			return nil, fmt.Errorf("boolean expected, got %v", value)
+		}
+		return &zcl.AttributeDataTypeValue{DataType: zcl.TypeBoolean, Value: b}, nil
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("string expected, got %v", value)
+		}
+		return &zcl.AttributeDataTypeValue{DataType: zcl.TypeStringCharacter8, Value: s}, nil
+	case "single":
+		number, ok := value.(float64)
+		if !ok {
+			return nil, fmt.Errorf("number expected, got %v", value)
+		}
+		return &zcl.AttributeDataTypeValue{DataType: zcl.TypeFloatSingle, Value: float32(number)}, nil
+	}
+
+	return nil, fmt.Errorf("writing attributes of type '%v' is not supported", typeName)
+}
+
+// zclReportableChange returns ZCL data type of the attribute named in zcldef and the change which is reported,
+// the change is not sent for discrete types.
+func zclReportableChange(typeName string, change float64) (*zcl.AttributeDataTypeValue, error) {
+	switch typeName {
+	case "boolean":
+		return &zcl.AttributeDataTypeValue{DataType: zcl.TypeBoolean}, nil
+	case "string":
+		return &zcl.AttributeDataTypeValue{DataType: zcl.TypeStringCharacter8}, nil
+	}
+
+	ret, err := zclAttributeValue(typeName, change)
+	if err != nil {
+		return nil, fmt.Errorf("reportable change: %w", err)
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,55 @@
+package router
+
+import (
+	"testing"
+
+	"github.com/shimmeringbee/zcl"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestZclAttributeValue(t *testing.T) {
+	v, err := zclAttributeValue("uint16", float64(300))
+	assert.NoError(t, err)
+	assert.Equal(t, &zcl.AttributeDataTypeValue{DataType: zcl.TypeUnsignedInt16, Value: uint64(300)}, v)
+
+	v, err = zclAttributeValue("int8", float64(-5))
+	assert.NoError(t, err)
+	assert.Equal(t, &zcl.AttributeDataTypeValue{DataType: zcl.TypeSignedInt8, Value: int64(-5)}, v)
+
+	v, err = zclAttributeValue("utc", float64(1000))
+	assert.NoError(t, err)
+	assert.Equal(t, &zcl.AttributeDataTypeValue{DataType: zcl.TypeUTCTime, Value: zcl.UTCTime(1000)}, v)
+
+	v, err = zclAttributeValue("boolean", true)
+	assert.NoError(t, err)
+	assert.Equal(t, &zcl.AttributeDataTypeValue{DataType: zcl.TypeBoolean, Value: true}, v)
+
+	v, err = zclAttributeValue("string", "kitchen")
+	assert.NoError(t, err)
+	assert.Equal(t, &zcl.AttributeDataTypeValue{DataType: zcl.TypeStringCharacter8, Value: "kitchen"}, v)
+
+	_, err = zclAttributeValue("uint8", float64(-1))
+	assert.Error(t, err)
+
+	_, err = zclAttributeValue("uint8", 1.5)
+	assert.Error(t, err)
+
+	_, err = zclAttributeValue("boolean", "yes")
+	assert.Error(t, err)
+
+	_, err = zclAttributeValue("array", []interface{}{})
+	assert.Error(t, err)
+}
+
+func TestZclReportableChange(t *testing.T) {
+	v, err := zclReportableChange("int16", 50)
+	assert.NoError(t, err)
+	assert.Equal(t, &zcl.AttributeDataTypeValue{DataType: zcl.TypeSignedInt16, Value: int64(50)}, v)
+
+	v, err = zclReportableChange("boolean", 50)
+	assert.NoError(t, err)
+	assert.Equal(t, &zcl.AttributeDataTypeValue{DataType: zcl.TypeBoolean}, v)
+
+	_, err = zclReportableChange("uint16", 0.5)
+	assert.Error(t, err)
+}
@@ -2,8 +2,10 @@ package router
 
 import (
 	"context"
-	"log"
-	"os"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/shimmeringbee/zcl"
@@ -18,6 +20,7 @@ import (
 	"github.com/supby/gigbee2mqtt/internal/configuration"
 	"github.com/supby/gigbee2mqtt/internal/db"
 	"github.com/supby/gigbee2mqtt/internal/logger"
+	"github.com/supby/gigbee2mqtt/internal/metrics"
 	"github.com/supby/gigbee2mqtt/internal/mqtt"
 	"github.com/supby/gigbee2mqtt/internal/types"
 	"github.com/supby/gigbee2mqtt/internal/utils"
@@ -25,8 +28,13 @@ import (
 	"go.bug.st/serial.v1"
 )
 
+// eventLoopReadTimeout is the longest the event loop waits for an event, so it iterates also when the network is quiet.
+const eventLoopReadTimeout = time.Second
+
 type zigbeeRouter struct {
+	mtx                        sync.RWMutex // guards zstack and port, replaced when the coordinator is reopened
 	zstack                     *zstack.ZStack
+	nodeTable                  *zstack.NodeTable
 	configuration              *configuration.Configuration
 	zclCommandRegistry         *zcl.CommandRegistry
 	zclDefService              zcldef.ZCLDefService
@@ -36,7 +44,16 @@ type zigbeeRouter struct {
 	onDeviceJoin               func(e zigbee.NodeJoinEvent)
 	onDeviceLeave              func(e zigbee.NodeLeaveEvent)
 	onDeviceUpdate             func(e zigbee.NodeUpdateEvent)
+	onCoordinatorEvent         func(event string, msg mqtt.CoordinatorEventMessage)
+	onFatalError               func(err error)
+	permitJoinMtx              sync.Mutex // guards permitJoin and serialises changing it on the adapter
+	permitJoin                 bool       // applied to the adapter, also when it is reopened
 	logger                     logger.Logger
+	port                       serial.Port
+	inFlight                   inFlight
+	stopEventLoop              context.CancelFunc
+	eventLoopDone              chan struct{}
+	heartbeat                  int64 // unix nanoseconds of the last event loop iteration
 }
 
 func (mh *zigbeeRouter) SubscribeOnDeviceMessage(callback func(devMsg mqtt.DeviceMessage)) {
@@ -59,31 +76,62 @@ func (mh *zigbeeRouter) SubscribeOnDeviceUpdate(cb func(e zigbee.NodeUpdateEvent
 	mh.onDeviceUpdate = cb
 }
 
-func (mh *zigbeeRouter) ProccessSetDeviceConfigMessage(ctx context.Context, devCmd types.DeviceConfigSetMessage) {
-	if devCmd.PermitJoin == mh.configuration.PermitJoin {
-		return
+func (mh *zigbeeRouter) SubscribeOnFatalError(cb func(err error)) {
+	mh.onFatalError = cb
+}
+
+func (mh *zigbeeRouter) SubscribeOnCoordinatorEvent(cb func(event string, msg mqtt.CoordinatorEventMessage)) {
+	mh.onCoordinatorEvent = cb
+}
+
+func (mh *zigbeeRouter) ProccessSetDeviceConfigMessage(ctx context.Context, devCmd types.DeviceConfigSetMessage) error {
+	mh.inFlight.Start()
+	defer mh.inFlight.Done()
+
+	mh.permitJoinMtx.Lock()
+	defer mh.permitJoinMtx.Unlock()
+
+	if devCmd.PermitJoin == mh.permitJoin {
+		return nil
+	}
+
+	z := mh.coordinator()
+	if z == nil {
+		mh.logger.Warn("Error setting PermitJoin, coordinator is not available\n")
+		return ErrCoordinatorUnavailable
 	}
 
 	if devCmd.PermitJoin {
-		err := mh.zstack.PermitJoin(ctx, true)
+		err := z.PermitJoin(ctx, true)
 		if err != nil {
 			mh.logger.Error("Error PermitJoin, %v\n", err)
+			return fmt.Errorf("%w: permit join: %v", ErrCoordinatorUnavailable, err)
 		}
 	} else {
-		err := mh.zstack.DenyJoin(ctx)
+		err := z.DenyJoin(ctx)
 		if err != nil {
 			mh.logger.Error("Error DenyJoin to true, %v\n", err)
+			return fmt.Errorf("%w: deny join: %v", ErrCoordinatorUnavailable, err)
 		}
 
 	}
+
+	// remember applied state, otherwise switching back to the initial value is skipped
+	mh.permitJoin = devCmd.PermitJoin
+
+	return nil
 }
 
-func (mh *zigbeeRouter) ProccessGetDeviceDescriptionMessage(ctx context.Context, devCmd types.DeviceExploreMessage) {
-	mh.logger.Info("Quering description of node 0x%x\n", devCmd.IEEEAddress)
+func (mh *zigbeeRouter) ProccessGetDeviceDescriptionMessage(ctx context.Context, devCmd types.DeviceExploreMessage) error {
+	mh.inFlight.Start()
+	defer mh.inFlight.Done()
+
+	devLogger := mh.deviceLogger(devCmd.IEEEAddress)
+	devLogger.Info("Quering description of node\n")
 
 	if !mh.isDeviceRegistered(devCmd.IEEEAddress) {
-		mh.logger.Warn("[ProccessGetDeviceDescriptionMessage] device %v does not registered\n", devCmd.IEEEAddress)
-		return
+		devLogger.Warn("[ProccessGetDeviceDescriptionMessage] device does not registered\n")
+		return fmt.Errorf("0x%x: %w", devCmd.IEEEAddress, ErrDeviceNotFound)
 	}
 
 	ret := mqtt.DeviceDescriptionMessage{
@@ -91,29 +139,39 @@ func (mh *zigbeeRouter) ProccessGetDeviceDescriptionMessage(ctx context.Context,
 		Endpoints:   make([]mqtt.EndpointDescription, 0),
 	}
 
+	z := mh.coordinator()
+	if z == nil {
+		devLogger.Warn("[ProccessGetDeviceDescriptionMessage] coordinator is not available\n")
+		return ErrCoordinatorUnavailable
+	}
+
 	ctx, cancel := context.WithTimeout(ctx, 1*time.Minute)
 	defer cancel()
 
-	descriptor, err := mh.zstack.QueryNodeDescription(ctx, zigbee.IEEEAddress(devCmd.IEEEAddress))
+	mh.setInterviewStatus(devCmd.IEEEAddress, db.InterviewInProgress)
+
+	descriptor, err := z.QueryNodeDescription(ctx, zigbee.IEEEAddress(devCmd.IEEEAddress))
 	if err != nil {
-		mh.logger.Error("Failed to get node descriptor: %v\n", err)
-		return
+		devLogger.Error("Failed to get node descriptor: %v\n", err)
+		mh.setInterviewStatus(devCmd.IEEEAddress, db.InterviewFailed)
+		return fmt.Errorf("%w: querying node descriptor: %v", ErrDeviceUnreachable, err)
 	}
 
 	ret.LogicalType = uint8(descriptor.LogicalType)
 	ret.ManufacturerCode = uint16(descriptor.ManufacturerCode)
 
-	endpoints, err := mh.zstack.QueryNodeEndpoints(ctx, zigbee.IEEEAddress(devCmd.IEEEAddress))
+	endpoints, err := z.QueryNodeEndpoints(ctx, zigbee.IEEEAddress(devCmd.IEEEAddress))
 	if err != nil {
-		mh.logger.Error("Failed to get node endpoints: %v\n", err)
-		return
+		devLogger.Error("Failed to get node endpoints: %v\n", err)
+		mh.setInterviewStatus(devCmd.IEEEAddress, db.InterviewFailed)
+		return fmt.Errorf("%w: querying node endpoints: %v", ErrDeviceUnreachable, err)
 	}
 
 	for _, endpoint := range endpoints {
-		endpointDes, err := mh.zstack.QueryNodeEndpointDescription(ctx, zigbee.IEEEAddress(devCmd.IEEEAddress), endpoint)
+		endpointDes, err := z.QueryNodeEndpointDescription(ctx, zigbee.IEEEAddress(devCmd.IEEEAddress), endpoint)
 
 		if err != nil {
-			mh.logger.Error("Failed to get node endpoint description: %v / %d\n", err, endpoint)
+			devLogger.With("endpoint", endpoint).Error("Failed to get node endpoint description: %v\n", err)
 			continue
 		}
 
@@ -137,15 +195,101 @@ func (mh *zigbeeRouter) ProccessGetDeviceDescriptionMessage(ctx context.Context,
 		ret.Endpoints = append(ret.Endpoints, newEl)
 	}
 
+	mh.saveDeviceDescription(ret)
+	mh.requestDeviceInfo(ctx, ret)
+
 	mh.onDeviceDescriptionMessage(ret)
+
+	return nil
 }
 
-func (mh *zigbeeRouter) ProccessGetMessageToDevice(ctx context.Context, devCmd types.DeviceGetMessage) {
-	if !mh.isDeviceRegistered(devCmd.IEEEAddress) {
-		mh.logger.Warn("[ProccessGetMessageToDevice] device %v does not registered\n", devCmd.IEEEAddress)
+func (mh *zigbeeRouter) setInterviewStatus(ieeeAddress uint64, status string) {
+	_, err := mh.database.UpdateDevice(context.Background(), ieeeAddress, func(device *db.Device, exists bool) error {
+		if !exists {
+			return errNoDeviceUpdate
+		}
+		device.InterviewStatus = status
+		return nil
+	})
+	if err != nil && err != errNoDeviceUpdate {
+		mh.logger.Error("Error saving interview status of 0x%x: %v\n", ieeeAddress, err)
+	}
+}
+
+func (mh *zigbeeRouter) saveDeviceDescription(description mqtt.DeviceDescriptionMessage) {
+	_, err := mh.database.UpdateDevice(context.Background(), description.IEEEAddress, func(device *db.Device, exists bool) error {
+		if !exists {
+			return errNoDeviceUpdate
+		}
+
+		device.LogicalType = description.LogicalType
+		device.ManufacturerCode = description.ManufacturerCode
+		device.Endpoints = make([]db.Endpoint, len(description.Endpoints))
+		for i, e := range description.Endpoints {
+			device.Endpoints[i] = db.Endpoint{
+				Endpoint:       e.Endpoint,
+				ProfileID:      e.ProfileID,
+				DeviceID:       e.DeviceID,
+				DeviceVersion:  e.DeviceVersion,
+				InClusterList:  e.InClusterList,
+				OutClusterList: e.OutClusterList,
+			}
+		}
+		device.InterviewStatus = db.InterviewCompleted
+
+		return nil
+	})
+	if err != nil && err != errNoDeviceUpdate {
+		mh.logger.Error("Error saving description of 0x%x: %v\n", description.IEEEAddress, err)
+	}
+}
+
+// requestDeviceInfo reads Basic and Power Configuration attributes, device record is updated when they are received.
+func (mh *zigbeeRouter) requestDeviceInfo(ctx context.Context, description mqtt.DeviceDescriptionMessage) {
+	for clusterID, attributes := range deviceInfoAttributes {
+		for _, endpoint := range description.Endpoints {
+			if !containsCluster(endpoint.InClusterList, clusterID) {
+				continue
+			}
+
+			mh.ProccessGetMessageToDevice(ctx, types.DeviceGetMessage{
+				IEEEAddress: description.IEEEAddress,
+				ClusterID:   clusterID,
+				Endpoint:    endpoint.Endpoint,
+				Attributes:  attributes,
+			})
+			break
+		}
+	}
+}
+
+func (mh *zigbeeRouter) updateDeviceInfo(ieeeAddress uint64, clusterID uint16, attributes map[uint16]interface{}) {
+	if _, ok := deviceInfoAttributes[clusterID]; !ok {
 		return
 	}
 
+	_, err := mh.database.UpdateDevice(context.Background(), ieeeAddress, func(device *db.Device, exists bool) error {
+		if !exists || !applyDeviceAttributes(device, clusterID, attributes) {
+			return errNoDeviceUpdate
+		}
+		return nil
+	})
+	if err != nil && err != errNoDeviceUpdate {
+		mh.logger.Error("Error saving device info of 0x%x: %v\n", ieeeAddress, err)
+	}
+}
+
+func (mh *zigbeeRouter) ProccessGetMessageToDevice(ctx context.Context, devCmd types.DeviceGetMessage) error {
+	mh.inFlight.Start()
+	defer mh.inFlight.Done()
+
+	devLogger := mh.deviceLogger(devCmd.IEEEAddress).With("cluster", devCmd.ClusterID)
+
+	if !mh.isDeviceRegistered(devCmd.IEEEAddress) {
+		devLogger.Warn("[ProccessGetMessageToDevice] device does not registered\n")
+		return fmt.Errorf("0x%x: %w", devCmd.IEEEAddress, ErrDeviceNotFound)
+	}
+
 	attributeIds := make([]zcl.AttributeID, 0)
 	for _, attr := range devCmd.Attributes {
 		attributeIds = append(attributeIds, zcl.AttributeID(attr))
@@ -154,7 +298,7 @@ func (mh *zigbeeRouter) ProccessGetMessageToDevice(ctx context.Context, devCmd t
 	message := zcl.Message{
 		FrameType:           zcl.FrameGlobal,
 		Direction:           zcl.ClientToServer,
-		TransactionSequence: 1, // TODO: do something with this
+		TransactionSequence: transactionSequence(devCmd.IEEEAddress, devCmd.TransactionSequence),
 		Manufacturer:        zigbee.NoManufacturer,
 		ClusterID:           zigbee.ClusterID(devCmd.ClusterID),
 		SourceEndpoint:      zigbee.Endpoint(0x01),
@@ -167,32 +311,191 @@ func (mh *zigbeeRouter) ProccessGetMessageToDevice(ctx context.Context, devCmd t
 
 	appMsg, err := mh.zclCommandRegistry.Marshal(message)
 	if err != nil {
-		mh.logger.Error("[ProccessGetMessageToDevice] Error Marshal zcl message: %v\n", err)
-		return
+		devLogger.Error("[ProccessGetMessageToDevice] Error Marshal zcl message: %v\n", err)
+		return fmt.Errorf("%w: %v", ErrInvalidCommand, err)
+	}
+
+	z := mh.coordinator()
+	if z == nil {
+		devLogger.Warn("[ProccessGetMessageToDevice] coordinator is not available\n")
+		return ErrCoordinatorUnavailable
 	}
 
-	err = mh.zstack.SendApplicationMessageToNode(ctx, zigbee.IEEEAddress(devCmd.IEEEAddress), appMsg, false)
+	err = z.SendApplicationMessageToNode(ctx, zigbee.IEEEAddress(devCmd.IEEEAddress), appMsg, false)
 	if err != nil {
-		mh.logger.Error("[ProccessGetMessageToDevice] Error sending message: %v\n", err)
-		return
+		devLogger.Error("[ProccessGetMessageToDevice] Error sending message: %v\n", err)
+		metrics.SendError(metrics.CommandGet)
+		return fmt.Errorf("%w: %v", ErrDeviceUnreachable, err)
 	}
+	metrics.CommandSent(metrics.CommandGet)
+
+	devLogger.Info("[ProccessMessageToDevice] Message (Command: %v) is sent\n", message.CommandIdentifier)
 
-	mh.logger.Info(
-		"[ProccessMessageToDevice] Message (ClusterID: %v, Command: %v) is sent to %v device\n",
-		message.ClusterID, message.CommandIdentifier, devCmd.IEEEAddress)
+	return nil
 }
 
-func (mh *zigbeeRouter) ProccessMessageToDevice(ctx context.Context, devCmd types.DeviceCommandMessage) {
+func (mh *zigbeeRouter) ProccessWriteMessageToDevice(ctx context.Context, devCmd types.DeviceWriteMessage) error {
+	mh.inFlight.Start()
+	defer mh.inFlight.Done()
+
+	devLogger := mh.deviceLogger(devCmd.IEEEAddress).With("cluster", devCmd.ClusterID)
 
 	if !mh.isDeviceRegistered(devCmd.IEEEAddress) {
-		mh.logger.Warn("[ProccessMessageToDevice] device %v does not registered\n", devCmd.IEEEAddress)
-		return
+		devLogger.Warn("[ProccessWriteMessageToDevice] device does not registered\n")
+		return fmt.Errorf("0x%x: %w", devCmd.IEEEAddress, ErrDeviceNotFound)
+	}
+
+	clusterDef := mh.zclDefService.GetById(devCmd.ClusterID)
+
+	records := make([]global.WriteAttributesRecord, 0, len(devCmd.Attributes))
+	for id, value := range devCmd.Attributes {
+		attrDef, ok := clusterDef.Attributes[id]
+		if !ok {
+			devLogger.Error("[ProccessWriteMessageToDevice] Unknown attribute %v\n", id)
+			return fmt.Errorf("%w: unknown attribute %v of cluster %v", ErrInvalidCommand, id, devCmd.ClusterID)
+		}
+
+		dataTypeValue, err := zclAttributeValue(attrDef.Type, value)
+		if err != nil {
+			devLogger.Error("[ProccessWriteMessageToDevice] Attribute %v: %v\n", attrDef.Name, err)
+			return fmt.Errorf("%w: attribute %v: %v", ErrInvalidCommand, attrDef.Name, err)
+		}
+
+		records = append(records, global.WriteAttributesRecord{
+			Identifier:    zcl.AttributeID(id),
+			DataTypeValue: dataTypeValue,
+		})
+	}
+
+	message := zcl.Message{
+		FrameType:           zcl.FrameGlobal,
+		Direction:           zcl.ClientToServer,
+		TransactionSequence: transactionSequence(devCmd.IEEEAddress, devCmd.TransactionSequence),
+		Manufacturer:        zigbee.NoManufacturer,
+		ClusterID:           zigbee.ClusterID(devCmd.ClusterID),
+		SourceEndpoint:      zigbee.Endpoint(0x01),
+		DestinationEndpoint: zigbee.Endpoint(devCmd.Endpoint),
+		CommandIdentifier:   global.WriteAttributesID,
+		Command: &global.WriteAttributes{
+			Records: records,
+		},
+	}
+
+	appMsg, err := mh.zclCommandRegistry.Marshal(message)
+	if err != nil {
+		devLogger.Error("[ProccessWriteMessageToDevice] Error Marshal zcl message: %v\n", err)
+		return fmt.Errorf("%w: %v", ErrInvalidCommand, err)
+	}
+
+	z := mh.coordinator()
+	if z == nil {
+		devLogger.Warn("[ProccessWriteMessageToDevice] coordinator is not available\n")
+		return ErrCoordinatorUnavailable
+	}
+
+	err = z.SendApplicationMessageToNode(ctx, zigbee.IEEEAddress(devCmd.IEEEAddress), appMsg, false)
+	if err != nil {
+		devLogger.Error("[ProccessWriteMessageToDevice] Error sending message: %v\n", err)
+		metrics.SendError(metrics.CommandWrite)
+		return fmt.Errorf("%w: %v", ErrDeviceUnreachable, err)
+	}
+	metrics.CommandSent(metrics.CommandWrite)
+
+	devLogger.Info("[ProccessWriteMessageToDevice] Message (Command: %v) is sent\n", message.CommandIdentifier)
+
+	return nil
+}
+
+func (mh *zigbeeRouter) ProccessConfigureReportingMessage(ctx context.Context, devCmd types.DeviceConfigureReportingMessage) error {
+	mh.inFlight.Start()
+	defer mh.inFlight.Done()
+
+	devLogger := mh.deviceLogger(devCmd.IEEEAddress).With("cluster", devCmd.ClusterID)
+
+	if !mh.isDeviceRegistered(devCmd.IEEEAddress) {
+		devLogger.Warn("[ProccessConfigureReportingMessage] device does not registered\n")
+		return fmt.Errorf("0x%x: %w", devCmd.IEEEAddress, ErrDeviceNotFound)
+	}
+
+	clusterDef := mh.zclDefService.GetById(devCmd.ClusterID)
+
+	records := make([]global.ConfigureReportingRecord, 0, len(devCmd.Attributes))
+	for _, id := range devCmd.Attributes {
+		attrDef, ok := clusterDef.Attributes[id]
+		if !ok {
+			devLogger.Error("[ProccessConfigureReportingMessage] Unknown attribute %v\n", id)
+			return fmt.Errorf("%w: unknown attribute %v of cluster %v", ErrInvalidCommand, id, devCmd.ClusterID)
+		}
+
+		change, err := zclReportableChange(attrDef.Type, devCmd.ReportableChange)
+		if err != nil {
+			devLogger.Error("[ProccessConfigureReportingMessage] Attribute %v: %v\n", attrDef.Name, err)
+			return fmt.Errorf("%w: attribute %v: %v", ErrInvalidCommand, attrDef.Name, err)
+		}
+
+		records = append(records, global.ConfigureReportingRecord{
+			Identifier:       zcl.AttributeID(id),
+			DataType:         change.DataType,
+			MinimumInterval:  devCmd.MinInterval,
+			MaximumInterval:  devCmd.MaxInterval,
+			ReportableChange: &zcl.AttributeDataValue{Value: change.Value},
+		})
+	}
+
+	message := zcl.Message{
+		FrameType:           zcl.FrameGlobal,
+		Direction:           zcl.ClientToServer,
+		TransactionSequence: transactionSequence(devCmd.IEEEAddress, devCmd.TransactionSequence),
+		Manufacturer:        zigbee.NoManufacturer,
+		ClusterID:           zigbee.ClusterID(devCmd.ClusterID),
+		SourceEndpoint:      zigbee.Endpoint(0x01),
+		DestinationEndpoint: zigbee.Endpoint(devCmd.Endpoint),
+		CommandIdentifier:   global.ConfigureReportingID,
+		Command: &global.ConfigureReporting{
+			Records: records,
+		},
+	}
+
+	appMsg, err := mh.zclCommandRegistry.Marshal(message)
+	if err != nil {
+		devLogger.Error("[ProccessConfigureReportingMessage] Error Marshal zcl message: %v\n", err)
+		return fmt.Errorf("%w: %v", ErrInvalidCommand, err)
+	}
+
+	z := mh.coordinator()
+	if z == nil {
+		devLogger.Warn("[ProccessConfigureReportingMessage] coordinator is not available\n")
+		return ErrCoordinatorUnavailable
+	}
+
+	err = z.SendApplicationMessageToNode(ctx, zigbee.IEEEAddress(devCmd.IEEEAddress), appMsg, false)
+	if err != nil {
+		devLogger.Error("[ProccessConfigureReportingMessage] Error sending message: %v\n", err)
+		metrics.SendError(metrics.CommandReporting)
+		return fmt.Errorf("%w: %v", ErrDeviceUnreachable, err)
+	}
+	metrics.CommandSent(metrics.CommandReporting)
+
+	devLogger.Info("[ProccessConfigureReportingMessage] Message (Command: %v) is sent\n", message.CommandIdentifier)
+
+	return nil
+}
+
+func (mh *zigbeeRouter) ProccessMessageToDevice(ctx context.Context, devCmd types.DeviceCommandMessage) error {
+	mh.inFlight.Start()
+	defer mh.inFlight.Done()
+
+	devLogger := mh.deviceLogger(devCmd.IEEEAddress).With("cluster", devCmd.ClusterID)
+
+	if !mh.isDeviceRegistered(devCmd.IEEEAddress) {
+		devLogger.Warn("[ProccessMessageToDevice] device does not registered\n")
+		return fmt.Errorf("0x%x: %w", devCmd.IEEEAddress, ErrDeviceNotFound)
 	}
 
 	message := zcl.Message{
 		FrameType:           zcl.FrameLocal,
 		Direction:           zcl.ClientToServer,
-		TransactionSequence: 1, // TODO: do something with this
+		TransactionSequence: transactionSequence(devCmd.IEEEAddress, devCmd.TransactionSequence),
 		Manufacturer:        zigbee.NoManufacturer,
 		ClusterID:           zigbee.ClusterID(devCmd.ClusterID),
 		SourceEndpoint:      zigbee.Endpoint(0x01),
@@ -202,13 +505,13 @@ func (mh *zigbeeRouter) ProccessMessageToDevice(ctx context.Context, devCmd type
 
 	command, err := mh.zclCommandRegistry.GetLocalCommand(message.ClusterID, message.Manufacturer, message.Direction, message.CommandIdentifier)
 	if err != nil {
-		mh.logger.Error("[ProccessMessageToDevice] Error Local command for ClusterID: %v, Manufacturer: %v, Direction: %v, CommandIdentifier: %v. Error: %v \n",
+		devLogger.Error("[ProccessMessageToDevice] Error Local command for ClusterID: %v, Manufacturer: %v, Direction: %v, CommandIdentifier: %v. Error: %v \n",
 			message.ClusterID,
 			message.Manufacturer,
 			message.Direction,
 			message.CommandIdentifier,
 			err)
-		return
+		return fmt.Errorf("%w: command %v of cluster %v: %v", ErrInvalidCommand, message.CommandIdentifier, message.ClusterID, err)
 	}
 
 	utils.SetStructProperties(devCmd.CommandData, command)
@@ -217,37 +520,92 @@ func (mh *zigbeeRouter) ProccessMessageToDevice(ctx context.Context, devCmd type
 
 	appMsg, err := mh.zclCommandRegistry.Marshal(message)
 	if err != nil {
-		mh.logger.Error("[ProccessMessageToDevice] Error Marshal zcl message: %v\n", err)
-		return
+		devLogger.Error("[ProccessMessageToDevice] Error Marshal zcl message: %v\n", err)
+		return fmt.Errorf("%w: %v", ErrInvalidCommand, err)
+	}
+
+	z := mh.coordinator()
+	if z == nil {
+		devLogger.Warn("[ProccessMessageToDevice] coordinator is not available\n")
+		return ErrCoordinatorUnavailable
 	}
 
 	// timeoutCtx, timeoutCancel := context.WithTimeout(ctx, time.Minute)
 	// defer timeoutCancel()
 
-	//err = mh.zstack.SendApplicationMessageToNode(timeoutCtx, zigbee.IEEEAddress(devCmd.IEEEAddress), appMsg, true)
-	err = mh.zstack.SendApplicationMessageToNode(ctx, zigbee.IEEEAddress(devCmd.IEEEAddress), appMsg, false)
+	//err = z.SendApplicationMessageToNode(timeoutCtx, zigbee.IEEEAddress(devCmd.IEEEAddress), appMsg, true)
+	err = z.SendApplicationMessageToNode(ctx, zigbee.IEEEAddress(devCmd.IEEEAddress), appMsg, false)
 	if err != nil {
-		mh.logger.Error("[ProccessMessageToDevice] Error sending message: %v\n", err)
-		return
+		devLogger.Error("[ProccessMessageToDevice] Error sending message: %v\n", err)
+		metrics.SendError(metrics.CommandSet)
+		return fmt.Errorf("%w: %v", ErrDeviceUnreachable, err)
 	}
+	metrics.CommandSent(metrics.CommandSet)
+
+	devLogger.Info("[ProccessMessageToDevice] Message (Command: %v) is sent\n", message.CommandIdentifier)
 
-	mh.logger.Info(
-		"[ProccessMessageToDevice] Message (ClusterID: %v, Command: %v) is sent to %v device\n",
-		message.ClusterID, message.CommandIdentifier, devCmd.IEEEAddress)
+	return nil
 }
 
-func saveNodeDB(znode zigbee.Node, dbObj db.DeviceDB) {
-	newDevice := db.Device{
-		IEEEAddress:    uint64(znode.IEEEAddress),
-		NetworkAddress: uint16(znode.NetworkAddress),
-		LogicalType:    uint8(znode.LogicalType),
-		LQI:            znode.LQI,
-		Depth:          znode.Depth,
-		LastDiscovered: znode.LastDiscovered,
-		LastReceived:   znode.LastReceived,
+// ProccessRemoveMessage asks the device to leave the network and removes it from the database,
+// device_removed event is published by the database.
+func (mh *zigbeeRouter) ProccessRemoveMessage(ctx context.Context, devCmd types.DeviceRemoveMessage) error {
+	mh.inFlight.Start()
+	defer mh.inFlight.Done()
+
+	devLogger := mh.deviceLogger(devCmd.IEEEAddress)
+
+	z := mh.coordinator()
+	if z == nil {
+		devLogger.Warn("[ProccessRemoveMessage] coordinator is not available\n")
+		return ErrCoordinatorUnavailable
+	}
+
+	err := z.RequestNodeLeave(ctx, zigbee.IEEEAddress(devCmd.IEEEAddress))
+	if err != nil {
+		if !devCmd.Force {
+			devLogger.Error("[ProccessRemoveMessage] Device did not leave: %v\n", err)
+			return fmt.Errorf("0x%x: %w: %v", devCmd.IEEEAddress, ErrDeviceNotRemoved, err)
+		}
+
+		devLogger.Warn("[ProccessRemoveMessage] Device did not leave, removing by force: %v\n", err)
+		err = z.ForceNodeLeave(ctx, zigbee.IEEEAddress(devCmd.IEEEAddress))
+		if err != nil {
+			devLogger.Warn("[ProccessRemoveMessage] %v\n", err)
+		}
+	}
+
+	err = mh.database.DeleteDevice(ctx, devCmd.IEEEAddress)
+	if err != nil {
+		devLogger.Error("[ProccessRemoveMessage] Error removing device: %v\n", err)
+		return err
 	}
 
-	dbObj.SaveDevice(context.Background(), newDevice)
+	return nil
+}
+
+// saveNodeDB updates network state of the device keeping interview results and user fields.
+func saveNodeDB(znode zigbee.Node, dbObj db.DeviceDB) {
+	dbObj.UpdateDevice(context.Background(), uint64(znode.IEEEAddress), func(device *db.Device, exists bool) error {
+		device.NetworkAddress = uint16(znode.NetworkAddress)
+		device.LogicalType = uint8(znode.LogicalType)
+		device.LQI = znode.LQI
+		device.Depth = znode.Depth
+		device.LastDiscovered = znode.LastDiscovered
+		device.LastReceived = znode.LastReceived
+
+		if !exists {
+			device.InterviewStatus = db.InterviewPending
+			device.PowerSource = db.PowerSourceUnknown
+		}
+
+		return nil
+	})
+}
+
+// deviceLogger adds address of the device, as in its MQTT topic, to logged lines.
+func (mh *zigbeeRouter) deviceLogger(ieeeAddress uint64) logger.Logger {
+	return mh.logger.With("device", fmt.Sprintf("0x%x", ieeeAddress))
 }
 
 func (mh *zigbeeRouter) isDeviceRegistered(IEEEAddress uint64) bool {
@@ -257,7 +615,7 @@ func (mh *zigbeeRouter) isDeviceRegistered(IEEEAddress uint64) bool {
 }
 
 func (mh *zigbeeRouter) processNodeJoin(e zigbee.NodeJoinEvent) {
-	go saveNodeDB(e.Node, mh.database)
+	mh.inFlight.Go(func() { saveNodeDB(e.Node, mh.database) })
 
 	if mh.onDeviceJoin != nil {
 		mh.onDeviceJoin(e)
@@ -265,6 +623,10 @@ func (mh *zigbeeRouter) processNodeJoin(e zigbee.NodeJoinEvent) {
 }
 
 func (mh *zigbeeRouter) processNodeLeave(e zigbee.NodeLeaveEvent) {
+	err := mh.database.DeleteDevice(context.Background(), uint64(e.IEEEAddress))
+	if err != nil {
+		mh.logger.Error("Error removing device 0x%x: %v\n", uint64(e.IEEEAddress), err)
+	}
 
 	if mh.onDeviceLeave != nil {
 		mh.onDeviceLeave(e)
@@ -272,7 +634,7 @@ func (mh *zigbeeRouter) processNodeLeave(e zigbee.NodeLeaveEvent) {
 }
 
 func (mh *zigbeeRouter) processNodeUpdate(e zigbee.NodeUpdateEvent) {
-	go saveNodeDB(e.Node, mh.database)
+	mh.inFlight.Go(func() { saveNodeDB(e.Node, mh.database) })
 
 	if mh.onDeviceUpdate != nil {
 		mh.onDeviceUpdate(e)
@@ -280,24 +642,34 @@ func (mh *zigbeeRouter) processNodeUpdate(e zigbee.NodeUpdateEvent) {
 }
 
 func (mh *zigbeeRouter) processIncomingMessage(e zigbee.NodeIncomingMessageEvent) {
-	go saveNodeDB(e.Node, mh.database)
+	mh.inFlight.Go(func() { saveNodeDB(e.Node, mh.database) })
 	msg := e.IncomingMessage
+	devLogger := mh.deviceLogger(uint64(msg.SourceAddress.IEEEAddress)).With("cluster", uint16(msg.ApplicationMessage.ClusterID))
+
 	message, err := mh.zclCommandRegistry.Unmarshal(msg.ApplicationMessage)
 	if err != nil {
-		mh.logger.Error("[ProcessIncomingMessage] Error parse incomming message: %v\n", err)
+		devLogger.Error("[ProcessIncomingMessage] Error parse incomming message: %v\n", err)
+		metrics.UnmarshalError()
 		return
 	}
 
-	mh.logger.Info("[ProcessIncomingMessage] Incomming command of type (%T) is received. ClusterId=%v, SourceEndpoint=%v\n",
-		message.Command, message.ClusterID, message.SourceEndpoint)
+	metrics.IncomingMessage(mh.clusterLabel(uint16(message.ClusterID)))
+
+	devLogger.With("transaction", message.TransactionSequence).Info(
+		"[ProcessIncomingMessage] Incomming command of type (%T) is received. SourceEndpoint=%v\n",
+		message.Command, message.SourceEndpoint)
 
 	switch cmd := message.Command.(type) {
 	case *global.ReportAttributes:
 		mh.processReportAttributes(msg, cmd)
 	case *global.DefaultResponse:
-		mh.processDefaultResponse(msg, cmd)
+		mh.processDefaultResponse(msg, message.TransactionSequence, cmd)
 	case *global.ReadAttributesResponse:
-		mh.processReadAttributesResponse(msg, cmd)
+		mh.processReadAttributesResponse(msg, message.TransactionSequence, cmd)
+	case *global.WriteAttributesResponse:
+		mh.processWriteAttributesResponse(msg, message.TransactionSequence, cmd)
+	case *global.ConfigureReportingResponse:
+		mh.processConfigureReportingResponse(msg, message.TransactionSequence, cmd)
 	case *ias_zone.ZoneStatusChangeNotification:
 		mh.processZoneStatusChangeNotification(msg, cmd)
 	}
@@ -324,12 +696,13 @@ func (mh *zigbeeRouter) processZoneStatusChangeNotification(msg zigbee.IncomingM
 	}
 }
 
-func (mh *zigbeeRouter) processReadAttributesResponse(msg zigbee.IncomingMessage, cmd *global.ReadAttributesResponse) {
+func (mh *zigbeeRouter) processReadAttributesResponse(msg zigbee.IncomingMessage, tsn uint8, cmd *global.ReadAttributesResponse) {
 	clusterDef := mh.zclDefService.GetById(uint16(msg.ApplicationMessage.ClusterID))
 
 	mqttMessage := mqtt.DeviceMessage{
-		IEEEAddress: uint64(msg.SourceAddress.IEEEAddress),
-		LinkQuality: msg.LinkQuality,
+		IEEEAddress:         uint64(msg.SourceAddress.IEEEAddress),
+		LinkQuality:         msg.LinkQuality,
+		TransactionSequence: tsn,
 	}
 
 	deviceMessage := mqtt.DeviceAttributesReportMessage{
@@ -338,11 +711,15 @@ func (mh *zigbeeRouter) processReadAttributesResponse(msg zigbee.IncomingMessage
 	}
 
 	clusterAttr := make(map[string]interface{})
+	attrValues := make(map[uint16]interface{})
 	for _, r := range cmd.Records {
 		attrDef := clusterDef.Attributes[uint16(r.Identifier)]
 		clusterAttr[attrDef.Name] = r.DataTypeValue.Value
+		attrValues[uint16(r.Identifier)] = r.DataTypeValue.Value
 	}
 
+	mh.updateDeviceInfo(mqttMessage.IEEEAddress, uint16(msg.ApplicationMessage.ClusterID), attrValues)
+
 	deviceMessage.ClusterAttributes = clusterAttr
 
 	mqttMessage.Message = deviceMessage
@@ -366,11 +743,15 @@ func (mh *zigbeeRouter) processReportAttributes(msg zigbee.IncomingMessage, cmd
 	}
 
 	clusterAttr := make(map[string]interface{})
+	attrValues := make(map[uint16]interface{})
 	for _, r := range cmd.Records {
 		attrDef := clusterDef.Attributes[uint16(r.Identifier)]
 		clusterAttr[attrDef.Name] = r.DataTypeValue.Value
+		attrValues[uint16(r.Identifier)] = r.DataTypeValue.Value
 	}
 
+	mh.updateDeviceInfo(mqttMessage.IEEEAddress, uint16(msg.ApplicationMessage.ClusterID), attrValues)
+
 	deviceMessage.ClusterAttributes = clusterAttr
 
 	mqttMessage.Message = deviceMessage
@@ -380,10 +761,61 @@ func (mh *zigbeeRouter) processReportAttributes(msg zigbee.IncomingMessage, cmd
 	}
 }
 
-func (mh *zigbeeRouter) processDefaultResponse(msg zigbee.IncomingMessage, cmd *global.DefaultResponse) {
+func (mh *zigbeeRouter) processWriteAttributesResponse(msg zigbee.IncomingMessage, tsn uint8, cmd *global.WriteAttributesResponse) {
+	response := mqtt.DeviceWriteAttributesResponseMessage{
+		ClusterID: uint16(msg.ApplicationMessage.ClusterID),
+		Records:   make([]mqtt.WriteAttributeStatus, len(cmd.Records)),
+	}
+
+	for i, r := range cmd.Records {
+		response.Records[i] = mqtt.WriteAttributeStatus{
+			AttributeID: uint16(r.Identifier),
+			Status:      r.Status,
+		}
+	}
+
 	mqttMessage := mqtt.DeviceMessage{
-		IEEEAddress: uint64(msg.SourceAddress.IEEEAddress),
-		LinkQuality: msg.LinkQuality,
+		IEEEAddress:         uint64(msg.SourceAddress.IEEEAddress),
+		LinkQuality:         msg.LinkQuality,
+		TransactionSequence: tsn,
+		Message:             response,
+	}
+
+	if mh.onDeviceMessage != nil {
+		mh.onDeviceMessage(mqttMessage)
+	}
+}
+
+func (mh *zigbeeRouter) processConfigureReportingResponse(msg zigbee.IncomingMessage, tsn uint8, cmd *global.ConfigureReportingResponse) {
+	response := mqtt.DeviceConfigureReportingResponseMessage{
+		ClusterID: uint16(msg.ApplicationMessage.ClusterID),
+		Records:   make([]mqtt.WriteAttributeStatus, len(cmd.Records)),
+	}
+
+	for i, r := range cmd.Records {
+		response.Records[i] = mqtt.WriteAttributeStatus{
+			AttributeID: uint16(r.Identifier),
+			Status:      r.Status,
+		}
+	}
+
+	mqttMessage := mqtt.DeviceMessage{
+		IEEEAddress:         uint64(msg.SourceAddress.IEEEAddress),
+		LinkQuality:         msg.LinkQuality,
+		TransactionSequence: tsn,
+		Message:             response,
+	}
+
+	if mh.onDeviceMessage != nil {
+		mh.onDeviceMessage(mqttMessage)
+	}
+}
+
+func (mh *zigbeeRouter) processDefaultResponse(msg zigbee.IncomingMessage, tsn uint8, cmd *global.DefaultResponse) {
+	mqttMessage := mqtt.DeviceMessage{
+		IEEEAddress:         uint64(msg.SourceAddress.IEEEAddress),
+		LinkQuality:         msg.LinkQuality,
+		TransactionSequence: tsn,
 		Message: mqtt.DeviceDefaultResponseMessage{
 			ClusterID:         uint16(msg.ApplicationMessage.ClusterID),
 			CommandIdentifier: cmd.CommandIdentifier,
@@ -410,37 +842,140 @@ func NewZigbeeRouter(
 	ias_zone.Register(zclCommandRegistry)
 
 	ret := zigbeeRouter{
+		nodeTable:          zstack.NewNodeTable(),
 		configuration:      cfg,
 		zclCommandRegistry: zclCommandRegistry,
 		zclDefService:      zclDefService,
 		database:           database,
 		logger:             logger.GetLogger("[Zigbee Router]", cfg.LogLevel),
+		permitJoin:         cfg.PermitJoin,
 	}
 
 	return &ret
 }
 
-func (mh *zigbeeRouter) StartAsync(ctx context.Context) {
-	z, err := mh.initZStack(ctx)
-	if err != nil {
-		mh.logger.Error("zstack initialization error: %v\n", err)
-		os.Exit(1)
+// StartAsync initialises the coordinator and starts reading its events, error is returned when the coordinator
+// can not be initialised. The coordinator lost later is reopened in the background.
+func (mh *zigbeeRouter) StartAsync(ctx context.Context) error {
+	if err := mh.initZStack(ctx); err != nil {
+		return fmt.Errorf("zstack initialization error: %w", err)
+	}
+	metrics.SetCoordinatorConnected(true)
+
+	supervisor := coordinatorSupervisor{
+		run:   mh.startEventLoop,
+		open:  mh.initZStack,
+		close: mh.closeCoordinator,
+		beat:  mh.beat,
+		onLost: func(err error) {
+			metrics.SetCoordinatorConnected(false)
+			mh.publishCoordinatorEvent(COORDINATOR_LOST, mqtt.CoordinatorEventMessage{Error: err.Error()})
+		},
+		onRecovered: func(attempts int, downtime time.Duration) {
+			metrics.SetCoordinatorConnected(true)
+			mh.publishCoordinatorEvent(COORDINATOR_RECOVERED, mqtt.CoordinatorEventMessage{
+				Attempts:        attempts,
+				DowntimeSeconds: downtime.Seconds(),
+			})
+		},
+		minBackoff:  reopenMinBackoff,
+		maxBackoff:  reopenMaxBackoff,
+		maxDowntime: reopenMaxDowntime,
+		logger:      mh.logger,
 	}
 
-	mh.zstack = z
+	loopCtx, cancel := context.WithCancel(ctx)
+	mh.stopEventLoop = cancel
+	mh.eventLoopDone = make(chan struct{})
+
+	go func() {
+		defer close(mh.eventLoopDone)
+		if err := supervisor.Run(loopCtx); err != nil {
+			mh.logger.Error("%v\n", err)
+			if mh.onFatalError != nil {
+				mh.onFatalError(err)
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (mh *zigbeeRouter) publishCoordinatorEvent(event string, msg mqtt.CoordinatorEventMessage) {
+	msg.Time = time.Now()
+	if mh.onCoordinatorEvent != nil {
+		mh.onCoordinatorEvent(event, msg)
+	}
+}
+
+// coordinator returns nil while the coordinator is lost and not reopened yet.
+func (mh *zigbeeRouter) coordinator() *zstack.ZStack {
+	mh.mtx.RLock()
+	defer mh.mtx.RUnlock()
+
+	return mh.zstack
+}
+
+func (mh *zigbeeRouter) Nodes() []zigbee.Node {
+	return mh.nodeTable.Nodes()
+}
+
+func (mh *zigbeeRouter) beat() {
+	atomic.StoreInt64(&mh.heartbeat, time.Now().UnixNano())
+}
+
+func (mh *zigbeeRouter) Heartbeat() time.Time {
+	if ns := atomic.LoadInt64(&mh.heartbeat); ns != 0 {
+		return time.Unix(0, ns)
+	}
+
+	return time.Time{}
+}
+
+func (mh *zigbeeRouter) AdapterNode() zigbee.Node {
+	z := mh.coordinator()
+	if z == nil {
+		return zigbee.Node{}
+	}
 
-	go mh.startEventLoop(ctx)
+	return z.AdapterNode()
 }
 
+// Drain waits for commands and events being processed, new ones are still accepted.
+func (mh *zigbeeRouter) Drain(ctx context.Context) error {
+	return mh.inFlight.Wait(ctx)
+}
+
+// Stop stops the event loop and closes the serial port.
 func (mh *zigbeeRouter) Stop() {
-	if mh.zstack == nil {
+	if mh.stopEventLoop == nil {
 		return
 	}
 
-	mh.zstack.Stop()
+	mh.stopEventLoop()
+	<-mh.eventLoopDone
+
+	mh.closeCoordinator()
 }
 
-func (mh *zigbeeRouter) initZStack(ctx context.Context) (*zstack.ZStack, error) {
+func (mh *zigbeeRouter) closeCoordinator() {
+	mh.mtx.Lock()
+	z, port := mh.zstack, mh.port
+	mh.zstack, mh.port = nil, nil
+	mh.mtx.Unlock()
+
+	if z == nil {
+		return
+	}
+
+	z.Stop()
+	if err := port.Close(); err != nil {
+		mh.logger.Error("Error closing serial port: %v\n", err)
+	}
+}
+
+// initZStack opens the serial port and initialises the adapter with the node table stored in the database.
+func (mh *zigbeeRouter) initZStack(ctx context.Context) error {
 	initCtx, cancel := context.WithTimeout(ctx, 2*time.Minute)
 	defer cancel()
 
@@ -448,18 +983,34 @@ func (mh *zigbeeRouter) initZStack(ctx context.Context) (*zstack.ZStack, error)
 		BaudRate: int(mh.configuration.SerialConfiguration.BaudRate),
 	}
 
-	port, err := serial.Open(mh.configuration.SerialConfiguration.PortName, mode)
+	serialPort, err := serial.Open(mh.configuration.SerialConfiguration.PortName, mode)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	port.SetRTS(true)
+	serialPort.SetRTS(true)
+	port := &coordinatorPort{Port: serialPort}
 
+	z, err := mh.initAdapter(initCtx, port)
+	if err != nil {
+		port.Close()
+		return err
+	}
+
+	mh.mtx.Lock()
+	mh.zstack = z
+	mh.port = port
+	mh.mtx.Unlock()
+
+	return nil
+}
+
+func (mh *zigbeeRouter) initAdapter(initCtx context.Context, port serial.Port) (*zstack.ZStack, error) {
 	/* Construct node table, cache of network nodes. */
 	dbDevices, err := mh.database.GetDevices(initCtx)
 	if err != nil {
 		return nil, err
 	}
-	t := zstack.NewNodeTable()
+	t := mh.nodeTable
 	znodes := make([]zigbee.Node, len(dbDevices))
 	for i, dbNode := range dbDevices {
 		znodes[i] = zigbee.Node{
@@ -487,10 +1038,15 @@ func (mh *zigbeeRouter) initZStack(ctx context.Context) (*zstack.ZStack, error)
 	/* Initialise ZStack and CC253X */
 	err = z.Initialise(initCtx, netCfg)
 	if err != nil {
-		log.Fatal(err)
+		z.Stop()
+		return nil, fmt.Errorf("initialising adapter: %w", err)
 	}
 
-	if mh.configuration.PermitJoin {
+	mh.permitJoinMtx.Lock()
+	permitJoin := mh.permitJoin
+	mh.permitJoinMtx.Unlock()
+
+	if permitJoin {
 		err = z.PermitJoin(initCtx, true)
 		if err != nil {
 			mh.logger.Error("error permit join: %v\n", err)
@@ -510,39 +1066,74 @@ func (mh *zigbeeRouter) initZStack(ctx context.Context) (*zstack.ZStack, error)
 		1,
 		[]zigbee.ClusterID{},
 		[]zigbee.ClusterID{}); err != nil {
-		log.Fatal(err)
+		z.Stop()
+		return nil, fmt.Errorf("registering adapter endpoint: %w", err)
 	}
 
 	return z, nil
 }
 
-func (mh *zigbeeRouter) startEventLoop(ctx context.Context) {
+// dispatchEvent processes event in its own goroutine tracking number of events in flight.
+func (mh *zigbeeRouter) dispatchEvent(process func()) {
+	metrics.EventStarted()
+	mh.inFlight.Go(func() {
+		defer metrics.EventDone()
+		process()
+	})
+}
+
+func (mh *zigbeeRouter) clusterLabel(clusterID uint16) string {
+	if def := mh.zclDefService.GetById(clusterID); def.Name != "" {
+		return def.Name
+	}
+
+	return strconv.Itoa(int(clusterID))
+}
+
+// startEventLoop processes events of the coordinator until ctx is done, error is returned when the coordinator
+// is lost: probing the adapter fails adapterMaxFailures times in a row. ReadEvent of zstack fails only when
+// its context is done, so a lost adapter is never seen there and is detected by probeAdapter alone.
+func (mh *zigbeeRouter) startEventLoop(ctx context.Context) error {
+	z := mh.coordinator()
+
+	probeCtx, stopProbe := context.WithCancel(ctx)
+	defer stopProbe()
+	lost := make(chan error, 1)
+	go probeAdapter(probeCtx, z, adapterProbeInterval, lost)
+
 	mh.logger.Info("[Event loop] Start event")
 	for {
 		select {
 		case <-ctx.Done():
-			return
+			return nil
+		case err := <-lost:
+			return err
 		default:
 		}
 
-		event, err := mh.zstack.ReadEvent(ctx)
+		mh.beat()
+
+		readCtx, cancel := context.WithTimeout(ctx, eventLoopReadTimeout)
+		event, err := z.ReadEvent(readCtx)
+		cancel()
 		if err != nil {
-			mh.logger.Error("[Event loop] Error read event: %v\n", err)
+			// no event within the timeout or ctx is done
+			continue
 		}
 
 		switch e := event.(type) {
 		case zigbee.NodeJoinEvent:
 			mh.logger.Info("[Event loop] Node join: %v\n", e)
-			go mh.processNodeJoin(e)
+			mh.dispatchEvent(func() { mh.processNodeJoin(e) })
 		case zigbee.NodeLeaveEvent:
 			mh.logger.Info("[Event loop] Node leave: %v\n", e)
-			go mh.processNodeLeave(e)
+			mh.dispatchEvent(func() { mh.processNodeLeave(e) })
 		case zigbee.NodeUpdateEvent:
 			mh.logger.Debug("[Event loop] Node update: %v\n", e)
-			go mh.processNodeUpdate(e)
+			mh.dispatchEvent(func() { mh.processNodeUpdate(e) })
 		case zigbee.NodeIncomingMessageEvent:
 			mh.logger.Debug("[Event loop] Node message: %v\n", e)
-			go mh.processIncomingMessage(e)
+			mh.dispatchEvent(func() { mh.processIncomingMessage(e) })
 		}
 	}
 }
@@ -0,0 +1,106 @@
+package systemd
+
+import (
+	"context"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// States sent to systemd, see sd_notify(3).
+const (
+	READY    = "READY=1"
+	STOPPING = "STOPPING=1"
+	WATCHDOG = "WATCHDOG=1"
+	STATUS   = "STATUS="
+)
+
+// Notifier sends state of the service to systemd over the socket in NOTIFY_SOCKET. It does nothing
+// when the gateway is not started by systemd as Type=notify service.
+type Notifier struct {
+	socket string
+}
+
+func NewNotifier() *Notifier {
+	return &Notifier{
+		socket: os.Getenv("NOTIFY_SOCKET"),
+	}
+}
+
+func (n *Notifier) Enabled() bool {
+	return n.socket != ""
+}
+
+// Notify sends states, e.g. READY and STATUS, in one datagram.
+func (n *Notifier) Notify(states ...string) error {
+	if !n.Enabled() {
+		return nil
+	}
+
+	addr := &net.UnixAddr{Name: n.socket, Net: "unixgram"}
+	// abstract socket namespace
+	if strings.HasPrefix(addr.Name, "@") {
+		addr.Name = "\x00" + addr.Name[1:]
+	}
+
+	conn, err := net.DialUnix(addr.Net, nil, addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(strings.Join(states, "\n")))
+
+	return err
+}
+
+func (n *Notifier) Ready(status string) error {
+	return n.Notify(READY, STATUS+status)
+}
+
+func (n *Notifier) Status(status string) error {
+	return n.Notify(STATUS + status)
+}
+
+func (n *Notifier) Stopping() error {
+	return n.Notify(STOPPING)
+}
+
+// RunWatchdog pings systemd twice per interval while alive returns true, until ctx is done. Without pings
+// systemd restarts the service after WatchdogSec.
+func (n *Notifier) RunWatchdog(ctx context.Context, interval time.Duration, alive func() bool) {
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if alive() {
+			n.Notify(WATCHDOG)
+		}
+	}
+}
+
+// WatchdogInterval returns WatchdogSec of the service, zero when the watchdog is not enabled for this process.
+func WatchdogInterval() time.Duration {
+	return watchdogInterval(os.Getenv, os.Getpid())
+}
+
+func watchdogInterval(getenv func(string) string, pid int) time.Duration {
+	if watchdogPID := getenv("WATCHDOG_PID"); watchdogPID != "" && watchdogPID != strconv.Itoa(pid) {
+		return 0
+	}
+
+	usec, err := strconv.ParseInt(getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+
+	return time.Duration(usec) * time.Microsecond
+}
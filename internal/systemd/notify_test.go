@@ -0,0 +1,88 @@
+package systemd
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func listenNotifySocket(t *testing.T) (*Notifier, *net.UnixConn) {
+	socket := filepath.Join(t.TempDir(), "notify.sock")
+
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return &Notifier{socket: socket}, conn
+}
+
+func readState(t *testing.T, conn *net.UnixConn) string {
+	buf := make([]byte, 1024)
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	assert.NoError(t, err)
+
+	return string(buf[:n])
+}
+
+func TestNotify(t *testing.T) {
+	n, conn := listenNotifySocket(t)
+
+	assert.NoError(t, n.Ready("12 devices"))
+	assert.Equal(t, "READY=1\nSTATUS=12 devices", readState(t, conn))
+
+	assert.NoError(t, n.Stopping())
+	assert.Equal(t, "STOPPING=1", readState(t, conn))
+}
+
+func TestNotifyDisabled(t *testing.T) {
+	n := &Notifier{}
+
+	assert.False(t, n.Enabled())
+	assert.NoError(t, n.Ready(""))
+}
+
+func TestRunWatchdog(t *testing.T) {
+	n, conn := listenNotifySocket(t)
+
+	var alive int32 = 1
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go n.RunWatchdog(ctx, 20*time.Millisecond, func() bool { return atomic.LoadInt32(&alive) == 1 })
+
+	assert.Equal(t, "WATCHDOG=1", readState(t, conn))
+
+	atomic.StoreInt32(&alive, 0)
+	time.Sleep(30 * time.Millisecond)
+	conn.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	for {
+		// drain pings sent before alive was cleared
+		if _, err := conn.Read(make([]byte, 64)); err != nil {
+			break
+		}
+	}
+
+	conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	_, err := conn.Read(make([]byte, 64))
+	assert.Error(t, err, "no ping while not alive")
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	env := map[string]string{"WATCHDOG_USEC": "30000000", "WATCHDOG_PID": "42"}
+	getenv := func(name string) string { return env[name] }
+
+	assert.Equal(t, 30*time.Second, watchdogInterval(getenv, 42))
+	assert.Equal(t, time.Duration(0), watchdogInterval(getenv, 43))
+
+	delete(env, "WATCHDOG_PID")
+	assert.Equal(t, 30*time.Second, watchdogInterval(getenv, 43))
+
+	delete(env, "WATCHDOG_USEC")
+	assert.Equal(t, time.Duration(0), watchdogInterval(getenv, 43))
+}
@@ -1,24 +1,55 @@
 package types
 
+// TransactionSequence of device commands is the ZCL transaction sequence number the answer of the device
+// carries, a new one is allocated when it is 0.
 type DeviceCommandMessage struct {
-	IEEEAddress       uint64
-	ClusterID         uint16
-	Endpoint          uint8
-	CommandIdentifier uint8
-	CommandData       map[string]interface{}
+	IEEEAddress         uint64
+	ClusterID           uint16
+	Endpoint            uint8
+	CommandIdentifier   uint8
+	CommandData         map[string]interface{}
+	TransactionSequence uint8
 }
 
 type DeviceGetMessage struct {
-	IEEEAddress uint64
-	ClusterID   uint16
-	Endpoint    uint8
-	Attributes  []uint16
+	IEEEAddress         uint64
+	ClusterID           uint16
+	Endpoint            uint8
+	Attributes          []uint16
+	TransactionSequence uint8
+}
+
+// DeviceWriteMessage writes attribute values, data types are taken from zcldef.
+type DeviceWriteMessage struct {
+	IEEEAddress         uint64
+	ClusterID           uint16
+	Endpoint            uint8
+	Attributes          map[uint16]interface{}
+	TransactionSequence uint8
+}
+
+// DeviceConfigureReportingMessage sets reporting of attributes, data types are taken from zcldef.
+type DeviceConfigureReportingMessage struct {
+	IEEEAddress         uint64
+	ClusterID           uint16
+	Endpoint            uint8
+	Attributes          []uint16
+	MinInterval         uint16 // seconds
+	MaxInterval         uint16 // seconds
+	ReportableChange    float64
+	TransactionSequence uint8
 }
 
 type DeviceExploreMessage struct {
 	IEEEAddress uint64
 }
 
+// DeviceRemoveMessage asks the device to leave the network, Force removes it also when it does not answer.
+type DeviceRemoveMessage struct {
+	IEEEAddress uint64
+	Force       bool
+}
+
 type DeviceConfigSetMessage struct {
 	PermitJoin bool
 }
@@ -3,6 +3,7 @@ package utils
 import (
 	"math"
 	"reflect"
+	"strings"
 )
 
 func setInt(f *reflect.Value, value interface{}) {
@@ -109,6 +110,10 @@ func setStructPropertyByNamne(name string, value interface{}, dst interface{}) {
 	s := dstValue.Elem()
 	if s.Kind() == reflect.Struct {
 		f := s.FieldByName(name)
+		if !f.IsValid() {
+			// zcldef names parameters in lower case
+			f = s.FieldByNameFunc(func(n string) bool { return strings.EqualFold(n, name) })
+		}
 		if f.IsValid() && f.CanSet() {
 			switch f.Kind() {
 			case reflect.Uint:
@@ -0,0 +1,386 @@
+'use strict';
+
+// IEEE addresses do not fit into JS numbers, so they are turned into strings before parsing.
+function parseJSON(text) {
+  return JSON.parse(text.replace(/"IEEEAddress":\s*(\d+)/g, '"IEEEAddress":"$1"'));
+}
+
+function hexAddress(address) {
+  return '0x' + BigInt(address).toString(16).padStart(16, '0');
+}
+
+async function api(method, path, body) {
+  const options = { method: method, headers: {} };
+  if (body !== undefined) {
+    options.headers['Content-Type'] = 'application/json';
+    options.body = JSON.stringify(body);
+  }
+
+  const resp = await fetch('api/' + path, options);
+  const text = await resp.text();
+  const data = text ? parseJSON(text) : null;
+  if (!resp.ok) {
+    throw new Error((data && data.error) || resp.statusText);
+  }
+  return data;
+}
+
+const state = {
+  config: null,
+  devices: [],
+  selected: null, // hex address of selected device
+  cluster: null, // {endpoint, definition}
+  values: {}, // cluster ID -> attribute name -> value of selected device
+};
+
+function el(tag, text, className) {
+  const e = document.createElement(tag);
+  if (text !== undefined) {
+    e.textContent = text;
+  }
+  if (className) {
+    e.className = className;
+  }
+  return e;
+}
+
+function showResponse(data) {
+  document.getElementById('response').textContent =
+    data instanceof Error ? 'Error: ' + data.message : JSON.stringify(data, null, 2);
+}
+
+async function run(action) {
+  try {
+    showResponse(await action());
+  } catch (err) {
+    showResponse(err);
+  }
+}
+
+function isOnline(device) {
+  if (!device.LastReceived || device.LastReceived.startsWith('0001-')) {
+    return false;
+  }
+  const availability = state.config.Availability;
+  const timeout = device.PowerSource === 'battery'
+    ? availability.PassiveTimeoutInSeconds : availability.ActiveTimeoutInSeconds;
+  return Date.now() - Date.parse(device.LastReceived) <= timeout * 1000;
+}
+
+function lastSeen(device) {
+  if (!device.LastReceived || device.LastReceived.startsWith('0001-')) {
+    return 'never';
+  }
+  const seconds = Math.round((Date.now() - Date.parse(device.LastReceived)) / 1000);
+  if (seconds < 60) return seconds + 's ago';
+  if (seconds < 3600) return Math.round(seconds / 60) + 'm ago';
+  if (seconds < 86400) return Math.round(seconds / 3600) + 'h ago';
+  return Math.round(seconds / 86400) + 'd ago';
+}
+
+function deviceName(device) {
+  return device.FriendlyName || hexAddress(device.IEEEAddress);
+}
+
+function renderDevices() {
+  const rows = document.getElementById('device-rows');
+  rows.textContent = '';
+
+  for (const device of state.devices) {
+    const address = hexAddress(device.IEEEAddress);
+    const tr = el('tr');
+    if (address === state.selected) {
+      tr.className = 'selected';
+    }
+
+    tr.appendChild(el('td', deviceName(device)));
+    tr.appendChild(el('td', device.ModelID));
+    const power = device.BatteryLevel !== null && device.BatteryLevel !== undefined
+      ? device.PowerSource + ' ' + device.BatteryLevel + '%' : device.PowerSource;
+    tr.appendChild(el('td', power));
+    tr.appendChild(el('td', device.LQI));
+    tr.appendChild(el('td', lastSeen(device)));
+    const online = isOnline(device);
+    const status = el('td');
+    status.appendChild(el('span', online ? 'online' : 'offline', 'status ' + (online ? 'online' : 'offline')));
+    tr.appendChild(status);
+    tr.appendChild(el('td', device.InterviewStatus));
+
+    tr.addEventListener('click', () => selectDevice(address));
+    rows.appendChild(tr);
+  }
+}
+
+async function loadDevices() {
+  state.devices = await api('GET', 'devices');
+  state.devices.sort((a, b) => deviceName(a).localeCompare(deviceName(b)));
+  renderDevices();
+  if (state.selected) {
+    renderDevice();
+  }
+}
+
+function selectedDevice() {
+  return state.devices.find((d) => hexAddress(d.IEEEAddress) === state.selected);
+}
+
+async function selectDevice(address) {
+  state.selected = address;
+  state.cluster = null;
+  state.values = {};
+
+  const reports = await api('GET', 'devices/' + address + '/state');
+  for (const report of reports) {
+    state.values[report.ClusterID] = report.ClusterAttributes || {};
+  }
+
+  renderDevices();
+  renderDevice();
+}
+
+function renderDevice() {
+  const device = selectedDevice();
+  const section = document.getElementById('device');
+  if (!device) {
+    section.hidden = true;
+    return;
+  }
+  section.hidden = false;
+
+  document.getElementById('device-title').textContent = deviceName(device);
+  const nameInput = document.getElementById('device-name');
+  if (document.activeElement !== nameInput) {
+    nameInput.value = device.FriendlyName || '';
+  }
+
+  const info = document.getElementById('device-info');
+  info.textContent = '';
+  const fields = [
+    ['Address', hexAddress(device.IEEEAddress)],
+    ['Network address', '0x' + device.NetworkAddress.toString(16)],
+    ['Manufacturer', device.ManufacturerName],
+    ['Model', device.ModelID],
+    ['Firmware', device.FirmwareBuild],
+    ['Power source', device.PowerSource],
+    ['Tags', (device.Tags || []).join(', ')],
+    ['Notes', device.Notes],
+  ];
+  for (const [name, value] of fields) {
+    info.appendChild(el('dt', name));
+    info.appendChild(el('dd', value || '-'));
+  }
+
+  const endpoints = document.getElementById('endpoints');
+  endpoints.textContent = '';
+  for (const endpoint of device.Endpoints || []) {
+    const div = el('div');
+    div.appendChild(el('strong', 'Endpoint ' + endpoint.Endpoint + ' '));
+    for (const clusterID of endpoint.InClusterList || []) {
+      const button = el('button', clusterID, 'cluster');
+      button.title = 'input cluster';
+      button.addEventListener('click', () => run(() => selectCluster(endpoint.Endpoint, clusterID)));
+      div.appendChild(button);
+    }
+    if ((endpoint.OutClusterList || []).length > 0) {
+      div.appendChild(el('span', ' out: ' + endpoint.OutClusterList.join(', ')));
+    }
+    endpoints.appendChild(div);
+  }
+
+  renderCluster();
+}
+
+async function selectCluster(endpoint, clusterID) {
+  const definition = await api('GET', 'zcl/' + clusterID);
+  state.cluster = { endpoint: endpoint, definition: definition };
+
+  const buttons = document.querySelectorAll('#endpoints button');
+  buttons.forEach((b) => b.classList.toggle('selected', b.textContent === String(clusterID)));
+
+  const select = document.getElementById('command');
+  select.textContent = '';
+  const commands = Object.values(definition.Commands || {}).sort((a, b) => a.ID - b.ID);
+  for (const command of commands) {
+    const option = el('option', command.Name + ' (' + command.ID + ')');
+    option.value = command.ID;
+    select.appendChild(option);
+  }
+  fillCommandTemplate();
+
+  renderCluster();
+  return definition;
+}
+
+function fillCommandTemplate() {
+  const definition = state.cluster.definition;
+  const command = (definition.Commands || {})[document.getElementById('command').value];
+  const data = {};
+  for (const [name, type] of (command && command.Parameters) || []) {
+    data[name] = type === 'string' || type === 'octstr' ? '' : 0;
+  }
+  document.getElementById('command-data').value = JSON.stringify(data, null, 2);
+}
+
+function renderCluster() {
+  const section = document.getElementById('cluster');
+  if (!state.cluster) {
+    section.hidden = true;
+    return;
+  }
+  section.hidden = false;
+
+  const definition = state.cluster.definition;
+  document.getElementById('cluster-title').textContent =
+    definition.Name + ' (' + definition.ID + '), endpoint ' + state.cluster.endpoint;
+
+  const values = state.values[definition.ID] || {};
+  const rows = document.getElementById('attribute-rows');
+  rows.textContent = '';
+
+  const attributes = Object.values(definition.Attributes || {}).sort((a, b) => a.ID - b.ID);
+  for (const attribute of attributes) {
+    const tr = el('tr');
+    tr.appendChild(el('td', attribute.Name));
+    tr.appendChild(el('td', attribute.ID));
+    tr.appendChild(el('td', attribute.Type));
+    const value = values[attribute.Name];
+    tr.appendChild(el('td', value === undefined ? '' : JSON.stringify(value)));
+
+    const actions = el('td');
+    const read = el('button', 'Read');
+    read.addEventListener('click', () => run(() => readAttribute(attribute)));
+    const input = el('input');
+    input.size = 8;
+    const write = el('button', 'Write');
+    write.addEventListener('click', () => run(() => writeAttribute(attribute, input.value)));
+    actions.append(read, input, write);
+    tr.appendChild(actions);
+
+    rows.appendChild(tr);
+  }
+}
+
+function readAttribute(attribute) {
+  return api('POST', 'devices/' + state.selected + '/get', {
+    ClusterID: state.cluster.definition.ID,
+    Endpoint: state.cluster.endpoint,
+    Attributes: [attribute.ID],
+  });
+}
+
+function writeAttribute(attribute, text) {
+  let value = text;
+  if (attribute.Type !== 'string') {
+    value = JSON.parse(text);
+  }
+
+  const attributes = {};
+  attributes[attribute.ID] = value;
+
+  return api('POST', 'devices/' + state.selected + '/write', {
+    ClusterID: state.cluster.definition.ID,
+    Endpoint: state.cluster.endpoint,
+    Attributes: attributes,
+  });
+}
+
+function sendCommand() {
+  return api('POST', 'devices/' + state.selected + '/set', {
+    ClusterID: state.cluster.definition.ID,
+    Endpoint: state.cluster.endpoint,
+    CommandIdentifier: Number(document.getElementById('command').value),
+    CommandData: JSON.parse(document.getElementById('command-data').value || '{}'),
+  });
+}
+
+function setGatewayStatus(status, permitJoin) {
+  const e = document.getElementById('gateway-status');
+  e.textContent = status;
+  e.className = 'status ' + status;
+  if (permitJoin !== undefined) {
+    document.getElementById('permit-join').checked = permitJoin;
+  }
+}
+
+function onEvent(e) {
+  if (e.Type === 'status') {
+    setGatewayStatus(e.Payload.Status, e.Payload.PermitJoin);
+    return;
+  }
+
+  if (e.Type === 'device_added' || e.Type === 'device_removed' || e.Type === 'description') {
+    loadDevices();
+    return;
+  }
+
+  const device = state.devices.find((d) => d.IEEEAddress === e.IEEEAddress);
+  if (!device) {
+    return;
+  }
+  if (e.Type === 'message') {
+    device.LQI = e.Payload.LinkQuality;
+    device.LastReceived = e.Time;
+
+    const message = e.Payload.Message;
+    if (hexAddress(e.IEEEAddress) === state.selected && message && message.ClusterAttributes) {
+      state.values[message.ClusterID] = Object.assign(state.values[message.ClusterID] || {}, message.ClusterAttributes);
+      renderCluster();
+    }
+  }
+  renderDevices();
+}
+
+function connectEvents() {
+  const url = new URL('api/events', window.location.href);
+  url.protocol = url.protocol === 'https:' ? 'wss:' : 'ws:';
+
+  const ws = new WebSocket(url);
+  ws.onmessage = (msg) => onEvent(parseJSON(msg.data));
+  ws.onclose = () => {
+    setGatewayStatus('offline');
+    setTimeout(connectEvents, 5000);
+  };
+  ws.onopen = () => loadDevices();
+}
+
+document.getElementById('permit-join').addEventListener('change', (e) =>
+  run(() => api('POST', 'permit_join', { PermitJoin: e.target.checked })));
+
+document.getElementById('rename').addEventListener('click', () => run(async () => {
+  const device = await api('PATCH', 'devices/' + state.selected, {
+    FriendlyName: document.getElementById('device-name').value,
+  });
+  await loadDevices();
+  return device;
+}));
+
+document.getElementById('explore').addEventListener('click', () =>
+  run(() => api('POST', 'devices/' + state.selected + '/explore?timeout=60s', {})));
+
+document.getElementById('remove').addEventListener('click', () => run(async () => {
+  const device = selectedDevice();
+  if (!confirm('Remove ' + deviceName(device) + ' from the network?')) {
+    return 'cancelled';
+  }
+  const force = document.getElementById('remove-force').checked;
+  await api('DELETE', 'devices/' + state.selected + (force ? '?force=true' : ''));
+  state.selected = null;
+  await loadDevices();
+  renderDevice();
+  return 'removed';
+}));
+
+document.getElementById('command').addEventListener('change', fillCommandTemplate);
+document.getElementById('send-command').addEventListener('click', () => run(sendCommand));
+
+(async function init() {
+  try {
+    state.config = await api('GET', 'config');
+    document.getElementById('permit-join').checked = state.config.PermitJoin;
+    await loadDevices();
+  } catch (err) {
+    showResponse(err);
+  }
+  connectEvents();
+  setInterval(renderDevices, 30000);
+})();
@@ -0,0 +1,512 @@
+package z2m
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/supby/gigbee2mqtt/internal/configuration"
+	"github.com/supby/gigbee2mqtt/internal/db"
+	"github.com/supby/gigbee2mqtt/internal/utils"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	DatabaseFilename      = "database.db"
+	ConfigurationFilename = "configuration.yaml"
+	BackupFilename        = "coordinator_backup.json"
+)
+
+// Result is gigbee2mqtt configuration and devices made of zigbee2mqtt data.
+type Result struct {
+	Configuration configuration.Configuration
+	Devices       []db.Device
+	Warnings      []string
+}
+
+// Import reads database.db, configuration.yaml and coordinator_backup.json from zigbee2mqtt data directory.
+// configuration.yaml is required, the other files are used when they exist.
+// Network parameters of coordinator backup take precedence as they are what the coordinator actually runs.
+func Import(dataDir string, base configuration.Configuration) (*Result, error) {
+	ret := &Result{
+		Configuration: base,
+	}
+
+	z2mCfg, err := readConfiguration(filepath.Join(dataDir, ConfigurationFilename))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ret.applyConfiguration(z2mCfg); err != nil {
+		return nil, err
+	}
+
+	devices := map[uint64]db.Device{}
+
+	dbRecords, err := readDatabase(filepath.Join(dataDir, DatabaseFilename))
+	if errors.Is(err, os.ErrNotExist) {
+		ret.warn("%v not found, devices are taken from coordinator backup only", DatabaseFilename)
+	} else if err != nil {
+		return nil, err
+	}
+
+	for _, record := range dbRecords {
+		if record.Type == "Coordinator" {
+			continue
+		}
+
+		device, err := record.toDevice()
+		if err != nil {
+			ret.warn("skipping device '%v': %v", record.IEEEAddr, err)
+			continue
+		}
+
+		devices[device.IEEEAddress] = device
+	}
+
+	backup, err := readBackup(filepath.Join(dataDir, BackupFilename))
+	if errors.Is(err, os.ErrNotExist) {
+		ret.warn("%v not found, network parameters are taken from %v", BackupFilename, ConfigurationFilename)
+	} else if err != nil {
+		return nil, err
+	} else {
+		if err := ret.applyBackup(backup, devices); err != nil {
+			return nil, err
+		}
+	}
+
+	for ieee, options := range z2mCfg.Devices {
+		address, err := parseIEEEAddress(ieee)
+		if err != nil {
+			ret.warn("skipping options of device '%v': %v", ieee, err)
+			continue
+		}
+
+		device, ok := devices[address]
+		if !ok {
+			ret.warn("device '%v' (%v) from %v is not in the device database", ieee, options.FriendlyName, ConfigurationFilename)
+			continue
+		}
+
+		device.FriendlyName = options.FriendlyName
+		devices[address] = device
+	}
+
+	for _, device := range devices {
+		ret.Devices = append(ret.Devices, device)
+	}
+	sort.Slice(ret.Devices, func(i, j int) bool { return ret.Devices[i].IEEEAddress < ret.Devices[j].IEEEAddress })
+
+	return ret, nil
+}
+
+func (r *Result) warn(format string, args ...interface{}) {
+	r.Warnings = append(r.Warnings, fmt.Sprintf(format, args...))
+}
+
+type z2mConfiguration struct {
+	PermitJoin bool `yaml:"permit_join"`
+	MQTT       struct {
+		BaseTopic string `yaml:"base_topic"`
+		Server    string `yaml:"server"`
+		User      string `yaml:"user"`
+		Password  string `yaml:"password"`
+		ClientID  string `yaml:"client_id"`
+	} `yaml:"mqtt"`
+	Serial struct {
+		Port string `yaml:"port"`
+	} `yaml:"serial"`
+	Advanced struct {
+		PanID      interface{} `yaml:"pan_id"`
+		ExtPanID   interface{} `yaml:"ext_pan_id"`
+		NetworkKey interface{} `yaml:"network_key"`
+		Channel    uint8       `yaml:"channel"`
+	} `yaml:"advanced"`
+	Devices map[string]struct {
+		FriendlyName string `yaml:"friendly_name"`
+	} `yaml:"devices"`
+}
+
+func readConfiguration(filename string) (*z2mConfiguration, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret z2mConfiguration
+	if err := yaml.Unmarshal(data, &ret); err != nil {
+		return nil, fmt.Errorf("error parsing '%v': %v", filename, err)
+	}
+
+	return &ret, nil
+}
+
+func (r *Result) applyConfiguration(z2mCfg *z2mConfiguration) error {
+	cfg := &r.Configuration
+
+	cfg.PermitJoin = z2mCfg.PermitJoin
+
+	if z2mCfg.Serial.Port != "" {
+		cfg.SerialConfiguration.PortName = z2mCfg.Serial.Port
+	}
+
+	if z2mCfg.MQTT.BaseTopic != "" {
+		cfg.MqttConfiguration.RootTopic = z2mCfg.MQTT.BaseTopic
+	}
+	cfg.MqttConfiguration.Username = z2mCfg.MQTT.User
+	cfg.MqttConfiguration.Password = z2mCfg.MQTT.Password
+	cfg.MqttConfiguration.ClientID = z2mCfg.MQTT.ClientID
+
+	if z2mCfg.MQTT.Server != "" {
+		if err := applyMqttServer(&cfg.MqttConfiguration, z2mCfg.MQTT.Server); err != nil {
+			return err
+		}
+	}
+
+	adv := z2mCfg.Advanced
+
+	if adv.Channel != 0 {
+		cfg.ZNetworkConfiguration.Channel = adv.Channel
+	}
+
+	if panID, ok, err := parseYAMLUint(adv.PanID, 16); err != nil {
+		return fmt.Errorf("invalid advanced.pan_id: %v", err)
+	} else if ok {
+		cfg.ZNetworkConfiguration.PANID = uint16(panID)
+	} else {
+		r.warn("advanced.pan_id is not set, default %v is used", cfg.ZNetworkConfiguration.PANID)
+	}
+
+	if extPanID, ok, err := parseYAMLBytes(adv.ExtPanID, 8); err != nil {
+		return fmt.Errorf("invalid advanced.ext_pan_id: %v", err)
+	} else if ok {
+		cfg.ZNetworkConfiguration.ExtendedPANID = utils.Btoi64(extPanID)
+	} else {
+		r.warn("advanced.ext_pan_id is not set, default %v is used", cfg.ZNetworkConfiguration.ExtendedPANID)
+	}
+
+	if key, ok, err := parseYAMLBytes(adv.NetworkKey, 16); err != nil {
+		return fmt.Errorf("invalid advanced.network_key: %v", err)
+	} else if ok {
+		copy(cfg.ZNetworkConfiguration.NetworkKey[:], key)
+	} else {
+		r.warn("advanced.network_key is not set, default key is used")
+	}
+
+	return nil
+}
+
+func applyMqttServer(cfg *configuration.MqttConfiguration, server string) error {
+	u, err := url.Parse(server)
+	if err != nil {
+		return fmt.Errorf("invalid mqtt.server '%v': %v", server, err)
+	}
+
+	defaultPort := 1883
+	switch u.Scheme {
+	case "mqtt", "tcp":
+		cfg.Scheme = "tcp"
+	case "mqtts", "ssl", "tls":
+		cfg.Scheme = "ssl"
+		defaultPort = 8883
+	case "ws":
+		cfg.Scheme = "ws"
+		defaultPort = 80
+	case "wss":
+		cfg.Scheme = "wss"
+		defaultPort = 443
+	default:
+		return fmt.Errorf("unsupported mqtt.server scheme '%v'", u.Scheme)
+	}
+
+	cfg.Address = u.Hostname()
+	cfg.Port = uint16(defaultPort)
+	if u.Port() != "" {
+		port, err := strconv.ParseUint(u.Port(), 10, 16)
+		if err != nil {
+			return fmt.Errorf("invalid mqtt.server port '%v'", u.Port())
+		}
+		cfg.Port = uint16(port)
+	}
+	cfg.Path = u.Path
+
+	return nil
+}
+
+// parseYAMLUint accepts a number or a hex string, "GENERATE" and empty values are reported as missing.
+func parseYAMLUint(value interface{}, bitSize int) (uint64, bool, error) {
+	switch v := value.(type) {
+	case nil:
+		return 0, false, nil
+	case int:
+		if v < 0 || uint64(v) >= 1<<uint(bitSize) {
+			return 0, false, fmt.Errorf("%v is out of range", v)
+		}
+		return uint64(v), true, nil
+	case string:
+		if v == "" || v == "GENERATE" {
+			return 0, false, nil
+		}
+		ret, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(v), "0x"), 16, bitSize)
+		return ret, err == nil, err
+	}
+
+	return 0, false, fmt.Errorf("unexpected value %v", value)
+}
+
+// parseYAMLBytes accepts a list of bytes, "GENERATE" and empty values are reported as missing.
+func parseYAMLBytes(value interface{}, size int) ([]byte, bool, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, false, nil
+	case string:
+		if v == "" || v == "GENERATE" {
+			return nil, false, nil
+		}
+	case []interface{}:
+		if len(v) != size {
+			return nil, false, fmt.Errorf("%v bytes expected, got %v", size, len(v))
+		}
+
+		ret := make([]byte, size)
+		for i, b := range v {
+			n, ok := b.(int)
+			if !ok || n < 0 || n > 0xff {
+				return nil, false, fmt.Errorf("invalid byte %v", b)
+			}
+			ret[i] = byte(n)
+		}
+
+		return ret, true, nil
+	}
+
+	return nil, false, fmt.Errorf("unexpected value %v", value)
+}
+
+type z2mDevice struct {
+	Type               string                 `json:"type"`
+	IEEEAddr           string                 `json:"ieeeAddr"`
+	NwkAddr            uint16                 `json:"nwkAddr"`
+	ManufID            uint16                 `json:"manufId"`
+	ManufName          string                 `json:"manufName"`
+	PowerSource        string                 `json:"powerSource"`
+	ModelID            string                 `json:"modelId"`
+	SWBuildID          string                 `json:"swBuildId"`
+	InterviewCompleted bool                   `json:"interviewCompleted"`
+	LastSeen           int64                  `json:"lastSeen"`
+	Endpoints          map[string]z2mEndpoint `json:"endpoints"`
+}
+
+type z2mEndpoint struct {
+	ProfID         uint16   `json:"profId"`
+	EpID           uint8    `json:"epId"`
+	DevID          uint16   `json:"devId"`
+	InClusterList  []uint16 `json:"inClusterList"`
+	OutClusterList []uint16 `json:"outClusterList"`
+}
+
+// readDatabase reads zigbee2mqtt database.db which has one JSON record per line.
+func readDatabase(filename string) ([]z2mDevice, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []z2mDevice
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+
+		var record z2mDevice
+		if err := json.Unmarshal(text, &record); err != nil {
+			return nil, fmt.Errorf("error parsing '%v' line %v: %v", filename, line, err)
+		}
+
+		ret = append(ret, record)
+	}
+
+	return ret, scanner.Err()
+}
+
+func (d *z2mDevice) toDevice() (db.Device, error) {
+	address, err := parseIEEEAddress(d.IEEEAddr)
+	if err != nil {
+		return db.Device{}, err
+	}
+
+	ret := db.Device{
+		IEEEAddress:      address,
+		NetworkAddress:   d.NwkAddr,
+		LogicalType:      logicalType(d.Type),
+		ManufacturerCode: d.ManufID,
+		ManufacturerName: d.ManufName,
+		ModelID:          d.ModelID,
+		FirmwareBuild:    d.SWBuildID,
+		PowerSource:      powerSource(d.PowerSource),
+		InterviewStatus:  db.InterviewPending,
+	}
+
+	if d.InterviewCompleted {
+		ret.InterviewStatus = db.InterviewCompleted
+	}
+
+	if d.LastSeen > 0 {
+		ret.LastReceived = time.Unix(0, d.LastSeen*int64(time.Millisecond))
+	}
+
+	for _, e := range d.Endpoints {
+		ret.Endpoints = append(ret.Endpoints, db.Endpoint{
+			Endpoint:       e.EpID,
+			ProfileID:      e.ProfID,
+			DeviceID:       e.DevID,
+			InClusterList:  e.InClusterList,
+			OutClusterList: e.OutClusterList,
+		})
+	}
+	sort.Slice(ret.Endpoints, func(i, j int) bool { return ret.Endpoints[i].Endpoint < ret.Endpoints[j].Endpoint })
+
+	return ret, nil
+}
+
+func logicalType(z2mType string) uint8 {
+	switch z2mType {
+	case "Coordinator":
+		return 0
+	case "Router":
+		return 1
+	case "EndDevice":
+		return 2
+	}
+
+	return 0xff
+}
+
+func powerSource(z2mPowerSource string) string {
+	lower := strings.ToLower(z2mPowerSource)
+
+	switch {
+	case strings.Contains(lower, "mains"):
+		return db.PowerSourceMains
+	case strings.Contains(lower, "battery"):
+		return db.PowerSourceBattery
+	case strings.Contains(lower, "dc"):
+		return db.PowerSourceDC
+	}
+
+	return db.PowerSourceUnknown
+}
+
+// z2mBackup is Open Coordinator Backup format written by zigbee2mqtt, hex values have no 0x prefix.
+type z2mBackup struct {
+	CoordinatorIEEE string `json:"coordinator_ieee"`
+	PanID           string `json:"pan_id"`
+	ExtendedPanID   string `json:"extended_pan_id"`
+	Channel         uint8  `json:"channel"`
+	NetworkKey      struct {
+		Key string `json:"key"`
+	} `json:"network_key"`
+	Devices []struct {
+		NwkAddress  string `json:"nwk_address"`
+		IEEEAddress string `json:"ieee_address"`
+	} `json:"devices"`
+}
+
+func readBackup(filename string) (*z2mBackup, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret z2mBackup
+	if err := json.Unmarshal(data, &ret); err != nil {
+		return nil, fmt.Errorf("error parsing '%v': %v", filename, err)
+	}
+
+	return &ret, nil
+}
+
+func (r *Result) applyBackup(backup *z2mBackup, devices map[uint64]db.Device) error {
+	netCfg := &r.Configuration.ZNetworkConfiguration
+
+	panID, err := strconv.ParseUint(backup.PanID, 16, 16)
+	if err != nil {
+		return fmt.Errorf("invalid pan_id in coordinator backup: %v", err)
+	}
+	if uint16(panID) != netCfg.PANID {
+		r.warn("PAN ID %v from coordinator backup is used instead of %v", panID, netCfg.PANID)
+	}
+	netCfg.PANID = uint16(panID)
+
+	// extended PAN ID is written most significant byte first
+	extPanID, err := strconv.ParseUint(backup.ExtendedPanID, 16, 64)
+	if err != nil {
+		return fmt.Errorf("invalid extended_pan_id in coordinator backup: %v", err)
+	}
+	if extPanID != netCfg.ExtendedPANID {
+		r.warn("extended PAN ID %v from coordinator backup is used instead of %v", extPanID, netCfg.ExtendedPANID)
+	}
+	netCfg.ExtendedPANID = extPanID
+
+	key, err := hex.DecodeString(backup.NetworkKey.Key)
+	if err != nil || len(key) != len(netCfg.NetworkKey) {
+		return fmt.Errorf("invalid network_key in coordinator backup")
+	}
+	if !bytes.Equal(key, netCfg.NetworkKey[:]) {
+		r.warn("network key from coordinator backup is used")
+	}
+	copy(netCfg.NetworkKey[:], key)
+
+	if backup.Channel != 0 {
+		if backup.Channel != netCfg.Channel {
+			r.warn("channel %v from coordinator backup is used instead of %v", backup.Channel, netCfg.Channel)
+		}
+		netCfg.Channel = backup.Channel
+	}
+
+	for _, d := range backup.Devices {
+		address, err := strconv.ParseUint(d.IEEEAddress, 16, 64)
+		if err != nil {
+			r.warn("skipping backup device '%v': %v", d.IEEEAddress, err)
+			continue
+		}
+
+		if _, ok := devices[address]; ok {
+			continue
+		}
+
+		nwkAddress, err := strconv.ParseUint(d.NwkAddress, 16, 16)
+		if err != nil {
+			r.warn("skipping backup device '%v': %v", d.IEEEAddress, err)
+			continue
+		}
+
+		devices[address] = db.Device{
+			IEEEAddress:     address,
+			NetworkAddress:  uint16(nwkAddress),
+			LogicalType:     0xff,
+			PowerSource:     db.PowerSourceUnknown,
+			InterviewStatus: db.InterviewPending,
+		}
+	}
+
+	return nil
+}
+
+func parseIEEEAddress(value string) (uint64, error) {
+	return strconv.ParseUint(strings.TrimPrefix(strings.ToLower(value), "0x"), 16, 64)
+}
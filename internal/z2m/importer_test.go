@@ -0,0 +1,144 @@
+package z2m
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/supby/gigbee2mqtt/internal/configuration"
+	"github.com/supby/gigbee2mqtt/internal/db"
+	"github.com/supby/gigbee2mqtt/internal/utils"
+)
+
+const testConfiguration = `
+homeassistant: false
+permit_join: false
+mqtt:
+  base_topic: zigbee2mqtt
+  server: mqtts://broker.local:8884
+  user: z2m
+  password: secret
+serial:
+  port: /dev/ttyUSB0
+advanced:
+  pan_id: 0x1a62
+  ext_pan_id: [0xDD, 0xDD, 0xDD, 0xDD, 0xDD, 0xDD, 0xDD, 0x01]
+  network_key: [1, 3, 5, 7, 9, 11, 13, 15, 0, 2, 4, 6, 8, 10, 12, 13]
+  channel: 20
+devices:
+  '0x842e14fffe05b879':
+    friendly_name: kitchen_light
+  '0x00158d0001000001':
+    friendly_name: removed_device
+`
+
+const testDatabase = `{"id":1,"type":"Coordinator","ieeeAddr":"0x00124b0021000000","nwkAddr":0,"manufId":0,"epList":[1],"endpoints":{},"interviewCompleted":true,"meta":{}}
+{"id":2,"type":"Router","ieeeAddr":"0x842e14fffe05b879","nwkAddr":59230,"manufId":4476,"manufName":"IKEA of Sweden","powerSource":"Mains (single phase)","modelId":"TRADFRI bulb E27 WS opal 980lm","epList":[1,242],"endpoints":{"242":{"profId":41440,"epId":242,"devId":97,"inClusterList":[],"outClusterList":[33]},"1":{"profId":260,"epId":1,"devId":544,"inClusterList":[0,3,4,5,6,8,768],"outClusterList":[5,25,32],"clusters":{},"binds":[],"configuredReportings":[],"meta":{}}},"swBuildId":"2.3.086","interviewCompleted":true,"lastSeen":1659193524527,"meta":{}}
+{"id":3,"type":"EndDevice","ieeeAddr":"0x00158d0002000002","nwkAddr":4321,"manufName":"LUMI","powerSource":"Battery","modelId":"lumi.sensor_magnet","epList":[1],"endpoints":{"1":{"profId":260,"epId":1,"devId":260,"inClusterList":[0],"outClusterList":[]}},"interviewCompleted":false,"meta":{}}
+`
+
+const testBackup = `{
+  "metadata": {"format": "zigpy/open-coordinator-backup", "version": 1},
+  "coordinator_ieee": "00124b0021000000",
+  "pan_id": "1a62",
+  "extended_pan_id": "01dddddddddddddd",
+  "channel": 25,
+  "network_key": {"key": "01030507090b0d0f00020406080a0c0d", "sequence_number": 0, "frame_counter": 16054},
+  "devices": [
+    {"nwk_address": "e75e", "ieee_address": "842e14fffe05b879", "is_child": false},
+    {"nwk_address": "1234", "ieee_address": "00158d0003000003", "is_child": true}
+  ]
+}`
+
+func writeTestFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+	return dir
+}
+
+func TestImport(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		ConfigurationFilename: testConfiguration,
+		DatabaseFilename:      testDatabase,
+		BackupFilename:        testBackup,
+	})
+
+	result, err := Import(dir, configuration.Default())
+	assert.NoError(t, err)
+
+	cfg := result.Configuration
+	assert.Equal(t, "ssl", cfg.MqttConfiguration.Scheme)
+	assert.Equal(t, "broker.local", cfg.MqttConfiguration.Address)
+	assert.Equal(t, uint16(8884), cfg.MqttConfiguration.Port)
+	assert.Equal(t, "zigbee2mqtt", cfg.MqttConfiguration.RootTopic)
+	assert.Equal(t, "z2m", cfg.MqttConfiguration.Username)
+	assert.Equal(t, "/dev/ttyUSB0", cfg.SerialConfiguration.PortName)
+	assert.False(t, cfg.PermitJoin)
+
+	assert.Equal(t, uint16(0x1a62), cfg.ZNetworkConfiguration.PANID)
+	assert.Equal(t, utils.Btoi64([]byte{0xDD, 0xDD, 0xDD, 0xDD, 0xDD, 0xDD, 0xDD, 0x01}), cfg.ZNetworkConfiguration.ExtendedPANID)
+	assert.Equal(t, [16]byte{1, 3, 5, 7, 9, 11, 13, 15, 0, 2, 4, 6, 8, 10, 12, 13}, cfg.ZNetworkConfiguration.NetworkKey)
+	// coordinator backup wins over configuration.yaml
+	assert.Equal(t, uint8(25), cfg.ZNetworkConfiguration.Channel)
+
+	assert.Equal(t, 3, len(result.Devices))
+
+	sensor := result.Devices[0]
+	assert.Equal(t, uint64(0x00158d0002000002), sensor.IEEEAddress)
+	assert.Equal(t, uint8(2), sensor.LogicalType)
+	assert.Equal(t, db.PowerSourceBattery, sensor.PowerSource)
+	assert.Equal(t, db.InterviewPending, sensor.InterviewStatus)
+
+	child := result.Devices[1]
+	assert.Equal(t, uint64(0x00158d0003000003), child.IEEEAddress)
+	assert.Equal(t, uint16(0x1234), child.NetworkAddress)
+
+	bulb := result.Devices[2]
+	assert.Equal(t, uint64(0x842e14fffe05b879), bulb.IEEEAddress)
+	assert.Equal(t, uint16(59230), bulb.NetworkAddress)
+	assert.Equal(t, uint8(1), bulb.LogicalType)
+	assert.Equal(t, uint16(4476), bulb.ManufacturerCode)
+	assert.Equal(t, "IKEA of Sweden", bulb.ManufacturerName)
+	assert.Equal(t, "TRADFRI bulb E27 WS opal 980lm", bulb.ModelID)
+	assert.Equal(t, "2.3.086", bulb.FirmwareBuild)
+	assert.Equal(t, db.PowerSourceMains, bulb.PowerSource)
+	assert.Equal(t, db.InterviewCompleted, bulb.InterviewStatus)
+	assert.Equal(t, "kitchen_light", bulb.FriendlyName)
+	assert.Equal(t, int64(1659193524527), bulb.LastReceived.UnixNano()/1e6)
+	assert.Equal(t, 2, len(bulb.Endpoints))
+	assert.Equal(t, uint8(1), bulb.Endpoints[0].Endpoint)
+	assert.Equal(t, []uint16{0, 3, 4, 5, 6, 8, 768}, bulb.Endpoints[0].InClusterList)
+
+	assert.Contains(t, result.Warnings, "device '0x00158d0001000001' (removed_device) from configuration.yaml is not in the device database")
+}
+
+func TestImportWithoutBackup(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		ConfigurationFilename: testConfiguration,
+		DatabaseFilename:      testDatabase,
+	})
+
+	result, err := Import(dir, configuration.Default())
+	assert.NoError(t, err)
+	assert.Equal(t, uint8(20), result.Configuration.ZNetworkConfiguration.Channel)
+	assert.Equal(t, 2, len(result.Devices))
+}
+
+func TestImportGeneratedNetworkKey(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		ConfigurationFilename: "advanced:\n  network_key: GENERATE\n",
+	})
+
+	result, err := Import(dir, configuration.Default())
+	assert.NoError(t, err)
+	assert.Equal(t, configuration.Default().ZNetworkConfiguration.NetworkKey, result.Configuration.ZNetworkConfiguration.NetworkKey)
+	assert.Equal(t, 0, len(result.Devices))
+}
+
+func TestImportMissingConfiguration(t *testing.T) {
+	_, err := Import(t.TempDir(), configuration.Default())
+	assert.Error(t, err)
+}